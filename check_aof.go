@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xuning888/godis-tiny/redis"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkAofName 和 redis-check-aof 一样, 通过这个名称的软链接启动时检查 aof 文件
+const checkAofName = "godis-check-aof"
+
+// isCheckAof 通过软链接启动或者第一个参数是 check-aof 时返回检查 aof 需要的参数
+func isCheckAof(argv []string) ([]string, bool) {
+	if strings.HasPrefix(filepath.Base(argv[0]), checkAofName) {
+		return argv[1:], true
+	}
+	if len(argv) > 1 && argv[1] == "check-aof" {
+		return argv[2:], true
+	}
+	return nil, false
+}
+
+// checkAofMain godis-check-aof [--fix|--truncate-to-timestamp <timestamp>] <file.manifest|file.aof>,
+// 文件完整、修复或者截断成功时返回 0
+func checkAofMain(args []string) int {
+	fix := false
+	var toTimestamp int64
+	filename := ""
+	usage := len(args) == 0
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--fix":
+			fix = true
+		case arg == "--truncate-to-timestamp" && i+1 < len(args):
+			i++
+			timestamp, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || timestamp <= 0 {
+				fmt.Fprintf(os.Stderr, "Invalid timestamp: %s\n", args[i])
+				return 1
+			}
+			toTimestamp = timestamp
+		case filename == "" && !strings.HasPrefix(arg, "-"):
+			filename = arg
+		default:
+			usage = true
+		}
+	}
+	// 两种修改文件的方式不能同时使用
+	if usage || filename == "" || (fix && toTimestamp > 0) {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--fix|--truncate-to-timestamp <timestamp>] <file.manifest|file.aof>\n", checkAofName)
+		return 1
+	}
+	if err := redis.CheckAof(filename, fix, toTimestamp, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
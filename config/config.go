@@ -2,139 +2,249 @@ package config
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"github.com/xuning888/godis-tiny/pkg/util"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
-	"reflect"
-	"strconv"
 	"strings"
 )
 
 var defaultMaxClients = 10000
 
-var AppendOnlyDir = "appendOnlyDir/"
-
 type ServerProperties struct {
-	RunID                string `cfg:"runid"`
-	Bind                 string `cfg:"bind"`
-	Port                 int    `cfg:"port"`
-	Dir                  string `cfg:"dir"`
-	AppendOnly           bool   `cfg:"appendonly"`
-	AppendFilename       string `cfg:"appendfilename"`
-	AppendFsync          string `cfg:"appendfsync"`
-	MaxClients           int    `cfg:"maxclients"`
-	Databases            int    `cfg:"databases"`
-	AofRewriteMinSize    int    `cfg:"auto-aof-rewrite-min-size"`
+	RunID          string `cfg:"runid"`
+	Bind           string `cfg:"bind"`
+	Port           int    `cfg:"port"`
+	Dir            string `cfg:"dir"`
+	AppendOnly     bool   `cfg:"appendonly"`
+	AppendFilename string `cfg:"appendfilename"`
+	// AppendDirname dir 中保存 aof 文件和 manifest 的目录
+	AppendDirname string `cfg:"appenddirname"`
+	AppendFsync   string `cfg:"appendfsync"`
+	// AofUseRdbPreamble 重写时 base 文件使用 rdb 格式, 加载时不需要逐条执行命令
+	AofUseRdbPreamble bool `cfg:"aof-use-rdb-preamble"`
+	// AofLoadTruncated 加载时最后一个 aof 文件不完整的话截断之后继续启动, 否则拒绝启动
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// AofTimestampEnabled 在 aof 中写入 #TS:<unix> 注释, 记录之后的命令执行的时间, 用于恢复到指定的时间点
+	AofTimestampEnabled bool `cfg:"aof-timestamp-enabled"`
+	// AofLoadToTimestamp 加载时只执行这个时间(unix 秒)之前的命令, 并截断之后的数据, 0 表示全部加载
+	AofLoadToTimestamp int `cfg:"aof-load-to-timestamp"`
+	MaxClients         int `cfg:"maxclients"`
+	Databases          int `cfg:"databases"`
+	// AofRewriteMinSize 字节数, 配置中没有单位时按照 mb 计算
+	AofRewriteMinSize    int    `cfg:"auto-aof-rewrite-min-size" unit:"mb"`
 	AofRewritePercentage int    `cfg:"auto-aof-rewrite-percentage"`
+	ProtoMaxBulkLen      int    `cfg:"proto-max-bulk-len"`
+	RequirePass          string `cfg:"requirepass"`
+	AclFile              string `cfg:"aclfile"`
+	AclLogMaxLen         int    `cfg:"acllog-max-len"`
+	TrackingTableMaxKeys int    `cfg:"tracking-table-max-keys"`
+	// Timeout 客户端空闲多少秒之后关闭连接, 0 表示不关闭
+	Timeout                 int    `cfg:"timeout"`
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+	// SlowlogLogSlowerThan 执行时间超过多少微秒的命令记录到慢日志, 负数表示关闭慢日志
+	SlowlogLogSlowerThan int `cfg:"slowlog-log-slower-than"`
+	SlowlogMaxLen        int `cfg:"slowlog-max-len"`
+	// LatencyMonitorThreshold 超过多少毫秒的延迟记录到延迟监控中, 0 表示关闭
+	LatencyMonitorThreshold int `cfg:"latency-monitor-threshold"`
+	// MetricsPort prometheus 指标的 http 端口, 0 表示关闭
+	MetricsPort int `cfg:"metrics-port"`
+	// LogLevel debug, verbose, notice, warning, nothing
+	LogLevel string `cfg:"loglevel"`
+	// LogFile 为空时输出到标准错误
+	LogFile   string `cfg:"logfile"`
+	LogFormat string `cfg:"log-format"`
+	// LogMaxSize 日志文件达到这个大小之后轮转, 配置中没有单位时按照 mb 计算
+	LogMaxSize int `cfg:"log-max-size" unit:"mb"`
+	// LogMaxBackups 最多保留多少个轮转之后的日志文件, 0 表示全部保留
+	LogMaxBackups int `cfg:"log-max-backups"`
+	// LogMaxAge 轮转之后的日志文件保留多少天, 0 表示不按时间删除
+	LogMaxAge int `cfg:"log-max-age"`
+	// SyslogIdent 每一行日志都带上的名称, 用来区分多个实例
+	SyslogIdent string `cfg:"syslog-ident"`
 	// config file path
 	CfPath string `cfg:"cf,omitempty"`
 }
 
 var Properties *ServerProperties = nil
 
+// errBadDirective 不认识的配置或者没有值
+var errBadDirective = errors.New("bad directive or wrong number of arguments")
+
+// ParseError 配置中无法解析的行
+type ParseError struct {
+	// File 配置文件的路径, 标准输入和命令行参数分别为 (stdin) 和 (command line)
+	File string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: '%s': %v", e.File, e.Line, e.Text, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 func init() {
-	Properties = &ServerProperties{
+	Properties = defaultProperties()
+}
+
+func defaultProperties() *ServerProperties {
+	return &ServerProperties{
 		Bind:           "0.0.0.0",
 		Port:           6389,
 		AppendOnly:     false,
-		AppendFilename: "",
+		AppendFilename: "appendonly.aof",
+		AppendDirname:  "appendonlydir",
+		AppendFsync:    "everysec",
 		Databases:      16,
 		RunID:          util.RandStr(40),
+		// 和 redis 的默认值相同
+		AofUseRdbPreamble:    true,
+		AofLoadTruncated:     true,
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen:        128,
+		LogLevel:             "notice",
+		LogFormat:            "console",
+		LogMaxSize:           100 * 1024 * 1024,
 	}
 }
 
-func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+// parser 解析配置, 后面的配置覆盖前面的
+type parser struct {
+	config *ServerProperties
+	// including 正在解析的配置文件, 用来发现循环的 include
+	including map[string]bool
+}
+
+func newParser(config *ServerProperties) *parser {
+	return &parser{config: config, including: make(map[string]bool)}
+}
 
-	// read config file
-	rawMap := make(map[string]string)
+// parseFile 解析配置文件, include 的相对路径相对于当前的工作目录
+func (p *parser) parseFile(filename string) error {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if p.including[absPath] {
+		return fmt.Errorf("include loop detected: %s", filename)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer util.Close(file)
+	p.including[absPath] = true
+	defer delete(p.including, absPath)
+	return p.parse(file, filename)
+}
+
+func (p *parser) parse(src io.Reader, name string) error {
 	scanner := bufio.NewScanner(src)
+	lineNum := 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 0 && strings.TrimLeft(line, " ")[0] == '#' {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
 			continue
 		}
-		pivot := strings.IndexAny(line, " ")
-		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
-			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+		if err := p.parseLine(line); err != nil {
+			// include 的文件中的错误已经带上了位置
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				return err
+			}
+			return &ParseError{File: name, Line: lineNum, Text: line, Err: err}
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	return scanner.Err()
+}
+
+// parseLine 配置名之后的整行都是配置的值, 用引号包起来的值会去掉引号
+func (p *parser) parseLine(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return errBadDirective
 	}
+	name := strings.ToLower(fields[0])
+	value := unquote(strings.TrimSpace(line[len(fields[0]):]))
+	if name == "include" {
+		return p.parseFile(value)
+	}
+	field, fieldVal, ok := lookupFieldOf(p.config, name)
+	if !ok {
+		return errBadDirective
+	}
+	if err := setField(field, fieldVal, value); err != nil {
+		return err
+	}
+	// 和 CONFIG SET 使用相同的校验
+	if validate := params[name].validate; validate != nil {
+		return validate(value)
+	}
+	return nil
+}
 
-	// parse format
-	t := reflect.TypeOf(config)
-	v := reflect.ValueOf(config)
-	n := t.Elem().NumField()
-	for i := 0; i < n; i++ {
-		field := t.Elem().Field(i)
-		fieldVal := v.Elem().Field(i)
-		key, ok := field.Tag.Lookup("cfg")
-		if !ok || strings.TrimLeft(key, " ") == "" {
-			key = field.Name
-		}
-		value, ok := rawMap[strings.ToLower(key)]
-		if ok {
-			// fill config
-			switch field.Type.Kind() {
-			case reflect.String:
-				fieldVal.SetString(value)
-			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
-				}
-			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
-			case reflect.Slice:
-				if field.Type.Elem().Kind() == reflect.String {
-					slice := strings.Split(value, ",")
-					fieldVal.Set(reflect.ValueOf(slice))
-				}
-			}
-		}
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
 	}
-	return config
+	return value
 }
 
-func SetUpConfig(filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		panic(err)
+// LoadConfig 依次加载配置文件、标准输入和命令行参数中的配置, 后面的配置覆盖前面的。
+// filename 为空时不读取配置文件, stdin 为 nil 时不读取标准输入, options 中每行一个配置
+func LoadConfig(filename string, stdin io.Reader, options string) error {
+	config := defaultProperties()
+	p := newParser(config)
+	if filename != "" {
+		if err := p.parseFile(filename); err != nil {
+			return err
+		}
+		configFilePath, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		config.CfPath = configFilePath
 	}
-	defer util.Close(file)
-	Properties = parse(file)
-	Properties.RunID = util.RandStr(40)
-	configFilePath, err := filepath.Abs(filename)
-	if err != nil {
-		return
-	}
-	maxClinets := Properties.MaxClients
-	if maxClinets == 0 {
-		maxClinets = defaultMaxClients
-	} else {
-		if maxClinets > defaultMaxClients {
-			maxClinets = defaultMaxClients
+	if stdin != nil {
+		if err := p.parse(stdin, "(stdin)"); err != nil {
+			return err
+		}
+	}
+	if options != "" {
+		if err := p.parse(strings.NewReader(options), "(command line)"); err != nil {
+			return err
 		}
 	}
-	Properties.MaxClients = maxClinets
 
-	Properties.CfPath = configFilePath
-	if Properties.Dir == "" {
-		Properties.Dir = "."
+	config.RunID = util.RandStr(40)
+	if config.MaxClients == 0 || config.MaxClients > defaultMaxClients {
+		config.MaxClients = defaultMaxClients
 	}
+	if config.Dir == "" {
+		config.Dir = "."
+	}
+	if config.Databases == 0 {
+		config.Databases = 16
+	}
+	Properties = config
+	return nil
+}
 
-	// convert to byte
-	rewriteMinSize := Properties.AofRewriteMinSize * 1024 * 1024
-	Properties.AofRewriteMinSize = rewriteMinSize
+// AppendDirPath aof 目录的路径, 相对于 dir
+func AppendDirPath() string {
+	return filepath.Join(Properties.Dir, Properties.AppendDirname)
+}
 
-	if Properties.Databases == 0 {
-		Properties.Databases = 16
+func SetUpConfig(filename string) {
+	if err := LoadConfig(filename, nil, ""); err != nil {
+		panic(err)
 	}
 }
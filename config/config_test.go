@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "included.conf")
+	assert.Nil(t, os.WriteFile(included, []byte("maxclients 100\ntimeout 10\n"), 0644))
+	filename := filepath.Join(dir, "redis.conf")
+	content := "# comment\n  port 7000\nrequirepass \"\"\ninclude " + included + "\ntimeout 20\n" +
+		"client-output-buffer-limit normal 0 0 0 pubsub 1mb 1mb 0\n"
+	assert.Nil(t, os.WriteFile(filename, []byte(content), 0644))
+
+	err := LoadConfig(filename, strings.NewReader("maxclients 200\n"), "appendonly yes\ntimeout 30")
+	assert.Nil(t, err)
+	assert.Equal(t, 7000, Properties.Port)
+	assert.Equal(t, "", Properties.RequirePass)
+	assert.Equal(t, 200, Properties.MaxClients)
+	assert.True(t, Properties.AppendOnly)
+	assert.Equal(t, 30, Properties.Timeout)
+	assert.Equal(t, "normal 0 0 0 pubsub 1mb 1mb 0", Properties.ClientOutputBufferLimit)
+	assert.Equal(t, filename, Properties.CfPath)
+
+	// 没有配置文件时使用默认值
+	assert.Nil(t, LoadConfig("", nil, "port 7001"))
+	assert.Equal(t, 7001, Properties.Port)
+	assert.Equal(t, "0.0.0.0", Properties.Bind)
+	assert.Equal(t, "", Properties.CfPath)
+}
+
+func TestLoadConfigError(t *testing.T) {
+	dir := t.TempDir()
+	loop := filepath.Join(dir, "loop.conf")
+	assert.Nil(t, os.WriteFile(loop, []byte("port 7000\ninclude "+loop+"\n"), 0644))
+	bad := filepath.Join(dir, "bad.conf")
+	assert.Nil(t, os.WriteFile(bad, []byte("\n# comment\nmaxclients ten\n"), 0644))
+	tests := []struct {
+		content string
+		line    int
+		text    string
+	}{
+		{"port 7000\nno-such-option 1\n", 2, "no-such-option 1"},
+		{"port\n", 1, "port"},
+		{"port 7000\nport seven\n", 2, "port seven"},
+		{"appendonly true\n", 1, "appendonly true"},
+		{"auto-aof-rewrite-min-size 1xb\n", 1, "auto-aof-rewrite-min-size 1xb"},
+		{"port 7000\nappendfsync bogus\n", 2, "appendfsync bogus"},
+		{"port 70000\n", 1, "port 70000"},
+		{"client-output-buffer-limit normal 0 0\n", 1, "client-output-buffer-limit normal 0 0"},
+		{"client-output-buffer-limit foo 0 0 0\n", 1, "client-output-buffer-limit foo 0 0 0"},
+		{"client-output-buffer-limit pubsub 1xb 0 0\n", 1, "client-output-buffer-limit pubsub 1xb 0 0"},
+		{"include " + filepath.Join(dir, "missing.conf") + "\n", 1, "include " + filepath.Join(dir, "missing.conf")},
+		{"port 7000\ninclude " + loop + "\n", 2, "include " + loop},
+	}
+	filename := filepath.Join(dir, "redis.conf")
+	for _, test := range tests {
+		assert.Nil(t, os.WriteFile(filename, []byte(test.content), 0644))
+		err := LoadConfig(filename, nil, "")
+		var parseErr *ParseError
+		if assert.True(t, errors.As(err, &parseErr), test.content) {
+			assert.Equal(t, test.line, parseErr.Line, test.content)
+			assert.Equal(t, test.text, parseErr.Text, test.content)
+		}
+	}
+
+	// include 的文件中的错误指向出错的文件
+	var parseErr *ParseError
+	err := LoadConfig("", strings.NewReader("include "+bad+"\n"), "")
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, bad, parseErr.File)
+	assert.Equal(t, 3, parseErr.Line)
+
+	err = LoadConfig("", nil, "port 7000\nbind")
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "(command line)", parseErr.File)
+	assert.Equal(t, 2, parseErr.Line)
+
+	// 命令行参数和标准输入中的值也要通过校验
+	err = LoadConfig("", nil, "appendfsync bogus")
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "(command line)", parseErr.File)
+	err = LoadConfig("", strings.NewReader("appenddirname a/b\n"), "")
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "(stdin)", parseErr.File)
+}
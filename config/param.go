@@ -0,0 +1,436 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownOption = errors.New("unknown option")
+	ErrImmutable     = errors.New("can't set immutable config")
+	ErrNoConfigFile  = errors.New("the server is running without a config file")
+)
+
+// SetError CONFIG SET 失败的配置项和原因
+type SetError struct {
+	Name string
+	Err  error
+}
+
+func (e *SetError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SetError) Unwrap() error {
+	return e.Err
+}
+
+// param 配置项的元数据, 值的类型由 ServerProperties 中的字段决定, validate 在类型检查之后再校验取值范围
+type param struct {
+	// mutable 可以通过 CONFIG SET 在运行时修改
+	mutable  bool
+	validate func(value string) error
+}
+
+var params = map[string]param{
+	"runid":                       {},
+	"bind":                        {},
+	"port":                        {validate: intRange(0, 65535)},
+	"dir":                         {},
+	"appendonly":                  {},
+	"appendfilename":              {validate: notEmpty},
+	"appenddirname":               {validate: baseName},
+	"appendfsync":                 {mutable: true, validate: oneOf("always", "everysec", "no")},
+	"aof-use-rdb-preamble":        {mutable: true},
+	"aof-load-truncated":          {mutable: true},
+	"aof-timestamp-enabled":       {mutable: true},
+	"aof-load-to-timestamp":       {validate: intRange(0, math.MaxInt64)},
+	"maxclients":                  {mutable: true, validate: intRange(1, int64(defaultMaxClients))},
+	"databases":                   {validate: intRange(1, math.MaxInt32)},
+	"auto-aof-rewrite-min-size":   {mutable: true},
+	"auto-aof-rewrite-percentage": {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"proto-max-bulk-len":          {mutable: true, validate: intRange(1024*1024, math.MaxInt32)},
+	"requirepass":                 {mutable: true},
+	"aclfile":                     {},
+	"acllog-max-len":              {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"tracking-table-max-keys":     {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"timeout":                     {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"client-output-buffer-limit":  {mutable: true, validate: bufferLimits},
+	"slowlog-log-slower-than":     {mutable: true, validate: intRange(-1, math.MaxInt64)},
+	"slowlog-max-len":             {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"latency-monitor-threshold":   {mutable: true, validate: intRange(0, math.MaxInt64)},
+	"metrics-port":                {validate: intRange(0, 65535)},
+	"loglevel":                    {mutable: true, validate: oneOf("debug", "verbose", "notice", "warning", "nothing")},
+	"logfile":                     {mutable: true},
+	"log-format":                  {mutable: true, validate: oneOf("console", "json")},
+	"log-max-size":                {mutable: true},
+	"log-max-backups":             {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"log-max-age":                 {mutable: true, validate: intRange(0, math.MaxInt32)},
+	"syslog-ident":                {mutable: true},
+}
+
+// onChange 配置修改之后的热加载回调
+var onChange = make(map[string]func() error)
+
+// modified 运行时修改过的配置, CONFIG REWRITE 只改写这些配置
+var modified = make(map[string]struct{})
+
+// OnChange 注册配置修改之后的回调, 回调返回错误时这次 CONFIG SET 的所有修改都会回滚
+func OnChange(name string, fn func() error) {
+	onChange[name] = fn
+}
+
+func intRange(min, max int64) func(value string) error {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+		}
+		return nil
+	}
+}
+
+func oneOf(values ...string) func(value string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if strings.ToLower(value) == v {
+				return nil
+			}
+		}
+		return errors.New("argument(s) must be one of the following: " + strings.Join(values, ", "))
+	}
+}
+
+func notEmpty(value string) error {
+	if value == "" {
+		return errors.New("argument can't be empty")
+	}
+	return nil
+}
+
+// baseName 不能包含路径
+func baseName(value string) error {
+	if value == "" || value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+		return errors.New("must be a plain name without a path")
+	}
+	return nil
+}
+
+// bufferLimits <class> <hard> <soft> <soft seconds> [...], class 是 normal, replica(slave) 或者 pubsub
+func bufferLimits(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		switch strings.ToLower(fields[i]) {
+		case "normal", "replica", "slave", "pubsub":
+		default:
+			return errors.New("invalid client class specified in buffer limit configuration")
+		}
+		for _, size := range fields[i+1 : i+3] {
+			if _, err := util.ParseMemory(size); err != nil {
+				return err
+			}
+		}
+		if softSeconds, err := strconv.ParseInt(fields[i+3], 10, 64); err != nil || softSeconds < 0 {
+			return errors.New("soft limit seconds must be a non-negative integer")
+		}
+	}
+	return nil
+}
+
+// paramName 字段对应的配置名, 没有 cfg 标签或者标记了 omitempty 的字段不是配置项
+func paramName(field reflect.StructField) (string, bool) {
+	name, ok := field.Tag.Lookup("cfg")
+	if !ok || strings.Contains(name, ",omitempty") {
+		return "", false
+	}
+	_, ok = params[name]
+	return name, ok
+}
+
+// lookupField 根据配置名找到字段
+func lookupField(name string) (reflect.StructField, reflect.Value, bool) {
+	return lookupFieldOf(Properties, name)
+}
+
+func lookupFieldOf(config *ServerProperties, name string) (reflect.StructField, reflect.Value, bool) {
+	t := reflect.TypeOf(config).Elem()
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if fieldName, ok := paramName(t.Field(i)); ok && fieldName == name {
+			return t.Field(i), v.Field(i), true
+		}
+	}
+	return reflect.StructField{}, reflect.Value{}, false
+}
+
+// setField 按照字段的类型转换配置的值
+func setField(field reflect.StructField, fieldVal reflect.Value, value string) error {
+	switch field.Type.Kind() {
+	case reflect.String:
+		fieldVal.SetString(value)
+	case reflect.Int:
+		if unit, ok := field.Tag.Lookup("unit"); ok {
+			size, err := parseMemoryWithUnit(value, unit)
+			if err != nil {
+				return err
+			}
+			fieldVal.SetInt(size)
+			return nil
+		}
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		fieldVal.SetInt(intValue)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			fieldVal.SetBool(true)
+		case "no":
+			fieldVal.SetBool(false)
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+	case reflect.Slice:
+		if field.Type.Elem().Kind() == reflect.String {
+			slice := strings.Split(value, ",")
+			fieldVal.Set(reflect.ValueOf(slice))
+		}
+	}
+	return nil
+}
+
+// parseMemoryWithUnit 没有单位的数字按照 unit 计算
+func parseMemoryWithUnit(value, unit string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		base, _ := util.ParseMemory("1" + unit)
+		return n * base, nil
+	}
+	return util.ParseMemory(value)
+}
+
+func formatField(field reflect.StructField, fieldVal reflect.Value) string {
+	switch field.Type.Kind() {
+	case reflect.String:
+		return fieldVal.String()
+	case reflect.Int:
+		if _, ok := field.Tag.Lookup("unit"); ok {
+			return util.FormatMemory(fieldVal.Int())
+		}
+		return strconv.FormatInt(fieldVal.Int(), 10)
+	case reflect.Bool:
+		if fieldVal.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		if field.Type.Elem().Kind() == reflect.String {
+			return strings.Join(fieldVal.Interface().([]string), ",")
+		}
+	}
+	return ""
+}
+
+// Names 所有配置项的名称, 按照 ServerProperties 中字段的顺序
+func Names() []string {
+	names := make([]string, 0, len(params))
+	t := reflect.TypeOf(Properties).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := paramName(t.Field(i)); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Get 返回名称和 glob 匹配的配置项, name value 交替出现
+func Get(pattern string) []string {
+	result := make([]string, 0)
+	for _, name := range Names() {
+		if !util.StringMatch([]byte(pattern), []byte(name), true) {
+			continue
+		}
+		field, fieldVal, _ := lookupField(name)
+		result = append(result, name, formatField(field, fieldVal))
+	}
+	return result
+}
+
+// Set 修改一组配置, nameValues 中 name value 交替出现。
+// 所有的值都通过校验之后才会修改, 热加载失败时回滚所有的修改
+func Set(nameValues ...string) error {
+	if len(nameValues)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	names := make([]string, 0, len(nameValues)/2)
+	seen := make(map[string]struct{})
+	for i := 0; i < len(nameValues); i += 2 {
+		name, value := strings.ToLower(nameValues[i]), nameValues[i+1]
+		p, ok := params[name]
+		if !ok {
+			return &SetError{Name: nameValues[i], Err: ErrUnknownOption}
+		}
+		if _, dup := seen[name]; dup {
+			return &SetError{Name: name, Err: errors.New("duplicate parameter")}
+		}
+		seen[name] = struct{}{}
+		if !p.mutable {
+			return &SetError{Name: name, Err: ErrImmutable}
+		}
+		// 在副本上检查类型
+		field, fieldVal, _ := lookupField(name)
+		if err := setField(field, reflect.New(fieldVal.Type()).Elem(), value); err != nil {
+			return &SetError{Name: name, Err: err}
+		}
+		if p.validate != nil {
+			if err := p.validate(value); err != nil {
+				return &SetError{Name: name, Err: err}
+			}
+		}
+		names = append(names, name)
+	}
+
+	old := make([]string, len(names))
+	for i, name := range names {
+		field, fieldVal, _ := lookupField(name)
+		old[i] = formatField(field, fieldVal)
+		_ = setField(field, fieldVal, nameValues[2*i+1])
+	}
+	for _, name := range names {
+		fn, ok := onChange[name]
+		if !ok {
+			continue
+		}
+		if err := fn(); err != nil {
+			rollback(names, old)
+			return &SetError{Name: name, Err: err}
+		}
+	}
+	for _, name := range names {
+		modified[name] = struct{}{}
+	}
+	return nil
+}
+
+// rollback 恢复修改之前的值, 并重新执行热加载
+func rollback(names, old []string) {
+	for i, name := range names {
+		field, fieldVal, _ := lookupField(name)
+		_ = setField(field, fieldVal, old[i])
+	}
+	for _, name := range names {
+		if fn, ok := onChange[name]; ok {
+			_ = fn()
+		}
+	}
+}
+
+// Rewrite 把运行时修改过的配置写回配置文件。已经存在的配置在原来的位置修改, 注释和其他配置保持不变,
+// 文件中没有的配置追加到文件末尾
+func Rewrite() error {
+	if Properties.CfPath == "" {
+		return ErrNoConfigFile
+	}
+	content, err := os.ReadFile(Properties.CfPath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+	written := make(map[string]bool)
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		name := directiveName(line)
+		if _, ok := modified[name]; !ok {
+			result = append(result, line)
+			continue
+		}
+		// 同一个配置出现多次时只保留第一行
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		if newLine, ok := rewriteLine(name); ok {
+			result = append(result, newLine)
+		}
+	}
+	appended := false
+	for _, name := range Names() {
+		if _, ok := modified[name]; !ok || written[name] {
+			continue
+		}
+		newLine, ok := rewriteLine(name)
+		if !ok {
+			continue
+		}
+		if !appended {
+			result = append(result, "", "# Generated by CONFIG REWRITE")
+			appended = true
+		}
+		result = append(result, newLine)
+	}
+	return writeFileAtomic(Properties.CfPath, strings.Join(result, "\n")+"\n")
+}
+
+// directiveName 配置行中的配置名, 注释和空行返回空字符串
+func directiveName(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || trimmed[0] == '#' {
+		return ""
+	}
+	return strings.ToLower(strings.Fields(trimmed)[0])
+}
+
+// rewriteLine 配置的值为空时删除这一行
+func rewriteLine(name string) (string, bool) {
+	field, fieldVal, _ := lookupField(name)
+	value := formatField(field, fieldVal)
+	if value == "" {
+		return "", false
+	}
+	return name + " " + value, true
+}
+
+// writeFileAtomic 先写临时文件再重命名, 避免写到一半的时候宕机损坏配置文件
+func writeFileAtomic(filename, content string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	_, err = writer.WriteString(content)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if stat, statErr := os.Stat(filename); statErr == nil {
+			err = os.Chmod(tmp.Name(), stat.Mode())
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setUpProperties 每个测试使用独立的配置
+func setUpProperties(t *testing.T, content string) {
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	assert.Nil(t, os.WriteFile(filename, []byte(content), 0644))
+	SetUpConfig(filename)
+	modified = make(map[string]struct{})
+	onChange = make(map[string]func() error)
+}
+
+func TestEveryFieldHasParam(t *testing.T) {
+	typ := reflect.TypeOf(ServerProperties{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, ok := typ.Field(i).Tag.Lookup("cfg")
+		if !ok || strings.Contains(name, ",omitempty") {
+			continue
+		}
+		_, ok = params[name]
+		assert.True(t, ok, name)
+	}
+}
+
+func TestConfigGet(t *testing.T) {
+	setUpProperties(t, "port 6400\nauto-aof-rewrite-min-size 64\nappendonly yes\n")
+	assert.Equal(t, []string{"port", "6400"}, Get("port"))
+	assert.Equal(t, []string{"auto-aof-rewrite-min-size", "64mb"}, Get("auto-aof-rewrite-min-*"))
+	assert.Equal(t, []string{"appendonly", "yes", "appendfilename", "appendonly.aof", "appenddirname", "appendonlydir",
+		"appendfsync", "everysec"}, Get("APPEND*"))
+	assert.Equal(t, 64*1024*1024, Properties.AofRewriteMinSize)
+	assert.Empty(t, Get("no-such-*"))
+	assert.Len(t, Get("*"), 2*len(Names()))
+	// 和 KEYS、ACL 一样使用 redis 的 glob 规则, 没有闭合的 [ 不会让整个模式失效
+	assert.Equal(t, []string{"port", "6400"}, Get("p[o]r[t"))
+	assert.Equal(t, []string{"port", "6400"}, Get("P?RT"))
+}
+
+func TestConfigSet(t *testing.T) {
+	setUpProperties(t, "maxclients 100\n")
+	assert.Nil(t, Set("maxclients", "200", "auto-aof-rewrite-min-size", "1gb"))
+	assert.Equal(t, 200, Properties.MaxClients)
+	assert.Equal(t, 1024*1024*1024, Properties.AofRewriteMinSize)
+
+	var setErr *SetError
+	err := Set("no-such-option", "1")
+	assert.True(t, errors.Is(err, ErrUnknownOption))
+	assert.True(t, errors.As(err, &setErr))
+	assert.Equal(t, "no-such-option", setErr.Name)
+	assert.True(t, errors.Is(Set("port", "6400"), ErrImmutable))
+	for _, nameValues := range [][]string{
+		{"maxclients", "abc"},
+		{"maxclients", "0"},
+		{"appendfsync", "sometimes"},
+		{"timeout", "1", "timeout", "2"},
+		// 一个值不合法时其他的配置也不会修改
+		{"timeout", "10", "maxclients", "-1"},
+	} {
+		assert.NotNil(t, Set(nameValues...), nameValues)
+	}
+	assert.Equal(t, 200, Properties.MaxClients)
+	assert.Equal(t, 0, Properties.Timeout)
+
+	// 热加载失败时回滚
+	calls := 0
+	OnChange("timeout", func() error {
+		calls++
+		if Properties.Timeout == 10 {
+			return errors.New("reload failed")
+		}
+		return nil
+	})
+	err = Set("maxclients", "300", "timeout", "10")
+	assert.True(t, errors.As(err, &setErr))
+	assert.Equal(t, "timeout", setErr.Name)
+	assert.Equal(t, 200, Properties.MaxClients)
+	assert.Equal(t, 0, Properties.Timeout)
+	assert.Equal(t, 2, calls)
+}
+
+func TestConfigRewrite(t *testing.T) {
+	content := "# 注释\nport 6389\n\n# 最大连接数\nmaxclients 100\nmaxclients 200\nrequirepass foo\n"
+	setUpProperties(t, content)
+	assert.Nil(t, Set("maxclients", "300", "timeout", "60", "requirepass", ""))
+	assert.Nil(t, Rewrite())
+	data, err := os.ReadFile(Properties.CfPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "# 注释\nport 6389\n\n# 最大连接数\nmaxclients 300\n\n# Generated by CONFIG REWRITE\ntimeout 60\n",
+		string(data))
+
+	// 改写之后的配置文件可以再次加载
+	SetUpConfig(Properties.CfPath)
+	assert.Equal(t, 300, Properties.MaxClients)
+	assert.Equal(t, 60, Properties.Timeout)
+
+	Properties.CfPath = ""
+	assert.True(t, errors.Is(Rewrite(), ErrNoConfigFile))
+}
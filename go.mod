@@ -6,6 +6,7 @@ require (
 	github.com/panjf2000/gnet/v2 v2.5.0
 	github.com/stretchr/testify v1.8.4
 	go.uber.org/zap v1.21.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
 
 require (
@@ -16,6 +17,5 @@ require (
 	go.uber.org/multierr v1.7.0 // indirect
 	golang.org/x/sync v0.6.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
-	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
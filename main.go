@@ -1,61 +1,144 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/xuning888/godis-tiny/config"
 	"github.com/xuning888/godis-tiny/pkg/logger"
-	"github.com/xuning888/godis-tiny/pkg/util"
 	"github.com/xuning888/godis-tiny/redis"
+	"io"
 	"os"
+	"strings"
 )
 
 var serverName = "godis-tiny"
 
-var defaultConfig = &config.ServerProperties{
-	Bind:           "0.0.0.0",
-	Port:           6389,
-	AppendOnly:     false,
-	AppendFilename: "",
-	RunID:          util.RandStr(40),
-}
-
 func fileExists(filename string) bool {
 	stat, err := os.Stat(filename)
 	return err == nil && !stat.IsDir()
 }
 
-func setupConfiguration(configPath string) {
-	if fileExists(configPath) {
-		config.SetUpConfig(configPath)
-		logger.Infof("Loaded configuration from %s", configPath)
-	} else {
+// parseArgs 解析 [/path/to/redis.conf] [-] [--name value ...], 命令行参数转换为每行一个的配置
+func parseArgs(args []string) (configPath string, fromStdin bool, options string, err error) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		configPath = args[0]
+		args = args[1:]
+	}
+	lines := make([]string, 0)
+	for _, arg := range args {
+		switch {
+		case arg == "-":
+			fromStdin = true
+		case strings.HasPrefix(arg, "--") && len(arg) > 2:
+			lines = append(lines, arg[2:])
+		case len(lines) == 0:
+			return "", false, "", fmt.Errorf("unexpected argument '%s'", arg)
+		default:
+			// 一个配置可以有多个值, 例如 --client-output-buffer-limit normal 0 0 0
+			if arg == "" {
+				arg = `""`
+			}
+			lines[len(lines)-1] += " " + arg
+		}
+	}
+	return configPath, fromStdin, strings.Join(lines, "\n"), nil
+}
+
+func setupConfiguration(args []string) error {
+	configPath, fromStdin, options, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+	if configPath == "" && len(args) == 0 {
+		configPath = "redis.conf"
+	}
+	if configPath != "" && !fileExists(configPath) {
 		logger.Infof("Config file '%s' not found. Using default settings.", configPath)
-		config.Properties = defaultConfig
+		configPath = ""
+	}
+	var stdin io.Reader
+	if fromStdin {
+		stdin = os.Stdin
+	}
+	if err = config.LoadConfig(configPath, stdin, options); err != nil {
+		return err
+	}
+	if configPath != "" {
+		logger.Infof("Loaded configuration from %s", configPath)
 	}
+	return nil
+}
+
+// logOptions 日志相关的配置, lumberjack 轮转的大小以 MB 为单位, 最小 1MB
+func logOptions() logger.Options {
+	maxSize := config.Properties.LogMaxSize / (1024 * 1024)
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	return logger.Options{
+		Level:      config.Properties.LogLevel,
+		File:       config.Properties.LogFile,
+		Format:     config.Properties.LogFormat,
+		MaxSize:    maxSize,
+		MaxBackups: config.Properties.LogMaxBackups,
+		MaxAge:     config.Properties.LogMaxAge,
+		Ident:      config.Properties.SyslogIdent,
+	}
+}
+
+// setupLogger 按照配置初始化日志, CONFIG SET 修改日志的配置之后重新初始化
+func setupLogger() error {
+	apply := func() error {
+		return logger.Configure(logOptions())
+	}
+	for _, name := range []string{"loglevel", "logfile", "log-format", "log-max-size", "log-max-backups",
+		"log-max-age", "syslog-ident"} {
+		config.OnChange(name, apply)
+	}
+	return apply()
 }
 
 func printHelp() {
 	helpText := `Usage: ./` + serverName + ` [/path/to/redis.conf] [options] [-]
+       ./` + serverName + ` - (read config from stdin)
        ./` + serverName + ` -h or --help
+       ./` + serverName + ` check-aof [--fix|--truncate-to-timestamp <timestamp>] <file.manifest|file.aof>
+
+Examples:
+       ./` + serverName + ` (run the server with redis.conf in the working directory)
+       ./` + serverName + ` /etc/redis/6379.conf
+       ./` + serverName + ` --port 7777
+       ./` + serverName + ` /etc/myredis.conf --appendonly yes
+       echo 'maxclients 100' | ./` + serverName + ` /etc/myredis.conf -
 `
 	fmt.Print(helpText)
 }
 
 func main() {
+	if args, ok := isCheckAof(os.Args); ok {
+		os.Exit(checkAofMain(args))
+	}
 	logger.InitLogger()
 	args := os.Args[1:]
-	if len(args) > 0 {
-		for _, arg := range args {
-			switch arg {
-			case "-h", "--help":
-				printHelp()
-				return
-			default:
-				setupConfiguration(arg)
-			}
+	for _, arg := range args {
+		if arg == "-h" || arg == "--help" {
+			printHelp()
+			return
 		}
-	} else {
-		setupConfiguration("redis.conf")
+	}
+	if err := setupConfiguration(args); err != nil {
+		var parseErr *config.ParseError
+		if errors.As(err, &parseErr) {
+			fmt.Fprintf(os.Stderr, "\n*** FATAL CONFIG FILE ERROR ***\nReading the configuration file, at line %d of %s\n>>> '%s'\n%v\n",
+				parseErr.Line, parseErr.File, parseErr.Text, parseErr.Err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Fatal error, can't load config: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	if err := setupLogger(); err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error, can't setup logger: %v\n", err)
+		os.Exit(1)
 	}
 	s := redis.NewRedisServer()
 	s.Spin()
@@ -0,0 +1,135 @@
+package hdr
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// subBucketBits 每个 2 的幂次区间被线性的分成 2^subBucketBits 份, 相对误差不超过 1/64
+	subBucketBits  = 6
+	subBucketCount = 1 << subBucketBits
+	// exactLimit 小于这个值的数字精确记录
+	exactLimit = subBucketCount << 1
+	// MaxValue 超过这个值的数字按照 MaxValue 记录
+	MaxValue = 1<<40 - 1
+)
+
+// Histogram HDR 风格的直方图, 记录 [0, MaxValue] 范围内的值。
+// 小的数字精确记录, 大的数字按照 2 的幂次分段, 每一段内线性划分, 占用的内存是固定的
+type Histogram struct {
+	counts     []int64
+	totalCount int64
+	min        int64
+	max        int64
+}
+
+func New() *Histogram {
+	return &Histogram{
+		counts: make([]int64, bucketIndex(MaxValue)+1),
+		min:    math.MaxInt64,
+	}
+}
+
+// bucketIndex 值所在的桶
+func bucketIndex(value int64) int {
+	if value < exactLimit {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - (subBucketBits + 1)
+	sub := value >> shift
+	return exactLimit + (shift-1)*subBucketCount + int(sub-subBucketCount)
+}
+
+// lowestValue 桶中最小的值
+func lowestValue(index int) int64 {
+	if index < exactLimit {
+		return int64(index)
+	}
+	shift := (index-exactLimit)/subBucketCount + 1
+	sub := int64((index-exactLimit)%subBucketCount + subBucketCount)
+	return sub << shift
+}
+
+// highestValue 桶中最大的值
+func highestValue(index int) int64 {
+	return lowestValue(index+1) - 1
+}
+
+func (h *Histogram) Record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	if value > MaxValue {
+		value = MaxValue
+	}
+	h.counts[bucketIndex(value)]++
+	h.totalCount++
+	if value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+}
+
+func (h *Histogram) TotalCount() int64 {
+	return h.totalCount
+}
+
+// Min 没有记录时返回 0
+func (h *Histogram) Min() int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// ValueAtPercentile 返回不小于 percentile% 的记录的值, 结果是所在桶中最大的值
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(h.totalCount)))
+	if target < 1 {
+		target = 1
+	}
+	var cumulative int64
+	for i, count := range h.counts {
+		cumulative += count
+		if cumulative >= target {
+			value := highestValue(i)
+			if value > h.max {
+				value = h.max
+			}
+			return value
+		}
+	}
+	return h.max
+}
+
+// ForEachPowerOfTwo 依次返回 1, 2, 4 ... 以及小于等于这个值的记录数, 直到覆盖最大的记录
+func (h *Histogram) ForEachPowerOfTwo(fn func(bound int64, cumulative int64)) {
+	if h.totalCount == 0 {
+		return
+	}
+	var cumulative int64
+	index := 0
+	for bound := int64(1); ; bound <<= 1 {
+		for index < len(h.counts) && lowestValue(index) <= bound {
+			cumulative += h.counts[index]
+			index++
+		}
+		fn(bound, cumulative)
+		if bound >= h.max {
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package hdr
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBucketIndex(t *testing.T) {
+	for _, value := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 123456789, MaxValue} {
+		index := bucketIndex(value)
+		if lowestValue(index) > value || highestValue(index) < value {
+			t.Fatalf("value %d out of bucket %d [%d, %d]", value, index, lowestValue(index), highestValue(index))
+		}
+	}
+	for i := 1; i < bucketIndex(MaxValue); i++ {
+		if lowestValue(i) != highestValue(i-1)+1 {
+			t.Fatalf("bucket %d is not continuous", i)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	h := New()
+	values := make([]int64, 10000)
+	for i := range values {
+		values[i] = rand.Int63n(1000000)
+		h.Record(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, percentile := range []float64{50, 99, 99.9, 100} {
+		exact := values[int(percentile/100*float64(len(values)))-1]
+		got := h.ValueAtPercentile(percentile)
+		if got < exact || float64(got-exact) > float64(exact)/subBucketCount+1 {
+			t.Fatalf("p%v: got %d, exact %d", percentile, got, exact)
+		}
+	}
+	if h.TotalCount() != int64(len(values)) || h.Min() != values[0] || h.Max() != values[len(values)-1] {
+		t.Fatalf("count %d, min %d, max %d", h.TotalCount(), h.Min(), h.Max())
+	}
+}
+
+func TestForEachPowerOfTwo(t *testing.T) {
+	h := New()
+	for _, value := range []int64{1, 3, 3, 8, 9, 100} {
+		h.Record(value)
+	}
+	expected := map[int64]int64{1: 1, 2: 1, 4: 3, 8: 4, 16: 5, 32: 5, 64: 5, 128: 6}
+	got := make(map[int64]int64)
+	h.ForEachPowerOfTwo(func(bound int64, cumulative int64) {
+		got[bound] = cumulative
+	})
+	if len(got) != len(expected) {
+		t.Fatalf("got %v", got)
+	}
+	for bound, cumulative := range expected {
+		if got[bound] != cumulative {
+			t.Fatalf("bound %d: got %d, expected %d", bound, got[bound], cumulative)
+		}
+	}
+}
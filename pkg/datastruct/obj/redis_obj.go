@@ -215,7 +215,7 @@ func StringObjEncoding(obj *RedisObject) (result []byte, err error) {
 		return nil, ErrorObjectType
 	}
 	switch obj.Encoding {
-	case EncEmbStr | EncRaw:
+	case EncEmbStr, EncRaw:
 		sdss := obj.Ptr.(*sds.Sds)
 		result = *sdss
 		break
@@ -234,7 +234,7 @@ func StringObjMem(obj *RedisObject) (int64, error) {
 	}
 	sizeof := int64(unsafe.Sizeof(*obj)) + 8
 	switch obj.Encoding {
-	case EncRaw | EncEmbStr:
+	case EncRaw, EncEmbStr:
 		sdss := obj.Ptr.(*sds.Sds)
 		return sizeof + int64(sdss.Memory()) + int64(8), nil
 	case EncInt:
@@ -4,58 +4,206 @@ import (
 	"fmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var globalLogger *zap.Logger
 
-func InitLogger() {
-	lgCfg := DefaultZapLoggerConfig
-	logger, err := lgCfg.Build()
-	if err != nil {
+// globalSugar 包级别的 Debugf, Infof 等函数使用, 跳过一层调用者
+var globalSugar *zap.SugaredLogger
+
+// Options 日志的配置, 对应 redis.conf 中的 loglevel, logfile, log-format, log-max-* 和 syslog-ident
+type Options struct {
+	// Level debug, verbose, notice, warning 或者 nothing
+	Level string
+	// File 日志文件, 为空时输出到标准错误
+	File string
+	// Format console 或者 json
+	Format string
+	// MaxSize 日志文件达到多少 MB 之后轮转
+	MaxSize int
+	// MaxBackups 最多保留多少个轮转之后的文件, 0 表示全部保留
+	MaxBackups int
+	// MaxAge 轮转之后的文件最多保留多少天, 0 表示不按时间删除
+	MaxAge int
+	// Ident 每一行日志都带上的名称, 用来区分同一台机器上的多个实例, 为空时不输出
+	Ident string
+}
+
+// DefaultOptions 输出到标准错误的 console 格式的日志
+var DefaultOptions = Options{Level: "notice", Format: "console", MaxSize: 100}
+
+var DefaultLevel = zap.InfoLevel
+
+// level 所有的 logger 共享, 修改 loglevel 之后马上生效
+var level = zap.NewAtomicLevelAt(DefaultLevel)
+
+// DefaultEncoderConfig copied from "zap.NewProductionEncoderConfig" with some updates
+var DefaultEncoderConfig = zapcore.EncoderConfig{
+	TimeKey:       "ts",
+	LevelKey:      "level",
+	NameKey:       "logger",
+	CallerKey:     "caller",
+	MessageKey:    "msg",
+	StacktraceKey: "stacktrace",
+	LineEnding:    zapcore.DefaultLineEnding,
+	EncodeLevel:   zapcore.LowercaseLevelEncoder,
+
+	// Custom EncodeTime function to ensure we match format and precision of historic capnslog timestamps
+	EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendString(t.Format("2006-01-02 15:04:05.000"))
+	},
+
+	EncodeDuration: zapcore.StringDurationEncoder,
+	EncodeCaller:   zapcore.ShortCallerEncoder,
+}
+
+// levels redis 的日志级别, verbose 和 debug 一样输出所有的日志, nothing 关闭日志
+var levels = map[string]zapcore.Level{
+	"debug":   zapcore.DebugLevel,
+	"verbose": zapcore.DebugLevel,
+	"notice":  zapcore.InfoLevel,
+	"warning": zapcore.WarnLevel,
+	"nothing": zapcore.FatalLevel + 1,
+}
+
+// ParseLevel 把 redis 的日志级别转换为 zap 的日志级别
+func ParseLevel(name string) (zapcore.Level, error) {
+	lvl, ok := levels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid log level '%s'", name)
+	}
+	return lvl, nil
+}
+
+// swappableCore 已经创建的 logger 都指向同一个 swappableCore, 修改日志的配置时只需要替换里面的 core
+type swappableCore struct {
+	current *atomic.Value
+	fields  []zapcore.Field
+}
+
+func (c *swappableCore) core() zapcore.Core {
+	core := c.current.Load().(zapcore.Core)
+	if len(c.fields) > 0 {
+		core = core.With(c.fields)
+	}
+	return core
+}
+
+func (c *swappableCore) Enabled(lvl zapcore.Level) bool {
+	return level.Enabled(lvl)
+}
+
+func (c *swappableCore) With(fields []zapcore.Field) zapcore.Core {
+	merged := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	merged = append(merged, c.fields...)
+	merged = append(merged, fields...)
+	return &swappableCore{current: c.current, fields: merged}
+}
+
+func (c *swappableCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core().Check(entry, checked)
+}
+
+func (c *swappableCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(entry, fields)
+}
+
+func (c *swappableCore) Sync() error {
+	return c.core().Sync()
+}
+
+var (
+	// configureMux 保证同一时间只有一个 Configure 在替换 core
+	configureMux sync.Mutex
+	currentCore  = &atomic.Value{}
+	// output 当前的日志文件, 替换之后关闭
+	output io.Closer
+)
+
+func init() {
+	if err := Configure(DefaultOptions); err != nil {
 		panic(fmt.Errorf("init logger failed: %w", err))
 	}
-	zap.ReplaceGlobals(logger)
-	globalLogger = logger
+	globalLogger = zap.New(&swappableCore{current: currentCore}, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	globalSugar = globalLogger.WithOptions(zap.AddCallerSkip(1)).Sugar()
+	zap.ReplaceGlobals(globalLogger)
 }
 
-func Named(name string) Logger {
-	sugar := globalLogger.Named(name).Sugar()
-	return sugar
+// InitLogger 使用默认的配置
+func InitLogger() {
+	if err := Configure(DefaultOptions); err != nil {
+		panic(fmt.Errorf("init logger failed: %w", err))
+	}
 }
 
-var DefaultLevel = zap.InfoLevel
+// Configure 按照 opts 重新创建日志的输出, 已经通过 Named 创建的 logger 也会使用新的配置。
+// 出错时保持原来的配置
+func Configure(opts Options) error {
+	lvl, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	var encoder zapcore.Encoder
+	switch strings.ToLower(opts.Format) {
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(DefaultEncoderConfig)
+	case "json":
+		encoder = zapcore.NewJSONEncoder(DefaultEncoderConfig)
+	default:
+		return fmt.Errorf("invalid log format '%s'", opts.Format)
+	}
 
-var DefaultZapLoggerConfig = zap.Config{
-	Level:       zap.NewAtomicLevelAt(DefaultLevel),
-	Development: true,
-	Sampling: &zap.SamplingConfig{
-		Initial:    100,
-		Thereafter: 100,
-	},
+	var writer zapcore.WriteSyncer
+	var closer io.Closer
+	if opts.File == "" {
+		writer = zapcore.Lock(os.Stderr)
+	} else {
+		// lumberjack 在第一次写的时候才打开文件, 先检查文件是否可以写
+		file, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("can't open the log file: %w", err)
+		}
+		_ = file.Close()
+		rotated := &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSize,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAge,
+			LocalTime:  true,
+		}
+		writer = zapcore.AddSync(rotated)
+		closer = rotated
+	}
 
-	Encoding: "console",
-
-	// copied from "zap.NewProductionEncoderConfig" with some updates
-	EncoderConfig: zapcore.EncoderConfig{
-		TimeKey:       "ts",
-		LevelKey:      "level",
-		NameKey:       "logger",
-		CallerKey:     "caller",
-		MessageKey:    "msg",
-		StacktraceKey: "stacktrace",
-		LineEnding:    zapcore.DefaultLineEnding,
-		EncodeLevel:   zapcore.LowercaseLevelEncoder,
-
-		// Custom EncodeTime function to ensure we match format and precision of historic capnslog timestamps
-		EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-			enc.AppendString(t.Format("2006-01-02 15:04:05.000"))
-		},
-
-		EncodeDuration: zapcore.StringDurationEncoder,
-		EncodeCaller:   zapcore.ShortCallerEncoder,
-	},
+	var core zapcore.Core = zapcore.NewCore(encoder, writer, level)
+	core = zapcore.NewSamplerWithOptions(core, time.Second, 100, 100)
+	if opts.Ident != "" {
+		core = core.With([]zapcore.Field{zap.String("ident", opts.Ident)})
+	}
 
-	OutputPaths:      []string{"stderr"},
-	ErrorOutputPaths: []string{"stderr"},
+	configureMux.Lock()
+	defer configureMux.Unlock()
+	if old := currentCore.Load(); old != nil {
+		_ = old.(zapcore.Core).Sync()
+	}
+	currentCore.Store(core)
+	level.SetLevel(lvl)
+	if output != nil {
+		_ = output.Close()
+	}
+	output = closer
+	return nil
+}
+
+func Named(name string) Logger {
+	sugar := globalLogger.Named(name).Sugar()
+	return sugar
 }
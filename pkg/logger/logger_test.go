@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	lvl, err := ParseLevel("Warning")
+	assert.Nil(t, err)
+	assert.Equal(t, zapcore.WarnLevel, lvl)
+	lvl, _ = ParseLevel("verbose")
+	assert.Equal(t, zapcore.DebugLevel, lvl)
+	_, err = ParseLevel("info")
+	assert.NotNil(t, err)
+}
+
+func readLines(t *testing.T, filename string) []string {
+	content, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestConfigure(t *testing.T) {
+	defer func() {
+		assert.Nil(t, Configure(DefaultOptions))
+	}()
+	filename := filepath.Join(t.TempDir(), "godis.log")
+	lg := Named("test")
+
+	assert.Nil(t, Configure(Options{Level: "notice", File: filename, Format: "json", MaxSize: 1, Ident: "godis-6379"}))
+	lg.Debugf("hidden")
+	lg.Infof("hello %s", "world")
+	Warnf("package %d", 1)
+	lines := readLines(t, filename)
+	assert.Equal(t, 2, len(lines))
+	entry := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "hello world", entry["msg"])
+	assert.Equal(t, "test", entry["logger"])
+	assert.Equal(t, "godis-6379", entry["ident"])
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "package 1", entry["msg"])
+	assert.Contains(t, entry["caller"], "logger_test.go")
+
+	// 已经创建的 logger 使用新的级别和格式
+	assert.Nil(t, Configure(Options{Level: "debug", File: filename, Format: "console", MaxSize: 1}))
+	lg.Debugf("visible")
+	lines = readLines(t, filename)
+	assert.Equal(t, 3, len(lines))
+	assert.Contains(t, lines[2], "\tdebug\ttest\t")
+	assert.NotContains(t, lines[2], "godis-6379")
+
+	// 出错时保持原来的配置
+	assert.NotNil(t, Configure(Options{Level: "notice", Format: "xml"}))
+	assert.NotNil(t, Configure(Options{Level: "notice", Format: "json", File: filepath.Join(filename, "dir", "x.log")}))
+	lg.Debugf("still debug")
+	assert.Equal(t, 4, len(readLines(t, filename)))
+}
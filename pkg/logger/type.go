@@ -21,38 +21,38 @@ type Logger interface {
 
 // Debugf logs messages at DEBUG level.
 func Debugf(format string, args ...interface{}) {
-	globalLogger.Sugar().Debugf(format, args)
+	globalSugar.Debugf(format, args...)
 }
 
 // Infof logs messages at INFO level.
 func Infof(format string, args ...interface{}) {
-	globalLogger.Sugar().Infof(format, args)
+	globalSugar.Infof(format, args...)
 }
 
 func Info(args ...interface{}) {
-	globalLogger.Sugar().Info(args)
+	globalSugar.Info(args...)
 }
 
 // Warnf logs messages at WARN level.
 func Warnf(format string, args ...interface{}) {
-	globalLogger.Sugar().Warnf(format, args)
+	globalSugar.Warnf(format, args...)
 }
 
 // Errorf logs messages at ERROR level.
 func Errorf(format string, args ...interface{}) {
-	globalLogger.Sugar().Errorf(format, args)
+	globalSugar.Errorf(format, args...)
 }
 
 func Error(args ...interface{}) {
-	globalLogger.Sugar().Error(args)
+	globalSugar.Error(args...)
 }
 
 // Fatalf logs messages at FATAL level.
 func Fatalf(format string, args ...interface{}) {
-	globalLogger.Sugar().Fatalf(format, args)
+	globalSugar.Fatalf(format, args...)
 }
 
 // Sync sync
 func Sync() error {
-	return globalLogger.Sugar().Sync()
+	return globalSugar.Sync()
 }
@@ -0,0 +1,534 @@
+// Package rdb 读写 redis rdb 格式的一个子集: 字符串、列表、集合和哈希的普通编码, 以及过期时间、select 和辅助字段。
+// 用于 aof 的 rdb 前导, 生成的文件可以被 redis 加载
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Magic rdb 文件的开头, 后面是 4 个字符的版本号
+const Magic = "REDIS"
+
+// Version 写出的 rdb 版本, 和 redis 7.0 相同
+const Version = 10
+
+// 对象的类型
+const (
+	TypeString = 0
+	TypeList   = 1
+	TypeSet    = 2
+	TypeHash   = 4
+)
+
+// 操作码
+const (
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+// 长度编码的前两位
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	encVal   = 3
+)
+
+// 特殊编码的字符串
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLzf   = 3
+)
+
+var (
+	ErrBadMagic         = errors.New("rdb: wrong signature")
+	ErrChecksumMismatch = errors.New("rdb: checksum mismatch")
+)
+
+// crcTable redis 使用 Jones 多项式的 crc64, 这里是它的反转形式
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// crc64Update redis 的 crc64 初始值为 0 并且结果不取反, hash/crc64 在开始和结束时都会取反
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+// Checksum redis 的 crc64
+func Checksum(p []byte) uint64 {
+	return crc64Update(0, p)
+}
+
+// Encoder 写出 rdb, 出错之后的写入都会被忽略, 最后通过 Err 检查
+type Encoder struct {
+	w   io.Writer
+	crc uint64
+	err error
+	buf [9]byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+func (e *Encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = crc64Update(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+// WriteRaw 写入另一个 Encoder 编码好的数据, 计入校验和
+func (e *Encoder) WriteRaw(p []byte) {
+	e.write(p)
+}
+
+func (e *Encoder) writeByte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
+
+// WriteHeader REDIS 加上 4 位的版本号
+func (e *Encoder) WriteHeader() {
+	e.write([]byte(fmt.Sprintf("%s%04d", Magic, Version)))
+}
+
+// WriteAux 辅助字段, 例如 redis-ver, ctime
+func (e *Encoder) WriteAux(key, value string) {
+	e.writeByte(opAux)
+	e.WriteString([]byte(key))
+	e.WriteString([]byte(value))
+}
+
+func (e *Encoder) WriteSelectDB(index int) {
+	e.writeByte(opSelectDB)
+	e.WriteLength(uint64(index))
+}
+
+// WriteExpireTimeMs 下一个 key 的过期时间, unix 毫秒
+func (e *Encoder) WriteExpireTimeMs(ms int64) {
+	e.writeByte(opExpireTimeMs)
+	binary.LittleEndian.PutUint64(e.buf[:8], uint64(ms))
+	e.write(e.buf[:8])
+}
+
+// WriteType 对象的类型, 后面是 key 和值
+func (e *Encoder) WriteType(t byte) {
+	e.writeByte(t)
+}
+
+func (e *Encoder) WriteLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.buf[0] = byte(n>>8) | len14Bit<<6
+		e.buf[1] = byte(n)
+		e.write(e.buf[:2])
+	case n <= math.MaxUint32:
+		e.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(e.buf[1:5], uint32(n))
+		e.write(e.buf[:5])
+	default:
+		e.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(e.buf[1:9], n)
+		e.write(e.buf[:9])
+	}
+}
+
+// WriteString 可以转换为 32 位整数的字符串使用整数编码
+func (e *Encoder) WriteString(p []byte) {
+	if len(p) > 0 && len(p) <= 11 {
+		if value, err := strconv.ParseInt(string(p), 10, 32); err == nil && strconv.FormatInt(value, 10) == string(p) {
+			e.writeInt(value)
+			return
+		}
+	}
+	e.WriteLength(uint64(len(p)))
+	e.write(p)
+}
+
+func (e *Encoder) writeInt(value int64) {
+	switch {
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		e.buf[0] = encVal<<6 | encInt8
+		e.buf[1] = byte(int8(value))
+		e.write(e.buf[:2])
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		e.buf[0] = encVal<<6 | encInt16
+		binary.LittleEndian.PutUint16(e.buf[1:3], uint16(int16(value)))
+		e.write(e.buf[:3])
+	default:
+		e.buf[0] = encVal<<6 | encInt32
+		binary.LittleEndian.PutUint32(e.buf[1:5], uint32(int32(value)))
+		e.write(e.buf[:5])
+	}
+}
+
+// WriteEOF 结束标记和校验和
+func (e *Encoder) WriteEOF() {
+	e.writeByte(opEOF)
+	if e.err != nil {
+		return
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	_, e.err = e.w.Write(e.buf[:8])
+}
+
+// Entry rdb 中的一个 key
+type Entry struct {
+	DB   int
+	Key  []byte
+	Type byte
+	// Value 字符串是 []byte, 列表和集合是 [][]byte, 哈希是 field value 交替出现的 [][]byte
+	Value interface{}
+	// ExpireAt 过期时间, unix 毫秒, 没有过期时间时为 -1
+	ExpireAt int64
+}
+
+// Decoder 读取 rdb, 读到结束标记之后停止, 之后的数据还可以从同一个 reader 中继续读取
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+	db      int
+	// Offset 已经读取的字节数
+	Offset int64
+	// Aux 读到的辅助字段
+	Aux map[string]string
+	buf [8]byte
+}
+
+func NewDecoder(r *bufio.Reader) *Decoder {
+	return &Decoder{r: r, Aux: make(map[string]string)}
+}
+
+// IsRdb reader 的开头是不是 rdb 的签名
+func IsRdb(r *bufio.Reader) bool {
+	p, err := r.Peek(len(Magic))
+	return err == nil && string(p) == Magic
+}
+
+func (d *Decoder) readFull(p []byte) error {
+	n, err := io.ReadFull(d.r, p)
+	d.Offset += int64(n)
+	d.crc = crc64Update(d.crc, p[:n])
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readChunkSize 长度来自文件, 文件损坏时可能非常大, 超过它的数据边读边扩容, 不会在读到数据之前按照长度分配内存
+const readChunkSize = 64 * 1024
+
+// readBytes 读取 n 个字节, 文件中剩余的数据不够时返回 io.ErrUnexpectedEOF
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if n <= readChunkSize {
+		p := make([]byte, n)
+		return p, d.readFull(p)
+	}
+	p := make([]byte, 0, readChunkSize)
+	for len(p) < n {
+		if len(p) == cap(p) {
+			p = append(p, 0)[:len(p)]
+		}
+		end := cap(p)
+		if end > n {
+			end = n
+		}
+		if err := d.readFull(p[len(p):end]); err != nil {
+			return nil, err
+		}
+		p = p[:end]
+	}
+	return p, nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if err := d.readFull(d.buf[:1]); err != nil {
+		return 0, err
+	}
+	return d.buf[0], nil
+}
+
+// ReadHeader 检查签名和版本
+func (d *Decoder) ReadHeader() error {
+	header := make([]byte, len(Magic)+4)
+	if err := d.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:len(Magic)]) != Magic {
+		return ErrBadMagic
+	}
+	version, err := strconv.Atoi(string(header[len(Magic):]))
+	if err != nil || version < 1 || version > Version {
+		return fmt.Errorf("rdb: can't handle RDB format version %s", header[len(Magic):])
+	}
+	d.version = version
+	return nil
+}
+
+// readLength 返回长度, 或者 encoded 为 true 时返回特殊编码的类型
+func (d *Decoder) readLength() (length uint64, encoded bool, err error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3F), false, nil
+	case len14Bit:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case encVal:
+		return uint64(first & 0x3F), true, nil
+	}
+	switch first {
+	case len32Bit:
+		if err = d.readFull(d.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(d.buf[:4])), false, nil
+	case len64Bit:
+		if err = d.readFull(d.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(d.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("rdb: unknown length encoding %d", first)
+}
+
+func (d *Decoder) readLen() (int, error) {
+	length, encoded, err := d.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || length > math.MaxInt32 {
+		return 0, fmt.Errorf("rdb: invalid length")
+	}
+	return int(length), nil
+}
+
+func (d *Decoder) readString() ([]byte, error) {
+	length, encoded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if length > math.MaxInt32 {
+			return nil, fmt.Errorf("rdb: string too long")
+		}
+		return d.readBytes(int(length))
+	}
+	switch length {
+	case encInt8:
+		b, err := d.readByte()
+		return strconv.AppendInt(nil, int64(int8(b)), 10), err
+	case encInt16:
+		err = d.readFull(d.buf[:2])
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(d.buf[:2]))), 10), err
+	case encInt32:
+		err = d.readFull(d.buf[:4])
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(d.buf[:4]))), 10), err
+	case encLzf:
+		return d.readLzf()
+	}
+	return nil, fmt.Errorf("rdb: unknown string encoding %d", length)
+}
+
+// readLzf redis 开启 rdbcompression 时较长的字符串使用 lzf 压缩
+func (d *Decoder) readLzf() ([]byte, error) {
+	compressedLen, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	length, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := d.readBytes(compressedLen)
+	if err != nil {
+		return nil, err
+	}
+	return lzfDecompress(compressed, length)
+}
+
+// lzfDecompress length 来自文件, 不能直接按照它分配内存, 解压出的数据超过 length 时马上停止
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	capacity := length
+	if capacity > readChunkSize {
+		capacity = readChunkSize
+	}
+	out := make([]byte, 0, capacity)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// 字面量
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > length {
+				return nil, errors.New("rdb: invalid lzf data")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// 回溯引用
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errors.New("rdb: invalid lzf data")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("rdb: invalid lzf data")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 || len(out)+n+2 > length {
+			return nil, errors.New("rdb: invalid lzf data")
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, errors.New("rdb: invalid lzf data")
+	}
+	return out, nil
+}
+
+func (d *Decoder) readStrings(n int) ([][]byte, error) {
+	// n 同样来自文件, 元素个数较多时随着读取扩容
+	capacity := n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	values := make([][]byte, 0, capacity)
+	for i := 0; i < n; i++ {
+		value, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Next 返回下一个 key, 读到结束标记并且校验和正确时返回 io.EOF
+func (d *Decoder) Next() (*Entry, error) {
+	var expireAt int64 = -1
+	for {
+		opcode, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opAux:
+			key, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			d.Aux[string(key)] = string(value)
+		case opResizeDB:
+			if _, err = d.readLen(); err != nil {
+				return nil, err
+			}
+			if _, err = d.readLen(); err != nil {
+				return nil, err
+			}
+		case opSelectDB:
+			if d.db, err = d.readLen(); err != nil {
+				return nil, err
+			}
+		case opExpireTimeMs:
+			if err = d.readFull(d.buf[:8]); err != nil {
+				return nil, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(d.buf[:8]))
+		case opExpireTime:
+			if err = d.readFull(d.buf[:4]); err != nil {
+				return nil, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(d.buf[:4])) * 1000
+		case opEOF:
+			return nil, d.readChecksum()
+		case TypeString, TypeList, TypeSet, TypeHash:
+			return d.readEntry(opcode, expireAt)
+		default:
+			return nil, fmt.Errorf("rdb: unsupported object type %d at offset %d", opcode, d.Offset-1)
+		}
+	}
+}
+
+func (d *Decoder) readEntry(t byte, expireAt int64) (*Entry, error) {
+	key, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{DB: d.db, Key: key, Type: t, ExpireAt: expireAt}
+	switch t {
+	case TypeString:
+		entry.Value, err = d.readString()
+	case TypeList, TypeSet:
+		var n int
+		if n, err = d.readLen(); err == nil {
+			entry.Value, err = d.readStrings(n)
+		}
+	case TypeHash:
+		var n int
+		if n, err = d.readLen(); err == nil {
+			entry.Value, err = d.readStrings(2 * n)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// readChecksum 版本 5 之后结束标记后面是 8 个字节的校验和, 为 0 表示没有计算校验和
+func (d *Decoder) readChecksum() error {
+	if d.version < 5 {
+		return io.EOF
+	}
+	expected := d.crc
+	n, err := io.ReadFull(d.r, d.buf[:8])
+	d.Offset += int64(n)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	checksum := binary.LittleEndian.Uint64(d.buf[:8])
+	if checksum != 0 && checksum != expected {
+		return ErrChecksumMismatch
+	}
+	return io.EOF
+}
@@ -0,0 +1,182 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	// redis 源码 crc64.c 中的测试数据
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), Checksum([]byte("123456789")))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	long := strings.Repeat("x", 20000)
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	enc.WriteHeader()
+	enc.WriteAux("redis-ver", "7.0.0")
+	enc.WriteSelectDB(0)
+	enc.WriteType(TypeString)
+	enc.WriteString([]byte("k1"))
+	enc.WriteString([]byte(long))
+	enc.WriteSelectDB(3)
+	enc.WriteExpireTimeMs(1700000000123)
+	enc.WriteType(TypeList)
+	enc.WriteString([]byte("k2"))
+	enc.WriteLength(4)
+	for _, value := range []string{"-1", "300", "-70000", "007"} {
+		enc.WriteString([]byte(value))
+	}
+	enc.WriteType(TypeHash)
+	enc.WriteString([]byte("k3"))
+	enc.WriteLength(1)
+	enc.WriteString([]byte("f"))
+	enc.WriteString([]byte(""))
+	enc.WriteEOF()
+	assert.Nil(t, enc.Err())
+	assert.True(t, strings.HasPrefix(buf.String(), "REDIS0010"))
+	// 整数使用特殊编码: c0 ff 表示 -1
+	assert.Contains(t, buf.String(), "\xc0\xff")
+
+	reader := bufio.NewReader(io.MultiReader(buf, strings.NewReader("tail")))
+	assert.True(t, IsRdb(reader))
+	dec := NewDecoder(reader)
+	assert.Nil(t, dec.ReadHeader())
+	entries := make([]*Entry, 0)
+	for {
+		entry, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(t, err) {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, "7.0.0", dec.Aux["redis-ver"])
+	assert.Equal(t, []*Entry{
+		{DB: 0, Key: []byte("k1"), Type: TypeString, Value: []byte(long), ExpireAt: -1},
+		{DB: 3, Key: []byte("k2"), Type: TypeList, Value: [][]byte{[]byte("-1"), []byte("300"), []byte("-70000"), []byte("007")},
+			ExpireAt: 1700000000123},
+		{DB: 3, Key: []byte("k3"), Type: TypeHash, Value: [][]byte{[]byte("f"), {}}, ExpireAt: -1},
+	}, entries)
+	// rdb 之后的数据留在 reader 中
+	tail, _ := io.ReadAll(reader)
+	assert.Equal(t, "tail", string(tail))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	enc.WriteHeader()
+	enc.WriteType(TypeString)
+	enc.WriteString([]byte("key"))
+	enc.WriteString([]byte("value"))
+	enc.WriteEOF()
+	data := buf.Bytes()
+
+	decodeAll := func(p []byte) error {
+		dec := NewDecoder(bufio.NewReader(bytes.NewReader(p)))
+		if err := dec.ReadHeader(); err != nil {
+			return err
+		}
+		for {
+			if _, err := dec.Next(); err != nil {
+				return err
+			}
+		}
+	}
+	assert.Equal(t, io.EOF, decodeAll(data))
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-12] ^= 1
+	assert.Equal(t, ErrChecksumMismatch, decodeAll(corrupted))
+	// 校验和为 0 时不检查
+	noChecksum := append(append([]byte{}, data[:len(data)-8]...), make([]byte, 8)...)
+	assert.Equal(t, io.EOF, decodeAll(noChecksum))
+	assert.Equal(t, io.ErrUnexpectedEOF, decodeAll(data[:len(data)-3]))
+	assert.Equal(t, ErrBadMagic, decodeAll([]byte("RADIS0010")))
+	assert.NotNil(t, decodeAll([]byte("REDIS0099")))
+}
+
+func TestDecodeCorruptedLength(t *testing.T) {
+	prefix := func(t byte) *Encoder {
+		buf := &bytes.Buffer{}
+		enc := NewEncoder(buf)
+		enc.WriteHeader()
+		enc.WriteType(t)
+		enc.WriteString([]byte("key"))
+		return enc
+	}
+	bytesOf := func(enc *Encoder) []byte {
+		return enc.w.(*bytes.Buffer).Bytes()
+	}
+	// 损坏的长度接近 MaxInt32, 之后只有几个字节的数据
+	stringLen := prefix(TypeString)
+	stringLen.WriteLength(math.MaxInt32)
+	stringLen.WriteRaw([]byte("value"))
+	listLen := prefix(TypeList)
+	listLen.WriteLength(math.MaxInt32)
+	listLen.WriteString([]byte("a"))
+	hashLen := prefix(TypeHash)
+	hashLen.WriteLength(math.MaxInt32)
+	lzfCompressedLen := prefix(TypeString)
+	lzfCompressedLen.WriteRaw([]byte{encVal<<6 | encLzf})
+	lzfCompressedLen.WriteLength(math.MaxInt32)
+	lzfCompressedLen.WriteLength(12)
+	lzfCompressedLen.WriteRaw([]byte{2, 'a', 'b', 'c'})
+	lzfLen := prefix(TypeString)
+	lzfLen.WriteRaw([]byte{encVal<<6 | encLzf})
+	lzfLen.WriteLength(7)
+	lzfLen.WriteLength(math.MaxInt32)
+	lzfLen.WriteRaw([]byte{2, 'a', 'b', 'c', 7<<5 | 0, 0, 2})
+	lzfLen.WriteEOF()
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"string", bytesOf(stringLen), io.ErrUnexpectedEOF},
+		{"list", bytesOf(listLen), io.ErrUnexpectedEOF},
+		{"hash", bytesOf(hashLen), io.ErrUnexpectedEOF},
+		{"lzf compressed length", bytesOf(lzfCompressedLen), io.ErrUnexpectedEOF},
+		{"lzf length", bytesOf(lzfLen), nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			dec := NewDecoder(bufio.NewReader(bytes.NewReader(tc.data)))
+			assert.Nil(t, dec.ReadHeader())
+			_, err := dec.Next()
+			runtime.ReadMemStats(&after)
+			if tc.expected != nil {
+				assert.Equal(t, tc.expected, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+			// 不能在读到数据之前按照损坏的长度分配内存
+			assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+		})
+	}
+}
+
+func TestLzf(t *testing.T) {
+	// "abcabcabcabc": 3 个字面量, 然后从 3 个字节之前复制 9 个字节
+	compressed := []byte{2, 'a', 'b', 'c', 7<<5 | 0, 0, 2}
+	value, err := lzfDecompress(compressed, 12)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcabcabcabc", string(value))
+	_, err = lzfDecompress(compressed, 11)
+	assert.NotNil(t, err)
+	_, err = lzfDecompress([]byte{0x20, 5}, 3)
+	assert.NotNil(t, err)
+}
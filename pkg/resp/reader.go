@@ -0,0 +1,340 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	// DefaultMaxBulkLen bulk string 的默认最大长度, 与 redis 的 proto-max-bulk-len 一致
+	DefaultMaxBulkLen int64 = 512 * 1024 * 1024
+	// DefaultMaxArrayLen 聚合类型的默认最大元素个数
+	DefaultMaxArrayLen int64 = math.MaxInt32
+	// DefaultMaxLineLen 一行的默认最大长度
+	DefaultMaxLineLen = 64 * 1024
+	// DefaultMaxDepth 聚合类型的默认最大嵌套层数
+	DefaultMaxDepth = 128
+)
+
+// ProtocolError 协议错误, Offset 是出错的那一行在流中的起始位置
+type ProtocolError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("resp: protocol error at offset %d: %s", e.Offset, e.Msg)
+}
+
+type Option func(r *Reader)
+
+// WithMaxBulkLen 限制 bulk string, blob error, verbatim string 的长度
+func WithMaxBulkLen(n int64) Option {
+	return func(r *Reader) {
+		r.maxBulkLen = n
+	}
+}
+
+// WithMaxArrayLen 限制 array, set, push, map, attribute 的元素个数
+func WithMaxArrayLen(n int64) Option {
+	return func(r *Reader) {
+		r.maxArrayLen = n
+	}
+}
+
+// WithMaxLineLen 限制一行的长度
+func WithMaxLineLen(n int) Option {
+	return func(r *Reader) {
+		r.maxLineLen = n
+	}
+}
+
+// WithMaxDepth 限制聚合类型的嵌套层数
+func WithMaxDepth(n int) Option {
+	return func(r *Reader) {
+		r.maxDepth = n
+	}
+}
+
+// Reader 从流中逐个读取 RESP2/RESP3 的值。
+// 在值的边界上遇到流的末尾返回 io.EOF, 在值的中间遇到流的末尾返回 io.ErrUnexpectedEOF,
+// 协议错误返回 *ProtocolError, 出错的那一行已经被读取, 但是错误可能出现在聚合类型的中间, 之后的数据不一定从值的边界开始, 调用方应该停止读取
+type Reader struct {
+	br          *bufio.Reader
+	offset      int64
+	maxBulkLen  int64
+	maxArrayLen int64
+	maxLineLen  int
+	maxDepth    int
+}
+
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	reader := &Reader{
+		br:          bufio.NewReader(r),
+		maxBulkLen:  DefaultMaxBulkLen,
+		maxArrayLen: DefaultMaxArrayLen,
+		maxLineLen:  DefaultMaxLineLen,
+		maxDepth:    DefaultMaxDepth,
+	}
+	for _, opt := range opts {
+		opt(reader)
+	}
+	return reader
+}
+
+// Offset 已经读取的字节数, 即下一个值在流中的起始位置
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// ReadValue 读取下一个完整的值
+func (r *Reader) ReadValue() (Value, error) {
+	return r.readValue(0)
+}
+
+// PeekByte 查看下一个字节但不读取它
+func (r *Reader) PeekByte() (byte, error) {
+	buf, err := r.br.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// ReadLine 读取以 \n 结尾的一行, 返回的内容不包含行尾的 \r\n 或者 \n。
+// 用于读取 AOF 中的注释这类不属于 RESP 的内容
+func (r *Reader) ReadLine() ([]byte, error) {
+	line, err := r.readRawLine(true)
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// ReadAnnotation 下一行是 AOF 中以 # 开头的注释(例如 #TS:1700000000)时读取它, 返回的内容不包含行尾。
+// RESP3 的 boolean(#t\r\n 和 #f\r\n)不是注释, 这时返回 false, 调用方继续使用 ReadValue 读取
+func (r *Reader) ReadAnnotation() ([]byte, bool, error) {
+	buf, _ := r.br.Peek(3)
+	if len(buf) == 0 || buf[0] != '#' {
+		return nil, false, nil
+	}
+	if len(buf) >= 2 && (buf[1] == 't' || buf[1] == 'f') && (len(buf) == 2 || buf[2] == '\r') {
+		return nil, false, nil
+	}
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, false, err
+	}
+	return line, true, nil
+}
+
+func (r *Reader) protocolError(offset int64, format string, args ...interface{}) error {
+	return &ProtocolError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// readRawLine 读取包含 \n 在内的一行
+func (r *Reader) readRawLine(first bool) ([]byte, error) {
+	start := r.offset
+	var line []byte
+	for {
+		frag, err := r.br.ReadSlice('\n')
+		r.offset += int64(len(frag))
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if err == bufio.ErrBufferFull {
+			if len(line) > r.maxLineLen {
+				return nil, r.protocolError(start, "line too long")
+			}
+			continue
+		}
+		if err == io.EOF {
+			if first && len(line) == 0 {
+				return nil, io.EOF
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(line) > r.maxLineLen {
+		return nil, r.protocolError(start, "line too long")
+	}
+	return line, nil
+}
+
+func (r *Reader) readLine(first bool) ([]byte, int64, error) {
+	start := r.offset
+	line, err := r.readRawLine(first)
+	if err != nil {
+		return nil, start, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, start, r.protocolError(start, "expected '\\r\\n' at the end of line %q", line)
+	}
+	return line[:len(line)-2], start, nil
+}
+
+func (r *Reader) readValue(depth int) (Value, error) {
+	line, start, err := r.readLine(depth == 0)
+	if err != nil {
+		return Value{}, err
+	}
+	t := Type(line[0])
+	payload := line[1:]
+	switch t {
+	case SimpleString, Error, BigNumber:
+		return Value{Type: t, Str: payload}, nil
+	case Integer:
+		n, err := strconv.ParseInt(string(payload), 10, 64)
+		if err != nil {
+			return Value{}, r.protocolError(start, "invalid integer %q", payload)
+		}
+		return IntegerValue(n), nil
+	case Double:
+		f, err := ParseDouble(string(payload))
+		if err != nil {
+			return Value{}, r.protocolError(start, "invalid double %q", payload)
+		}
+		return DoubleValue(f), nil
+	case Boolean:
+		if len(payload) != 1 || (payload[0] != 't' && payload[0] != 'f') {
+			return Value{}, r.protocolError(start, "invalid boolean %q", payload)
+		}
+		return BooleanValue(payload[0] == 't'), nil
+	case Null:
+		if len(payload) != 0 {
+			return Value{}, r.protocolError(start, "invalid null %q", payload)
+		}
+		return NullValue(), nil
+	case BulkString, BlobError, VerbatimString:
+		return r.readBulk(t, payload, start)
+	case Array, Set, Push, Map, Attribute:
+		return r.readAggregate(t, payload, start, depth)
+	default:
+		return Value{}, r.protocolError(start, "unknown type %q", line[0])
+	}
+}
+
+func (r *Reader) readBulk(t Type, header []byte, start int64) (Value, error) {
+	n, err := strconv.ParseInt(string(header), 10, 64)
+	if err != nil {
+		return Value{}, r.protocolError(start, "invalid bulk length %q", header)
+	}
+	if n == -1 && t == BulkString {
+		return NullBulkValue(), nil
+	}
+	if n < 0 || n > r.maxBulkLen {
+		return Value{}, r.protocolError(start, "invalid bulk length %d", n)
+	}
+	body, err := r.readFull(n + 2)
+	if err != nil {
+		return Value{}, err
+	}
+	if body[n] != '\r' || body[n+1] != '\n' {
+		return Value{}, r.protocolError(r.offset-2, "expected '\\r\\n' after bulk string")
+	}
+	body = body[:n:n]
+	if t == VerbatimString {
+		if len(body) < 4 || body[3] != ':' {
+			return Value{}, r.protocolError(start, "invalid verbatim string")
+		}
+		return Value{Type: t, Format: string(body[:3]), Str: body[4:]}, nil
+	}
+	return Value{Type: t, Str: body}, nil
+}
+
+// readChunkSize 长度来自对端, 可能远大于实际发送的数据, 超过它的 bulk 边读边扩容, 不会在读到数据之前按照长度分配内存
+const readChunkSize = 64 * 1024
+
+// readFull 读取 n 个字节, 流中剩余的数据不够时返回 io.ErrUnexpectedEOF
+func (r *Reader) readFull(n int64) ([]byte, error) {
+	capacity := n
+	if capacity > readChunkSize {
+		capacity = readChunkSize
+	}
+	p := make([]byte, 0, capacity)
+	for int64(len(p)) < n {
+		if len(p) == cap(p) {
+			p = append(p, 0)[:len(p)]
+		}
+		end := int64(cap(p))
+		if end > n {
+			end = n
+		}
+		read, err := io.ReadFull(r.br, p[len(p):end])
+		r.offset += int64(read)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		p = p[:end]
+	}
+	return p, nil
+}
+
+func (r *Reader) readAggregate(t Type, header []byte, start int64, depth int) (Value, error) {
+	n, err := strconv.ParseInt(string(header), 10, 64)
+	if err != nil {
+		return Value{}, r.protocolError(start, "invalid aggregate length %q", header)
+	}
+	if n == -1 && t == Array {
+		return NullArrayValue(), nil
+	}
+	if n < 0 || n > r.maxArrayLen {
+		return Value{}, r.protocolError(start, "invalid aggregate length %d", n)
+	}
+	if depth+1 > r.maxDepth {
+		return Value{}, r.protocolError(start, "too many nested aggregates")
+	}
+	if t == Map || t == Attribute {
+		n *= 2
+	}
+	capacity := n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	elems := make([]Value, 0, capacity)
+	for i := int64(0); i < n; i++ {
+		elem, err := r.readValue(depth + 1)
+		if err != nil {
+			return Value{}, err
+		}
+		elems = append(elems, elem)
+	}
+	return Value{Type: t, Elems: elems}, nil
+}
+
+// ParseDouble 解析 RESP3 的 double, 支持 inf, -inf 和 nan
+func ParseDouble(s string) (float64, error) {
+	switch s {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// FormatDouble 格式化 RESP3 的 double, 使用能够还原原值的最短表示
+func FormatDouble(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	} else if math.IsInf(f, -1) {
+		return "-inf"
+	} else if math.IsNaN(f) {
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
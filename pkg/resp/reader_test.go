@@ -0,0 +1,174 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadValue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Value
+	}{
+		{"simple string", "+OK\r\n", SimpleStringValue("OK")},
+		{"error", "-ERR boom\r\n", ErrorValue("ERR boom")},
+		{"integer", ":-42\r\n", IntegerValue(-42)},
+		{"bulk string", "$5\r\nhello\r\n", BulkValue([]byte("hello"))},
+		{"empty bulk string", "$0\r\n\r\n", BulkValue([]byte{})},
+		{"null bulk string", "$-1\r\n", NullBulkValue()},
+		{"null array", "*-1\r\n", NullArrayValue()},
+		{"array", "*2\r\n:1\r\n$1\r\na\r\n", ArrayValue(IntegerValue(1), BulkValue([]byte("a")))},
+		{"null", "_\r\n", NullValue()},
+		{"double", ",3.14\r\n", DoubleValue(3.14)},
+		{"double inf", ",-inf\r\n", DoubleValue(math.Inf(-1))},
+		{"boolean", "#t\r\n", BooleanValue(true)},
+		{"big number", "(3492890328409238509324850943850943825024385\r\n",
+			Value{Type: BigNumber, Str: []byte("3492890328409238509324850943850943825024385")}},
+		{"blob error", "!10\r\nSYNTAX bad\r\n", Value{Type: BlobError, Str: []byte("SYNTAX bad")}},
+		{"verbatim string", "=15\r\ntxt:Some string\r\n", Value{Type: VerbatimString, Format: "txt", Str: []byte("Some string")}},
+		{"map", "%1\r\n+key\r\n:1\r\n", MapValue(SimpleStringValue("key"), IntegerValue(1))},
+		{"set", "~2\r\n+a\r\n+b\r\n", SetValue(SimpleStringValue("a"), SimpleStringValue("b"))},
+		{"push", ">2\r\n+message\r\n+hi\r\n", PushValue(SimpleStringValue("message"), SimpleStringValue("hi"))},
+		{"nested", "*1\r\n*1\r\n:1\r\n", ArrayValue(ArrayValue(IntegerValue(1)))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 每次只读一个字节, 验证半包的处理
+			reader := NewReader(iotest.OneByteReader(strings.NewReader(tc.input)))
+			value, err := reader.ReadValue()
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, value)
+			assert.Equal(t, int64(len(tc.input)), reader.Offset())
+			_, err = reader.ReadValue()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestReadValueUnexpectedEOF(t *testing.T) {
+	inputs := []string{"+OK", "$5\r\nhel", "*2\r\n:1\r\n", "%1\r\n+key\r\n"}
+	for _, input := range inputs {
+		_, err := NewReader(strings.NewReader(input)).ReadValue()
+		assert.Equal(t, io.ErrUnexpectedEOF, err, input)
+	}
+}
+
+func TestReadBulkHugeLength(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := NewReader(strings.NewReader("$536870912\r\nhello")).ReadValue()
+	runtime.ReadMemStats(&after)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	// 不能在读到数据之前按照对端声明的长度分配内存
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	// 超过一个分块的 bulk 仍然可以完整地读出来
+	body := strings.Repeat("a", 3*readChunkSize+1)
+	value, err := NewReader(strings.NewReader("$" + strconv.Itoa(len(body)) + "\r\n" + body + "\r\n")).ReadValue()
+	assert.Nil(t, err)
+	assert.Equal(t, body, string(value.Str))
+}
+
+func TestReadValueProtocolError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		offset int64
+	}{
+		{"unknown type", "+OK\r\n@foo\r\n", 5},
+		{"invalid integer", ":abc\r\n", 0},
+		{"missing cr", "+OK\r\n+OK\n", 5},
+		{"invalid bulk length", "*1\r\n$-2\r\n", 4},
+		{"bad bulk terminator", "$3\r\nfooXX", 7},
+		{"invalid boolean", "#x\r\n", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := NewReader(strings.NewReader(tc.input))
+			var err error
+			for err == nil {
+				_, err = reader.ReadValue()
+			}
+			var protocolErr *ProtocolError
+			assert.True(t, errors.As(err, &protocolErr), err)
+			assert.Equal(t, tc.offset, protocolErr.Offset)
+		})
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	_, err := NewReader(strings.NewReader("$5\r\nhello\r\n"), WithMaxBulkLen(4)).ReadValue()
+	assert.IsType(t, &ProtocolError{}, err)
+
+	_, err = NewReader(strings.NewReader("*3\r\n:1\r\n:2\r\n:3\r\n"), WithMaxArrayLen(2)).ReadValue()
+	assert.IsType(t, &ProtocolError{}, err)
+
+	_, err = NewReader(strings.NewReader("+"+strings.Repeat("a", 100)+"\r\n"), WithMaxLineLen(10)).ReadValue()
+	assert.IsType(t, &ProtocolError{}, err)
+
+	_, err = NewReader(strings.NewReader("*1\r\n*1\r\n*1\r\n:1\r\n"), WithMaxDepth(2)).ReadValue()
+	assert.IsType(t, &ProtocolError{}, err)
+
+	value, err := NewReader(strings.NewReader("*1\r\n*1\r\n:1\r\n"), WithMaxDepth(2)).ReadValue()
+	assert.Nil(t, err)
+	assert.Equal(t, ArrayValue(ArrayValue(IntegerValue(1))), value)
+}
+
+func TestReadLine(t *testing.T) {
+	reader := NewReader(strings.NewReader("#TS:1700000000\r\n*1\r\n$4\r\nPING\r\n"))
+	b, err := reader.PeekByte()
+	assert.Nil(t, err)
+	assert.Equal(t, byte('#'), b)
+	line, err := reader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("#TS:1700000000"), line)
+	value, err := reader.ReadValue()
+	assert.Nil(t, err)
+	args, ok := value.Args()
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{[]byte("PING")}, args)
+}
+
+func TestReadAnnotation(t *testing.T) {
+	reader := NewReader(strings.NewReader("#TS:1700000000\r\n#t\r\n#f\r\n#tag\r\n"))
+	line, ok, err := reader.ReadAnnotation()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("#TS:1700000000"), line)
+	// boolean 不是注释
+	for _, expected := range []bool{true, false} {
+		_, ok, err = reader.ReadAnnotation()
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		value, err := reader.ReadValue()
+		assert.Nil(t, err)
+		assert.Equal(t, BooleanValue(expected), value)
+	}
+	line, ok, err = reader.ReadAnnotation()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("#tag"), line)
+	_, ok, err = reader.ReadAnnotation()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = reader.ReadValue()
+	assert.Equal(t, io.EOF, err)
+
+	_, _, err = NewReader(strings.NewReader("#TS:17")).ReadAnnotation()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestValueString(t *testing.T) {
+	value := ArrayValue(BulkValue([]byte("a")), IntegerValue(1), NullBulkValue())
+	assert.Equal(t, "1) \"a\"\n2) (integer) 1\n3) (nil)", value.String())
+	assert.True(t, bytes.Contains([]byte(MapValue(BulkValue([]byte("k")), BulkValue([]byte("v"))).String()), []byte("=>")))
+}
@@ -0,0 +1,238 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Type RESP 数据类型, 值为协议中表示类型的第一个字节
+type Type byte
+
+const (
+	// RESP2
+	SimpleString Type = '+'
+	Error        Type = '-'
+	Integer      Type = ':'
+	BulkString   Type = '$'
+	Array        Type = '*'
+	// RESP3
+	Null           Type = '_'
+	Double         Type = ','
+	Boolean        Type = '#'
+	BlobError      Type = '!'
+	VerbatimString Type = '='
+	BigNumber      Type = '('
+	Map            Type = '%'
+	Set            Type = '~'
+	Attribute      Type = '|'
+	Push           Type = '>'
+)
+
+func (t Type) String() string {
+	switch t {
+	case SimpleString:
+		return "simple-string"
+	case Error:
+		return "error"
+	case Integer:
+		return "integer"
+	case BulkString:
+		return "bulk-string"
+	case Array:
+		return "array"
+	case Null:
+		return "null"
+	case Double:
+		return "double"
+	case Boolean:
+		return "boolean"
+	case BlobError:
+		return "blob-error"
+	case VerbatimString:
+		return "verbatim-string"
+	case BigNumber:
+		return "big-number"
+	case Map:
+		return "map"
+	case Set:
+		return "set"
+	case Attribute:
+		return "attribute"
+	case Push:
+		return "push"
+	default:
+		return fmt.Sprintf("unknown(%q)", byte(t))
+	}
+}
+
+// Value 一个 RESP 值
+type Value struct {
+	Type Type
+	// Str SimpleString, Error, BulkString, BlobError, VerbatimString, BigNumber 的内容
+	Str []byte
+	// Format VerbatimString 的格式, 如 txt
+	Format string
+	// Int Integer 的值
+	Int int64
+	// Float Double 的值
+	Float float64
+	// Bool Boolean 的值
+	Bool bool
+	// Elems Array, Set, Push 的元素; Map 和 Attribute 中 key value 交替出现
+	Elems []Value
+	// IsNull RESP2 中的 $-1 和 *-1
+	IsNull bool
+}
+
+func SimpleStringValue(s string) Value {
+	return Value{Type: SimpleString, Str: []byte(s)}
+}
+
+func ErrorValue(s string) Value {
+	return Value{Type: Error, Str: []byte(s)}
+}
+
+func IntegerValue(n int64) Value {
+	return Value{Type: Integer, Int: n}
+}
+
+func BulkValue(b []byte) Value {
+	return Value{Type: BulkString, Str: b}
+}
+
+func NullBulkValue() Value {
+	return Value{Type: BulkString, IsNull: true}
+}
+
+func ArrayValue(elems ...Value) Value {
+	if elems == nil {
+		elems = make([]Value, 0)
+	}
+	return Value{Type: Array, Elems: elems}
+}
+
+func NullArrayValue() Value {
+	return Value{Type: Array, IsNull: true}
+}
+
+func NullValue() Value {
+	return Value{Type: Null}
+}
+
+func DoubleValue(f float64) Value {
+	return Value{Type: Double, Float: f}
+}
+
+func BooleanValue(b bool) Value {
+	return Value{Type: Boolean, Bool: b}
+}
+
+func MapValue(pairs ...Value) Value {
+	if pairs == nil {
+		pairs = make([]Value, 0)
+	}
+	return Value{Type: Map, Elems: pairs}
+}
+
+func SetValue(elems ...Value) Value {
+	if elems == nil {
+		elems = make([]Value, 0)
+	}
+	return Value{Type: Set, Elems: elems}
+}
+
+func PushValue(elems ...Value) Value {
+	if elems == nil {
+		elems = make([]Value, 0)
+	}
+	return Value{Type: Push, Elems: elems}
+}
+
+// CommandValue 把命令编码为 bulk string 组成的数组
+func CommandValue(args ...[]byte) Value {
+	elems := make([]Value, len(args))
+	for i, arg := range args {
+		elems[i] = BulkValue(arg)
+	}
+	return ArrayValue(elems...)
+}
+
+// IsError Error 和 BlobError
+func (v Value) IsError() bool {
+	return v.Type == Error || v.Type == BlobError
+}
+
+// Args 如果是由 bulk string 组成的数组, 返回每个元素的内容
+func (v Value) Args() ([][]byte, bool) {
+	if v.Type != Array || v.IsNull {
+		return nil, false
+	}
+	args := make([][]byte, len(v.Elems))
+	for i, elem := range v.Elems {
+		if elem.Type != BulkString || elem.IsNull {
+			return nil, false
+		}
+		args[i] = elem.Str
+	}
+	return args, true
+}
+
+// String 类似 redis-cli 的展示格式, 用于调试和命令行工具
+func (v Value) String() string {
+	var buf bytes.Buffer
+	v.format(&buf, "")
+	return buf.String()
+}
+
+func (v Value) format(buf *bytes.Buffer, indent string) {
+	if v.IsNull || v.Type == Null {
+		buf.WriteString("(nil)")
+		return
+	}
+	switch v.Type {
+	case SimpleString, BigNumber:
+		buf.Write(v.Str)
+	case Error, BlobError:
+		buf.WriteString("(error) ")
+		buf.Write(v.Str)
+	case Integer:
+		buf.WriteString("(integer) ")
+		buf.WriteString(strconv.FormatInt(v.Int, 10))
+	case BulkString, VerbatimString:
+		buf.WriteString(strconv.Quote(string(v.Str)))
+	case Double:
+		buf.WriteString("(double) ")
+		buf.WriteString(FormatDouble(v.Float))
+	case Boolean:
+		if v.Bool {
+			buf.WriteString("(true)")
+		} else {
+			buf.WriteString("(false)")
+		}
+	case Array, Set, Push, Map, Attribute:
+		if len(v.Elems) == 0 {
+			buf.WriteString("(empty array)")
+			return
+		}
+		step := 1
+		if v.Type == Map || v.Type == Attribute {
+			step = 2
+		}
+		for i := 0; i < len(v.Elems); i += step {
+			if i > 0 {
+				buf.WriteString("\n")
+				buf.WriteString(indent)
+			}
+			prefix := strconv.Itoa(i/step+1) + ") "
+			buf.WriteString(prefix)
+			v.Elems[i].format(buf, indent+"   ")
+			if step == 2 && i+1 < len(v.Elems) {
+				buf.WriteString(" => ")
+				v.Elems[i+1].format(buf, indent+"   ")
+			}
+		}
+	default:
+		buf.WriteString(v.Type.String())
+	}
+}
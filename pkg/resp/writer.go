@@ -0,0 +1,115 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// Writer 把 RESP2/RESP3 的值写入流中, 写入的数据会先缓存起来, 需要调用 Flush
+type Writer struct {
+	bw      *bufio.Writer
+	scratch []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		bw: bufio.NewWriter(w),
+	}
+}
+
+// WriteValue 写入一个值
+func (w *Writer) WriteValue(v Value) error {
+	w.scratch = AppendValue(w.scratch[:0], v)
+	_, err := w.bw.Write(w.scratch)
+	return err
+}
+
+// WriteCommand 把命令编码为 bulk string 组成的数组写入
+func (w *Writer) WriteCommand(args ...[]byte) error {
+	w.scratch = AppendCommand(w.scratch[:0], args...)
+	_, err := w.bw.Write(w.scratch)
+	return err
+}
+
+// Flush 把缓存的数据写入底层的流
+func (w *Writer) Flush() error {
+	return w.bw.Flush()
+}
+
+// Buffered 缓存中还没有写入底层流的字节数
+func (w *Writer) Buffered() int {
+	return w.bw.Buffered()
+}
+
+// AppendCommand 把命令编码为 bulk string 组成的数组追加到 dst
+func AppendCommand(dst []byte, args ...[]byte) []byte {
+	dst = appendHeader(dst, Array, int64(len(args)))
+	for _, arg := range args {
+		dst = appendHeader(dst, BulkString, int64(len(arg)))
+		dst = append(dst, arg...)
+		dst = append(dst, '\r', '\n')
+	}
+	return dst
+}
+
+// AppendValue 把值的编码追加到 dst
+func AppendValue(dst []byte, v Value) []byte {
+	if v.IsNull {
+		if v.Type == Array {
+			return append(dst, "*-1\r\n"...)
+		}
+		return append(dst, "$-1\r\n"...)
+	}
+	switch v.Type {
+	case SimpleString, Error, BigNumber:
+		dst = append(dst, byte(v.Type))
+		dst = append(dst, v.Str...)
+		return append(dst, '\r', '\n')
+	case Integer:
+		return appendHeader(dst, Integer, v.Int)
+	case Double:
+		dst = append(dst, byte(Double))
+		dst = append(dst, FormatDouble(v.Float)...)
+		return append(dst, '\r', '\n')
+	case Boolean:
+		if v.Bool {
+			return append(dst, "#t\r\n"...)
+		}
+		return append(dst, "#f\r\n"...)
+	case Null:
+		return append(dst, "_\r\n"...)
+	case BulkString, BlobError:
+		dst = appendHeader(dst, v.Type, int64(len(v.Str)))
+		dst = append(dst, v.Str...)
+		return append(dst, '\r', '\n')
+	case VerbatimString:
+		format := v.Format
+		if len(format) != 3 {
+			format = "txt"
+		}
+		dst = appendHeader(dst, VerbatimString, int64(len(v.Str)+4))
+		dst = append(dst, format...)
+		dst = append(dst, ':')
+		dst = append(dst, v.Str...)
+		return append(dst, '\r', '\n')
+	case Array, Set, Push, Map, Attribute:
+		n := int64(len(v.Elems))
+		if v.Type == Map || v.Type == Attribute {
+			n /= 2
+		}
+		dst = appendHeader(dst, v.Type, n)
+		for _, elem := range v.Elems {
+			dst = AppendValue(dst, elem)
+		}
+		return dst
+	default:
+		return dst
+	}
+}
+
+func appendHeader(dst []byte, t Type, n int64) []byte {
+	dst = append(dst, byte(t))
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, '\r', '\n')
+}
@@ -0,0 +1,52 @@
+package resp
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestWriteValueRoundTrip(t *testing.T) {
+	values := []Value{
+		SimpleStringValue("OK"),
+		ErrorValue("ERR boom"),
+		IntegerValue(math.MaxInt64),
+		BulkValue([]byte("hello\r\nworld")),
+		NullBulkValue(),
+		NullArrayValue(),
+		ArrayValue(IntegerValue(1), ArrayValue(BulkValue([]byte("nested")))),
+		NullValue(),
+		DoubleValue(-1.5),
+		DoubleValue(math.Inf(1)),
+		BooleanValue(false),
+		Value{Type: BigNumber, Str: []byte("12345678901234567890")},
+		Value{Type: BlobError, Str: []byte("SYNTAX invalid")},
+		Value{Type: VerbatimString, Format: "mkd", Str: []byte("# title")},
+		MapValue(BulkValue([]byte("k")), SetValue(BulkValue([]byte("a")))),
+		PushValue(BulkValue([]byte("invalidate")), ArrayValue(BulkValue([]byte("key")))),
+	}
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	for _, value := range values {
+		assert.Nil(t, writer.WriteValue(value))
+	}
+	assert.Nil(t, writer.Flush())
+
+	reader := NewReader(&buf)
+	for _, expected := range values {
+		value, err := reader.ReadValue()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value)
+	}
+}
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	assert.Nil(t, writer.WriteCommand([]byte("SET"), []byte("key"), []byte("")))
+	assert.Equal(t, 0, buf.Len())
+	assert.Nil(t, writer.Flush())
+	assert.Equal(t, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n", buf.String())
+	assert.Equal(t, AppendValue(nil, CommandValue([]byte("SET"), []byte("key"), []byte(""))), buf.Bytes())
+}
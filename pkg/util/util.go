@@ -1,10 +1,12 @@
 package util
 
 import (
+	"errors"
 	"io"
 	"log"
 	"math/rand"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -61,3 +63,63 @@ func Close(closer io.Closer) {
 		log.Printf("close faild with error: %v\n", err)
 	}
 }
+
+var memoryUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory 解析配置中的内存大小, 和 redis 一样 1k = 1000, 1kb = 1024, 不区分大小写
+func ParseMemory(value string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			unit = u.size
+			break
+		}
+	}
+	size, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return size * unit, nil
+}
+
+// FormatMemory 把字节数格式化为 ParseMemory 可以解析的形式, 能整除时使用 gb, mb, kb
+func FormatMemory(size int64) string {
+	if size == 0 {
+		return "0"
+	}
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"gb", 1024 * 1024 * 1024}, {"mb", 1024 * 1024}, {"kb", 1024}} {
+		if size%u.size == 0 {
+			return strconv.FormatInt(size/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10) + "b"
+}
+
+// HumanBytes 把字节数转换为方便阅读的形式, 例如 1.50M, 用于 INFO
+func HumanBytes(size uint64) string {
+	value := float64(size)
+	switch {
+	case size < 1024:
+		return strconv.FormatUint(size, 10) + "B"
+	case size < 1024*1024:
+		return strconv.FormatFloat(value/1024, 'f', 2, 64) + "K"
+	case size < 1024*1024*1024:
+		return strconv.FormatFloat(value/(1024*1024), 'f', 2, 64) + "M"
+	case size < 1024*1024*1024*1024:
+		return strconv.FormatFloat(value/(1024*1024*1024), 'f', 2, 64) + "G"
+	default:
+		return strconv.FormatFloat(value/(1024*1024*1024*1024), 'f', 2, 64) + "T"
+	}
+}
@@ -0,0 +1,714 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserName = "default"
+	// defaultAclLogMaxLen acllog-max-len 的默认值
+	defaultAclLogMaxLen = 128
+	// aclLogGroupingMaxTime 相同的拒绝记录在这个时间内会合并为一条
+	aclLogGroupingMaxTime = time.Minute
+)
+
+var (
+	errAclNoPerm          = errors.New("NOPERM")
+	errAclUnknownCategory = errors.New("Unknown command or category name in ACL")
+	errAclSyntax          = errors.New("Syntax error")
+	errAclNoSuchPassword  = errors.New("The password you are trying to remove from the user does not exist")
+	errAclBadHash         = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errAclWrongPass       = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errAclNoAuth          = errors.New("NOAUTH Authentication required.")
+	errAclFileNotSet      = errors.New("ERR This Redis instance is not configured to use an ACL file. " +
+		"You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE " +
+		"(assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+)
+
+// aclUser 一个 ACL 用户, 包含密码、允许执行的命令、可以访问的 key 和 channel
+type aclUser struct {
+	name    string
+	enabled bool
+	nopass  bool
+	// passwords 密码的 sha256
+	passwords map[string]struct{}
+	// allCommands +@all, 包括之后新增的命令
+	allCommands bool
+	// allowed 允许执行的命令, 子命令使用 cmd|sub 的形式, 优先于父命令
+	allowed map[string]bool
+	// cmdRules 按顺序记录的命令规则, 用于 ACL LIST 和 ACL GETUSER
+	cmdRules    []string
+	allKeys     bool
+	keys        []string
+	allChannels bool
+	channels    []string
+}
+
+func newAclUser(name string) *aclUser {
+	return &aclUser{
+		name:      name,
+		passwords: make(map[string]struct{}),
+		allowed:   make(map[string]bool),
+		cmdRules:  []string{"-@all"},
+	}
+}
+
+// newDefaultUser 默认用户可以执行所有命令, requirepass 不为空时需要密码
+func newDefaultUser(requirePass string) *aclUser {
+	user := newAclUser(defaultUserName)
+	rules := []string{"on", "~*", "&*", "+@all"}
+	if requirePass == "" {
+		rules = append(rules, "nopass")
+	} else {
+		rules = append(rules, ">"+requirePass)
+	}
+	for _, rule := range rules {
+		_ = user.setRule(rule)
+	}
+	return user
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = make(map[string]struct{}, len(u.passwords))
+	for k := range u.passwords {
+		c.passwords[k] = struct{}{}
+	}
+	c.allowed = make(map[string]bool, len(u.allowed))
+	for k, v := range u.allowed {
+		c.allowed[k] = v
+	}
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	c.keys = append([]string(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	return &c
+}
+
+// setRules 依次应用规则, 任意一个规则出错时用户保持不变
+func (u *aclUser) setRules(rules []string) error {
+	c := u.clone()
+	for _, rule := range rules {
+		if err := c.setRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err.Error())
+		}
+	}
+	*u = *c
+	return nil
+}
+
+func (u *aclUser) setRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "allkeys":
+		return u.setRule("~*")
+	case "resetkeys":
+		u.allKeys = false
+		u.keys = nil
+		return nil
+	case "allchannels":
+		return u.setRule("&*")
+	case "resetchannels":
+		u.allChannels = false
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return u.setRule("+@all")
+	case "nocommands":
+		return u.setRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			_ = u.setRule(r)
+		}
+		return nil
+	}
+	if len(rule) == 0 {
+		return errAclSyntax
+	}
+	switch rule[0] {
+	case '>':
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+	case '<':
+		hash := hashPassword(rule[1:])
+		if _, ok := u.passwords[hash]; !ok {
+			return errAclNoSuchPassword
+		}
+		delete(u.passwords, hash)
+	case '#':
+		if !validPasswordHash(rule[1:]) {
+			return errAclBadHash
+		}
+		u.passwords[rule[1:]] = struct{}{}
+		u.nopass = false
+	case '!':
+		if _, ok := u.passwords[rule[1:]]; !ok {
+			return errAclNoSuchPassword
+		}
+		delete(u.passwords, rule[1:])
+	case '~':
+		return u.addKeyPattern(rule[1:])
+	case '&':
+		return u.addChannelPattern(rule[1:])
+	case '+', '-':
+		return u.setCommandRule(rule[0] == '+', lower[1:])
+	default:
+		return errAclSyntax
+	}
+	return nil
+}
+
+func (u *aclUser) addKeyPattern(pattern string) error {
+	if pattern == "*" {
+		u.allKeys = true
+		u.keys = nil
+		return nil
+	}
+	if u.allKeys {
+		return nil
+	}
+	u.keys = appendPattern(u.keys, pattern)
+	return nil
+}
+
+func (u *aclUser) addChannelPattern(pattern string) error {
+	if pattern == "*" {
+		u.allChannels = true
+		u.channels = nil
+		return nil
+	}
+	if u.allChannels {
+		return nil
+	}
+	u.channels = appendPattern(u.channels, pattern)
+	return nil
+}
+
+func appendPattern(patterns []string, pattern string) []string {
+	for _, p := range patterns {
+		if p == pattern {
+			return patterns
+		}
+	}
+	return append(patterns, pattern)
+}
+
+// setCommandRule +cmd, -cmd, +cmd|sub, -cmd|sub, +@category, -@category
+func (u *aclUser) setCommandRule(allow bool, name string) error {
+	sign := "-"
+	if allow {
+		sign = "+"
+	}
+	if strings.HasPrefix(name, "@") {
+		category := name[1:]
+		if !validAclCategory(category) {
+			return errAclUnknownCategory
+		}
+		if category == "all" {
+			u.allCommands = allow
+			u.allowed = make(map[string]bool)
+			u.cmdRules = []string{sign + name}
+			return nil
+		}
+		for _, cmdName := range commandsInCategory(category) {
+			u.setCommand(allow, cmdName)
+		}
+		u.cmdRules = append(u.cmdRules, sign+name)
+		return nil
+	}
+	cmdName, sub, hasSub := strings.Cut(name, "|")
+	if _, ok := commandRouter[cmdName]; !ok {
+		return errAclUnknownCategory
+	}
+	if hasSub {
+		if sub == "" || strings.Contains(sub, "|") {
+			return errAclSyntax
+		}
+		if u.allCommands {
+			if allow {
+				u.cmdRules = append(u.cmdRules, sign+name)
+				return nil
+			}
+			u.materializeAllCommands()
+		}
+		u.allowed[cmdName+"|"+sub] = allow
+	} else {
+		u.setCommand(allow, cmdName)
+	}
+	u.cmdRules = append(u.cmdRules, sign+name)
+	return nil
+}
+
+func (u *aclUser) setCommand(allow bool, cmdName string) {
+	if !allow && u.allCommands {
+		u.materializeAllCommands()
+	}
+	prefix := cmdName + "|"
+	for k := range u.allowed {
+		if strings.HasPrefix(k, prefix) {
+			delete(u.allowed, k)
+		}
+	}
+	if allow {
+		u.allowed[cmdName] = true
+	} else {
+		delete(u.allowed, cmdName)
+	}
+}
+
+// materializeAllCommands 从 +@all 中去掉某个命令之后, 只能把当前所有的命令展开
+func (u *aclUser) materializeAllCommands() {
+	u.allCommands = false
+	for name := range commandRouter {
+		if _, ok := u.allowed[name]; !ok {
+			u.allowed[name] = true
+		}
+	}
+}
+
+// canRun 是否可以执行命令, sub 是小写的第一个参数, 没有参数时为空
+func (u *aclUser) canRun(cmdName, sub string) bool {
+	if u.allCommands {
+		return true
+	}
+	if sub != "" {
+		if allow, ok := u.allowed[cmdName+"|"+sub]; ok {
+			return allow
+		}
+	}
+	return u.allowed[cmdName]
+}
+
+func (u *aclUser) keyAllowed(key []byte) bool {
+	if u.allKeys {
+		return true
+	}
+	for _, pattern := range u.keys {
+		if util.StringMatch([]byte(pattern), key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelAllowed 还没有实现发布订阅, pubsub 相关的命令加入之后用它检查 channel
+func (u *aclUser) channelAllowed(channel []byte) bool {
+	if u.allChannels {
+		return true
+	}
+	for _, pattern := range u.channels {
+		if util.StringMatch([]byte(pattern), channel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *aclUser) checkPassword(password []byte) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	_, ok := u.passwords[hashPassword(string(password))]
+	return ok
+}
+
+func (u *aclUser) flags() []string {
+	flags := make([]string, 0, 2)
+	if u.enabled {
+		flags = append(flags, "on")
+	} else {
+		flags = append(flags, "off")
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) passwordHashes() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func (u *aclUser) describeCommands() string {
+	return strings.Join(u.cmdRules, " ")
+}
+
+func (u *aclUser) describeKeys() string {
+	if u.allKeys {
+		return "~*"
+	}
+	patterns := make([]string, len(u.keys))
+	for i, key := range u.keys {
+		patterns[i] = "~" + key
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (u *aclUser) describeChannels() string {
+	if u.allChannels {
+		return "&*"
+	}
+	patterns := make([]string, len(u.channels))
+	for i, channel := range u.channels {
+		patterns[i] = "&" + channel
+	}
+	return strings.Join(patterns, " ")
+}
+
+// describe ACL LIST 和 aclfile 中的格式, 可以被 setRules 重新解析
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name}
+	parts = append(parts, u.flags()...)
+	for _, hash := range u.passwordHashes() {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.describeKeys(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if !u.allChannels {
+		parts = append(parts, "resetchannels")
+	}
+	if channels := u.describeChannels(); channels != "" {
+		parts = append(parts, channels)
+	}
+	parts = append(parts, u.describeCommands())
+	return strings.Join(parts, " ")
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func validPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		c := hash[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// aclLogEntry ACL LOG 中的一条记录
+type aclLogEntry struct {
+	entryId    int64
+	count      int64
+	reason     string
+	context    string
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+func (e *aclLogEntry) toReply() Reply {
+	now := time.Now()
+	return MakeMapReply([]Reply{
+		MakeBulkReply([]byte("count")), MakeIntReply(e.count),
+		MakeBulkReply([]byte("reason")), MakeBulkReply([]byte(e.reason)),
+		MakeBulkReply([]byte("context")), MakeBulkReply([]byte(e.context)),
+		MakeBulkReply([]byte("object")), MakeBulkReply([]byte(e.object)),
+		MakeBulkReply([]byte("username")), MakeBulkReply([]byte(e.username)),
+		MakeBulkReply([]byte("age-seconds")), MakeDoubleReply(float64(now.Sub(e.created).Milliseconds()) / 1000),
+		MakeBulkReply([]byte("client-info")), MakeBulkReply([]byte(e.clientInfo)),
+		MakeBulkReply([]byte("entry-id")), MakeIntReply(e.entryId),
+		MakeBulkReply([]byte("timestamp-created")), MakeIntReply(e.created.UnixMilli()),
+		MakeBulkReply([]byte("timestamp-last-updated")), MakeIntReply(e.updated.UnixMilli()),
+	})
+}
+
+// Acl 管理所有的用户和 ACL LOG, 只在事件循环中访问, 不需要加锁
+type Acl struct {
+	users       map[string]*aclUser
+	log         []*aclLogEntry
+	nextEntryId int64
+}
+
+var acl = NewAcl()
+
+func NewAcl() *Acl {
+	return &Acl{
+		users: map[string]*aclUser{
+			defaultUserName: newDefaultUser(""),
+		},
+		log: make([]*aclLogEntry, 0),
+	}
+}
+
+// Init 根据 requirepass 设置默认用户的密码, 配置了 aclfile 时从文件中加载用户
+func (a *Acl) Init() error {
+	*a.defaultUser() = *newDefaultUser(config.Properties.RequirePass)
+	if config.Properties.AclFile == "" {
+		return nil
+	}
+	_, err := a.loadFile(config.Properties.AclFile)
+	return err
+}
+
+// setRequirePass CONFIG SET requirepass 修改 default 用户的密码, 空字符串表示不需要密码
+func (a *Acl) setRequirePass(requirePass string) {
+	rules := []string{"resetpass", "nopass"}
+	if requirePass != "" {
+		rules = []string{"resetpass", ">" + requirePass}
+	}
+	_ = a.defaultUser().setRules(rules)
+}
+
+func (a *Acl) defaultUser() *aclUser {
+	return a.users[defaultUserName]
+}
+
+func (a *Acl) getUser(name string) (*aclUser, bool) {
+	user, ok := a.users[name]
+	return user, ok
+}
+
+// setUser 用户不存在时创建一个新用户
+func (a *Acl) setUser(name string, rules []string) error {
+	user, ok := a.users[name]
+	if !ok {
+		user = newAclUser(name)
+	}
+	if err := user.setRules(rules); err != nil {
+		return err
+	}
+	a.users[name] = user
+	return nil
+}
+
+// deleteUser 返回被删除的用户
+func (a *Acl) deleteUser(name string) *aclUser {
+	user, ok := a.users[name]
+	if !ok {
+		return nil
+	}
+	delete(a.users, name)
+	return user
+}
+
+func (a *Acl) userNames() []string {
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// authenticate 认证成功之后客户端切换到这个用户
+func (a *Acl) authenticate(conn *Client, username, password []byte) error {
+	user, ok := a.users[string(username)]
+	if !ok || !user.checkPassword(password) {
+		a.addLogEntry(conn, "auth", "toplevel", "AUTH", string(username))
+		return errAclWrongPass
+	}
+	conn.user = user
+	conn.authenticated = true
+	return nil
+}
+
+// authRequired 默认用户需要密码或者被禁用, 并且客户端还没有认证
+func (a *Acl) authRequired(conn *Client) bool {
+	defaultUser := a.defaultUser()
+	return (!defaultUser.nopass || !defaultUser.enabled) && !conn.authenticated
+}
+
+// checkCommand 在执行命令之前检查客户端是否有权限, 没有权限时返回对应的错误回复并记录到 ACL LOG
+func (a *Acl) checkCommand(conn *Client, cmd *Command) Reply {
+	if conn.IsInner() {
+		return nil
+	}
+	// auth, hello 这类命令不需要检查权限, 否则被禁止执行所有命令的用户没有办法切换到其他用户
+	if cmd.hasFlag(cmdNoAuth) {
+		return nil
+	}
+	cmdName := cmd.name
+	if a.authRequired(conn) {
+		return MakeStandardErrReply(errAclNoAuth.Error())
+	}
+	user := conn.user
+	var sub string
+	if args := conn.GetArgs(); len(args) > 0 {
+		sub = strings.ToLower(string(args[0]))
+	}
+	if !user.canRun(cmdName, sub) {
+		object := cmdName
+		if sub != "" && user.allowed[cmdName+"|"+sub] != user.allowed[cmdName] {
+			object = cmdName + "|" + sub
+		}
+		a.addLogEntry(conn, "command", "toplevel", object, user.name)
+		return MakeStandardErrReply(fmt.Sprintf("%s User %s has no permissions to run the '%s' command",
+			errAclNoPerm.Error(), user.name, object))
+	}
+	for _, key := range cmd.keys(conn.GetCmdLine()) {
+		if !user.keyAllowed(key) {
+			a.addLogEntry(conn, "key", "toplevel", string(key), user.name)
+			return MakeStandardErrReply(errAclNoPerm.Error() + " No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+// addLogEntry 一分钟内相同的记录只增加计数, 超过 acllog-max-len 时丢弃最旧的记录
+func (a *Acl) addLogEntry(conn *Client, reason, context, object, username string) {
+	now := time.Now()
+	for _, entry := range a.log {
+		if entry.reason == reason && entry.context == context && entry.object == object &&
+			entry.username == username && now.Sub(entry.updated) < aclLogGroupingMaxTime {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = conn.info()
+			return
+		}
+	}
+	entry := &aclLogEntry{
+		entryId:    a.nextEntryId,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: conn.info(),
+		created:    now,
+		updated:    now,
+	}
+	a.nextEntryId++
+	// 最新的记录在最前边
+	a.log = append([]*aclLogEntry{entry}, a.log...)
+	maxLen := config.Properties.AclLogMaxLen
+	if maxLen <= 0 {
+		maxLen = defaultAclLogMaxLen
+	}
+	if len(a.log) > maxLen {
+		a.log = a.log[:maxLen]
+	}
+}
+
+func (a *Acl) resetLog() {
+	a.log = make([]*aclLogEntry, 0)
+}
+
+// parseAclFile 解析 aclfile, 每一行的格式为 user <name> [rules ...], 忽略空行和 # 开头的注释
+func parseAclFile(filename string) (map[string]*aclUser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(file)
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return nil, fmt.Errorf("%s:%d: should start with user keyword followed by the username", filename, lineNum)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s' found", filename, lineNum, name)
+		}
+		user := newAclUser(name)
+		if err := user.setRules(fields[2:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, lineNum, err.Error())
+		}
+		users[name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// loadFile 从 aclfile 加载用户, 文件中有任何错误时保持原来的用户不变。
+// 文件中没有 default 用户时保留当前的 default 用户。
+// 已经存在的用户原地更新, 这样已经认证的客户端会立即使用新的权限, 返回被删除的用户
+func (a *Acl) loadFile(filename string) ([]*aclUser, error) {
+	users, err := parseAclFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := users[defaultUserName]; !ok {
+		users[defaultUserName] = a.defaultUser().clone()
+	}
+	removed := make([]*aclUser, 0)
+	for name, old := range a.users {
+		if loaded, ok := users[name]; ok {
+			*old = *loaded
+			users[name] = old
+		} else {
+			removed = append(removed, old)
+		}
+	}
+	a.users = users
+	return removed, nil
+}
+
+// saveFile 先写临时文件再重命名, 避免写了一半的 aclfile
+func (a *Acl) saveFile(filename string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, name := range a.userNames() {
+		if _, err = writer.WriteString(a.users[name].describe() + "\n"); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
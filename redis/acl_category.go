@@ -0,0 +1,58 @@
+package redis
+
+// ACL 命令分类, 与 redis 的 @category 一致
+const (
+	aclCategoryKeyspace    = "keyspace"
+	aclCategoryRead        = "read"
+	aclCategoryWrite       = "write"
+	aclCategorySet         = "set"
+	aclCategorySortedSet   = "sortedset"
+	aclCategoryList        = "list"
+	aclCategoryHash        = "hash"
+	aclCategoryString      = "string"
+	aclCategoryBitmap      = "bitmap"
+	aclCategoryHyperLogLog = "hyperloglog"
+	aclCategoryGeo         = "geo"
+	aclCategoryStream      = "stream"
+	aclCategoryPubSub      = "pubsub"
+	aclCategoryAdmin       = "admin"
+	aclCategoryFast        = "fast"
+	aclCategorySlow        = "slow"
+	aclCategoryBlocking    = "blocking"
+	aclCategoryDangerous   = "dangerous"
+	aclCategoryConnection  = "connection"
+	aclCategoryTransaction = "transaction"
+	aclCategoryScripting   = "scripting"
+)
+
+var aclCategories = []string{
+	aclCategoryKeyspace, aclCategoryRead, aclCategoryWrite, aclCategorySet, aclCategorySortedSet,
+	aclCategoryList, aclCategoryHash, aclCategoryString, aclCategoryBitmap, aclCategoryHyperLogLog,
+	aclCategoryGeo, aclCategoryStream, aclCategoryPubSub, aclCategoryAdmin, aclCategoryFast,
+	aclCategorySlow, aclCategoryBlocking, aclCategoryDangerous, aclCategoryConnection,
+	aclCategoryTransaction, aclCategoryScripting,
+}
+
+// validAclCategory category 是否存在, all 表示所有命令
+func validAclCategory(category string) bool {
+	if category == "all" {
+		return true
+	}
+	for _, c := range aclCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// commandsInCategory 属于 category 的所有命令, 按名称排序
+func commandsInCategory(category string) []string {
+	names := make([]string, 0)
+	for _, name := range commandNames() {
+		if category == "all" || commandRouter[name].hasCategory(category) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAclUserRules(t *testing.T) {
+	user := newAclUser("alice")
+	err := user.setRules([]string{"on", ">secret", "~cache:*", "+@read", "-keys", "+acl|whoami"})
+	assert.Nil(t, err)
+
+	assert.True(t, user.checkPassword([]byte("secret")))
+	assert.False(t, user.checkPassword([]byte("wrong")))
+
+	assert.True(t, user.canRun("get", ""))
+	assert.True(t, user.canRun("lrange", ""))
+	assert.False(t, user.canRun("keys", ""))
+	assert.False(t, user.canRun("set", ""))
+	assert.True(t, user.canRun("acl", "whoami"))
+	assert.False(t, user.canRun("acl", "setuser"))
+
+	assert.True(t, user.keyAllowed([]byte("cache:1")))
+	assert.False(t, user.keyAllowed([]byte("session:1")))
+	assert.False(t, user.channelAllowed([]byte("news")))
+
+	assert.Equal(t, "user alice on #2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b "+
+		"~cache:* resetchannels -@all +@read -keys +acl|whoami", user.describe())
+}
+
+func TestAclKeyPatternWithSlash(t *testing.T) {
+	user := newAclUser("carol")
+	assert.Nil(t, user.setRules([]string{"on", "nopass", "+@all", "~user:*", "~tmp/?", "~a[-/]b", "&news.*"}))
+	// * 和 ? 可以匹配 /, path.Match 会把 / 当作分隔符
+	for _, key := range []string{"user:1", "user:1/profile", "user:/", "tmp/x", "tmp//", "a/b", "a-b"} {
+		assert.True(t, user.keyAllowed([]byte(key)), key)
+	}
+	for _, key := range []string{"tmp/xy", "tmp/", "a:b", "session/user:1"} {
+		assert.False(t, user.keyAllowed([]byte(key)), key)
+	}
+	assert.True(t, user.channelAllowed([]byte("news.a/b")))
+	assert.False(t, user.channelAllowed([]byte("sports/news.a")))
+	// 和 redis 一样不检查模式的格式
+	assert.Nil(t, user.setRules([]string{"~[", "~\\"}))
+}
+
+func TestStringMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		str     string
+		nocase  bool
+		matched bool
+	}{
+		{"*", "a/b/c", false, true},
+		{"a*c", "a/b/c", false, true},
+		{"a*c", "a/b/cd", false, false},
+		{"a**c", "abc", false, true},
+		{"a*", "a", false, true},
+		{"*", "", false, false},
+		{"", "", false, true},
+		{"?", "/", false, true},
+		{"a?c", "ac", false, false},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"h[A-B]llo", "hbllo", true, true},
+		{"h[\\]]llo", "h]llo", false, true},
+		{"h[abc", "hc", false, true},
+		{"h[abc", "hd", false, false},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"a\\", "a\\", false, true},
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"a*b*c*d*e*f*g*h*i*j*k*l*m*n*o*p*q*r*s*t*u*v*w*x*y*z*", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.matched, util.StringMatch([]byte(tc.pattern), []byte(tc.str), tc.nocase), "%s %s", tc.pattern, tc.str)
+	}
+}
+
+func TestAclUserRulesAtomic(t *testing.T) {
+	user := newAclUser("bob")
+	assert.Nil(t, user.setRules([]string{"on", "nopass", "+@all"}))
+
+	err := user.setRules([]string{"-get", "+nosuchcommand"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL", err.Error())
+	// 出错时之前的规则也不能生效
+	assert.True(t, user.canRun("get", ""))
+
+	assert.Nil(t, user.setRules([]string{"-get"}))
+	assert.False(t, user.canRun("get", ""))
+	assert.True(t, user.canRun("set", ""))
+
+	assert.Nil(t, user.setRules([]string{"reset"}))
+	assert.False(t, user.enabled)
+	assert.False(t, user.canRun("set", ""))
+	assert.False(t, user.checkPassword(nil))
+
+	for _, rule := range []string{"<missing", "#abc", "+@nosuchcategory", "bad"} {
+		assert.NotNil(t, user.setRules([]string{rule}), rule)
+	}
+}
+
+func TestAclSetUserInvalidName(t *testing.T) {
+	server := makeTempServer()
+	_, run := newResp3Client(t, server)
+	for _, name := range []string{"a b", "a\tb", "a\nb", "a\x00b"} {
+		assert.Equal(t, "-ERR Usernames can't contain spaces or null characters\r\n", run("acl", "setuser", name, "on"), "%q", name)
+		_, ok := acl.getUser(name)
+		assert.False(t, ok, "%q", name)
+	}
+}
+
+func TestAclLoadAndSave(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.acl")
+	content := "# users\n" +
+		"user default on nopass ~* &* +@all\n" +
+		"user reader on >123 ~* resetchannels -@all +@read\n"
+	assert.Nil(t, os.WriteFile(filename, []byte(content), 0644))
+
+	a := NewAcl()
+	assert.Nil(t, a.setUser("old", []string{"on", "nopass"}))
+	removed, err := a.loadFile(filename)
+	assert.Nil(t, err)
+	assert.Len(t, removed, 1)
+	assert.Equal(t, "old", removed[0].name)
+	assert.Equal(t, []string{"default", "reader"}, a.userNames())
+
+	assert.Nil(t, a.saveFile(filename))
+	reloaded, err := parseAclFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, a.users["reader"].describe(), reloaded["reader"].describe())
+	assert.Equal(t, a.users["default"].describe(), reloaded["default"].describe())
+
+	assert.Nil(t, os.WriteFile(filename, []byte("user broken on +nosuchcommand\n"), 0644))
+	_, err = a.loadFile(filename)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"default", "reader"}, a.userNames())
+}
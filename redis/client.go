@@ -7,6 +7,8 @@ import (
 	"go.uber.org/zap"
 	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type DBRangeCheck func(index int) error
@@ -15,21 +17,68 @@ type Rewrite func() error
 
 type ClearDatabase func()
 
+// ForEachClient 遍历所有的客户端, 回调返回 false 时停止
+type ForEachClient func(cb func(client *Client) bool)
+
+// Info 生成 INFO 命令的内容, sections 为空时返回默认的部分
+type Info func(sections []string) string
+
+// Monitor 把客户端注册为 monitor
+type Monitor func(client *Client)
+
+var nextClientId int64 = 0
+
+// CLIENT REPLY 的模式
+const (
+	replyOn = iota
+	replyOff
+)
+
 type Client struct {
 	Fd              int
+	id              int64
+	name            string
+	protocol        int
 	dbId            int
 	db              *DB
 	RangeCheck      DBRangeCheck
 	Rewrite         Rewrite
 	ClearDatabase   ClearDatabase
-	inner           bool
-	totalReplyBytes int
-	conn            gnet.Conn
-	writeBuffer     *bufio.Writer
-	codec           *Codec
-	curCommand      [][]byte
-	queryBuffer     *list.List
-	lg              *zap.Logger
+	ForEachClient   ForEachClient
+	Info            Info
+	Monitor         Monitor
+	user            *aclUser
+	authenticated   bool
+	createTime      time.Time
+	lastInteraction time.Time
+	lastCmd         string
+	noEvict         bool
+	monitor         bool
+	replyMode       int
+	replySkipNext   bool
+	replySkip       bool
+	pauseBlocked    bool
+	tracking        *clientTracking
+	caching         int
+	cachingNext     int
+	pushing         bool
+	// holding appendfsync 为 always 时写命令的回复等待 aof fsync, 之后的回复也保留在 heldReplies 中, 保证顺序
+	holding     bool
+	heldReplies []byte
+	// heldOffset 保留的回复需要等待 aof 确认的位置, waitingOffset 已经向 writer 注册等待的位置
+	heldOffset    int64
+	waitingOffset int64
+	// obufSoftLimitReachedTime 输出缓冲区第一次超过 soft limit 的时间
+	obufSoftLimitReachedTime time.Time
+	closeASAP                bool
+	inner                    bool
+	totalReplyBytes          int
+	conn                     gnet.Conn
+	writeBuffer              *bufio.Writer
+	codec                    *Codec
+	curCommand               [][]byte
+	queryBuffer              *list.List
+	lg                       *zap.Logger
 }
 
 func (c *Client) GetDbIndex() int {
@@ -48,6 +97,32 @@ func (c *Client) GetDb() *DB {
 	return c.db
 }
 
+func (c *Client) GetId() int64 {
+	return c.id
+}
+
+func (c *Client) GetName() string {
+	return c.name
+}
+
+func (c *Client) SetName(name string) {
+	c.name = name
+}
+
+// IsResp3 客户端是否通过 HELLO 切换到了 RESP3 协议
+func (c *Client) IsResp3() bool {
+	return c.protocol == resp3
+}
+
+func (c *Client) SetProtocol(protocol int) {
+	c.protocol = protocol
+}
+
+// GetUser 客户端当前使用的 ACL 用户
+func (c *Client) GetUser() string {
+	return c.user.name
+}
+
 func (c *Client) IsInner() bool {
 	return c.inner
 }
@@ -60,10 +135,31 @@ func (c *Client) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
-func (c *Client) Write(bytes []byte) (int, error) {
+// addr 客户端的地址, 服务器内部的客户端返回空字符串
+func (c *Client) addr() string {
 	if c.conn == nil {
+		return ""
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *Client) Write(bytes []byte) (int, error) {
+	if c.conn == nil || c.closeASAP {
 		return 0, nil
 	}
+	// 错误回复总是一次写入的, 关闭了回复的客户端也要统计
+	if len(bytes) > 0 && bytes[0] == '-' && !c.pushing {
+		stats.errorReply(bytes)
+	}
+	// CLIENT REPLY OFF 或者 SKIP 时丢弃回复, 推送的消息除外
+	if (c.replyMode == replyOff || c.replySkip) && !c.pushing {
+		return len(bytes), nil
+	}
+	if c.holding {
+		c.heldReplies = append(c.heldReplies, bytes...)
+		c.totalReplyBytes += len(bytes)
+		return len(bytes), nil
+	}
 	n, err := c.writeBuffer.Write(bytes)
 	if err != nil {
 		return 0, err
@@ -72,7 +168,18 @@ func (c *Client) Write(bytes []byte) (int, error) {
 	return n, err
 }
 
+// Close 异步关闭连接, 可以在处理其他客户端的命令时调用
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
 func (c *Client) Flush() error {
+	if c.holding {
+		return nil
+	}
 	if c.writeBuffer.Buffered() > 0 {
 		if err := c.writeBuffer.Flush(); err != nil {
 			return err
@@ -81,6 +188,20 @@ func (c *Client) Flush() error {
 	return nil
 }
 
+// releaseHeld 发送等待 aof fsync 的回复
+func (c *Client) releaseHeld() error {
+	c.holding = false
+	held := c.heldReplies
+	c.heldReplies = nil
+	if c.conn == nil || c.closeASAP || len(held) == 0 {
+		return nil
+	}
+	if _, err := c.writeBuffer.Write(held); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
 func (c *Client) PollCmd() [][]byte {
 	if c.queryBuffer.Len() > 0 {
 		front := c.queryBuffer.Front()
@@ -92,6 +213,14 @@ func (c *Client) PollCmd() [][]byte {
 	return nil
 }
 
+// PeekCmd 返回下一个要执行的命令, 但不从队列中移除
+func (c *Client) PeekCmd() [][]byte {
+	if c.queryBuffer.Len() > 0 {
+		return c.queryBuffer.Front().Value.([][]byte)
+	}
+	return nil
+}
+
 func (c *Client) PushCmd(cmdline [][]byte) {
 	c.queryBuffer.PushBack(cmdline)
 }
@@ -132,6 +261,11 @@ func (c *Client) GetCmdLine() [][]byte {
 func NewClient(Fd int, conn gnet.Conn, inner bool) *Client {
 	client := &Client{}
 	client.Fd = Fd
+	client.id = atomic.AddInt64(&nextClientId, 1)
+	client.protocol = resp2
+	client.user = acl.defaultUser()
+	client.createTime = time.Now()
+	client.lastInteraction = client.createTime
 	client.dbId = 0
 	client.conn = conn
 	client.writeBuffer = bufio.NewWriterSize(conn, 1<<16) // 64KB
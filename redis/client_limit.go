@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputBufferLimit 一类客户端的输出缓冲区限制, 为 0 表示不限制。
+// 超过 hard 马上断开, 超过 soft 持续 softSeconds 之后断开
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int64
+}
+
+// outputBufferLimitClasses client-output-buffer-limit 中客户端的分类
+var outputBufferLimitClasses = []string{"normal", "replica", "pubsub"}
+
+// outputBufferLimits 和 redis 的默认值相同
+var outputBufferLimits = map[string]outputBufferLimit{
+	"normal":  {0, 0, 0},
+	"replica": {256 * 1024 * 1024, 64 * 1024 * 1024, 60},
+	"pubsub":  {32 * 1024 * 1024, 8 * 1024 * 1024, 60},
+}
+
+// parseOutputBufferLimits 解析 <class> <hard> <soft> <soft seconds> [<class> <hard> <soft> <soft seconds> ...],
+// 没有出现的分类保持原来的配置
+func parseOutputBufferLimits(value string) (map[string]outputBufferLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	limits := make(map[string]outputBufferLimit, len(outputBufferLimits))
+	for class, limit := range outputBufferLimits {
+		limits[class] = limit
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		if _, ok := limits[class]; !ok {
+			return nil, fmt.Errorf("invalid client class specified in buffer limit configuration")
+		}
+		hard, err := util.ParseMemory(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+		soft, err := util.ParseMemory(fields[i+2])
+		if err != nil {
+			return nil, err
+		}
+		softSeconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || softSeconds < 0 {
+			return nil, errors.New("soft limit seconds must be a non-negative integer")
+		}
+		limits[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: softSeconds}
+	}
+	return limits, nil
+}
+
+// applyOutputBufferLimits 使用 client-output-buffer-limit 的配置, 并把配置改写为包含所有分类的完整形式
+func applyOutputBufferLimits() error {
+	if value := config.Properties.ClientOutputBufferLimit; value != "" {
+		limits, err := parseOutputBufferLimits(value)
+		if err != nil {
+			return err
+		}
+		outputBufferLimits = limits
+	}
+	config.Properties.ClientOutputBufferLimit = formatOutputBufferLimits(outputBufferLimits)
+	return nil
+}
+
+// formatOutputBufferLimits 按照 normal, replica, pubsub 的顺序输出配置
+func formatOutputBufferLimits(limits map[string]outputBufferLimit) string {
+	parts := make([]string, 0, len(outputBufferLimitClasses))
+	for _, class := range outputBufferLimitClasses {
+		limit := limits[class]
+		name := class
+		if class == "replica" {
+			name = "slave"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d %d %d", name, limit.hard, limit.soft, limit.softSeconds))
+	}
+	return strings.Join(parts, " ")
+}
+
+// outputBufferLimitClass 主节点使用 normal 的限制
+func outputBufferLimitClass(client *Client) string {
+	class := clientType(client)
+	if class == "master" {
+		return "normal"
+	}
+	return class
+}
+
+// outputBufferSize 还没有写到 socket 的回复
+func (c *Client) outputBufferSize() int64 {
+	if c.conn == nil {
+		return 0
+	}
+	return int64(c.writeBuffer.Buffered() + len(c.heldReplies) + c.conn.OutboundBuffered())
+}
+
+// outputBufferLimitReached 是否超过了输出缓冲区的限制, 只能在事件循环中调用
+func (c *Client) outputBufferLimitReached(now time.Time) bool {
+	if c.conn == nil {
+		return false
+	}
+	limit := outputBufferLimits[outputBufferLimitClass(c)]
+	used := c.outputBufferSize()
+	hard := limit.hard > 0 && used >= limit.hard
+	soft := limit.soft > 0 && used >= limit.soft
+	if !soft {
+		c.obufSoftLimitReachedTime = time.Time{}
+		return hard
+	}
+	// 第一次超过 soft 的时候开始计时
+	if c.obufSoftLimitReachedTime.IsZero() {
+		c.obufSoftLimitReachedTime = now
+		return hard
+	}
+	if int64(now.Sub(c.obufSoftLimitReachedTime).Seconds()) <= limit.softSeconds {
+		return hard
+	}
+	return true
+}
+
+// closeAsap 丢弃之后的回复和还没有执行的命令, 并且异步的关闭连接
+func (c *Client) closeAsap() {
+	c.closeASAP = true
+	c.ResetQueryBuffer()
+	_ = c.Close()
+}
+
+// idleTimeout 客户端空闲的时间是否超过了 timeout, 被 CLIENT PAUSE 阻塞的客户端和 monitor 不会超时
+func (c *Client) idleTimeout(now time.Time, timeout time.Duration) bool {
+	if timeout <= 0 || c.IsInner() || c.pauseBlocked || c.monitor {
+		return false
+	}
+	return now.Sub(c.lastInteraction) > timeout
+}
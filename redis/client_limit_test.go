@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseOutputBufferLimits(t *testing.T) {
+	limits, err := parseOutputBufferLimits("normal 1mb 512kb 10 slave 0 0 0")
+	assert.Nil(t, err)
+	assert.Equal(t, outputBufferLimit{hard: 1024 * 1024, soft: 512 * 1024, softSeconds: 10}, limits["normal"])
+	assert.Equal(t, outputBufferLimit{}, limits["replica"])
+	// 没有配置的分类使用原来的值
+	assert.Equal(t, outputBufferLimits["pubsub"], limits["pubsub"])
+	assert.Equal(t, "normal 1048576 524288 10 slave 0 0 0 pubsub 33554432 8388608 60", formatOutputBufferLimits(limits))
+
+	for _, value := range []string{"", "normal 1mb 1mb", "unknown 0 0 0", "normal 1xb 0 0", "normal 0 0 -1"} {
+		_, err = parseOutputBufferLimits(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	client := NewClient(0, nil, false)
+	now := client.lastInteraction.Add(3 * time.Second)
+	assert.False(t, client.idleTimeout(now, 0))
+	assert.False(t, client.idleTimeout(now, 5*time.Second))
+	assert.True(t, client.idleTimeout(now, 2*time.Second))
+	client.pauseBlocked = true
+	assert.False(t, client.idleTimeout(now, 2*time.Second))
+	assert.False(t, systemClient.idleTimeout(now, 2*time.Second))
+}
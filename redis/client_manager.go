@@ -10,6 +10,9 @@ type ConnCount interface {
 
 type Manager struct {
 	conns map[int]*Client
+	ids   map[int64]*Client
+	// monitors 执行了 MONITOR 的客户端
+	monitors map[int64]*Client
 }
 
 func (s *Manager) CountConnections() int {
@@ -18,12 +21,15 @@ func (s *Manager) CountConnections() int {
 
 func (s *Manager) RegisterConn(fd int, client *Client) {
 	s.conns[fd] = client
+	s.ids[client.id] = client
 }
 
 func (s *Manager) RemoveConnByKey(fd int) {
-	_, exists := s.conns[fd]
+	client, exists := s.conns[fd]
 	if exists {
 		delete(s.conns, fd)
+		delete(s.ids, client.id)
+		delete(s.monitors, client.id)
 		return
 	}
 }
@@ -33,12 +39,47 @@ func (s *Manager) Get(fd int) *Client {
 	return c
 }
 
+// GetById 根据客户端 id 查找客户端, 不存在时返回 nil
+func (s *Manager) GetById(id int64) *Client {
+	return s.ids[id]
+}
+
+func (s *Manager) ForEach(cb func(client *Client) bool) {
+	for _, client := range s.conns {
+		if !cb(client) {
+			return
+		}
+	}
+}
+
 func (s *Manager) RemoveConn(conn *Client) {
 	delete(s.conns, conn.Fd)
+	delete(s.ids, conn.id)
+	delete(s.monitors, conn.id)
+}
+
+// AddMonitor 客户端开始接收所有执行的命令
+func (s *Manager) AddMonitor(client *Client) {
+	client.monitor = true
+	s.monitors[client.id] = client
+}
+
+func (s *Manager) MonitorCount() int {
+	return len(s.monitors)
+}
+
+func (s *Manager) ForEachMonitor(cb func(client *Client) bool) {
+	for _, client := range s.monitors {
+		if !cb(client) {
+			return
+		}
+	}
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		conns: make(map[int]*Client),
+		conns:    make(map[int]*Client),
+		ids:      make(map[int64]*Client),
+		monitors: make(map[int64]*Client),
 	}
 }
@@ -0,0 +1,273 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/logger"
+	"strconv"
+	"strings"
+)
+
+// execAuth auth [username] password
+func execAuth(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	if len(args) > 2 {
+		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
+	}
+	username := []byte(defaultUserName)
+	password := args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if acl.defaultUser().nopass {
+		return MakeStandardErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?").WriteTo(conn)
+	}
+	if err := acl.authenticate(conn, username, password); err != nil {
+		return MakeStandardErrReply(err.Error()).WriteTo(conn)
+	}
+	return MakeOkReply().WriteTo(conn)
+}
+
+// execAcl acl <subcommand> [args ...]
+func execAcl(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	subCommand := string(args[0])
+	args = args[1:]
+	switch strings.ToLower(subCommand) {
+	case "setuser":
+		return aclSetUser(conn, args)
+	case "getuser":
+		return aclGetUser(conn, args)
+	case "deluser":
+		return aclDelUser(conn, args)
+	case "users":
+		return aclUsers(conn, args)
+	case "list":
+		return aclList(conn, args)
+	case "whoami":
+		return aclWhoami(conn, args)
+	case "cat":
+		return aclCat(conn, args)
+	case "log":
+		return aclLog(conn, args)
+	case "load":
+		return aclLoad(conn, args)
+	case "save":
+		return aclSave(conn, args)
+	default:
+		return MakeStandardErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try ACL HELP.", subCommand)).WriteTo(conn)
+	}
+}
+
+func aclSubCommandArgsErr(subCommand string) Reply {
+	return MakeStandardErrReply(fmt.Sprintf("ERR wrong number of arguments for 'acl|%s' command", subCommand))
+}
+
+// aclSetUser acl setuser username [rule [rule ...]]
+func aclSetUser(conn *Client, args [][]byte) error {
+	if len(args) < 1 {
+		return aclSubCommandArgsErr("setuser").WriteTo(conn)
+	}
+	name := string(args[0])
+	if strings.ContainsAny(name, " \t\r\n\x00") {
+		return MakeStandardErrReply("ERR Usernames can't contain spaces or null characters").WriteTo(conn)
+	}
+	rules := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		rules[i] = string(arg)
+	}
+	if err := acl.setUser(name, rules); err != nil {
+		return MakeStandardErrReply("ERR " + err.Error()).WriteTo(conn)
+	}
+	return MakeOkReply().WriteTo(conn)
+}
+
+// aclGetUser acl getuser username
+func aclGetUser(conn *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return aclSubCommandArgsErr("getuser").WriteTo(conn)
+	}
+	user, ok := acl.getUser(string(args[0]))
+	if !ok {
+		return MakeNullBulkReply().WriteTo(conn)
+	}
+	flags := make([][]byte, 0)
+	for _, flag := range user.flags() {
+		flags = append(flags, []byte(flag))
+	}
+	passwords := make([][]byte, 0)
+	for _, hash := range user.passwordHashes() {
+		passwords = append(passwords, []byte(hash))
+	}
+	return MakeMapReply([]Reply{
+		MakeBulkReply([]byte("flags")), MakeSetReply(flags),
+		MakeBulkReply([]byte("passwords")), MakeMultiBulkReply(passwords),
+		MakeBulkReply([]byte("commands")), MakeBulkReply([]byte(user.describeCommands())),
+		MakeBulkReply([]byte("keys")), MakeBulkReply([]byte(user.describeKeys())),
+		MakeBulkReply([]byte("channels")), MakeBulkReply([]byte(user.describeChannels())),
+		MakeBulkReply([]byte("selectors")), MakeEmptyMultiBulkReply(),
+	}).WriteTo(conn)
+}
+
+// aclDelUser acl deluser username [username ...], 使用被删除用户认证的客户端会被断开
+func aclDelUser(conn *Client, args [][]byte) error {
+	if len(args) < 1 {
+		return aclSubCommandArgsErr("deluser").WriteTo(conn)
+	}
+	for _, arg := range args {
+		if string(arg) == defaultUserName {
+			return MakeStandardErrReply("ERR The 'default' user cannot be removed").WriteTo(conn)
+		}
+	}
+	removed := make([]*aclUser, 0, len(args))
+	for _, arg := range args {
+		if user := acl.deleteUser(string(arg)); user != nil {
+			removed = append(removed, user)
+		}
+	}
+	if err := MakeIntReply(int64(len(removed))).WriteTo(conn); err != nil {
+		return err
+	}
+	killClientsOfUsers(conn, removed)
+	return nil
+}
+
+// killClientsOfUsers 断开使用这些用户认证的客户端
+func killClientsOfUsers(conn *Client, users []*aclUser) {
+	if len(users) == 0 || conn.ForEachClient == nil {
+		return
+	}
+	conn.ForEachClient(func(client *Client) bool {
+		for _, user := range users {
+			if client.user == user {
+				_ = client.Close()
+				break
+			}
+		}
+		return true
+	})
+}
+
+// aclUsers acl users
+func aclUsers(conn *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return aclSubCommandArgsErr("users").WriteTo(conn)
+	}
+	names := acl.userNames()
+	result := make([][]byte, len(names))
+	for i, name := range names {
+		result[i] = []byte(name)
+	}
+	return MakeMultiBulkReply(result).WriteTo(conn)
+}
+
+// aclList acl list
+func aclList(conn *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return aclSubCommandArgsErr("list").WriteTo(conn)
+	}
+	names := acl.userNames()
+	result := make([][]byte, len(names))
+	for i, name := range names {
+		user, _ := acl.getUser(name)
+		result[i] = []byte(user.describe())
+	}
+	return MakeMultiBulkReply(result).WriteTo(conn)
+}
+
+// aclWhoami acl whoami
+func aclWhoami(conn *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return aclSubCommandArgsErr("whoami").WriteTo(conn)
+	}
+	return MakeBulkReply([]byte(conn.user.name)).WriteTo(conn)
+}
+
+// aclCat acl cat [category]
+func aclCat(conn *Client, args [][]byte) error {
+	if len(args) > 1 {
+		return aclSubCommandArgsErr("cat").WriteTo(conn)
+	}
+	var names []string
+	if len(args) == 0 {
+		names = aclCategories
+	} else {
+		category := strings.ToLower(string(args[0]))
+		if category == "all" || !validAclCategory(category) {
+			return MakeStandardErrReply(fmt.Sprintf("ERR Unknown category '%s'", string(args[0]))).WriteTo(conn)
+		}
+		names = commandsInCategory(category)
+	}
+	result := make([][]byte, len(names))
+	for i, name := range names {
+		result[i] = []byte(name)
+	}
+	return MakeMultiBulkReply(result).WriteTo(conn)
+}
+
+// aclLog acl log [count | RESET]
+func aclLog(conn *Client, args [][]byte) error {
+	if len(args) > 1 {
+		return aclSubCommandArgsErr("log").WriteTo(conn)
+	}
+	count := len(acl.log)
+	if len(args) == 1 {
+		if strings.ToLower(string(args[0])) == "reset" {
+			acl.resetLog()
+			return MakeOkReply().WriteTo(conn)
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil || n < 0 {
+			return MakeOutOfRangeOrNotInt().WriteTo(conn)
+		}
+		if n < count {
+			count = n
+		}
+	}
+	replies := make([]Reply, count)
+	for i := 0; i < count; i++ {
+		replies[i] = acl.log[i].toReply()
+	}
+	return MakeMultiRowReply(replies).WriteTo(conn)
+}
+
+// aclLoad acl load 重新加载 aclfile
+func aclLoad(conn *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return aclSubCommandArgsErr("load").WriteTo(conn)
+	}
+	if config.Properties.AclFile == "" {
+		return MakeStandardErrReply(errAclFileNotSet.Error()).WriteTo(conn)
+	}
+	removed, err := acl.loadFile(config.Properties.AclFile)
+	if err != nil {
+		return MakeStandardErrReply("ERR " + err.Error()).WriteTo(conn)
+	}
+	if err = MakeOkReply().WriteTo(conn); err != nil {
+		return err
+	}
+	killClientsOfUsers(conn, removed)
+	return nil
+}
+
+// aclSave acl save 把当前的用户写入 aclfile
+func aclSave(conn *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return aclSubCommandArgsErr("save").WriteTo(conn)
+	}
+	if config.Properties.AclFile == "" {
+		return MakeStandardErrReply(errAclFileNotSet.Error()).WriteTo(conn)
+	}
+	if err := acl.saveFile(config.Properties.AclFile); err != nil {
+		logger.Errorf("save aclfile %s failed with error: %v", config.Properties.AclFile, err)
+		return MakeStandardErrReply("ERR There was an error trying to save the ACLs. " +
+			"Please check the server logs for more information").WriteTo(conn)
+	}
+	return MakeOkReply().WriteTo(conn)
+}
+
+func init() {
+	register("auth", execAuth, -2, "noscript fast no_auth @connection", 0, 0, 0).withSensitiveArgs(allArgsSensitive)
+	register("acl", execAcl, -2, "admin noscript", 0, 0, 0)
+}
@@ -0,0 +1,634 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CLIENT PAUSE 的模式
+const (
+	pauseOff = iota
+	pauseWrite
+	pauseAll
+)
+
+// clientPauseState CLIENT PAUSE 的状态, 只在事件循环中访问
+type clientPauseState struct {
+	mode int
+	end  time.Time
+}
+
+var clientPause = &clientPauseState{}
+
+func (p *clientPauseState) active(now time.Time) bool {
+	return p.mode != pauseOff && now.Before(p.end)
+}
+
+// expired 暂停的时间已经到了, 但是还没有恢复被阻塞的客户端
+func (p *clientPauseState) expired(now time.Time) bool {
+	return p.mode != pauseOff && !now.Before(p.end)
+}
+
+// pause 已经在暂停中时, 使用更长的结束时间和更严格的模式
+func (p *clientPauseState) pause(mode int, end time.Time) {
+	if p.mode == pauseOff || end.After(p.end) {
+		p.end = end
+	}
+	if mode > p.mode {
+		p.mode = mode
+	}
+}
+
+// blocks 暂停期间客户端的下一个命令是否需要等待
+func (p *clientPauseState) blocks(conn *Client, now time.Time) bool {
+	if conn.IsInner() || !p.active(now) {
+		return false
+	}
+	if p.mode == pauseAll {
+		return true
+	}
+	cmdLine := conn.PeekCmd()
+	if len(cmdLine) == 0 {
+		return false
+	}
+	cmd, err := router(string(cmdLine[0]))
+	if err != nil {
+		return false
+	}
+	return cmd.hasFlag(cmdWrite)
+}
+
+// wakeAfterPause 暂停结束的时候唤醒客户端, 不需要等到下一次定时任务
+func (p *clientPauseState) wakeAfterPause(conn *Client, now time.Time) {
+	if conn.conn == nil {
+		return
+	}
+	c := conn.conn
+	time.AfterFunc(p.end.Sub(now), func() {
+		_ = c.Wake(nil)
+	})
+}
+
+// unpauseClients 结束暂停, 唤醒被阻塞的客户端继续执行命令
+func unpauseClients(forEach ForEachClient) {
+	clientPause.mode = pauseOff
+	if forEach == nil {
+		return
+	}
+	forEach(func(client *Client) bool {
+		if client.pauseBlocked {
+			client.pauseBlocked = false
+			if client.conn != nil {
+				_ = client.conn.Wake(nil)
+			}
+		}
+		return true
+	})
+}
+
+// clientType 还没有主从复制和发布订阅, 所有的客户端都是 normal
+func clientType(client *Client) string {
+	return "normal"
+}
+
+func validClientType(typ string) bool {
+	switch typ {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+	return false
+}
+
+func (c *Client) flagString() string {
+	var flags []byte
+	if c.pauseBlocked {
+		flags = append(flags, 'b')
+	}
+	if c.noEvict {
+		flags = append(flags, 'e')
+	}
+	if c.monitor {
+		flags = append(flags, 'O')
+	}
+	if c.tracking != nil {
+		flags = append(flags, 't')
+		if c.tracking.redirectBroken {
+			flags = append(flags, 'R')
+		}
+		if c.tracking.bcast {
+			flags = append(flags, 'B')
+		}
+	}
+	if len(flags) == 0 {
+		return "N"
+	}
+	return string(flags)
+}
+
+// argvMem 还在队列中等待执行的命令占用的内存
+func (c *Client) argvMem() int {
+	size := 0
+	for e := c.queryBuffer.Front(); e != nil; e = e.Next() {
+		for _, arg := range e.Value.([][]byte) {
+			size += len(arg)
+		}
+	}
+	return size
+}
+
+// info CLIENT LIST 和 CLIENT INFO 中的一行
+func (c *Client) info() string {
+	now := time.Now()
+	var addr, laddr string
+	var qbuf, omem int
+	if c.conn != nil {
+		addr = c.RemoteAddr().String()
+		laddr = c.conn.LocalAddr().String()
+		qbuf = c.conn.InboundBuffered()
+		omem = c.conn.OutboundBuffered()
+	}
+	username := ""
+	if c.user != nil {
+		username = c.user.name
+	}
+	cmd := c.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	argvMem := c.argvMem()
+	obl := 0
+	if c.writeBuffer != nil {
+		obl = c.writeBuffer.Buffered()
+	}
+	var redir int64 = -1
+	if c.tracking != nil {
+		redir = c.tracking.redirect
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=%d name=%s age=%d idle=%d flags=%s db=%d sub=0 psub=0 ssub=0 "+
+		"multi=-1 qbuf=%d qbuf-free=0 argv-mem=%d multi-mem=0 rbs=0 rbp=0 obl=%d oll=0 omem=%d tot-mem=%d "+
+		"events=r cmd=%s user=%s redir=%d resp=%d",
+		c.id, addr, laddr, c.Fd, c.name, int64(now.Sub(c.createTime).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		c.flagString(), c.dbId, qbuf, argvMem, obl, omem, qbuf+argvMem+obl+omem, cmd, username, redir, c.protocol)
+}
+
+// execClient client <subcommand> [args ...]
+func execClient(ctx context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	subCommand := string(args[0])
+	args = args[1:]
+	switch strings.ToLower(subCommand) {
+	case "id":
+		if len(args) != 0 {
+			return clientSubCommandArgsErr("id").WriteTo(conn)
+		}
+		return MakeIntReply(conn.GetId()).WriteTo(conn)
+	case "info":
+		if len(args) != 0 {
+			return clientSubCommandArgsErr("info").WriteTo(conn)
+		}
+		return MakeVerbatimReply("txt", []byte(conn.info()+"\n")).WriteTo(conn)
+	case "list":
+		return clientList(conn, args)
+	case "setname":
+		return clientSetName(conn, args)
+	case "getname":
+		if len(args) != 0 {
+			return clientSubCommandArgsErr("getname").WriteTo(conn)
+		}
+		if conn.GetName() == "" {
+			return MakeNullBulkReply().WriteTo(conn)
+		}
+		return MakeBulkReply([]byte(conn.GetName())).WriteTo(conn)
+	case "kill":
+		return clientKill(conn, args)
+	case "pause":
+		return clientPauseCmd(conn, args)
+	case "unpause":
+		if len(args) != 0 {
+			return clientSubCommandArgsErr("unpause").WriteTo(conn)
+		}
+		unpauseClients(conn.ForEachClient)
+		return MakeOkReply().WriteTo(conn)
+	case "no-evict":
+		return clientNoEvict(conn, args)
+	case "reply":
+		return clientReply(conn, args)
+	case "tracking":
+		return clientTrackingCmd(conn, args)
+	case "caching":
+		return clientCaching(conn, args)
+	case "getredir":
+		if len(args) != 0 {
+			return clientSubCommandArgsErr("getredir").WriteTo(conn)
+		}
+		if conn.tracking == nil {
+			return MakeIntReply(-1).WriteTo(conn)
+		}
+		return MakeIntReply(conn.tracking.redirect).WriteTo(conn)
+	case "trackinginfo":
+		if len(args) != 0 {
+			return clientSubCommandArgsErr("trackinginfo").WriteTo(conn)
+		}
+		return clientTrackingInfo(conn)
+	default:
+		return MakeStandardErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", subCommand)).WriteTo(conn)
+	}
+}
+
+func clientSubCommandArgsErr(subCommand string) Reply {
+	return MakeStandardErrReply(fmt.Sprintf("ERR wrong number of arguments for 'client|%s' command", subCommand))
+}
+
+// allClients 按 id 排序的所有客户端
+func allClients(conn *Client) []*Client {
+	clients := make([]*Client, 0)
+	if conn.ForEachClient == nil {
+		return clients
+	}
+	conn.ForEachClient(func(client *Client) bool {
+		clients = append(clients, client)
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].id < clients[j].id
+	})
+	return clients
+}
+
+// clientList client list [TYPE normal|master|replica|pubsub] [ID client-id [client-id ...]]
+func clientList(conn *Client, args [][]byte) error {
+	var typ string
+	var ids map[int64]struct{}
+	if len(args) > 0 {
+		option := strings.ToLower(string(args[0]))
+		if option == "type" && len(args) == 2 {
+			typ = strings.ToLower(string(args[1]))
+			if !validClientType(typ) {
+				return MakeStandardErrReply(fmt.Sprintf("ERR Unknown client type '%s'", string(args[1]))).WriteTo(conn)
+			}
+		} else if option == "id" && len(args) >= 2 {
+			ids = make(map[int64]struct{})
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseInt(string(arg), 10, 64)
+				if err != nil || id <= 0 {
+					return MakeStandardErrReply("ERR Invalid client ID").WriteTo(conn)
+				}
+				ids[id] = struct{}{}
+			}
+		} else {
+			return MakeSyntaxReply().WriteTo(conn)
+		}
+	}
+	var builder strings.Builder
+	for _, client := range allClients(conn) {
+		if typ != "" && clientType(client) != typ {
+			continue
+		}
+		if ids != nil {
+			if _, ok := ids[client.id]; !ok {
+				continue
+			}
+		}
+		builder.WriteString(client.info())
+		builder.WriteString("\n")
+	}
+	return MakeVerbatimReply("txt", []byte(builder.String())).WriteTo(conn)
+}
+
+// clientSetName client setname connection-name, 空字符串会清除名称
+func clientSetName(conn *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return clientSubCommandArgsErr("setname").WriteTo(conn)
+	}
+	name := string(args[0])
+	if !validClientName(name) {
+		return MakeStandardErrReply("ERR Client names cannot contain spaces, newlines or special characters.").WriteTo(conn)
+	}
+	conn.SetName(name)
+	return MakeOkReply().WriteTo(conn)
+}
+
+// clientKillFilter CLIENT KILL 的过滤条件, 所有条件都满足的客户端会被断开
+type clientKillFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	user   string
+	typ    string
+	maxAge int64
+	skipMe bool
+}
+
+func (f *clientKillFilter) match(self, client *Client) bool {
+	if f.skipMe && client == self {
+		return false
+	}
+	if f.id != 0 && client.id != f.id {
+		return false
+	}
+	if f.addr != "" && (client.conn == nil || client.RemoteAddr().String() != f.addr) {
+		return false
+	}
+	if f.laddr != "" && (client.conn == nil || client.conn.LocalAddr().String() != f.laddr) {
+		return false
+	}
+	if f.user != "" && (client.user == nil || client.user.name != f.user) {
+		return false
+	}
+	if f.typ != "" && clientType(client) != f.typ {
+		return false
+	}
+	if f.maxAge != 0 && int64(time.Since(client.createTime).Seconds()) < f.maxAge {
+		return false
+	}
+	return true
+}
+
+// clientKill client kill ip:port 或者
+// client kill [ID client-id] [TYPE type] [USER username] [ADDR ip:port] [LADDR ip:port] [SKIPME yes/no] [MAXAGE maxage]
+func clientKill(conn *Client, args [][]byte) error {
+	if len(args) == 0 {
+		return clientSubCommandArgsErr("kill").WriteTo(conn)
+	}
+	// 旧的格式只能按照地址断开, 并且会断开自己
+	if len(args) == 1 {
+		filter := &clientKillFilter{addr: string(args[0])}
+		if killClients(conn, filter) == 0 {
+			return MakeStandardErrReply("ERR No such client").WriteTo(conn)
+		}
+		return MakeOkReply().WriteTo(conn)
+	}
+	if len(args)%2 != 0 {
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	filter := &clientKillFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return MakeStandardErrReply("ERR client-id should be greater than 0").WriteTo(conn)
+			}
+			filter.id = id
+		case "type":
+			filter.typ = strings.ToLower(value)
+			if !validClientType(filter.typ) {
+				return MakeStandardErrReply(fmt.Sprintf("ERR Unknown client type '%s'", value)).WriteTo(conn)
+			}
+			if filter.typ == "slave" {
+				filter.typ = "replica"
+			}
+		case "user":
+			if _, ok := acl.getUser(value); !ok {
+				return MakeStandardErrReply(fmt.Sprintf("ERR No such user '%s'", value)).WriteTo(conn)
+			}
+			filter.user = value
+		case "addr":
+			filter.addr = value
+		case "laddr":
+			filter.laddr = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return MakeSyntaxReply().WriteTo(conn)
+			}
+		case "maxage":
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || maxAge <= 0 {
+				return MakeOutOfRangeOrNotInt().WriteTo(conn)
+			}
+			filter.maxAge = maxAge
+		default:
+			return MakeSyntaxReply().WriteTo(conn)
+		}
+	}
+	return MakeIntReply(int64(killClients(conn, filter))).WriteTo(conn)
+}
+
+// killClients 断开满足条件的客户端, 断开是异步的, 当前客户端会在回复发送之后断开
+func killClients(conn *Client, filter *clientKillFilter) int {
+	killed := 0
+	for _, client := range allClients(conn) {
+		if filter.match(conn, client) {
+			_ = client.Close()
+			killed++
+		}
+	}
+	return killed
+}
+
+// clientPauseCmd client pause timeout [WRITE|ALL]
+func clientPauseCmd(conn *Client, args [][]byte) error {
+	if len(args) < 1 || len(args) > 2 {
+		return clientSubCommandArgsErr("pause").WriteTo(conn)
+	}
+	timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || timeout < 0 {
+		return MakeStandardErrReply("ERR timeout is not an integer or out of range").WriteTo(conn)
+	}
+	mode := pauseAll
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "write":
+			mode = pauseWrite
+		case "all":
+			mode = pauseAll
+		default:
+			return MakeSyntaxReply().WriteTo(conn)
+		}
+	}
+	clientPause.pause(mode, time.Now().Add(time.Duration(timeout)*time.Millisecond))
+	return MakeOkReply().WriteTo(conn)
+}
+
+// clientNoEvict client no-evict ON|OFF
+func clientNoEvict(conn *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return clientSubCommandArgsErr("no-evict").WriteTo(conn)
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+		conn.noEvict = true
+	case "off":
+		conn.noEvict = false
+	default:
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	return MakeOkReply().WriteTo(conn)
+}
+
+// clientReply client reply ON|OFF|SKIP, OFF 和 SKIP 本身也没有回复
+func clientReply(conn *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return clientSubCommandArgsErr("reply").WriteTo(conn)
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+		conn.replyMode = replyOn
+		conn.replySkip = false
+		return MakeOkReply().WriteTo(conn)
+	case "off":
+		conn.replyMode = replyOff
+	case "skip":
+		if conn.replyMode != replyOff {
+			conn.replySkipNext = true
+		}
+	default:
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	return nil
+}
+
+// clientTrackingCmd client tracking ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTrackingCmd(conn *Client, args [][]byte) error {
+	if len(args) == 0 {
+		return clientSubCommandArgsErr("tracking").WriteTo(conn)
+	}
+	options := &clientTracking{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return MakeSyntaxReply().WriteTo(conn)
+			}
+			i++
+			id, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return MakeOutOfRangeOrNotInt().WriteTo(conn)
+			}
+			if id != conn.id && (tracking.clientById == nil || tracking.clientById(id) == nil) {
+				return MakeStandardErrReply("ERR The client ID you want redirect to does not exist").WriteTo(conn)
+			}
+			options.redirect = id
+		case "prefix":
+			if i+1 >= len(args) {
+				return MakeSyntaxReply().WriteTo(conn)
+			}
+			i++
+			options.prefixes = append(options.prefixes, string(args[i]))
+		case "bcast":
+			options.bcast = true
+		case "optin":
+			options.optIn = true
+		case "optout":
+			options.optOut = true
+		case "noloop":
+			options.noLoop = true
+		default:
+			return MakeSyntaxReply().WriteTo(conn)
+		}
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+	case "off":
+		tracking.disable(conn)
+		return MakeOkReply().WriteTo(conn)
+	default:
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	if len(options.prefixes) > 0 && !options.bcast {
+		return MakeStandardErrReply("ERR PREFIX option requires BCAST mode to be enabled").WriteTo(conn)
+	}
+	if options.optIn && options.optOut {
+		return MakeStandardErrReply("ERR You can't use both OPTIN and OPTOUT").WriteTo(conn)
+	}
+	if options.bcast && (options.optIn || options.optOut) {
+		return MakeStandardErrReply("ERR OPTIN and OPTOUT are not compatible with BCAST").WriteTo(conn)
+	}
+	if current := conn.tracking; current != nil {
+		if current.bcast != options.bcast {
+			return MakeStandardErrReply("ERR You can't switch BCAST mode on/off before disabling tracking " +
+				"for this client, and then re-enabling it with a different mode.").WriteTo(conn)
+		}
+		if (options.optIn && current.optOut) || (options.optOut && current.optIn) {
+			return MakeStandardErrReply("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking " +
+				"for this client, and then re-enabling it with a different mode.").WriteTo(conn)
+		}
+	}
+	if options.bcast {
+		if err := tracking.checkPrefixes(conn, options.prefixes); err != nil {
+			return MakeStandardErrReply(err.Error()).WriteTo(conn)
+		}
+	}
+	tracking.enable(conn, options)
+	return MakeOkReply().WriteTo(conn)
+}
+
+// clientCaching client caching YES|NO, 只对下一个命令生效
+func clientCaching(conn *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return clientSubCommandArgsErr("caching").WriteTo(conn)
+	}
+	if conn.tracking == nil || (!conn.tracking.optIn && !conn.tracking.optOut) {
+		return MakeStandardErrReply("ERR CLIENT CACHING can be called only when the client is in tracking mode " +
+			"with OPTIN or OPTOUT mode enabled").WriteTo(conn)
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "yes":
+		if !conn.tracking.optIn {
+			return MakeStandardErrReply("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.").WriteTo(conn)
+		}
+		conn.cachingNext = cachingYes
+	case "no":
+		if !conn.tracking.optOut {
+			return MakeStandardErrReply("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.").WriteTo(conn)
+		}
+		conn.cachingNext = cachingNo
+	default:
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	return MakeOkReply().WriteTo(conn)
+}
+
+// clientTrackingInfo client trackinginfo
+func clientTrackingInfo(conn *Client) error {
+	flags := make([]string, 0)
+	var redirect int64 = -1
+	prefixes := make([]string, 0)
+	if t := conn.tracking; t == nil {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		if t.bcast {
+			flags = append(flags, "bcast")
+		}
+		if t.optIn {
+			flags = append(flags, "optin")
+			if conn.cachingNext == cachingYes {
+				flags = append(flags, "caching-yes")
+			}
+		}
+		if t.optOut {
+			flags = append(flags, "optout")
+			if conn.cachingNext == cachingNo {
+				flags = append(flags, "caching-no")
+			}
+		}
+		if t.noLoop {
+			flags = append(flags, "noloop")
+		}
+		if t.redirectBroken {
+			flags = append(flags, "broken_redirect")
+		}
+		redirect = t.redirect
+		prefixes = append(prefixes, t.prefixes...)
+	}
+	return MakeMapReply([]Reply{
+		MakeBulkReply([]byte("flags")), MakeSetReply(stringsToBytes(flags)),
+		MakeBulkReply([]byte("redirect")), MakeIntReply(redirect),
+		MakeBulkReply([]byte("prefixes")), MakeMultiBulkReply(stringsToBytes(prefixes)),
+	}).WriteTo(conn)
+}
+
+func init() {
+	register("client", execClient, -2, "admin noscript @connection", 0, 0, 0)
+}
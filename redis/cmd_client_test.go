@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"context"
+	"github.com/panjf2000/gnet/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/pkg/logger"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClientPauseState(t *testing.T) {
+	now := time.Now()
+	p := &clientPauseState{}
+	client := NewClient(0, nil, false)
+	client.PushCmd(util.ToCmdLine("set", "k", "v"))
+	assert.False(t, p.blocks(client, now))
+
+	p.pause(pauseWrite, now.Add(time.Second))
+	assert.True(t, p.active(now))
+	assert.True(t, p.blocks(client, now))
+	client.ResetQueryBuffer()
+	client.PushCmd(util.ToCmdLine("get", "k"))
+	assert.False(t, p.blocks(client, now))
+
+	// 更短的暂停不会提前结束, 更严格的模式会覆盖
+	p.pause(pauseAll, now.Add(time.Millisecond))
+	assert.Equal(t, now.Add(time.Second), p.end)
+	assert.True(t, p.blocks(client, now))
+	assert.False(t, p.blocks(NewClient(0, nil, true), now))
+
+	later := now.Add(2 * time.Second)
+	assert.False(t, p.active(later))
+	assert.True(t, p.expired(later))
+	assert.False(t, p.blocks(client, later))
+}
+
+func TestClientKillFilter(t *testing.T) {
+	self := NewClient(0, nil, false)
+	other := NewClient(0, nil, false)
+
+	filter := &clientKillFilter{skipMe: true}
+	assert.False(t, filter.match(self, self))
+	assert.True(t, filter.match(self, other))
+
+	filter = &clientKillFilter{id: other.GetId()}
+	assert.True(t, filter.match(self, other))
+	assert.False(t, filter.match(self, self))
+
+	filter = &clientKillFilter{user: "default", typ: "normal"}
+	assert.True(t, filter.match(self, other))
+	filter = &clientKillFilter{user: "nobody"}
+	assert.False(t, filter.match(self, other))
+	filter = &clientKillFilter{maxAge: 60}
+	assert.False(t, filter.match(self, other))
+}
+
+// closingConn 模拟对端已经关闭的连接, gnet 在 Write 失败时同步地调用 OnClose
+type closingConn struct {
+	gnet.Conn
+	server *RedisServer
+}
+
+func (c *closingConn) Write(p []byte) (int, error) {
+	c.server.OnClose(c, syscall.EPIPE)
+	return 0, syscall.EPIPE
+}
+
+func (c *closingConn) Fd() int {
+	return 1
+}
+
+func (c *closingConn) OutboundBuffered() int {
+	return 0
+}
+
+func (c *closingConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func TestFreeClientWhileProcessing(t *testing.T) {
+	server := makeTempServer()
+	server.connManager = NewManager()
+	server.lg = logger.Named("client-test")
+	conn := &closingConn{server: server}
+	client := NewClient(conn.Fd(), conn, false)
+	server.connManager.RegisterConn(conn.Fd(), client)
+	client.PushCmd(util.ToCmdLine("ping"))
+	done := make(chan error)
+	go func() {
+		done <- server.process(context.Background(), client)
+	}()
+	select {
+	case err := <-done:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("process deadlocked when the connection closed during a write")
+	}
+	assert.Nil(t, server.connManager.Get(conn.Fd()))
+}
@@ -0,0 +1,239 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"strings"
+)
+
+// commandDoc COMMAND DOCS 返回的文档
+type commandDoc struct {
+	summary string
+	since   string
+	group   string
+}
+
+var commandDocs = map[string]commandDoc{
+	"del":          {"Deletes one or more keys.", "1.0.0", "generic"},
+	"keys":         {"Returns all key names that match a pattern.", "1.0.0", "generic"},
+	"exists":       {"Determines whether one or more keys exist.", "1.0.0", "generic"},
+	"ttl":          {"Returns the expiration time in seconds of a key.", "1.0.0", "generic"},
+	"pttl":         {"Returns the expiration time in milliseconds of a key.", "2.6.0", "generic"},
+	"expire":       {"Sets the expiration time of a key in seconds.", "1.0.0", "generic"},
+	"persist":      {"Removes the expiration time of a key.", "2.2.0", "generic"},
+	"expireat":     {"Sets the expiration time of a key to a Unix timestamp.", "1.2.0", "generic"},
+	"type":         {"Determines the type of value stored at a key.", "1.0.0", "generic"},
+	"sort":         {"Sorts the elements in a list or a set, optionally storing the result.", "1.0.0", "generic"},
+	"sort_ro":      {"Returns the sorted elements of a list or a set.", "7.0.0", "generic"},
+	"sadd":         {"Adds one or more members to a set.", "1.0.0", "set"},
+	"smembers":     {"Returns all members of a set.", "1.0.0", "set"},
+	"scard":        {"Returns the number of members in a set.", "1.0.0", "set"},
+	"lpush":        {"Prepends one or more elements to a list.", "1.0.0", "list"},
+	"lpop":         {"Returns the first elements in a list after removing it.", "1.0.0", "list"},
+	"lrange":       {"Returns a range of elements from a list.", "1.0.0", "list"},
+	"rpush":        {"Appends one or more elements to a list.", "1.0.0", "list"},
+	"llen":         {"Returns the length of a list.", "1.0.0", "list"},
+	"lindex":       {"Returns an element from a list by its index.", "1.0.0", "list"},
+	"rpop":         {"Returns and removes the last elements of a list.", "1.0.0", "list"},
+	"set":          {"Sets the string value of a key.", "1.0.0", "string"},
+	"get":          {"Returns the string value of a key.", "1.0.0", "string"},
+	"setnx":        {"Sets the string value of a key only when the key doesn't exist.", "1.0.0", "string"},
+	"strlen":       {"Returns the length of a string value.", "2.2.0", "string"},
+	"incr":         {"Increments the integer value of a key by one.", "1.0.0", "string"},
+	"decr":         {"Decrements the integer value of a key by one.", "1.0.0", "string"},
+	"getset":       {"Returns the previous string value of a key after setting it to a new value.", "1.0.0", "string"},
+	"getrange":     {"Returns a substring of the string stored at a key.", "2.4.0", "string"},
+	"mget":         {"Atomically returns the string values of one or more keys.", "1.0.0", "string"},
+	"mset":         {"Atomically creates or modifies the string values of one or more keys.", "1.0.1", "string"},
+	"getdel":       {"Returns the string value of a key after deleting the key.", "6.2.0", "string"},
+	"incrby":       {"Increments the integer value of a key by a number.", "1.0.0", "string"},
+	"decrby":       {"Decrements a number from the integer value of a key.", "1.0.0", "string"},
+	"hset":         {"Creates or modifies the value of a field in a hash.", "2.0.0", "hash"},
+	"hget":         {"Returns the value of a field in a hash.", "2.0.0", "hash"},
+	"hgetall":      {"Returns all fields and values in a hash.", "2.0.0", "hash"},
+	"ping":         {"Returns the server's liveliness response.", "1.0.0", "connection"},
+	"hello":        {"Handshakes with the Redis server.", "6.0.0", "connection"},
+	"auth":         {"Authenticates the connection.", "1.0.0", "connection"},
+	"select":       {"Changes the selected database.", "1.0.0", "connection"},
+	"quit":         {"Closes the connection.", "1.0.0", "connection"},
+	"ttlops":       {"Actively expires keys, used internally by the server cron.", "1.0.0", "server"},
+	"bgrewriteaof": {"Asynchronously rewrites the append-only file to disk.", "1.0.0", "server"},
+	"flushdb":      {"Removes all keys from the current database.", "1.0.0", "server"},
+	"memory":       {"Estimates the memory usage of a key.", "4.0.0", "server"},
+	"info":         {"Returns information and statistics about the server.", "1.0.0", "server"},
+	"gc":           {"Triggers a garbage collection of the Go runtime.", "1.0.0", "server"},
+	"acl":          {"Manages ACL users, permissions and the ACL log.", "6.0.0", "server"},
+	"command":      {"Returns detailed information about all commands.", "2.8.13", "server"},
+	"client":       {"Inspects, names, kills, pauses and configures client connections.", "2.4.0", "connection"},
+	"config":       {"Reads, modifies and persists the server configuration at runtime.", "2.0.0", "server"},
+	"slowlog":      {"Reads or resets the slow log.", "2.2.12", "server"},
+	"latency":      {"Inspects latency spikes and per-command latency histograms.", "2.8.13", "server"},
+	"monitor":      {"Listens for all requests received by the server in real-time.", "1.0.0", "server"},
+}
+
+// execCommand command [COUNT | INFO [command ...] | DOCS [command ...] | GETKEYS command [arg ...] | LIST [FILTERBY ...]]
+func execCommand(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	if len(args) == 0 {
+		return commandInfo(conn, commandNames())
+	}
+	subCommand := string(args[0])
+	args = args[1:]
+	switch strings.ToLower(subCommand) {
+	case "count":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'command|count' command").WriteTo(conn)
+		}
+		return MakeIntReply(int64(len(commandRouter))).WriteTo(conn)
+	case "info":
+		names := commandNames()
+		if len(args) > 0 {
+			names = bytesToStrings(args)
+		}
+		return commandInfo(conn, names)
+	case "docs":
+		names := commandNames()
+		if len(args) > 0 {
+			names = bytesToStrings(args)
+		}
+		return commandDocsReply(conn, names)
+	case "getkeys":
+		return commandGetKeys(conn, args)
+	case "list":
+		return commandList(conn, args)
+	default:
+		return MakeStandardErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", subCommand)).WriteTo(conn)
+	}
+}
+
+func bytesToStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
+
+func stringsToBytes(strs []string) [][]byte {
+	result := make([][]byte, len(strs))
+	for i, str := range strs {
+		result[i] = []byte(str)
+	}
+	return result
+}
+
+// commandInfo 不存在的命令返回 nil
+func commandInfo(conn *Client, names []string) error {
+	replies := make([]Reply, len(names))
+	for i, name := range names {
+		cmd, err := router(name)
+		if err != nil {
+			replies[i] = MakeNullBulkReply()
+			continue
+		}
+		replies[i] = cmd.infoReply()
+	}
+	return MakeMultiRowReply(replies).WriteTo(conn)
+}
+
+// infoReply name, arity, flags, first key, last key, step, acl categories, tips, key specs, subcommands
+func (cmd *Command) infoReply() Reply {
+	categories := make([]string, len(cmd.categories))
+	for i, category := range cmd.categories {
+		categories[i] = "@" + category
+	}
+	return MakeMultiRowReply([]Reply{
+		MakeBulkReply([]byte(cmd.name)),
+		MakeIntReply(int64(cmd.arity)),
+		MakeSetReply(stringsToBytes(cmd.flagNames())),
+		MakeIntReply(int64(cmd.firstKey)),
+		MakeIntReply(int64(cmd.lastKey)),
+		MakeIntReply(int64(cmd.keyStep)),
+		MakeSetReply(stringsToBytes(categories)),
+		MakeEmptyMultiBulkReply(),
+		MakeEmptyMultiBulkReply(),
+		MakeEmptyMultiBulkReply(),
+	})
+}
+
+// commandDocsReply 返回 name => doc 的 map, 忽略不存在的命令
+func commandDocsReply(conn *Client, names []string) error {
+	pairs := make([]Reply, 0, len(names)*2)
+	for _, name := range names {
+		cmd, err := router(name)
+		if err != nil {
+			continue
+		}
+		doc := commandDocs[cmd.name]
+		fields := make([]Reply, 0, 6)
+		if doc.summary != "" {
+			fields = append(fields, MakeBulkReply([]byte("summary")), MakeBulkReply([]byte(doc.summary)))
+		}
+		if doc.since != "" {
+			fields = append(fields, MakeBulkReply([]byte("since")), MakeBulkReply([]byte(doc.since)))
+		}
+		if doc.group != "" {
+			fields = append(fields, MakeBulkReply([]byte("group")), MakeBulkReply([]byte(doc.group)))
+		}
+		pairs = append(pairs, MakeBulkReply([]byte(cmd.name)), MakeMapReply(fields))
+	}
+	return MakeMapReply(pairs).WriteTo(conn)
+}
+
+// commandGetKeys command getkeys command [arg ...]
+func commandGetKeys(conn *Client, args [][]byte) error {
+	if len(args) == 0 {
+		return MakeStandardErrReply("ERR wrong number of arguments for 'command|getkeys' command").WriteTo(conn)
+	}
+	cmd, err := router(string(args[0]))
+	if err != nil {
+		return MakeStandardErrReply("ERR Invalid command specified").WriteTo(conn)
+	}
+	if !cmd.checkArity(len(args)) {
+		return MakeStandardErrReply("ERR Invalid number of arguments specified for command").WriteTo(conn)
+	}
+	keys := cmd.keys(args)
+	if len(keys) == 0 {
+		return MakeStandardErrReply("ERR The command has no key arguments").WriteTo(conn)
+	}
+	return MakeMultiBulkReply(keys).WriteTo(conn)
+}
+
+// commandList command list [FILTERBY MODULE module-name | ACLCAT category | PATTERN pattern]
+func commandList(conn *Client, args [][]byte) error {
+	names := commandNames()
+	if len(args) == 0 {
+		return MakeMultiBulkReply(stringsToBytes(names)).WriteTo(conn)
+	}
+	if len(args) != 3 || strings.ToLower(string(args[0])) != "filterby" {
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	filter := strings.ToLower(string(args[1]))
+	value := string(args[2])
+	result := make([]string, 0)
+	switch filter {
+	case "module":
+		// 还没有支持模块
+	case "aclcat":
+		category := strings.ToLower(value)
+		for _, name := range names {
+			if commandRouter[name].hasCategory(category) {
+				result = append(result, name)
+			}
+		}
+	case "pattern":
+		for _, name := range names {
+			if util.StringMatch([]byte(value), []byte(name), true) {
+				result = append(result, name)
+			}
+		}
+	default:
+		return MakeSyntaxReply().WriteTo(conn)
+	}
+	return MakeMultiBulkReply(stringsToBytes(result)).WriteTo(conn)
+}
+
+func init() {
+	register("command", execCommand, -1, "@connection", 0, 0, 0)
+}
@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/logger"
+	"strings"
+)
+
+// execConfig config GET parameter [parameter ...] | SET parameter value [parameter value ...] | REWRITE | RESETSTAT
+func execConfig(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	subCommand := string(args[0])
+	args = args[1:]
+	switch strings.ToLower(subCommand) {
+	case "get":
+		if len(args) == 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'config|get' command").WriteTo(conn)
+		}
+		return configGet(conn, args)
+	case "set":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'config|set' command").WriteTo(conn)
+		}
+		return configSet(conn, args)
+	case "rewrite":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'config|rewrite' command").WriteTo(conn)
+		}
+		if err := config.Rewrite(); err != nil {
+			if errors.Is(err, config.ErrNoConfigFile) {
+				return MakeStandardErrReply("ERR The server is running without a config file").WriteTo(conn)
+			}
+			logger.Errorf("CONFIG REWRITE failed: %v", err)
+			return MakeStandardErrReply(fmt.Sprintf("ERR Rewriting config file: %v", err)).WriteTo(conn)
+		}
+		logger.Infof("CONFIG REWRITE executed with success.")
+		return MakeOkReply().WriteTo(conn)
+	case "resetstat":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'config|resetstat' command").WriteTo(conn)
+		}
+		stats.reset()
+		return MakeOkReply().WriteTo(conn)
+	default:
+		return MakeStandardErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", subCommand)).WriteTo(conn)
+	}
+}
+
+// configGet 多个 pattern 匹配到同一个配置时只返回一次
+func configGet(conn *Client, patterns [][]byte) error {
+	seen := make(map[string]struct{})
+	pairs := make([]Reply, 0)
+	for _, pattern := range patterns {
+		nameValues := config.Get(string(pattern))
+		for i := 0; i < len(nameValues); i += 2 {
+			if _, ok := seen[nameValues[i]]; ok {
+				continue
+			}
+			seen[nameValues[i]] = struct{}{}
+			pairs = append(pairs, MakeBulkReply([]byte(nameValues[i])), MakeBulkReply([]byte(nameValues[i+1])))
+		}
+	}
+	return MakeMapReply(pairs).WriteTo(conn)
+}
+
+func configSet(conn *Client, args [][]byte) error {
+	err := config.Set(bytesToStrings(args)...)
+	if err == nil {
+		return MakeOkReply().WriteTo(conn)
+	}
+	var setErr *config.SetError
+	if !errors.As(err, &setErr) {
+		return MakeStandardErrReply("ERR " + err.Error()).WriteTo(conn)
+	}
+	if errors.Is(err, config.ErrUnknownOption) {
+		return MakeStandardErrReply(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'",
+			setErr.Name)).WriteTo(conn)
+	}
+	return MakeStandardErrReply(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s",
+		setErr.Name, setErr.Err.Error())).WriteTo(conn)
+}
+
+func init() {
+	register("config", execConfig, -2, "admin noscript", 0, 0, 0)
+}
@@ -23,10 +23,6 @@ func ping(ctx context.Context, conn *Client) error {
 }
 
 func selectDb(ctx context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	index, err := strconv.Atoi(string(cmdData[0]))
 	if err != nil {
@@ -41,10 +37,6 @@ func selectDb(ctx context.Context, conn *Client) error {
 }
 
 func execType(ctx context.Context, conn *Client) error {
-	args := conn.GetArgs()
-	if len(args) != 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	key := string(conn.GetArgs()[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
 	if !exists {
@@ -54,6 +46,72 @@ func execType(ctx context.Context, conn *Client) error {
 	return MakeSimpleReply([]byte(typeName)).WriteTo(conn)
 }
 
+// execHello hello [protover [AUTH username password] [SETNAME clientname]]
+func execHello(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	protocol := conn.protocol
+	if len(args) > 0 {
+		ver, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return MakeStandardErrReply("ERR Protocol version is not an integer or out of range").WriteTo(conn)
+		}
+		if ver < resp2 || ver > resp3 {
+			return MakeStandardErrReply("NOPROTO unsupported protocol version").WriteTo(conn)
+		}
+		protocol = int(ver)
+	}
+	var username, password []byte
+	var clientName []byte
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		leftArgs := len(args) - i - 1
+		if option == "AUTH" && leftArgs >= 2 {
+			username, password = args[i+1], args[i+2]
+			i += 2
+		} else if option == "SETNAME" && leftArgs >= 1 {
+			clientName = args[i+1]
+			if !validClientName(string(clientName)) {
+				return MakeStandardErrReply("ERR Client names cannot contain spaces, newlines or special characters.").WriteTo(conn)
+			}
+			i++
+		} else {
+			return MakeStandardErrReply(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", string(args[i]))).WriteTo(conn)
+		}
+	}
+	if username != nil {
+		if err := acl.authenticate(conn, username, password); err != nil {
+			return MakeStandardErrReply(err.Error()).WriteTo(conn)
+		}
+	} else if acl.authRequired(conn) {
+		return MakeStandardErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time").WriteTo(conn)
+	}
+	if clientName != nil {
+		conn.SetName(string(clientName))
+	}
+	conn.SetProtocol(protocol)
+	return MakeMapReply([]Reply{
+		MakeBulkReply([]byte("server")), MakeBulkReply([]byte("redis")),
+		MakeBulkReply([]byte("version")), MakeBulkReply([]byte(redisVersion)),
+		MakeBulkReply([]byte("proto")), MakeIntReply(int64(protocol)),
+		MakeBulkReply([]byte("id")), MakeIntReply(conn.GetId()),
+		MakeBulkReply([]byte("mode")), MakeBulkReply([]byte("standalone")),
+		MakeBulkReply([]byte("role")), MakeBulkReply([]byte("master")),
+		MakeBulkReply([]byte("modules")), MakeEmptyMultiBulkReply(),
+	}).WriteTo(conn)
+}
+
+// validClientName 客户端名称中不能包含空格、换行等特殊字符
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 // gc
 // Note 并不一定能够百分之百的触发gc, 仅用于测试
 func gc(c context.Context, conn *Client) error {
@@ -111,10 +169,6 @@ func clearTTL(c context.Context, conn *Client) error {
 }
 
 func execRewriteAof(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum != 0 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	if config.Properties.AppendOnly {
 		go func() {
 			err := conn.Rewrite()
@@ -127,10 +181,6 @@ func execRewriteAof(c context.Context, conn *Client) error {
 }
 
 func execQuit(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum != 0 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	return MakeOkReply().WriteTo(conn)
 }
 
@@ -171,39 +221,42 @@ func execMemory(c context.Context, conn *Client) error {
 	return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
 }
 
-// execInfo
-func execInfo(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
+// helloSensitiveArgs hello 中 AUTH 之后的用户名和密码
+func helloSensitiveArgs(cmdLine [][]byte) []int {
+	for i := 2; i < len(cmdLine); i++ {
+		if strings.ToLower(string(cmdLine[i])) == "auth" {
+			return []int{i + 1, i + 2}
+		}
 	}
-	// todo
-	cmdData := conn.GetArgs()
-	var logStr string
-	for _, data := range cmdData {
-		logStr += string(data)
+	return nil
+}
+
+// execMonitor monitor 之后客户端会收到所有客户端执行的命令
+func execMonitor(c context.Context, conn *Client) error {
+	if conn.monitor {
+		return nil
 	}
-	return MakeBulkReply([]byte(infoClients())).WriteTo(conn)
+	conn.Monitor(conn)
+	return MakeOkReply().WriteTo(conn)
 }
 
-func infoClients() string {
-	return fmt.Sprintf("# Clients\r\n"+
-		"connected_clients:%d\r\n"+
-		"maxclients:%d\r\n",
-		ConnCounter.CountConnections(),
-		config.Properties.MaxClients,
-	)
+// execInfo info [section [section ...]]
+func execInfo(c context.Context, conn *Client) error {
+	sections := bytesToStrings(conn.GetArgs())
+	return MakeVerbatimReply("txt", []byte(conn.Info(sections))).WriteTo(conn)
 }
 
 func init() {
-	register("ping", ping)
-	register("select", selectDb)
-	register("type", execType)
-	register("ttlops", clearTTL)
-	register("bgrewriteaof", execRewriteAof)
-	register("flushdb", flushDb)
-	register("quit", execQuit)
-	register("memory", execMemory)
-	register("info", execInfo)
-	register("gc", gc)
+	register("ping", ping, -1, "fast @connection", 0, 0, 0)
+	register("hello", execHello, -1, "noscript fast no_auth @connection", 0, 0, 0).withSensitiveArgs(helloSensitiveArgs)
+	register("select", selectDb, 2, "fast @connection", 0, 0, 0)
+	register("type", execType, 2, "readonly fast @keyspace", 1, 1, 1)
+	register("ttlops", clearTTL, -1, "admin noscript", 0, 0, 0)
+	register("bgrewriteaof", execRewriteAof, 1, "admin noscript", 0, 0, 0)
+	register("flushdb", flushDb, -1, "write @keyspace @dangerous", 0, 0, 0)
+	register("quit", execQuit, -1, "fast no_auth @connection", 0, 0, 0)
+	register("memory", execMemory, -2, "readonly", 2, 2, 1)
+	register("info", execInfo, -1, "@dangerous", 0, 0, 0)
+	register("gc", gc, 1, "admin noscript", 0, 0, 0)
+	register("monitor", execMonitor, 1, "admin noscript", 0, 0, 0)
 }
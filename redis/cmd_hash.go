@@ -25,6 +25,7 @@ func hset(c context.Context, conn *Client) error {
 			field, value := string(pairs[i]), pairs[i+1]
 			result += int64(simpleDict.Put(field, value))
 		}
+		conn.GetDb().SignalModifiedKey(key)
 		return MakeIntReply(result).WriteTo(conn)
 	}
 	redisObj = obj.NewHashObject()
@@ -39,10 +40,6 @@ func hset(c context.Context, conn *Client) error {
 }
 
 func hget(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum != 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	args := conn.GetArgs()
 	key := string(args[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -57,7 +54,27 @@ func hget(c context.Context, conn *Client) error {
 	return MakeNullBulkReply().WriteTo(conn)
 }
 
+// hgetall hgetall key
+func hgetall(c context.Context, conn *Client) error {
+	key := string(conn.GetArgs()[0])
+	redisObj, exists := conn.GetDb().GetEntity(key)
+	if !exists {
+		return MakeMapReply(nil).WriteTo(conn)
+	}
+	if redisObj.ObjType != obj.RedisHash {
+		return MakeWrongTypeErrReply().WriteTo(conn)
+	}
+	simpleDict := redisObj.Ptr.(*dict.SimpleDict)
+	pairs := make([]Reply, 0, simpleDict.Len()*2)
+	simpleDict.ForEach(func(field string, val interface{}) bool {
+		pairs = append(pairs, MakeBulkReply([]byte(field)), MakeBulkReply(val.([]byte)))
+		return true
+	})
+	return MakeMapReply(pairs).WriteTo(conn)
+}
+
 func init() {
-	register("hset", hset)
-	register("hget", hget)
+	register("hset", hset, -4, "write denyoom fast @hash", 1, 1, 1)
+	register("hget", hget, 3, "readonly fast @hash", 1, 1, 1)
+	register("hgetall", hgetall, 2, "readonly @hash", 1, 1, 1)
 }
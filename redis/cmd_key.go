@@ -4,22 +4,19 @@ import (
 	"context"
 	"github.com/xuning888/godis-tiny/pkg/util"
 	"math"
-	"path"
 	"strconv"
 	"time"
 )
 
 func execDel(ctx context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 {
-		// 错误参数
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	var deleted = 0
 	db := conn.GetDb()
 	for i := 0; i < len(cmdData); i++ {
+		// 删除大 key 的时候会阻塞服务器
+		start := time.Now()
 		result := db.Remove(string(cmdData[i]))
+		latency.addSampleIfNeeded(latencyEventDel, time.Since(start))
 		deleted += result
 	}
 	if deleted > 0 {
@@ -30,26 +27,17 @@ func execDel(ctx context.Context, conn *Client) error {
 }
 
 func execKeys(ctx context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	args := conn.GetArgs()
-	pattern := string(args[0])
-	_, err := path.Match(pattern, "")
-	if err != nil {
-		return MakeStandardErrReply("ERR invalid pattern").WriteTo(conn)
-	}
+	pattern := args[0]
 	keys := conn.GetDb().Keys()
 	var matchedKeys [][]byte
-	if pattern == "*" {
+	if string(pattern) == "*" {
 		matchedKeys = make([][]byte, 0, len(keys))
 	} else {
 		matchedKeys = make([][]byte, 0)
 	}
 	for _, key := range keys {
-		matched, _ := path.Match(pattern, key)
-		if matched {
+		if util.StringMatch(pattern, []byte(key), false) {
 			matchedKeys = append(matchedKeys, []byte(key))
 		}
 	}
@@ -61,9 +49,6 @@ func execKeys(ctx context.Context, conn *Client) error {
 
 func execExists(c context.Context, conn *Client) error {
 	argNum := conn.GetArgNum()
-	if argNum < 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	keys := make([]string, argNum)
 	args := conn.GetArgs()
 	for i := 0; i < argNum; i++ {
@@ -75,10 +60,6 @@ func execExists(c context.Context, conn *Client) error {
 
 // execTTL ttl key
 func execTTL(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	db := conn.GetDb()
@@ -95,7 +76,7 @@ func execTTL(c context.Context, conn *Client) error {
 
 	// 如果过期了，删除key,并且返回-2
 	if expired {
-		db.Remove(key)
+		db.RemoveExpired(key)
 		return MakeIntReply(-2).WriteTo(conn)
 	}
 	// 如果没有过期，计算ttl时间
@@ -107,10 +88,6 @@ func execTTL(c context.Context, conn *Client) error {
 }
 
 func execPTTL(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	db := conn.GetDb()
@@ -127,7 +104,7 @@ func execPTTL(c context.Context, conn *Client) error {
 
 	// 如果过期了，删除key,并且返回-2
 	if expired {
-		db.Remove(key)
+		db.RemoveExpired(key)
 		return MakeIntReply(-2).WriteTo(conn)
 	}
 
@@ -139,10 +116,6 @@ func execPTTL(c context.Context, conn *Client) error {
 
 // execExpire expire key ttl
 func execExpire(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	ttl, err := strconv.ParseInt(string(cmdData[1]), 10, 64)
@@ -156,16 +129,13 @@ func execExpire(c context.Context, conn *Client) error {
 	}
 	expireTime := time.Now().Add(time.Duration(ttl) * time.Second)
 	conn.GetDb().ExpireV1(key, expireTime)
+	conn.GetDb().SignalModifiedKey(key)
 	conn.GetDb().AddAof(util.MakeExpireCmd(key, expireTime))
 	return MakeIntReply(1).WriteTo(conn)
 }
 
 // execPersist persist key 移除key的过期时间
 func execPersist(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	expired, exists := conn.GetDb().IsExpiredV1(key)
@@ -179,6 +149,7 @@ func execPersist(c context.Context, conn *Client) error {
 	}
 	// key 存在，并且没有过期，就移除他的ttl
 	conn.GetDb().RemoveTTLV1(key)
+	conn.GetDb().SignalModifiedKey(key)
 	// add aof
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeIntReply(1).WriteTo(conn)
@@ -186,10 +157,6 @@ func execPersist(c context.Context, conn *Client) error {
 
 // execExpireAt expireat key unix-time-seconds
 func execExpireAt(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	timestamp, err := strconv.ParseInt(string(cmdData[1]), 10, 64)
@@ -202,18 +169,19 @@ func execExpireAt(c context.Context, conn *Client) error {
 	}
 	expireTime := time.Unix(timestamp, 0)
 	conn.GetDb().ExpireV1(key, expireTime)
+	conn.GetDb().SignalModifiedKey(key)
 	// add aof
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeIntReply(1).WriteTo(conn)
 }
 
 func init() {
-	register("del", execDel)
-	register("keys", execKeys)
-	register("exists", execExists)
-	register("ttl", execTTL)
-	register("pttl", execPTTL)
-	register("expire", execExpire)
-	register("persist", execPersist)
-	register("expireat", execExpireAt)
+	register("del", execDel, -2, "write @keyspace", 1, -1, 1)
+	register("keys", execKeys, 2, "readonly @keyspace @dangerous", 0, 0, 0)
+	register("exists", execExists, -2, "readonly fast @keyspace", 1, -1, 1)
+	register("ttl", execTTL, 2, "readonly fast @keyspace", 1, 1, 1)
+	register("pttl", execPTTL, 2, "readonly fast @keyspace", 1, 1, 1)
+	register("expire", execExpire, 3, "write fast @keyspace", 1, 1, 1)
+	register("persist", execPersist, 2, "write fast @keyspace", 1, 1, 1)
+	register("expireat", execExpireAt, 3, "write fast @keyspace", 1, 1, 1)
 }
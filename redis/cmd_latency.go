@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// execLatency latency LATEST | HISTORY event | RESET [event ...] | DOCTOR | HISTOGRAM [command ...]
+func execLatency(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	subCommand := string(args[0])
+	args = args[1:]
+	switch strings.ToLower(subCommand) {
+	case "latest":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'latency|latest' command").WriteTo(conn)
+		}
+		return MakeMultiRowReply(latency.latest()).WriteTo(conn)
+	case "history":
+		if len(args) != 1 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'latency|history' command").WriteTo(conn)
+		}
+		samples := latency.history(string(args[0]))
+		replies := make([]Reply, len(samples))
+		for i, sample := range samples {
+			replies[i] = MakeMultiRowReply([]Reply{MakeIntReply(sample.time), MakeIntReply(sample.latency)})
+		}
+		return MakeMultiRowReply(replies).WriteTo(conn)
+	case "reset":
+		return MakeIntReply(int64(latency.reset(bytesToStrings(args)...))).WriteTo(conn)
+	case "doctor":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'latency|doctor' command").WriteTo(conn)
+		}
+		return MakeVerbatimReply("txt", []byte(latency.doctor())).WriteTo(conn)
+	case "histogram":
+		return latencyHistogram(conn, args)
+	default:
+		return MakeStandardErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try LATENCY HELP.", subCommand)).WriteTo(conn)
+	}
+}
+
+// latencyHistogram 返回 command => {calls, histogram_usec}, histogram_usec 中是小于等于 1, 2, 4 ... 微秒的累计次数,
+// 和 redis 一样省略累计次数没有变化的区间。
+// 没有指定命令时返回所有执行过的命令, 忽略不存在或者没有执行过的命令
+func latencyHistogram(conn *Client, args [][]byte) error {
+	names := bytesToStrings(args)
+	if len(names) == 0 {
+		names = commandNames()
+	}
+	pairs := make([]Reply, 0)
+	for _, name := range names {
+		cmd, err := router(name)
+		if err != nil {
+			continue
+		}
+		stat, ok := stats.commands[cmd.name]
+		if !ok || stat.latency == nil {
+			continue
+		}
+		buckets := make([]Reply, 0)
+		var previous int64
+		stat.latency.ForEachPowerOfTwo(func(bound int64, cumulative int64) {
+			if cumulative > previous {
+				buckets = append(buckets, MakeIntReply(bound), MakeIntReply(cumulative))
+			}
+			previous = cumulative
+		})
+		pairs = append(pairs, MakeBulkReply([]byte(cmd.name)), MakeMapReply([]Reply{
+			MakeBulkReply([]byte("calls")), MakeIntReply(stat.latency.TotalCount()),
+			MakeBulkReply([]byte("histogram_usec")), MakeMapReply(buckets),
+		}))
+	}
+	return MakeMapReply(pairs).WriteTo(conn)
+}
+
+func init() {
+	register("latency", execLatency, -2, "admin noscript", 0, 0, 0)
+}
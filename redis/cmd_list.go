@@ -10,10 +10,6 @@ import (
 )
 
 func execLLen(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum != 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	args := conn.GetArgs()
 	key := string(args[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -28,10 +24,6 @@ func execLLen(c context.Context, conn *Client) error {
 }
 
 func execLIndex(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -58,10 +50,6 @@ func execLIndex(c context.Context, conn *Client) error {
 }
 
 func execLPush(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -79,6 +67,7 @@ func execLPush(c context.Context, conn *Client) error {
 			}
 			curIdx = idx
 		}
+		conn.GetDb().SignalModifiedKey(key)
 		if err != nil && errors.Is(err, list.ErrorOutOfCapacity) {
 			conn.GetDb().AddAof(util.ToCmdLine2(key, cmdData[:curIdx+2]))
 			return MakeStandardErrReply("ERR list is full").WriteTo(conn)
@@ -111,7 +100,7 @@ func execLPush(c context.Context, conn *Client) error {
 // execLPop lpop key [count]
 func execLPop(c context.Context, conn *Client) error {
 	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 2 {
+	if argNum > 2 {
 		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
 	}
 	cmdData := conn.GetArgs()
@@ -149,6 +138,8 @@ func execLPop(c context.Context, conn *Client) error {
 		}
 		if dequeue.Len() == 0 {
 			conn.GetDb().Remove(key)
+		} else {
+			conn.GetDb().SignalModifiedKey(key)
 		}
 		// aof
 		conn.GetDb().AddAof(conn.GetCmdLine())
@@ -163,6 +154,8 @@ func execLPop(c context.Context, conn *Client) error {
 	}
 	if dequeue.Len() == 0 {
 		conn.GetDb().Remove(key)
+	} else {
+		conn.GetDb().SignalModifiedKey(key)
 	}
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeBulkReply(pop.([]byte)).WriteTo(conn)
@@ -170,10 +163,6 @@ func execLPop(c context.Context, conn *Client) error {
 
 // execLRange lrange key start stop
 func execLRange(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 3 || argNum > 3 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	start, err := strconv.ParseInt(string(cmdData[1]), 10, 64)
@@ -235,10 +224,6 @@ func execLRange(c context.Context, conn *Client) error {
 }
 
 func execRPush(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -256,6 +241,7 @@ func execRPush(c context.Context, conn *Client) error {
 			}
 			curIdx = idx
 		}
+		conn.GetDb().SignalModifiedKey(key)
 		if err != nil && errors.Is(err, list.ErrorOutOfCapacity) {
 			conn.GetDb().AddAof(util.ToCmdLine2(key, cmdData[:curIdx+2]))
 			return MakeStandardErrReply("ERR list is full").WriteTo(conn)
@@ -291,7 +277,7 @@ func execRPush(c context.Context, conn *Client) error {
 // execRPop rpop key [count]
 func execRPop(c context.Context, conn *Client) error {
 	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 2 {
+	if argNum > 2 {
 		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
 	}
 	cmdData := conn.GetArgs()
@@ -329,6 +315,8 @@ func execRPop(c context.Context, conn *Client) error {
 		}
 		if dequeue.Len() == 0 {
 			conn.GetDb().Remove(key)
+		} else {
+			conn.GetDb().SignalModifiedKey(key)
 		}
 		conn.GetDb().AddAof(conn.GetCmdLine())
 		return conn.Flush()
@@ -341,17 +329,19 @@ func execRPop(c context.Context, conn *Client) error {
 	}
 	if dequeue.Len() == 0 {
 		conn.GetDb().Remove(key)
+	} else {
+		conn.GetDb().SignalModifiedKey(key)
 	}
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeBulkReply(pop.([]byte)).WriteTo(conn)
 }
 
 func init() {
-	register("lpush", execLPush)
-	register("lpop", execLPop)
-	register("lrange", execLRange)
-	register("rpush", execRPush)
-	register("llen", execLLen)
-	register("lindex", execLIndex)
-	register("rpop", execRPop)
+	register("lpush", execLPush, -3, "write denyoom fast @list", 1, 1, 1)
+	register("lpop", execLPop, -2, "write fast @list", 1, 1, 1)
+	register("lrange", execLRange, 4, "readonly @list", 1, 1, 1)
+	register("rpush", execRPush, -3, "write denyoom fast @list", 1, 1, 1)
+	register("llen", execLLen, 2, "readonly fast @list", 1, 1, 1)
+	register("lindex", execLIndex, 3, "readonly @list", 1, 1, 1)
+	register("rpop", execRPop, -2, "write fast @list", 1, 1, 1)
 }
@@ -10,10 +10,6 @@ import (
 )
 
 func sadd(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	key := string(conn.GetArgs()[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
 	if exists {
@@ -47,6 +43,9 @@ func sadd(c context.Context, conn *Client) error {
 				result += int64(simpleDict.Put(string(member), struct{}{}))
 			}
 		}
+		if result > 0 {
+			conn.GetDb().SignalModifiedKey(key)
+		}
 		return MakeIntReply(result).WriteTo(conn)
 	}
 	var result int64
@@ -56,59 +55,34 @@ func sadd(c context.Context, conn *Client) error {
 }
 
 func smembers(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	key := string(conn.GetArgs()[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
 	if !exists {
-		return MakeEmptyMultiBulkReply().WriteTo(conn)
+		return MakeSetReply(nil).WriteTo(conn)
 	}
 	if redisObj.ObjType != obj.RedisSet {
 		return MakeWrongTypeErrReply().WriteTo(conn)
 	}
+	var members [][]byte
 	if redisObj.Encoding == obj.EncIntSet {
 		intSet := redisObj.Ptr.(*intset.IntSet)
-		if err := MakeMultiBulkHeaderReply(int64(intSet.Len())).WriteTo(conn); err != nil {
-			return err
-		}
-		var err error
+		members = make([][]byte, 0, intSet.Len())
 		intSet.Range(func(index int, value int64) bool {
-			err = MakeBulkReply([]byte(fmt.Sprintf("%d", value))).WriteTo(conn)
-			if err != nil {
-				return false
-			}
+			members = append(members, []byte(fmt.Sprintf("%d", value)))
 			return true
 		})
-		if err != nil {
-			return err
-		}
 	} else {
 		simpleDic := redisObj.Ptr.(*dict.SimpleDict)
-		if err := MakeMultiBulkHeaderReply(int64(simpleDic.Len())).WriteTo(conn); err != nil {
-			return err
-		}
-		var err error
+		members = make([][]byte, 0, simpleDic.Len())
 		simpleDic.ForEach(func(key string, val interface{}) bool {
-			err = MakeBulkReply([]byte(key)).WriteTo(conn)
-			if err != nil {
-				return false
-			}
+			members = append(members, []byte(key))
 			return true
 		})
-		if err != nil {
-			return err
-		}
 	}
-	return nil
+	return MakeSetReply(members).WriteTo(conn)
 }
 
 func scard(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum != 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	key := string(conn.GetArgs()[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
 	if !exists {
@@ -128,7 +102,7 @@ func scard(c context.Context, conn *Client) error {
 }
 
 func init() {
-	register("sadd", sadd)
-	register("smembers", smembers)
-	register("scard", scard)
+	register("sadd", sadd, -3, "write denyoom fast @set", 1, 1, 1)
+	register("smembers", smembers, 2, "readonly @set", 1, 1, 1)
+	register("scard", scard, 2, "readonly fast @set", 1, 1, 1)
 }
@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// slowLogDefaultGetCount SLOWLOG GET 默认返回的记录数
+const slowLogDefaultGetCount = 10
+
+// execSlowlog slowlog GET [count] | LEN | RESET
+func execSlowlog(c context.Context, conn *Client) error {
+	args := conn.GetArgs()
+	subCommand := string(args[0])
+	args = args[1:]
+	switch strings.ToLower(subCommand) {
+	case "get":
+		if len(args) > 1 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'slowlog|get' command").WriteTo(conn)
+		}
+		count := slowLogDefaultGetCount
+		if len(args) == 1 {
+			n, err := strconv.Atoi(string(args[0]))
+			if err != nil || n < -1 {
+				return MakeStandardErrReply("ERR count should be greater than or equal to -1").WriteTo(conn)
+			}
+			count = n
+		}
+		// count 为 -1 时返回所有的记录
+		entries := slowlog.latest(count)
+		replies := make([]Reply, len(entries))
+		for i, entry := range entries {
+			replies[i] = entry.toReply()
+		}
+		return MakeMultiRowReply(replies).WriteTo(conn)
+	case "len":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'slowlog|len' command").WriteTo(conn)
+		}
+		return MakeIntReply(int64(slowlog.len())).WriteTo(conn)
+	case "reset":
+		if len(args) != 0 {
+			return MakeStandardErrReply("ERR wrong number of arguments for 'slowlog|reset' command").WriteTo(conn)
+		}
+		slowlog.reset()
+		return MakeOkReply().WriteTo(conn)
+	default:
+		return MakeStandardErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", subCommand)).WriteTo(conn)
+	}
+}
+
+func init() {
+	register("slowlog", execSlowlog, -2, "admin", 0, 0, 0)
+}
@@ -0,0 +1,365 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/dict"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/intset"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/list"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortItem 参与排序的元素
+type sortItem struct {
+	// value 元素本身
+	value []byte
+	// score 数值排序时使用的权重
+	score float64
+	// cmpObj 字典序排序时使用的权重, 为nil时表示BY对应的key不存在
+	cmpObj []byte
+}
+
+// sortOptions SORT 命令解析后的参数
+type sortOptions struct {
+	byPattern   []byte
+	dontSort    bool
+	getPatterns [][]byte
+	desc        bool
+	alpha       bool
+	offset      int
+	count       int
+	limit       bool
+	storeKey    string
+}
+
+var errSortScore = MakeStandardErrReply("ERR One or more scores can't be converted into double")
+
+// execSort sort key [BY pattern] [LIMIT offset count] [GET pattern [GET pattern ...]] [ASC|DESC] [ALPHA] [STORE destination]
+func execSort(c context.Context, conn *Client) error {
+	return doSort(conn, false)
+}
+
+// execSortRo sort_ro key [BY pattern] [LIMIT offset count] [GET pattern [GET pattern ...]] [ASC|DESC] [ALPHA]
+func execSortRo(c context.Context, conn *Client) error {
+	return doSort(conn, true)
+}
+
+func doSort(conn *Client, readonly bool) error {
+	args := conn.GetArgs()
+	key := string(args[0])
+	opts, errReply := parseSortOptions(args[1:], readonly)
+	if errReply != nil {
+		return errReply.WriteTo(conn)
+	}
+	db := conn.GetDb()
+	redisObj, exists := db.GetEntity(key)
+	var elements [][]byte
+	if exists {
+		var ok bool
+		elements, ok = sortElements(redisObj)
+		if !ok {
+			return MakeWrongTypeErrReply().WriteTo(conn)
+		}
+		// 集合是无序的, 为了结果稳定, 在 nosort 与 STORE/LIMIT 同时出现时按照字典序排序
+		if opts.dontSort && redisObj.ObjType == obj.RedisSet && (opts.storeKey != "" || opts.limit) {
+			opts.dontSort = false
+			opts.alpha = true
+			opts.byPattern = nil
+		}
+		if opts.dontSort && opts.desc && redisObj.ObjType == obj.RedisList {
+			for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+				elements[i], elements[j] = elements[j], elements[i]
+			}
+		}
+	}
+
+	items := make([]*sortItem, len(elements))
+	for i, element := range elements {
+		items[i] = &sortItem{value: element}
+	}
+
+	if !opts.dontSort {
+		for _, item := range items {
+			var byVal []byte
+			if opts.byPattern != nil {
+				byVal = lookupKeyByPattern(db, opts.byPattern, item.value)
+				if byVal == nil {
+					continue
+				}
+			} else {
+				byVal = item.value
+			}
+			if opts.alpha {
+				item.cmpObj = byVal
+				continue
+			}
+			score, err := strconv.ParseFloat(string(byVal), 64)
+			if err != nil {
+				return errSortScore.WriteTo(conn)
+			}
+			item.score = score
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			cmp := compareSortItem(items[i], items[j], opts)
+			if opts.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	// LIMIT
+	start, end := sortRange(opts, len(items))
+	items = items[start:end]
+
+	// GET
+	var result [][]byte
+	if len(opts.getPatterns) == 0 {
+		result = make([][]byte, 0, len(items))
+		for _, item := range items {
+			result = append(result, item.value)
+		}
+	} else {
+		result = make([][]byte, 0, len(items)*len(opts.getPatterns))
+		for _, item := range items {
+			for _, pattern := range opts.getPatterns {
+				result = append(result, lookupKeyByPattern(db, pattern, item.value))
+			}
+		}
+	}
+
+	if opts.storeKey == "" {
+		if len(result) == 0 {
+			return MakeEmptyMultiBulkReply().WriteTo(conn)
+		}
+		return MakeMultiBulkReply(result).WriteTo(conn)
+	}
+
+	// STORE, 结果以list的形式保存, aof中记录为 del + rpush
+	db.Remove(opts.storeKey)
+	if len(result) > 0 {
+		listObj := obj.NewListObject()
+		dequeue := listObj.Ptr.(list.Dequeue)
+		pushArgs := make([][]byte, 0, len(result)+1)
+		pushArgs = append(pushArgs, []byte(opts.storeKey))
+		for _, value := range result {
+			if value == nil {
+				value = []byte{}
+			}
+			_ = dequeue.AddLast(value)
+			pushArgs = append(pushArgs, value)
+		}
+		db.PutEntity(opts.storeKey, listObj)
+		db.AddAof(util.ToCmdLine("del", opts.storeKey))
+		db.AddAof(util.ToCmdLine2("rpush", pushArgs))
+	} else {
+		db.AddAof(util.ToCmdLine("del", opts.storeKey))
+	}
+	return MakeIntReply(int64(len(result))).WriteTo(conn)
+}
+
+func parseSortOptions(args [][]byte, readonly bool) (*sortOptions, Reply) {
+	opts := &sortOptions{offset: 0, count: -1}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		leftArgs := len(args) - i - 1
+		switch {
+		case option == "ASC":
+			opts.desc = false
+		case option == "DESC":
+			opts.desc = true
+		case option == "ALPHA":
+			opts.alpha = true
+		case option == "LIMIT" && leftArgs >= 2:
+			offset, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, MakeOutOfRangeOrNotInt()
+			}
+			count, err := strconv.Atoi(string(args[i+2]))
+			if err != nil {
+				return nil, MakeOutOfRangeOrNotInt()
+			}
+			opts.offset, opts.count, opts.limit = offset, count, true
+			i += 2
+		case option == "STORE" && leftArgs >= 1 && !readonly:
+			opts.storeKey = string(args[i+1])
+			i++
+		case option == "BY" && leftArgs >= 1:
+			opts.byPattern = args[i+1]
+			// BY 的 pattern 中不包含 *, 那么就不需要排序
+			if bytes.IndexByte(opts.byPattern, '*') < 0 {
+				opts.dontSort = true
+			}
+			i++
+		case option == "GET" && leftArgs >= 1:
+			opts.getPatterns = append(opts.getPatterns, args[i+1])
+			i++
+		default:
+			return nil, MakeSyntaxReply()
+		}
+	}
+	return opts, nil
+}
+
+// sortElements 取出 list 或 set 中的全部元素
+func sortElements(redisObj *obj.RedisObject) ([][]byte, bool) {
+	switch redisObj.ObjType {
+	case obj.RedisList:
+		dequeue := redisObj.Ptr.(list.Dequeue)
+		elements := make([][]byte, 0, dequeue.Len())
+		dequeue.ForEach(func(value interface{}, index int) bool {
+			elements = append(elements, value.([]byte))
+			return true
+		})
+		return elements, true
+	case obj.RedisSet:
+		if redisObj.Encoding == obj.EncIntSet {
+			intSet := redisObj.Ptr.(*intset.IntSet)
+			elements := make([][]byte, 0, intSet.Len())
+			intSet.Range(func(index int, value int64) bool {
+				elements = append(elements, []byte(fmt.Sprintf("%d", value)))
+				return true
+			})
+			return elements, true
+		}
+		simpleDict := redisObj.Ptr.(*dict.SimpleDict)
+		elements := make([][]byte, 0, simpleDict.Len())
+		simpleDict.ForEach(func(key string, val interface{}) bool {
+			elements = append(elements, []byte(key))
+			return true
+		})
+		return elements, true
+	default:
+		return nil, false
+	}
+}
+
+func compareSortItem(a, b *sortItem, opts *sortOptions) int {
+	var cmp int
+	if opts.alpha {
+		if opts.byPattern != nil {
+			// BY 对应的 key 不存在时, 认为它是最小的
+			if a.cmpObj == nil || b.cmpObj == nil {
+				if a.cmpObj == nil && b.cmpObj != nil {
+					cmp = -1
+				} else if a.cmpObj != nil && b.cmpObj == nil {
+					cmp = 1
+				}
+			} else {
+				cmp = bytes.Compare(a.cmpObj, b.cmpObj)
+			}
+		} else {
+			cmp = bytes.Compare(a.value, b.value)
+		}
+	} else if a.score > b.score {
+		cmp = 1
+	} else if a.score < b.score {
+		cmp = -1
+	}
+	if cmp == 0 {
+		// 权重相同时按照元素本身的字典序排序, 保证结果稳定
+		cmp = bytes.Compare(a.value, b.value)
+	}
+	return cmp
+}
+
+func sortRange(opts *sortOptions, length int) (start, end int) {
+	start = opts.offset
+	if start < 0 {
+		start = 0
+	}
+	if start > length {
+		start = length
+	}
+	if opts.count < 0 {
+		return start, length
+	}
+	end = start + opts.count
+	if end > length || end < start {
+		end = length
+	}
+	return start, end
+}
+
+// lookupKeyByPattern 用 subst 替换 pattern 中的第一个 *, 然后查找对应的值。
+// pattern 为 # 时返回 subst 本身; pattern 中包含 -> 时, -> 之后的部分是 hash 的 field。
+// key 不存在或者类型不匹配时返回 nil
+func lookupKeyByPattern(db *DB, pattern []byte, subst []byte) []byte {
+	if len(pattern) == 1 && pattern[0] == '#' {
+		return subst
+	}
+	star := bytes.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+	keyPattern := pattern
+	var field []byte
+	if arrow := bytes.Index(pattern[star:], []byte("->")); arrow >= 0 {
+		arrow += star
+		if arrow+2 < len(pattern) {
+			field = pattern[arrow+2:]
+			keyPattern = pattern[:arrow]
+		}
+	}
+	key := make([]byte, 0, len(keyPattern)+len(subst))
+	key = append(key, keyPattern[:star]...)
+	key = append(key, subst...)
+	key = append(key, keyPattern[star+1:]...)
+
+	redisObj, exists := db.GetEntity(string(key))
+	if !exists {
+		return nil
+	}
+	if field != nil {
+		if redisObj.ObjType != obj.RedisHash {
+			return nil
+		}
+		simpleDict := redisObj.Ptr.(*dict.SimpleDict)
+		value, ok := simpleDict.Get(string(field))
+		if !ok {
+			return nil
+		}
+		return value.([]byte)
+	}
+	if redisObj.ObjType != obj.RedisString {
+		return nil
+	}
+	value, err := obj.StringObjEncoding(redisObj)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// sortGetKeys sort key [BY pattern] [LIMIT offset count] [GET pattern ...] [STORE destination]
+// 跳过选项的参数, 避免把 GET store 这样的 pattern 当成 STORE
+func sortGetKeys(cmdLine [][]byte) [][]byte {
+	if len(cmdLine) < 2 {
+		return nil
+	}
+	keys := [][]byte{cmdLine[1]}
+	for i := 2; i < len(cmdLine); i++ {
+		switch strings.ToLower(string(cmdLine[i])) {
+		case "limit":
+			i += 2
+		case "get", "by":
+			i++
+		case "store":
+			if i+1 < len(cmdLine) {
+				keys = append(keys, cmdLine[i+1])
+				i++
+			}
+		}
+	}
+	return keys
+}
+
+func init() {
+	register("sort", execSort, -2, "write denyoom @set @sortedset @list @dangerous", 1, 1, 1).withGetKeys(sortGetKeys)
+	register("sort_ro", execSortRo, -2, "readonly @set @sortedset @list @dangerous", 1, 1, 1)
+}
@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sortReply 执行 cmdLines 中的全部命令, 返回最后一条命令的回复
+func sortReply(t *testing.T, server *RedisServer, cmdLines ...string) string {
+	conn := &replyConn{}
+	client := NewClient(0, conn, false)
+	for _, cmdLine := range cmdLines {
+		conn.replies.Reset()
+		fields := strings.Fields(cmdLine)
+		client.PushCmd(util.ToCmdLine(fields[0], fields[1:]...))
+		assert.Nil(t, server.process(context.Background(), client))
+	}
+	return conn.replies.String()
+}
+
+func multiBulk(values ...string) string {
+	if len(values) == 0 {
+		return string(MakeEmptyMultiBulkReply().ToBytes())
+	}
+	return string(MakeMultiBulkReply(util.ToCmdLine(values[0], values[1:]...)).ToBytes())
+}
+
+func TestSort(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    []string
+		cmdLine  string
+		expected string
+	}{
+		{"numeric", []string{"rpush l 3 10 1 2"}, "sort l", multiBulk("1", "2", "3", "10")},
+		{"numeric desc", []string{"rpush l 3 10 1 2"}, "sort l desc", multiBulk("10", "3", "2", "1")},
+		{"alpha", []string{"rpush l 3 10 1 2"}, "sort l alpha", multiBulk("1", "10", "2", "3")},
+		{"alpha desc", []string{"rpush l b c a"}, "sort l alpha desc", multiBulk("c", "b", "a")},
+		{"asc", []string{"rpush l 2 1"}, "sort l asc", multiBulk("1", "2")},
+		{"not a number", []string{"rpush l 1 a"}, "sort l",
+			string(errSortScore.ToBytes())},
+		{"limit", []string{"rpush l 5 4 3 2 1"}, "sort l limit 1 2", multiBulk("2", "3")},
+		{"limit desc", []string{"rpush l 5 4 3 2 1"}, "sort l desc limit 0 2", multiBulk("5", "4")},
+		{"limit out of range", []string{"rpush l 1 2"}, "sort l limit 5 2", multiBulk()},
+		{"limit negative count", []string{"rpush l 3 2 1"}, "sort l limit 1 -1", multiBulk("2", "3")},
+		{"missing key", nil, "sort nokey", multiBulk()},
+		{"wrong type", []string{"set s 1"}, "sort s", string(MakeWrongTypeErrReply().ToBytes())},
+		{"syntax error", []string{"rpush l 1"}, "sort l limit 1", string(MakeSyntaxReply().ToBytes())},
+		{"by nosort", []string{"rpush l 3 1 2"}, "sort l by nosort", multiBulk("3", "1", "2")},
+		{"by nosort desc", []string{"rpush l 3 1 2"}, "sort l by nosort desc", multiBulk("2", "1", "3")},
+		{"by pattern", []string{"rpush l a b c", "mset w_a 3 w_b 1 w_c 2"}, "sort l by w_*",
+			multiBulk("b", "c", "a")},
+		{"by missing pattern", []string{"rpush l a b c", "mset w_a 3 w_c 2"}, "sort l by w_*",
+			multiBulk("b", "c", "a")},
+		{"by hash field", []string{"rpush l 1 2 3", "hset h_1 age 30", "hset h_2 age 10", "hset h_3 age 20"},
+			"sort l by h_*->age", multiBulk("2", "3", "1")},
+		{"by hash field alpha desc", []string{"rpush l 1 2 3", "hset h_1 n b", "hset h_2 n c", "hset h_3 n a"},
+			"sort l by h_*->n alpha desc", multiBulk("2", "1", "3")},
+		{"get", []string{"rpush l 2 1", "mset k_1 a k_2 b"}, "sort l get k_*", multiBulk("a", "b")},
+		{"multiple get", []string{"rpush l 2 1", "mset k_1 a k_2 b", "hset h_1 f x"},
+			"sort l get # get k_* get h_*->f",
+			"*6\r\n$1\r\n1\r\n$1\r\na\r\n$1\r\nx\r\n$1\r\n2\r\n$1\r\nb\r\n$-1\r\n"},
+		{"get with limit", []string{"rpush l 3 2 1", "mset k_1 a k_2 b k_3 c"}, "sort l limit 1 1 get # get k_*",
+			multiBulk("2", "b")},
+		{"intset", []string{"sadd s 3 10 1 2"}, "sort s", multiBulk("1", "2", "3", "10")},
+		{"intset alpha", []string{"sadd s 3 10 1 2"}, "sort s alpha", multiBulk("1", "10", "2", "3")},
+		{"intset nosort limit", []string{"sadd s 3 10 1 2"}, "sort s by nosort limit 0 3", multiBulk("1", "10", "2")},
+		{"dict", []string{"sadd s b c a"}, "sort s alpha", multiBulk("a", "b", "c")},
+		{"dict desc get", []string{"sadd s b c a", "mset k_a 1 k_b 2 k_c 3"}, "sort s alpha desc get k_*",
+			multiBulk("3", "2", "1")},
+		{"dict nosort limit", []string{"sadd s b c a"}, "sort s by nosort limit 1 5", multiBulk("b", "c")},
+		{"sort_ro", []string{"rpush l 3 1 2"}, "sort_ro l desc", multiBulk("3", "2", "1")},
+		{"sort_ro store", []string{"rpush l 1"}, "sort_ro l store dst", string(MakeSyntaxReply().ToBytes())},
+		{"store", []string{"rpush l 3 1 2"}, "sort l store dst", ":3\r\n"},
+		{"store empty", []string{"rpush dst 1"}, "sort nokey store dst", ":0\r\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := makeTempServer()
+			cmdLines := append(append([]string{}, tc.setup...), tc.cmdLine)
+			assert.Equal(t, tc.expected, sortReply(t, server, cmdLines...))
+		})
+	}
+}
+
+func TestSortStore(t *testing.T) {
+	server := makeTempServer()
+	assert.Equal(t, ":3\r\n", sortReply(t, server, "sadd s 3 1 2", "sort s desc store dst"))
+	entity, ok := server.dbs[0].GetEntity("dst")
+	assert.True(t, ok)
+	assert.Equal(t, obj.RedisList, entity.ObjType)
+	assert.Equal(t, multiBulk("3", "2", "1"), sortReply(t, server, "lrange dst 0 -1"))
+
+	// 结果为空时删除目标 key
+	assert.Equal(t, ":0\r\n", sortReply(t, server, "sort nokey store dst"))
+	_, ok = server.dbs[0].GetEntity("dst")
+	assert.False(t, ok)
+}
+
+func TestSortStoreAof(t *testing.T) {
+	setAppendOnly(t)
+	dir := t.TempDir()
+	server, aof := newTestAof(t, dir, "")
+	execCommands(t, server, "rpush l 3 1 2", "sort l store dst", "sort nokey store empty")
+	syncAof(t, aof)
+
+	content, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"))
+	assert.Nil(t, err)
+	expected := string(MakeMultiBulkReply(util.ToCmdLine("del", "dst")).ToBytes()) +
+		string(MakeMultiBulkReply(util.ToCmdLine("rpush", "dst", "1", "2", "3")).ToBytes()) +
+		string(MakeMultiBulkReply(util.ToCmdLine("del", "empty")).ToBytes())
+	assert.True(t, strings.HasSuffix(string(content), expected), string(content))
+	// 写入 aof 的是结果而不是 sort 命令本身
+	assert.NotContains(t, string(content), "sort")
+
+	// 重新加载之后得到相同的结果
+	reloaded, _ := newTestAof(t, dir, "")
+	assert.Equal(t, multiBulk("1", "2", "3"), sortReply(t, reloaded, "lrange dst 0 -1"))
+}
+
+func TestSortGetKeys(t *testing.T) {
+	testCases := []struct {
+		cmdLine  []string
+		expected []string
+	}{
+		{[]string{"sort", "src"}, []string{"src"}},
+		{[]string{"sort", "src", "store", "dst"}, []string{"src", "dst"}},
+		{[]string{"sort", "src", "by", "w_*", "limit", "0", "1", "get", "#", "store", "dst"}, []string{"src", "dst"}},
+		// GET 和 BY 的参数不是 key
+		{[]string{"sort", "src", "get", "store", "alpha"}, []string{"src"}},
+		{[]string{"sort", "src", "get", "store", "store", "dst"}, []string{"src", "dst"}},
+		{[]string{"sort", "src", "store", "a", "store", "b"}, []string{"src", "a", "b"}},
+	}
+	for _, tc := range testCases {
+		keys := sortGetKeys(util.ToCmdLine(tc.cmdLine[0], tc.cmdLine[1:]...))
+		assert.Equal(t, util.ToCmdLine(tc.expected[0], tc.expected[1:]...), keys, strings.Join(tc.cmdLine, " "))
+	}
+}
@@ -11,10 +11,6 @@ import (
 )
 
 func execGet(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	args := conn.GetArgs()
 	key := string(args[0])
 	db := conn.GetDb()
@@ -46,9 +42,6 @@ const (
 
 func execSet(c context.Context, conn *Client) error {
 	argNum := conn.GetArgNum()
-	if argNum < 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	args := conn.GetArgs()
 	key := string(args[0])
 	value := args[1]
@@ -156,10 +149,6 @@ func execSet(c context.Context, conn *Client) error {
 
 // execSetNx setnx key value
 func execSetNx(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	value := cmdData[1]
@@ -172,10 +161,6 @@ func execSetNx(c context.Context, conn *Client) error {
 
 // execStrLen strlen key
 func execStrLen(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	db := conn.GetDb()
@@ -193,10 +178,6 @@ func execStrLen(c context.Context, conn *Client) error {
 
 // execGetSet getset key value
 func execGetSet(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	value := cmdData[1]
@@ -222,10 +203,6 @@ func execGetSet(c context.Context, conn *Client) error {
 // execIncr incr key
 // incr 命令存在对内存的读写操作，此处没有使用锁来保证线程安全, 而是在dbEngin中使用队列来保证命令排队执行
 func execIncr(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	db := conn.GetDb()
@@ -250,16 +227,13 @@ func execIncr(c context.Context, conn *Client) error {
 	}
 	value++
 	redisObj.Ptr = value
+	db.SignalModifiedKey(key)
 	db.AddAof(conn.GetCmdLine())
 	return MakeIntReply(value).WriteTo(conn)
 }
 
 // execDecr decr key
 func execDecr(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -283,16 +257,13 @@ func execDecr(c context.Context, conn *Client) error {
 	}
 	value--
 	redisObj.Ptr = value
+	conn.GetDb().SignalModifiedKey(key)
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeIntReply(value).WriteTo(conn)
 }
 
 // execGetRange getrange key start end
 func execGetRange(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 3 || argNum > 3 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	start, err := strconv.ParseInt(string(cmdData[1]), 10, 64)
@@ -337,10 +308,6 @@ func execGetRange(c context.Context, conn *Client) error {
 
 // execMGet mget key[key...]
 func execMGet(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	length := len(cmdData)
 	db := conn.GetDb()
@@ -373,8 +340,8 @@ func execMGet(c context.Context, conn *Client) error {
 // execMSet
 func execMSet(c context.Context, conn *Client) error {
 	argNum := conn.GetArgNum()
-	if argNum == 0 || argNum%2 != 0 {
-		return MakeUnknownCommand(conn.GetCmdName()).WriteTo(conn)
+	if argNum%2 != 0 {
+		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
 	}
 	args := conn.GetArgs()
 	db := conn.GetDb()
@@ -395,10 +362,6 @@ func execMSet(c context.Context, conn *Client) error {
 
 // execGetDel getdel
 func execGetDel(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 1 || argNum > 1 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	redisObj, exists := conn.GetDb().GetEntity(key)
@@ -416,10 +379,6 @@ func execGetDel(c context.Context, conn *Client) error {
 
 // execIncrBy incrby key increment
 func execIncrBy(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	increment, err := strconv.ParseInt(string(cmdData[1]), 10, 64)
@@ -453,15 +412,12 @@ func execIncrBy(c context.Context, conn *Client) error {
 	}
 	value += increment
 	redisObj.Ptr = value
+	conn.GetDb().SignalModifiedKey(key)
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeIntReply(value).WriteTo(conn)
 }
 
 func execDecrBy(c context.Context, conn *Client) error {
-	argNum := conn.GetArgNum()
-	if argNum < 2 || argNum > 2 {
-		return MakeNumberOfArgsErrReply(conn.GetCmdName()).WriteTo(conn)
-	}
 	cmdData := conn.GetArgs()
 	key := string(cmdData[0])
 	decrement, err := strconv.ParseInt(string(cmdData[1]), 10, 64)
@@ -497,22 +453,23 @@ func execDecrBy(c context.Context, conn *Client) error {
 	}
 	value -= decrement
 	redisObj.Ptr = value
+	conn.GetDb().SignalModifiedKey(key)
 	conn.GetDb().AddAof(conn.GetCmdLine())
 	return MakeIntReply(value).WriteTo(conn)
 }
 
 func init() {
-	register("set", execSet)
-	register("get", execGet)
-	register("setnx", execSetNx)
-	register("strlen", execStrLen)
-	register("incr", execIncr)
-	register("decr", execDecr)
-	register("getset", execGetSet)
-	register("getrange", execGetRange)
-	register("mget", execMGet)
-	register("mset", execMSet)
-	register("getdel", execGetDel)
-	register("incrby", execIncrBy)
-	register("decrby", execDecrBy)
+	register("set", execSet, -3, "write denyoom @string", 1, 1, 1)
+	register("get", execGet, 2, "readonly fast @string", 1, 1, 1)
+	register("setnx", execSetNx, 3, "write denyoom fast @string", 1, 1, 1)
+	register("strlen", execStrLen, 2, "readonly fast @string", 1, 1, 1)
+	register("incr", execIncr, 2, "write denyoom fast @string", 1, 1, 1)
+	register("decr", execDecr, 2, "write denyoom fast @string", 1, 1, 1)
+	register("getset", execGetSet, 3, "write denyoom fast @string", 1, 1, 1)
+	register("getrange", execGetRange, 4, "readonly @string", 1, 1, 1)
+	register("mget", execMGet, -2, "readonly fast @string", 1, -1, 1)
+	register("mset", execMSet, -3, "write denyoom @string", 1, -1, 2)
+	register("getdel", execGetDel, 2, "write fast @string", 1, 1, 1)
+	register("incrby", execIncrBy, 3, "write denyoom fast @string", 1, 1, 1)
+	register("decrby", execDecrBy, 3, "write denyoom fast @string", 1, 1, 1)
 }
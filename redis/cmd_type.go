@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"errors"
+	"sort"
 	"strings"
 )
 
@@ -13,17 +14,179 @@ var (
 
 type Process func(ctx context.Context, conn *Client) error
 
+// 命令的标记
+const (
+	// cmdWrite 可能修改数据
+	cmdWrite = 1 << iota
+	// cmdReadonly 只读取数据
+	cmdReadonly
+	// cmdDenyOOM 可能增加内存的使用, 内存不足时拒绝执行
+	cmdDenyOOM
+	// cmdAdmin 管理命令
+	cmdAdmin
+	// cmdPubSub 发布订阅相关的命令
+	cmdPubSub
+	// cmdNoScript 不能在脚本中执行
+	cmdNoScript
+	// cmdFast 时间复杂度为 O(1) 或者 O(log(N))
+	cmdFast
+	// cmdNoAuth 不需要认证就可以执行
+	cmdNoAuth
+)
+
+var commandFlagNames = []struct {
+	flag int
+	name string
+}{
+	{cmdWrite, "write"},
+	{cmdReadonly, "readonly"},
+	{cmdDenyOOM, "denyoom"},
+	{cmdAdmin, "admin"},
+	{cmdPubSub, "pubsub"},
+	{cmdNoScript, "noscript"},
+	{cmdFast, "fast"},
+	{cmdNoAuth, "no_auth"},
+}
+
+// Command 命令的元数据
 type Command struct {
 	name    string
 	process Process
+	// arity 参数的个数, 包含命令名, 负数表示至少 -arity 个
+	arity int
+	flags int
+	// firstKey, lastKey, keyStep 命令中 key 的位置, 下标从 1 开始, lastKey 为负数时从后往前数
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// categories ACL 分类, 不包含 @
+	categories []string
+	// getKeys 不能用固定位置描述 key 的命令, 比如 sort 的 STORE
+	getKeys func(cmdLine [][]byte) [][]byte
+	// sensitiveArgs 参数中有密码的命令, 返回需要在 MONITOR 和慢日志中隐藏的参数的下标
+	sensitiveArgs func(cmdLine [][]byte) []int
 }
 
-func register(name string, process Process) {
+// register 注册一个命令, flags 中 @ 开头的是 ACL 分类, 其他的是命令的标记。
+// write, readonly, admin, pubsub, fast 会隐式的加上对应的 ACL 分类, 没有 fast 的命令属于 @slow
+func register(name string, process Process, arity int, flags string, firstKey, lastKey, keyStep int) *Command {
 	cmd := &Command{
-		name:    strings.ToLower(name),
-		process: process,
+		name:     strings.ToLower(name),
+		process:  process,
+		arity:    arity,
+		firstKey: firstKey,
+		lastKey:  lastKey,
+		keyStep:  keyStep,
+	}
+	for _, flag := range strings.Fields(flags) {
+		if strings.HasPrefix(flag, "@") {
+			cmd.addCategory(flag[1:])
+			continue
+		}
+		cmd.flags |= parseCommandFlag(flag)
+	}
+	cmd.setImplicitCategories()
+	commandRouter[cmd.name] = cmd
+	return cmd
+}
+
+func parseCommandFlag(flag string) int {
+	for _, f := range commandFlagNames {
+		if f.name == flag {
+			return f.flag
+		}
+	}
+	panic("unknown command flag " + flag)
+}
+
+func (cmd *Command) setImplicitCategories() {
+	if cmd.hasFlag(cmdWrite) {
+		cmd.addCategory(aclCategoryWrite)
+	}
+	if cmd.hasFlag(cmdReadonly) {
+		cmd.addCategory(aclCategoryRead)
+	}
+	if cmd.hasFlag(cmdAdmin) {
+		cmd.addCategory(aclCategoryAdmin)
+		cmd.addCategory(aclCategoryDangerous)
+	}
+	if cmd.hasFlag(cmdPubSub) {
+		cmd.addCategory(aclCategoryPubSub)
+	}
+	if cmd.hasFlag(cmdFast) {
+		cmd.addCategory(aclCategoryFast)
+	} else {
+		cmd.addCategory(aclCategorySlow)
+	}
+}
+
+func (cmd *Command) addCategory(category string) {
+	if !validAclCategory(category) || category == "all" {
+		panic("unknown acl category " + category)
+	}
+	if !cmd.hasCategory(category) {
+		cmd.categories = append(cmd.categories, category)
+	}
+}
+
+// withGetKeys 设置取出 key 的函数, 用于 key 的位置不固定的命令
+func (cmd *Command) withGetKeys(getKeys func(cmdLine [][]byte) [][]byte) *Command {
+	cmd.getKeys = getKeys
+	return cmd
+}
+
+func (cmd *Command) hasFlag(flag int) bool {
+	return cmd.flags&flag != 0
+}
+
+func (cmd *Command) hasCategory(category string) bool {
+	for _, c := range cmd.categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArity argc 包含命令名
+func (cmd *Command) checkArity(argc int) bool {
+	if cmd.arity > 0 {
+		return argc == cmd.arity
+	}
+	return argc >= -cmd.arity
+}
+
+// flagNames COMMAND INFO 中的 flags
+func (cmd *Command) flagNames() []string {
+	names := make([]string, 0)
+	for _, f := range commandFlagNames {
+		if cmd.hasFlag(f.flag) {
+			names = append(names, f.name)
+		}
 	}
-	commandRouter[name] = cmd
+	return names
+}
+
+// keys 取出命令中的所有 key, cmdLine 包含命令名
+func (cmd *Command) keys(cmdLine [][]byte) [][]byte {
+	if cmd.getKeys != nil {
+		return cmd.getKeys(cmdLine)
+	}
+	if cmd.firstKey <= 0 || cmd.firstKey >= len(cmdLine) {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last = len(cmdLine) + last
+	}
+	if last >= len(cmdLine) {
+		last = len(cmdLine) - 1
+	}
+	keys := make([][]byte, 0, last-cmd.firstKey+1)
+	for i := cmd.firstKey; i <= last; i += cmd.keyStep {
+		keys = append(keys, cmdLine[i])
+	}
+	return keys
 }
 
 func router(name string) (*Command, error) {
@@ -33,3 +196,13 @@ func router(name string) (*Command, error) {
 	}
 	return nil, ErrorCommandNotFund
 }
+
+// commandNames 所有命令的名称, 按名称排序
+func commandNames() []string {
+	names := make([]string, 0, len(commandRouter))
+	for name := range commandRouter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
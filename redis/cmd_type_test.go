@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCommandArity(t *testing.T) {
+	get, _ := router("get")
+	assert.True(t, get.checkArity(2))
+	assert.False(t, get.checkArity(1))
+	assert.False(t, get.checkArity(3))
+
+	del, _ := router("DEL")
+	assert.False(t, del.checkArity(1))
+	assert.True(t, del.checkArity(2))
+	assert.True(t, del.checkArity(10))
+}
+
+func TestCommandCategories(t *testing.T) {
+	get, _ := router("get")
+	assert.Equal(t, []string{"string", "read", "fast"}, get.categories)
+	assert.Equal(t, []string{"readonly", "fast"}, get.flagNames())
+
+	gc, _ := router("gc")
+	assert.Equal(t, []string{"admin", "dangerous", "slow"}, gc.categories)
+	for _, name := range commandNames() {
+		assert.Contains(t, commandDocs, name, name)
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	toArgs := func(args ...string) [][]byte {
+		result := make([][]byte, len(args))
+		for i, arg := range args {
+			result[i] = []byte(arg)
+		}
+		return result
+	}
+	keys := func(args ...string) [][]byte {
+		cmd, err := router(args[0])
+		assert.Nil(t, err)
+		return cmd.keys(toArgs(args...))
+	}
+	assert.Equal(t, toArgs("a", "b"), keys("mset", "a", "1", "b", "2"))
+	assert.Equal(t, toArgs("a", "b", "c"), keys("del", "a", "b", "c"))
+	assert.Equal(t, toArgs("k"), keys("memory", "usage", "k"))
+	assert.Equal(t, toArgs("l", "dst"), keys("sort", "l", "GET", "store", "STORE", "dst"))
+	assert.Nil(t, keys("ping"))
+}
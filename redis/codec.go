@@ -1,20 +1,24 @@
 package redis
 
 import (
+	"bytes"
 	"container/list"
 	"errors"
 	"fmt"
 	"github.com/panjf2000/gnet/v2"
-	"strconv"
+	"github.com/xuning888/godis-tiny/config"
+	"math"
 )
 
-type DecodeFunc func(conn gnet.Conn) ([]byte, error)
-
 // Codec RESP2协议的codec
 // 参考了 netty RedisDecoder类的实现。
 // 对于redis服务端来说, 只需关注客户端发送来的 *(Array)和$(Bulk)。
 // 省去了对 +(simpleString) -(ERR) :(Integer)的支持。
 // + - : 都被认为是inline，然后返回 -ERR Protocol Unknown command <inline> with: <args> 给客户端，随后关闭连接。
+//
+// 每次 Decode 只 Peek 一次 gnet 的读缓冲区, 在这段内存上线性地扫描, 最后一次性 Discard 已经解码的字节。
+// 读缓冲区中已经有完整的命令时走快速路径, 参数被拷贝到池化的 arena 中, 队列中的命令都执行完之后复用;
+// 遇到半包时退化为状态机, 记录解码进度, 下次 Decode 时从中断的位置继续, 不会重复扫描。
 type Codec struct {
 	// state codec 当前需要处理的状态
 	state State
@@ -28,29 +32,72 @@ type Codec struct {
 	decodeForArray bool
 	// argsBuf 缓存已经解码的数据
 	argsBuf [][]byte
+	// scanned 查找行尾时已经检查过的字节数, 半包时下次从这里继续查找
+	scanned int
+	// offsets 快速路径中记录每个参数在读缓冲区中的位置, 在多次解码之间复用
+	offsets []int
+	// arenas 队列中的命令的参数所在的 arena
+	arenas []*argArena
 }
 
 func (c *Codec) Decode(conn gnet.Conn, commands *list.List) error {
-	for conn.InboundBuffered() > 0 {
-		decode, err2 := c.getDecode()
-		if err2 != nil {
-			return handleDecodeError(err2, c)
+	// 之前解码的命令都执行完了, 它们的参数不会再被访问
+	if commands.Len() == 0 && len(c.arenas) > 0 {
+		c.releaseArenas()
+	}
+	n := conn.InboundBuffered()
+	if n == 0 {
+		return nil
+	}
+	// 等待bulk string的内容时, 数据不够就不需要 Peek, 避免大的 bulk string 分多次到达时反复拷贝读缓冲区
+	if c.state == DecodeBulkStringContent && n < c.remainingBulkLength+2 {
+		return ErrIncompletePacket
+	}
+	buf, err := conn.Peek(-1)
+	if err != nil {
+		return err
+	}
+	consumed, err := c.decode(buf, commands)
+	if consumed > 0 {
+		if _, err2 := conn.Discard(consumed); err2 != nil {
+			return err2
 		}
+	}
+	if err != nil {
+		return handleDecodeError(err, c)
+	}
+	return nil
+}
 
-		line, err3 := decode(conn)
-		if err3 != nil {
-			return handleDecodeError(err3, c)
+// decode 解码 buf 中的命令, 返回已经处理的字节数
+func (c *Codec) decode(buf []byte, commands *list.List) (int, error) {
+	pos := 0
+	for pos < len(buf) {
+		var n int
+		var err error
+		switch c.state {
+		case DecodeType:
+			n, err = c.decodeType(buf[pos:], commands)
+		case DecodeInline:
+			n, err = c.decodeInline(buf[pos:], commands)
+		case DecodeLength:
+			n, err = c.decodeLength(buf[pos:])
+		case DecodeBulkStringContent:
+			n, err = c.decodeBulkString(buf[pos:], commands)
+		default:
+			err = errors.New("unhandled state")
 		}
-		if line != nil {
-			appendReply(line, commands, c)
+		pos += n
+		if err != nil {
+			return pos, err
 		}
 	}
-	return nil
+	return pos, nil
 }
 
-func appendReply(reply []byte, commands *list.List, c *Codec) {
+func (c *Codec) appendArg(arg []byte, commands *list.List) {
 	if c.decodeForArray {
-		c.argsBuf = append(c.argsBuf, reply)
+		c.argsBuf = append(c.argsBuf, arg)
 
 		if c.remainingBulkCount > 0 {
 			c.remainingBulkCount--
@@ -59,12 +106,13 @@ func appendReply(reply []byte, commands *list.List, c *Codec) {
 		if c.remainingBulkCount <= 0 {
 			c.decodeForArray = false
 			commands.PushBack(c.argsBuf)
-			c.argsBuf = make([][]byte, 0)
+			c.argsBuf = nil
 		}
 	} else {
-		commands.PushBack([][]byte{reply})
+		commands.PushBack([][]byte{arg})
 	}
 }
+
 func handleDecodeError(err error, c *Codec) error {
 	// 如果err是 黏包/半包 就直接返回，否则就把接收到的包丢弃
 	if errors.Is(err, ErrIncompletePacket) {
@@ -74,188 +122,387 @@ func handleDecodeError(err error, c *Codec) error {
 	return err
 }
 
-func (c *Codec) getDecode() (DecodeFunc, error) {
-	switch c.state {
-	case DecodeType:
-		return c.decodeType, nil
-	case DecodeInline:
-		return c.decodeInline, nil
-	case DecodeBulkStringContent:
-		return c.decodeBulkString, nil
-	case DecodeLength:
-		return c.decodeLength, nil
-	default:
-		return nil, errors.New("unhandled state")
-	}
-}
-
-func (c *Codec) decodeType(conn gnet.Conn) ([]byte, error) {
-	n := conn.InboundBuffered()
-	if n == 0 {
-		return nil, ErrIncompletePacket
-	}
-	buf, err := conn.Peek(1)
-	if err != nil {
-		return nil, err
-	}
+func (c *Codec) decodeType(buf []byte, commands *list.List) (int, error) {
 	// 查看第一个字节
 	b := buf[0]
+	if c.decodeForArray {
+		// 数组中只能是 bulk string
+		if b != '$' {
+			return 0, NewErrProtocol(fmt.Sprintf("expected '$', got '%c'", b))
+		}
+		c.messageType = BulkString
+		c.state = DecodeLength
+		return 0, nil
+	}
 	c.messageType = valueOf(b)
 	if c.messageType.isInline() {
 		c.state = DecodeInline
-	} else {
-		c.state = DecodeLength
+		return 0, nil
 	}
 	if c.messageType == ArrayHeader {
-		c.decodeForArray = true
+		n, args, err := c.decodeMultiBulk(buf)
+		if err == nil {
+			if len(args) > 0 {
+				commands.PushBack(args)
+			}
+			return n, nil
+		}
+		if !errors.Is(err, ErrIncompletePacket) {
+			return 0, err
+		}
+		// 半包, 交给状态机逐个解码
 	}
-	return nil, nil
+	c.state = DecodeLength
+	return 0, nil
 }
 
-func (c *Codec) decodeInline(conn gnet.Conn) ([]byte, error) {
-	line, err := c.readLine(conn)
+// decodeMultiBulk 快速路径, buf 中包含完整的命令时, 先扫描一遍得到每个参数的位置,
+// 然后把所有参数拷贝到 arena 中。命令不完整时返回 ErrIncompletePacket
+func (c *Codec) decodeMultiBulk(buf []byte) (int, [][]byte, error) {
+	line, pos, err := readLine(buf, 0)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	c.resetDecoder()
-	return line, nil
-}
-
-func (c *Codec) decodeLength(conn gnet.Conn) ([]byte, error) {
-	line, err := c.readLine(conn)
+	count, err := c.parseMultiBulkCount(line)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	length, err := c.parserNumber(line)
-	if err != nil {
-		return nil, err
+	if count <= 0 {
+		// 空命令直接忽略
+		return pos, nil, nil
 	}
-	switch c.messageType {
-	case ArrayHeader:
-		// 记录下这个array需要解码的bulk
-		c.remainingBulkCount = int(length)
-		c.resetDecoder()
-		return nil, nil
-	case BulkString:
-		if length > RedisMessageMaxLength {
-			return nil, NewErrProtocol("invalid bulk length")
+	offsets := c.offsets[:0]
+	total := 0
+	for i := 0; i < count; i++ {
+		if pos >= len(buf) {
+			return 0, nil, ErrIncompletePacket
 		}
-		c.remainingBulkLength = int(length)
-		bulkLine, err := c.decodeBulkString(conn)
-		return bulkLine, err
-	default:
-		return nil, nil
+		if buf[pos] != '$' {
+			return 0, nil, NewErrProtocol(fmt.Sprintf("expected '$', got '%c'", buf[pos]))
+		}
+		line, next, err := readLine(buf[pos:], 0)
+		if err != nil {
+			return 0, nil, err
+		}
+		length, err := c.parseBulkLength(line)
+		if err != nil {
+			return 0, nil, err
+		}
+		start := pos + next
+		end := start + length
+		if end+2 > len(buf) {
+			c.offsets = offsets
+			return 0, nil, ErrIncompletePacket
+		}
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			return 0, nil, expectedCRLF(buf[end], buf[end+1])
+		}
+		offsets = append(offsets, start, end)
+		total += length
+		pos = end + 2
 	}
-}
+	c.offsets = offsets
 
-func (c *Codec) decodeBulkString(conn gnet.Conn) ([]byte, error) {
-	n := conn.InboundBuffered()
-	if n == 0 {
-		c.state = DecodeBulkStringContent
-		return nil, ErrIncompletePacket
+	args, block := c.allocArgs(count, total)
+	written := 0
+	for i := 0; i < count; i++ {
+		start, end := offsets[2*i], offsets[2*i+1]
+		n := copy(block[written:], buf[start:end])
+		args[i] = block[written : written+n : written+n]
+		written += n
 	}
+	return pos, args, nil
+}
 
-	readableBytes := n
-	if readableBytes < c.remainingBulkLength+2 {
-		c.state = DecodeBulkStringContent
-		return nil, ErrIncompletePacket
+// decodeInline 解码 telnet 这类客户端发送的 inline 命令, 如 `SET foo "hello world"`。
+// 行尾可以是 \r\n 也可以是单独的 \n, 一行的长度不能超过 RedisInlineMaxSize
+func (c *Codec) decodeInline(buf []byte, commands *list.List) (int, error) {
+	index := bytes.IndexByte(buf[c.scanned:], '\n')
+	if index < 0 {
+		c.scanned = len(buf)
+		if len(buf) > RedisInlineMaxSize {
+			return 0, NewErrProtocol("too big inline request")
+		}
+		return 0, ErrIncompletePacket
 	}
+	index += c.scanned
+	c.scanned = 0
+	if index > RedisInlineMaxSize {
+		return 0, NewErrProtocol("too big inline request")
+	}
+	line := buf[:index]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	// 通过管道发送 aof 文件时, #TS:<unix> 注释和空行一样忽略。其他以 # 开头的行仍然按照命令处理, 返回未知命令的错误
+	if bytes.HasPrefix(line, []byte(aofTimestampPrefix)) {
+		c.resetDecoder()
+		return index + 1, nil
+	}
+	args, err := splitInlineArgs(line)
+	if err != nil {
+		return 0, err
+	}
+	// 空行直接忽略
+	if len(args) > 0 {
+		commands.PushBack(args)
+	}
+	c.resetDecoder()
+	return index + 1, nil
+}
 
-	var line []byte
-	if c.remainingBulkLength == 0 {
-		line = make([]byte, 0)
-	} else {
-		buf, err := conn.Peek(c.remainingBulkLength)
-		if err != nil {
-			return nil, err
+// splitInlineArgs 按照 redis-cli 的规则拆分 inline 命令。
+// 参数之间使用空白字符分隔, 双引号中支持 \n \r \t \b \a \\ \" 和 \xHH 转义,
+// 单引号中只支持 \' 转义, 引号闭合后必须紧跟空白字符或者行尾
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
 		}
-		line = make([]byte, c.remainingBulkLength)
-		copy(line, buf[:c.remainingBulkLength])
-		if _, err2 := conn.Discard(c.remainingBulkLength); err2 != nil {
-			return nil, err2
+		if i >= len(line) {
+			return args, nil
 		}
+		var current []byte
+		inDoubleQuotes, inSingleQuotes, done := false, false, false
+		for !done {
+			if inDoubleQuotes {
+				if i >= len(line) {
+					return nil, NewErrProtocol("unbalanced quotes in request")
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					current = append(current, hexDigitToInt(line[i+2])*16+hexDigitToInt(line[i+3]))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				} else if line[i] == '"' {
+					// 闭合的引号后边必须是空白字符或者行尾
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, NewErrProtocol("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					current = append(current, line[i])
+				}
+			} else if inSingleQuotes {
+				if i >= len(line) {
+					return nil, NewErrProtocol("unbalanced quotes in request")
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, NewErrProtocol("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					current = append(current, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					current = append(current, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if current == nil {
+			current = make([]byte, 0)
+		}
+		args = append(args, current)
 	}
-	if err2 := c.readEndOfLine(conn); err2 != nil {
-		return nil, err2
-	}
-	c.resetDecoder()
-	return line, nil
 }
 
-func (c *Codec) readLine(conn gnet.Conn) ([]byte, error) {
-	// $0\r\n\r\n
-	buf, err := conn.Peek(2)
-	if err != nil || len(buf) < 2 {
-		return nil, ErrIncompletePacket
+func isInlineSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\v' || b == '\f' || b == 0
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+func hexDigitToInt(b byte) byte {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0'
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10
+	default:
+		return b - 'A' + 10
 	}
+}
 
-	buff, index, err := peekBytes(conn, '\n')
+// decodeLength 状态机中解码 *<count>\r\n 和 $<length>\r\n
+func (c *Codec) decodeLength(buf []byte) (int, error) {
+	line, n, err := readLine(buf, c.scanned)
 	if err != nil {
-		// 没有读取到有效的line
-		return nil, ErrIncompletePacket
+		if errors.Is(err, ErrIncompletePacket) {
+			c.scanned = len(buf)
+		}
+		return 0, err
 	}
+	c.scanned = 0
+	switch c.messageType {
+	case ArrayHeader:
+		count, err := c.parseMultiBulkCount(line)
+		if err != nil {
+			return 0, err
+		}
+		c.resetDecoder()
+		if count > 0 {
+			// 记录下这个array需要解码的bulk
+			c.decodeForArray = true
+			c.remainingBulkCount = count
+			if count > 1024 {
+				count = 1024
+			}
+			c.argsBuf = make([][]byte, 0, count)
+		}
+		return n, nil
+	default:
+		length, err := c.parseBulkLength(line)
+		if err != nil {
+			return 0, err
+		}
+		c.remainingBulkLength = length
+		c.state = DecodeBulkStringContent
+		return n, nil
+	}
+}
 
-	crIndex := index - 1
-	data := make([]byte, crIndex)
-	copy(data, buff[:crIndex])
-	if _, err3 := conn.Discard(len(data)); err3 != nil {
-		return nil, err3
+func (c *Codec) decodeBulkString(buf []byte, commands *list.List) (int, error) {
+	length := c.remainingBulkLength
+	if len(buf) < length+2 {
+		return 0, ErrIncompletePacket
 	}
-	if err2 := c.readEndOfLine(conn); err2 != nil {
-		return nil, err2
+	if buf[length] != '\r' || buf[length+1] != '\n' {
+		return 0, expectedCRLF(buf[length], buf[length+1])
 	}
-	return data, nil
+	arg := make([]byte, length)
+	copy(arg, buf[:length])
+	c.resetDecoder()
+	c.appendArg(arg, commands)
+	return length + 2, nil
 }
 
-func peekBytes(conn gnet.Conn, b byte) ([]byte, int, error) {
-	offset := 0
-	for {
-		buf, err := conn.Peek(offset + 1) // 从偏移位置读取切片并增加长度
-		if err != nil {
-			return nil, 0, err
+// readLine 从 from 开始查找 \n, 返回不包含 \r\n 的一行和这一行占用的字节数
+func readLine(buf []byte, from int) ([]byte, int, error) {
+	index := bytes.IndexByte(buf[from:], '\n')
+	if index < 0 {
+		if len(buf) > RedisInlineMaxSize {
+			return nil, 0, NewErrProtocol("too big count string")
 		}
+		return nil, 0, ErrIncompletePacket
+	}
+	index += from
+	if index == 0 || buf[index-1] != '\r' {
+		return nil, 0, NewErrProtocol("expected '\\r\\n'")
+	}
+	return buf[:index-1], index + 1, nil
+}
 
-		// 检查我们刚刚读取的最后一个字节
-		if buf[offset] == b {
-			return buf, offset, nil
-		}
+func expectedCRLF(b1, b2 byte) error {
+	return NewErrProtocol(fmt.Sprintf("expected: '\\r\\n',got'%v, %v'", b1, b2))
+}
 
-		offset++ // 如果不是要找的字节，则继续增加偏移量
+// parseMultiBulkCount 解析 *<count>
+func (c *Codec) parseMultiBulkCount(line []byte) (int, error) {
+	count, ok := parseInt(line[1:])
+	if !ok || count > RedisMultiBulkMaxCount {
+		return 0, NewErrProtocol("invalid multibulk length")
 	}
+	return int(count), nil
 }
 
-func (c *Codec) readEndOfLine(conn gnet.Conn) error {
-	buf, err := conn.Peek(2)
-	if err == nil && len(buf) == 2 && buf[0] == '\r' && buf[1] == '\n' {
-		if _, err2 := conn.Discard(2); err2 != nil {
-			return err2
-		}
-		return nil
+// parseBulkLength 解析 $<length>
+func (c *Codec) parseBulkLength(line []byte) (int, error) {
+	length, ok := parseInt(line[1:])
+	if !ok || length < 0 || length > maxBulkLength() {
+		return 0, NewErrProtocol("invalid bulk length")
 	}
-	return NewErrProtocol(fmt.Sprintf("expected: '\\r\\n',got'%v, %v'", buf[0], buf[1]))
+	return int(length), nil
 }
 
-func (c *Codec) parserNumber(buf []byte) (int64, error) {
-	numberBytes := buf[1:]
-	number, err := strconv.ParseInt(string(numberBytes), 10, 64)
-	if err != nil {
-		return 0, NewErrProtocol("illegal number " + string(buf))
+// parseInt 不分配内存地解析十进制整数
+func parseInt(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	negative := false
+	if b[0] == '-' {
+		negative = true
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, false
+		}
+	}
+	var n int64
+	for _, ch := range b {
+		if ch < '0' || ch > '9' {
+			return 0, false
+		}
+		d := int64(ch - '0')
+		// 在乘法之前检查, 溢出之后的结果可能回绕成一个合法的正数
+		if n > (math.MaxInt64-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
 	}
-	return number, nil
+	if negative {
+		n = -n
+	}
+	return n, true
 }
 
 func (c *Codec) resetDecoder() {
 	c.state = DecodeType
 	c.remainingBulkLength = 0
+	c.scanned = 0
 }
 
 func (c *Codec) Reset() {
 	c.resetDecoder()
-	c.argsBuf = make([][]byte, 0)
+	c.decodeForArray = false
+	c.remainingBulkCount = 0
+	c.argsBuf = nil
 }
 
-func NewCodec() *Codec {
-	return &Codec{
-		argsBuf: make([][]byte, 0),
+// maxBulkLength 单个bulk string的最大长度, 每次解码时读取配置 proto-max-bulk-len, CONFIG SET 之后对已有的连接也生效
+func maxBulkLength() int64 {
+	if config.Properties != nil && config.Properties.ProtoMaxBulkLen > 0 {
+		return int64(config.Properties.ProtoMaxBulkLen)
 	}
+	return RedisMessageMaxLength
+}
+
+func NewCodec() *Codec {
+	return &Codec{}
 }
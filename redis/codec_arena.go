@@ -0,0 +1,86 @@
+package redis
+
+import "sync"
+
+const (
+	// argArenaSize 每块 arena 的大小, 超过它的命令单独分配内存
+	argArenaSize = 16 * 1024
+	// argArenaArgs 每块 arena 中最多切分的参数个数
+	argArenaArgs = 1024
+)
+
+// argArenaPool 所有客户端共用的 arena
+var argArenaPool = sync.Pool{
+	New: func() interface{} {
+		return &argArena{buf: make([]byte, 0, argArenaSize), args: make([][]byte, 0, argArenaArgs)}
+	},
+}
+
+// argArena 快速路径解码出的参数切分自同一块内存, 解码时不再为每个命令分配内存。
+// 客户端队列中的命令都执行完之后 arena 放回 argArenaPool, 所以执行之后还要保存参数的命令需要先拷贝, 见 detachArgs
+type argArena struct {
+	buf  []byte
+	args [][]byte
+}
+
+// alloc 切分出 count 个参数和 size 字节的内存, 剩余的空间不够时返回 false
+func (a *argArena) alloc(count, size int) ([][]byte, []byte, bool) {
+	bufLen, argsLen := len(a.buf), len(a.args)
+	if bufLen+size > cap(a.buf) || argsLen+count > cap(a.args) {
+		return nil, nil, false
+	}
+	a.buf = a.buf[:bufLen+size]
+	a.args = a.args[:argsLen+count]
+	return a.args[argsLen : argsLen+count : argsLen+count], a.buf[bufLen : bufLen+size : bufLen+size], true
+}
+
+func (a *argArena) reset() {
+	a.buf = a.buf[:0]
+	a.args = a.args[:0]
+}
+
+// allocArgs 从 arena 中切分参数, 当前的 arena 用完之后从池中再取一块, 太大的命令单独分配
+func (c *Codec) allocArgs(count, size int) ([][]byte, []byte) {
+	if size > argArenaSize || count > argArenaArgs {
+		return make([][]byte, count), make([]byte, size)
+	}
+	if n := len(c.arenas); n > 0 {
+		if args, block, ok := c.arenas[n-1].alloc(count, size); ok {
+			return args, block
+		}
+	}
+	arena := argArenaPool.Get().(*argArena)
+	c.arenas = append(c.arenas, arena)
+	args, block, _ := arena.alloc(count, size)
+	return args, block
+}
+
+// releaseArenas 之前解码的命令都已经执行完, 把 arena 放回池中
+func (c *Codec) releaseArenas() {
+	for i, arena := range c.arenas {
+		arena.reset()
+		argArenaPool.Put(arena)
+		c.arenas[i] = nil
+	}
+	c.arenas = c.arenas[:0]
+}
+
+// detachArgs 把当前命令的参数拷贝到单独的内存中, 写命令可能把参数保存到数据库中, 执行之前调用
+func (c *Client) detachArgs() {
+	if c.codec == nil || len(c.codec.arenas) == 0 {
+		return
+	}
+	size := 0
+	for _, arg := range c.curCommand {
+		size += len(arg)
+	}
+	block := make([]byte, size)
+	args := make([][]byte, len(c.curCommand))
+	written := 0
+	for i, arg := range c.curCommand {
+		n := copy(block[written:], arg)
+		args[i] = block[written : written+n : written+n]
+		written += n
+	}
+	c.curCommand = args
+}
@@ -8,6 +8,10 @@ import (
 var (
 	ErrIncompletePacket         = errors.New("incomplete packet")
 	RedisMessageMaxLength int64 = 512 * 1024 * 1024
+	// RedisInlineMaxSize inline 命令一行的最大长度
+	RedisInlineMaxSize = 64 * 1024
+	// RedisMultiBulkMaxCount 一个命令最多包含的参数个数
+	RedisMultiBulkMaxCount int64 = 1024 * 1024
 )
 
 type State int
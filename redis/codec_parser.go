@@ -1,14 +1,10 @@
 package redis
 
 import (
-	"bufio"
-	"bytes"
-	"errors"
 	"github.com/xuning888/godis-tiny/pkg/logger"
+	"github.com/xuning888/godis-tiny/pkg/resp"
 	"io"
-	"log"
 	"runtime/debug"
-	"strconv"
 )
 
 type Payload struct {
@@ -37,144 +33,84 @@ func decodeStream(r io.Reader, ch chan<- *Payload) {
 			logger.Error(err, string(debug.Stack()))
 		}
 	}()
-	reader := bufio.NewReader(r)
+	reader := resp.NewReader(r)
 	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			ch <- makePayload(nil, err)
-			close(ch)
-			return
-		}
-		length := len(line)
-		if length <= 2 || line[length-2] != '\r' {
+		// 跳过 aof 中的 #TS:<unix> 这类注释
+		_, isAnnotation, err := reader.ReadAnnotation()
+		if isAnnotation {
 			continue
 		}
-		copyLine := make([]byte, length)
-		copy(copyLine, line)
-		copyLine = bytes.TrimSuffix(copyLine, []byte{'\r', '\n'})
-		cmd := copyLine[0]
-		switch cmd {
-		case '+':
-			ch <- makePayload(MakeSimpleReply(copyLine[1:]), nil)
-		case '-':
-			ch <- makePayload(MakeStandardErrReply(string(copyLine[1:])), nil)
-		case ':':
-			value, err := strconv.ParseInt(string(copyLine[1:]), 10, 64)
-			if err != nil {
-				ch <- protocolErrPayload("illegal number " + string(copyLine[1:]))
-			} else {
-				ch <- makePayload(MakeIntReply(value), nil)
-			}
-		case '$':
-			strLen, err := strconv.ParseInt(string(copyLine[1:]), 10, 64)
-			if err != nil || strLen < -1 {
-				ch <- protocolErrPayload("illegal bulk string header: " + string(copyLine))
-			} else if strLen == -1 {
-				ch <- makePayload(MakeNullBulkReply(), nil)
-			} else {
-				body := make([]byte, strLen+2)
-				_, err = io.ReadFull(reader, body)
-				if err != nil {
-					ch <- makePayload(nil, err)
-				} else {
-					ch <- makePayload(MakeBulkReply(body[:len(body)-2]), nil)
-				}
-			}
-		case '*':
-			decodeInStreamArray(copyLine[1:], reader, ch)
-		default:
-			args := bytes.Split(line, []byte{' '})
-			ch <- makePayload(MakeMultiBulkReply(args), nil)
+		var value resp.Value
+		if err == nil {
+			value, err = reader.ReadValue()
 		}
-	}
-}
-
-func decodeInStreamArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) {
-	nStrs, err := strconv.ParseInt(string(header), 10, 64)
-	if err != nil || nStrs < 0 {
-		ch <- protocolErrPayload("illegal number " + string(header[1:]))
-		return
-	} else if nStrs == 0 {
-		ch <- makePayload(MakeEmptyMultiBulkReply(), nil)
-		return
-	}
-	lines := make([][]byte, 0, nStrs)
-	for i := int64(0); i < nStrs; i++ {
-		var line []byte
-		line, err = reader.ReadBytes('\n')
 		if err != nil {
+			// 协议错误可能出现在数组的中间, 这时无法找到下一个值的开始位置, 和其他错误一样停止解析
 			ch <- makePayload(nil, err)
+			close(ch)
 			return
 		}
-		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			ch <- protocolErrPayload("illegal bulk string header " + string(line))
-			return
-		}
-		copyLine := make([]byte, length)
-		copy(copyLine, line)
-		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			ch <- protocolErrPayload("illegal number " + string(line))
-			return
-		} else if strLen == -1 {
-			lines = append(lines, []byte{})
-		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
-			if err != nil {
-				ch <- makePayload(nil, err)
-				return
-			}
-			lines = append(lines, body[:len(body)-2:len(body)-2])
-		}
+		ch <- makePayload(valueToReply(value), nil)
 	}
-	ch <- makePayload(MakeMultiBulkReply(lines), nil)
 }
 
-func parseArray(header []byte, reader *bufio.Reader) (*Payload, error) {
-	nStrs, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || nStrs < 0 {
-		return protocolErrPayload("illegal number " + string(header[1:])), nil
-	} else if nStrs == 0 {
-		return makePayload(MakeEmptyMultiBulkReply(), nil), nil
-	}
-	lines := make([][]byte, 0, nStrs)
-	for i := int64(0); i < nStrs; i++ {
-		var line []byte
-		line, err = reader.ReadBytes('\n')
-		if err != nil {
-			log.Printf("read n failed with error %v\n", err)
-			return nil, err
+// valueToReply 把 resp.Value 转换为对应的 Reply
+func valueToReply(value resp.Value) Reply {
+	switch value.Type {
+	case resp.SimpleString:
+		return MakeSimpleReply(value.Str)
+	case resp.Error, resp.BlobError:
+		return MakeStandardErrReply(string(value.Str))
+	case resp.Integer:
+		return MakeIntReply(value.Int)
+	case resp.BulkString:
+		if value.IsNull {
+			return MakeNullBulkReply()
+		}
+		return MakeBulkReply(value.Str)
+	case resp.Array:
+		if value.IsNull {
+			return MakeNullBulkReply()
 		}
-		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			return protocolErrPayload("illegal bulk string header " + string(line)), nil
+		if len(value.Elems) == 0 {
+			return MakeEmptyMultiBulkReply()
 		}
-		copyLine := make([]byte, length)
-		copy(copyLine, line)
-		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			return protocolErrPayload("illegal number " + string(line)), nil
-		} else if strLen == -1 {
-			lines = append(lines, []byte{})
-		} else {
-			body := make([]byte, strLen+2)
-			totalRead := 0
-			for totalRead < len(body) {
-				n, err := reader.Read(body[totalRead:])
-				if err != nil && err != io.EOF {
-					log.Printf("read n failed with error %v\n", err)
-					return nil, err
-				}
-				totalRead += n
+		if args, ok := value.Args(); ok {
+			return MakeMultiBulkReply(args)
+		}
+		return MakeMultiRowReply(valuesToReplies(value.Elems))
+	case resp.Null:
+		return MakeNullBulkReply()
+	case resp.Double:
+		return MakeDoubleReply(value.Float)
+	case resp.Boolean:
+		return MakeBoolReply(value.Bool)
+	case resp.BigNumber:
+		return MakeBigNumberReply(string(value.Str))
+	case resp.VerbatimString:
+		return MakeVerbatimReply(value.Format, value.Str)
+	case resp.Map, resp.Attribute:
+		return MakeMapReply(valuesToReplies(value.Elems))
+	case resp.Set:
+		members := make([][]byte, 0, len(value.Elems))
+		for _, elem := range value.Elems {
+			if elem.Type != resp.BulkString && elem.Type != resp.SimpleString {
+				return MakeMultiRowReply(valuesToReplies(value.Elems))
 			}
-			lines = append(lines, body[:len(body)-2:len(body)-2])
+			members = append(members, elem.Str)
 		}
+		return MakeSetReply(members)
+	case resp.Push:
+		return MakePushReply(valuesToReplies(value.Elems))
+	default:
+		return MakeStandardErrReply("ERR unknown reply type " + value.Type.String())
 	}
-	return makePayload(MakeMultiBulkReply(lines), nil), nil
 }
 
-func protocolErrPayload(errMsg string) *Payload {
-	return makePayload(nil, errors.New("protocol error: "+errMsg))
+func valuesToReplies(values []resp.Value) []Reply {
+	replies := make([]Reply, len(values))
+	for i, value := range values {
+		replies[i] = valueToReply(value)
+	}
+	return replies
 }
@@ -0,0 +1,336 @@
+package redis
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/gnet/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/resp"
+	"io"
+	"net"
+	"testing"
+)
+
+// mockConn 只实现了 Codec 需要用到的读缓冲区相关方法
+type mockConn struct {
+	gnet.Conn
+	buf []byte
+}
+
+func (m *mockConn) InboundBuffered() int {
+	return len(m.buf)
+}
+
+func (m *mockConn) Peek(n int) ([]byte, error) {
+	if n > len(m.buf) {
+		return nil, io.ErrShortBuffer
+	}
+	if n <= 0 {
+		n = len(m.buf)
+	}
+	return m.buf[:n], nil
+}
+
+func (m *mockConn) Discard(n int) (int, error) {
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+type decoder interface {
+	Decode(conn gnet.Conn, commands *list.List) error
+}
+
+// decodeChunks 把 data 按照 chunkSize 分批写入读缓冲区, 模拟半包
+func decodeChunks(d decoder, data []byte, chunkSize int) ([][][]byte, error) {
+	conn := &mockConn{}
+	commands := list.New()
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		conn.buf = append(conn.buf, data[:n]...)
+		data = data[n:]
+		err := d.Decode(conn, commands)
+		if err != nil && !errors.Is(err, ErrIncompletePacket) {
+			return nil, err
+		}
+	}
+	result := make([][][]byte, 0, commands.Len())
+	for e := commands.Front(); e != nil; e = e.Next() {
+		result = append(result, e.Value.([][]byte))
+	}
+	return result, nil
+}
+
+func encodeCommands(commands [][][]byte) []byte {
+	var buf bytes.Buffer
+	for _, cmd := range commands {
+		buf.Write(MakeMultiBulkReply(cmd).ToBytes())
+	}
+	return buf.Bytes()
+}
+
+func TestCodecDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][][]byte
+	}{
+		{"multi bulk", "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", [][][]byte{{[]byte("GET"), []byte("foo")}}},
+		{"empty bulk", "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", [][][]byte{{[]byte("ECHO"), []byte("")}}},
+		{"pipeline", "*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nPING\r\n", [][][]byte{{[]byte("PING")}, {[]byte("PING")}}},
+		{"empty multi bulk", "*0\r\n*1\r\n$4\r\nPING\r\n", [][][]byte{{[]byte("PING")}}},
+		{"inline", "SET foo \"a b\"\r\n", [][][]byte{{[]byte("SET"), []byte("foo"), []byte("a b")}}},
+		{"inline lf", "PING\n\r\nPING\n", [][][]byte{{[]byte("PING")}, {[]byte("PING")}}},
+		{"aof annotation", "#TS:1700000000\r\n*1\r\n$4\r\nPING\r\n", [][][]byte{{[]byte("PING")}}},
+		{"hash line", "#comment\r\n#TS\r\n", [][][]byte{{[]byte("#comment")}, {[]byte("#TS")}}},
+	}
+	for _, tt := range tests {
+		for chunk := 1; chunk <= len(tt.input); chunk++ {
+			got, err := decodeChunks(NewCodec(), []byte(tt.input), chunk)
+			assert.Nil(t, err, tt.name)
+			assert.Equal(t, tt.want, got, fmt.Sprintf("%s chunk: %d", tt.name, chunk))
+		}
+	}
+}
+
+func TestDecodeStreamAnnotation(t *testing.T) {
+	replies := make([]Reply, 0)
+	for payload := range DecodeInStream(bytes.NewReader([]byte("#TS:1700000000\r\n*1\r\n$4\r\nPING\r\n#t\r\n"))) {
+		if payload.Error != nil {
+			assert.Equal(t, io.EOF, payload.Error)
+			continue
+		}
+		replies = append(replies, payload.Data)
+	}
+	assert.Equal(t, []Reply{MakeMultiBulkReply([][]byte{[]byte("PING")}), MakeBoolReply(true)}, replies)
+}
+
+func TestDecodeStreamProtocolError(t *testing.T) {
+	// 数组的第二个元素损坏, 之后的 $3\r\nbar\r\n 不能被当作新的值解析
+	input := "*1\r\n$4\r\nPING\r\n*3\r\n$3\r\nSET\r\n@foo\r\n$3\r\nbar\r\n*1\r\n$4\r\nPING\r\n"
+	replies := make([]Reply, 0)
+	errs := make([]error, 0)
+	for payload := range DecodeInStream(bytes.NewReader([]byte(input))) {
+		if payload.Error != nil {
+			errs = append(errs, payload.Error)
+			continue
+		}
+		replies = append(replies, payload.Data)
+	}
+	assert.Equal(t, []Reply{MakeMultiBulkReply([][]byte{[]byte("PING")})}, replies)
+	if assert.Equal(t, 1, len(errs)) {
+		var protocolErr *resp.ProtocolError
+		assert.True(t, errors.As(errs[0], &protocolErr))
+		assert.Equal(t, int64(len("*1\r\n$4\r\nPING\r\n*3\r\n$3\r\nSET\r\n")), protocolErr.Offset)
+	}
+}
+
+func TestCodecDecodeError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"negative bulk length", "*1\r\n$-1\r\n"},
+		{"bulk too long", "*1\r\n$536870913\r\n"},
+		{"multi bulk too long", "*1048577\r\n"},
+		{"bulk length overflow", "*1\r\n$18446744073709551620\r\n"},
+		{"multi bulk length overflow", "*18446744073709551620\r\n"},
+		{"illegal count", "*x\r\n"},
+		{"not bulk", "*1\r\n:1\r\n"},
+		{"missing crlf", "*1\r\n$3\r\nfooxx"},
+		{"unbalanced quotes", "SET foo \"bar\r\n"},
+	}
+	for _, tt := range tests {
+		_, err := decodeChunks(NewCodec(), []byte(tt.input), len(tt.input))
+		var errProtocol *ErrProtocol
+		assert.True(t, errors.As(err, &errProtocol), tt.name)
+	}
+}
+
+func TestCodecMaxBulkLength(t *testing.T) {
+	maxBulkLen := config.Properties.ProtoMaxBulkLen
+	t.Cleanup(func() {
+		config.Properties.ProtoMaxBulkLen = maxBulkLen
+	})
+	// 在 CONFIG SET 之前创建的 codec 也要使用新的配置
+	codec := NewCodec()
+	input := []byte("*1\r\n$1048577\r\n")
+	_, err := decodeChunks(NewCodec(), input, 100)
+	assert.Nil(t, err)
+
+	assert.Nil(t, config.Set("proto-max-bulk-len", "1048576"))
+	_, err = decodeChunks(codec, input, 100)
+	var errProtocol *ErrProtocol
+	assert.True(t, errors.As(err, &errProtocol), err)
+
+	got, err := decodeChunks(NewCodec(), []byte("*1\r\n$4\r\nPING\r\n"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, [][][]byte{{[]byte("PING")}}, got)
+}
+
+func TestCodecInlineTooBig(t *testing.T) {
+	_, err := decodeChunks(NewCodec(), bytes.Repeat([]byte("a"), RedisInlineMaxSize+1), 1024)
+	assert.NotNil(t, err)
+}
+
+func TestCodecArena(t *testing.T) {
+	codec := NewCodec()
+	conn := &mockConn{buf: encodeCommands([][][]byte{{[]byte("GET"), []byte("a")}, {[]byte("GET"), []byte("b")}})}
+	commands := list.New()
+	assert.Nil(t, codec.Decode(conn, commands))
+	assert.Equal(t, 1, len(codec.arenas))
+	first := commands.Front().Value.([][]byte)
+
+	// 队列中还有命令时 arena 不能复用
+	conn.buf = encodeCommands([][][]byte{{[]byte("GET"), []byte("c")}})
+	assert.Nil(t, codec.Decode(conn, commands))
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("a")}, first)
+	assert.Equal(t, 3, commands.Len())
+
+	// 命令都执行完之后放回池中
+	commands.Init()
+	assert.Nil(t, codec.Decode(conn, commands))
+	assert.Equal(t, 0, len(codec.arenas))
+
+	// 超过 arena 大小的命令单独分配
+	big := bytes.Repeat([]byte("v"), argArenaSize+1)
+	conn.buf = encodeCommands([][][]byte{{[]byte("SET"), []byte("k"), big}})
+	assert.Nil(t, codec.Decode(conn, commands))
+	assert.Equal(t, 0, len(codec.arenas))
+	assert.Equal(t, big, commands.Front().Value.([][]byte)[2])
+}
+
+// pipeConn 通过 codec 解码命令并记录回复的连接
+type pipeConn struct {
+	mockConn
+	replies bytes.Buffer
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	return c.replies.Write(p)
+}
+
+func (c *pipeConn) OutboundBuffered() int {
+	return 0
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func TestCodecArenaWriteCommand(t *testing.T) {
+	server := makeTempServer()
+	conn := &pipeConn{}
+	client := NewClient(0, conn, false)
+	run := func(cmdLines ...[][]byte) {
+		conn.buf = encodeCommands(cmdLines)
+		assert.Nil(t, client.Decode())
+		assert.Nil(t, server.process(context.Background(), client))
+	}
+	// 写命令保存的参数不能引用 arena, 之后的命令复用 arena 时不会覆盖已经保存的数据
+	run([][]byte{[]byte("SET"), []byte("k1"), []byte("value1")},
+		[][]byte{[]byte("RPUSH"), []byte("l"), []byte("item1")})
+	for i := 0; i < 10; i++ {
+		run([][]byte{[]byte("GET"), []byte("xx")}, [][]byte{[]byte("ECHO"), []byte("overwrite-the-arena")})
+	}
+	assert.Equal(t, "value1", stringValue(t, server, 0, "k1"))
+	conn.replies.Reset()
+	run([][]byte{[]byte("LRANGE"), []byte("l"), []byte("0"), []byte("-1")})
+	assert.Equal(t, "*1\r\n$5\r\nitem1\r\n", conn.replies.String())
+}
+
+func TestCodecInlineHashLine(t *testing.T) {
+	server := makeTempServer()
+	conn := &pipeConn{}
+	client := NewClient(0, conn, false)
+	// 只有 #TS: 注释被忽略, 其他以 # 开头的行按照未知命令处理
+	conn.buf = []byte("#TS:1700000000\r\n#comment\r\nPING\r\n")
+	assert.Nil(t, client.Decode())
+	assert.Nil(t, server.process(context.Background(), client))
+	assert.Equal(t, "-ERR unknown command '#comment', with args beginning with: \r\n+PONG\r\n", conn.replies.String())
+}
+
+// FuzzCodecDecode 把模糊测试的输入拆成命令和参数, 编码后按照随机的大小分批写入,
+// 新旧两个 Codec 的解码结果必须一致; 原始输入直接交给新的 Codec 时不能 panic
+func FuzzCodecDecode(f *testing.F) {
+	f.Add([]byte("SET\x00foo\x00bar\x01GET\x00foo"), uint8(3))
+	f.Add([]byte("PING"), uint8(1))
+	f.Add([]byte("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"), uint8(7))
+	f.Add([]byte("SET foo \"a\\x41\"\r\n"), uint8(64))
+	f.Fuzz(func(t *testing.T, data []byte, chunk uint8) {
+		chunkSize := int(chunk)%64 + 1
+		_, _ = decodeChunks(NewCodec(), data, chunkSize)
+
+		commands := make([][][]byte, 0)
+		for _, cmd := range bytes.Split(data, []byte{1}) {
+			commands = append(commands, bytes.Split(cmd, []byte{0}))
+		}
+		input := encodeCommands(commands)
+		want, err := decodeChunks(newLegacyCodec(), input, chunkSize)
+		if err != nil {
+			t.Fatalf("legacy codec: %v", err)
+		}
+		got, err := decodeChunks(NewCodec(), input, chunkSize)
+		if err != nil {
+			t.Fatalf("codec: %v", err)
+		}
+		assert.Equal(t, want, got)
+	})
+}
+
+func benchmarkPipeline(n int, valueSize int) []byte {
+	value := bytes.Repeat([]byte("v"), valueSize)
+	commands := make([][][]byte, n)
+	for i := 0; i < n; i++ {
+		commands[i] = [][]byte{[]byte("SET"), []byte(fmt.Sprintf("key:%d", i)), value}
+	}
+	return encodeCommands(commands)
+}
+
+func benchmarkDecode(b *testing.B, newDecoder func() decoder, data []byte) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	d := newDecoder()
+	commands := list.New()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn := &mockConn{buf: data}
+		// 上一批命令已经执行完
+		commands.Init()
+		if err := d.Decode(conn, commands); err != nil && !errors.Is(err, ErrIncompletePacket) {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCodecDecodePipeline(b *testing.B) {
+	benchmarkDecode(b, func() decoder { return NewCodec() }, benchmarkPipeline(1000, 16))
+}
+
+func BenchmarkLegacyCodecDecodePipeline(b *testing.B) {
+	benchmarkDecode(b, func() decoder { return newLegacyCodec() }, benchmarkPipeline(1000, 16))
+}
+
+func BenchmarkCodecDecodeBigValue(b *testing.B) {
+	benchmarkDecode(b, func() decoder { return NewCodec() }, benchmarkPipeline(10, 64*1024))
+}
+
+func BenchmarkLegacyCodecDecodeBigValue(b *testing.B) {
+	benchmarkDecode(b, func() decoder { return newLegacyCodec() }, benchmarkPipeline(10, 64*1024))
+}
+
+func BenchmarkCodecDecodeLongInline(b *testing.B) {
+	data := append(bytes.Repeat([]byte("a"), 32*1024), '\r', '\n')
+	benchmarkDecode(b, func() decoder { return NewCodec() }, data)
+}
+
+func BenchmarkLegacyCodecDecodeLongInline(b *testing.B) {
+	data := append(bytes.Repeat([]byte("a"), 32*1024), '\r', '\n')
+	benchmarkDecode(b, func() decoder { return newLegacyCodec() }, data)
+}
@@ -14,14 +14,26 @@ type DB struct {
 	data     dict.Dict
 	ttlCache ttl.Cache
 	AddAof   func(cmdline [][]byte)
+	// KeyRead, KeyModified, Flushed 读写 key 的钩子, 用于 CLIENT TRACKING
+	KeyRead     func(key string)
+	KeyModified func(key string)
+	Flushed     func()
+	// KeyLookup, KeyExpired 用于 INFO 中的 keyspace_hits, keyspace_misses 和 expired_keys
+	KeyLookup  func(hit bool)
+	KeyExpired func(key string)
 }
 
 func NewDB(index int, data dict.Dict, cache ttl.Cache) *DB {
 	db := &DB{
-		Index:    index,
-		data:     data,
-		ttlCache: cache,
-		AddAof:   func(cmdline [][]byte) {},
+		Index:       index,
+		data:        data,
+		ttlCache:    cache,
+		AddAof:      func(cmdline [][]byte) {},
+		KeyRead:     func(key string) {},
+		KeyModified: func(key string) {},
+		Flushed:     func() {},
+		KeyLookup:   func(hit bool) {},
+		KeyExpired:  func(key string) {},
 	}
 	return db
 }
@@ -37,7 +49,11 @@ func (db *DB) ForEach(cb func(key string, data *obj.RedisObject, expiration *tim
 		entity, _ := val.(*obj.RedisObject)
 		var expiration *time.Time = nil
 		expired, exists := db.ttlCache.IsExpired(key)
-		if exists && !expired {
+		if exists && expired {
+			// 已经过期但是还没有删除的 key
+			return true
+		}
+		if exists {
 			expireTime := db.ttlCache.ExpireAt(key)
 			expiration = &expireTime
 		}
@@ -47,7 +63,9 @@ func (db *DB) ForEach(cb func(key string, data *obj.RedisObject, expiration *tim
 
 // GetEntity getData
 func (db *DB) GetEntity(key string) (*obj.RedisObject, bool) {
+	db.KeyRead(key)
 	row, exists := db.data.Get(key)
+	db.KeyLookup(exists)
 	if !exists {
 		return nil, false
 	}
@@ -56,15 +74,30 @@ func (db *DB) GetEntity(key string) (*obj.RedisObject, bool) {
 }
 
 func (db *DB) PutEntity(key string, obj *obj.RedisObject) int {
-	return db.data.Put(key, obj)
+	result := db.data.Put(key, obj)
+	db.KeyModified(key)
+	return result
 }
 
 func (db *DB) PutIfExists(key string, entity *obj.RedisObject) int {
-	return db.data.PutIfExists(key, entity)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 {
+		db.KeyModified(key)
+	}
+	return result
 }
 
 func (db *DB) PutIfAbsent(key string, entity *obj.RedisObject) int {
-	return db.data.PutIfAbsent(key, entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.KeyModified(key)
+	}
+	return result
+}
+
+// SignalModifiedKey 直接修改了 key 对应的对象, 没有经过 PutEntity 时需要调用
+func (db *DB) SignalModifiedKey(key string) {
+	db.KeyModified(key)
 }
 
 // Remove 删除数据
@@ -72,10 +105,18 @@ func (db *DB) Remove(key string) int {
 	result := db.data.Remove(key)
 	if result > 0 {
 		db.ttlCache.Remove(key)
+		db.KeyModified(key)
 	}
 	return result
 }
 
+// RemoveExpired 删除已经过期的 key
+func (db *DB) RemoveExpired(key string) {
+	if db.Remove(key) > 0 {
+		db.KeyExpired(key)
+	}
+}
+
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
@@ -97,7 +138,9 @@ func (db *DB) Len() int {
 func (db *DB) Exists(keys []string) int64 {
 	var result int64 = 0
 	for _, key := range keys {
+		db.KeyRead(key)
 		_, ok := db.data.Get(key)
+		db.KeyLookup(ok)
 		if ok {
 			result++
 		}
@@ -111,6 +154,7 @@ func (db *DB) Flush() {
 		db.data.Clear()
 		db.ttlCache.Clear()
 	}
+	db.Flushed()
 }
 
 /* ---- Data TTL ----- */
@@ -130,6 +174,11 @@ func (db *DB) RemoveTTLV1(key string) {
 	db.ttlCache.Remove(key)
 }
 
+// ExpiresLen 设置了过期时间的 key 的数量
+func (db *DB) ExpiresLen() int {
+	return db.ttlCache.Len()
+}
+
 func (db *DB) ExpiredAt(key string) time.Time {
 	return db.ttlCache.ExpireAt(key)
 }
@@ -152,7 +201,7 @@ func (db *DB) RandomCheckTTLAndClear() {
 		}
 		if expired {
 			logger.Debugf("ttl check, db%d key: %s, 过期了", db.Index, key)
-			db.Remove(key)
+			db.RemoveExpired(key)
 		}
 	}
 }
@@ -173,7 +222,7 @@ func (db *DB) RandomCheckTTLAndClearV1() {
 		expired, _ := db.ttlCache.IsExpired(item.Key)
 		if expired {
 			logger.Debugf("ttl check, db%d key: %s, 过期了", db.Index, item.Key)
-			db.Remove(item.Key)
+			db.RemoveExpired(item.Key)
 		} else {
 			break
 		}
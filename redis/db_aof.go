@@ -1,63 +1,30 @@
 package redis
 
 import (
-	"bufio"
 	"context"
 	"errors"
-	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
 	"github.com/xuning888/godis-tiny/pkg/logger"
+	"github.com/xuning888/godis-tiny/pkg/rdb"
 	"github.com/xuning888/godis-tiny/pkg/util"
-	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	// aofBufferSize aof缓冲区大小16MB
+	// aofBufferSize 超过这个大小的缓冲区写完之后不再复用, 16MB
 	aofBufferSize = 1 << 24
 	_             = iota
 	none
 	rewrite
 )
 
-type FileBuffer struct {
-	file   *os.File
-	writer *bufio.Writer
-}
-
-// Write
-// 写入缓冲区，如果p的大小大于了缓冲区的可用大小
-func (f *FileBuffer) Write(p []byte) (int, error) {
-	return f.writer.Write(p)
-}
-
-func (f *FileBuffer) Sync() error {
-	err := f.writer.Flush()
-	if err != nil {
-		return err
-	}
-	return f.file.Sync()
-}
-
-func (f *FileBuffer) Close() error {
-	return f.file.Close()
-}
-
-func (f *FileBuffer) Buffered() int {
-	return f.writer.Buffered()
-}
-
-func NewFileBuffer(f *os.File, bufSize int) *FileBuffer {
-	return &FileBuffer{
-		file:   f,
-		writer: bufio.NewWriterSize(f, bufSize),
-	}
-}
-
 const (
 	// FsyncAlways do fsync for every command
 	FsyncAlways   = "always"
@@ -68,50 +35,110 @@ const (
 
 type Exec func(ctx context.Context, conn *Client) error
 
-type ForEach func(i int, fun func(key string, object *obj.RedisObject, expiration *time.Time) bool)
+// SelectDb 加载 rdb 前导时直接把数据写到 db 中
+type SelectDb func(index int) (*DB, error)
 
 // Aof persistence
 type Aof struct {
-	status      uint32
-	exec        Exec
-	tempDbMaker func() (Exec, ForEach)
-	each        ForEach
-	// aofFilename aof文件名称
-	aofFilename string
-	// aofFsync
-	aofFsync string
-	// aofFile
-	fileBuffer *FileBuffer
+	status   uint32
+	exec     Exec
+	selectDb SelectDb
+	// snapshot 正在进行的重写的快照, 只在持有 lock 时访问
+	snapshot *rewriteSnapshot
+	// dir 保存 aof 文件和 manifest 的目录
+	dir string
+	// filename appendfilename, aof 文件名称的前缀
+	filename string
+	// manifest 当前的 *aofManifest, 发布之后不再修改
+	manifest atomic.Value
+	// aofFsync appendfsync, 可以在运行时修改
+	aofFsync atomic.Value
+	// file 当前追加的 incr 文件, 只在持有 mux 时访问
+	file *os.File
 	// currentDb 后续命令操作的db
 	currentDb int
+	// loading 加载 aof 时执行的命令不再追加到 aof 中
+	loading bool
+	// buf 命令线程追加的数据, 只在持有 lock 时访问, 每次事件循环结束时交给 writer
+	buf []byte
+	// appendOffset 追加过的总字节数, 只在持有 lock 时访问
+	appendOffset int64
+	// timestamp 最后一次写入的 #TS 注释中的时间, 只在持有 lock 时访问, 切换文件时清零
+	timestamp int64
+	// pendingMux 保护 pending 和 waiters, 只在交换缓冲区时持有, 不会等待磁盘
+	pendingMux sync.Mutex
+	// pending 已经交给 writer 但是还没有写到文件中的数据, pendingOffset 是它结束的位置
+	pending       []byte
+	pendingOffset int64
+	// waiters 等待 fsync 的客户端
+	waiters []aofWaiter
+	// spare 写完的缓冲区, 下一次交换时复用。只在持有 mux 时访问
+	spare []byte
+	// writtenOffset, fsyncedOffset 已经写到文件中和已经 fsync 的位置, 只在持有 mux 时访问
+	writtenOffset  int64
+	fsyncedOffset  int64
+	lastFsyncTime  time.Time
+	lastWriteError time.Time
+	// syncedOffset 客户端的回复需要等待的位置, appendfsync 为 always 时等于 fsyncedOffset, 否则等于 writtenOffset
+	syncedOffset atomic.Int64
+	// wakeup 通知 writer 有新的数据, syncReq 要求 writer 马上写入并 fsync
+	wakeup     chan struct{}
+	syncReq    chan chan error
+	writerDone chan struct{}
 	// ctx
 	ctx context.Context
 	// cancel
 	cancel context.CancelFunc
 	// logger
 	lg logger.Logger
-	// mux
+	// mux 写文件和切换文件时持有, 命令线程不会获取
 	mux sync.Mutex
-	// lastRewriteAofSize
-	lastRewriteAofSize int64
+	// lastRewriteAofSize 最后一次重写之后 aof 的大小, 重写完成时写入, metrics 的 goroutine 也会读取
+	lastRewriteAofSize atomic.Int64
+	// rewriteStartTime 正在进行的重写开始的时间(unix 纳秒), 没有重写时为 0
+	rewriteStartTime atomic.Int64
+	// lastRewriteTimeSec 上一次重写花费的秒数, 没有重写过时为 -1
+	lastRewriteTimeSec atomic.Int64
+	// lastRewriteFailed 上一次重写是否失败了
+	lastRewriteFailed atomic.Bool
+	// rewrites 开始过的重写次数
+	rewrites atomic.Int64
+	// rewriteFailures 连续失败的重写次数, 成功之后清零
+	rewriteFailures atomic.Int64
+	// limiter 自动重写连续失败之后的退避
+	limiter rewriteLimiter
+}
+
+func (a *Aof) getManifest() *aofManifest {
+	return a.manifest.Load().(*aofManifest)
+}
+
+// path aof 目录中的文件
+func (a *Aof) path(name string) string {
+	return filepath.Join(a.dir, name)
 }
 
-// CurrentAofSize aof file size
+// CurrentAofSize base 和所有 incr 文件的大小
 func (a *Aof) CurrentAofSize() (int64, error) {
-	stat, err := os.Stat(a.aofFilename)
-	if err != nil {
-		return 0, err
+	var size int64
+	for _, name := range a.getManifest().files() {
+		stat, err := os.Stat(a.path(name))
+		if err != nil {
+			return 0, err
+		}
+		size += stat.Size()
 	}
-	return stat.Size(), nil
+	return size, nil
 }
 
 // LasAofRewriteSize last aof file size
 func (a *Aof) LasAofRewriteSize() int64 {
-	return a.lastRewriteAofSize
+	return a.lastRewriteAofSize.Load()
 }
 
+// AppendAof 把命令追加到内存中的缓冲区, 不会等待磁盘。需要持有 lock
 func (a *Aof) AppendAof(dbIndex int, cmdLine [][]byte) {
-	if a.fileBuffer == nil {
+	if a.loading {
 		return
 	}
 
@@ -119,154 +146,171 @@ func (a *Aof) AppendAof(dbIndex int, cmdLine [][]byte) {
 		return
 	}
 
-	a.writeAof(dbIndex, cmdLine)
-}
-
-func (a *Aof) writeAof(dbIndex int, cmdLine [][]byte) {
-	a.mux.Lock()
-	defer a.mux.Unlock()
+	start := len(a.buf)
+	if config.Properties.AofTimestampEnabled {
+		a.buf = a.appendTimestamp(a.buf)
+	}
 	if dbIndex != a.currentDb {
 		selectCmd := util.ToCmdLine("SELECT", strconv.Itoa(dbIndex))
-		selectData := MakeMultiBulkReply(selectCmd).ToBytes()
-		_, err := a.fileBuffer.Write(selectData)
-		if err != nil {
-			// 切换db失败
-			a.lg.Errorf("write aof file buffer failed with error: %v", err)
-			return
-		}
+		a.buf = append(a.buf, MakeMultiBulkReply(selectCmd).ToBytes()...)
 		a.currentDb = dbIndex
 	}
-	cmdLineData := MakeMultiBulkReply(cmdLine).ToBytes()
-	// 写入文件缓冲区
-	_, err := a.fileBuffer.Write(cmdLineData)
-	if err != nil {
-		a.lg.Errorf("write aof file buffer failed with error: %v", err)
-	}
+	a.buf = append(a.buf, MakeMultiBulkReply(cmdLine).ToBytes()...)
+	a.appendOffset += int64(len(a.buf) - start)
+}
 
-	// 如果模式是always,就将内存中的数据拷贝到磁盘
-	if a.aofFsync == FsyncAlways {
-		err = a.fileBuffer.Sync()
-		if err != nil {
-			a.lg.Errorf("wirte aof file sync fialed with error: %v", err)
-		}
+// appendTimestamp 时间变化之后的第一条命令之前写入 #TS:<unix> 注释, 同一秒内的命令共用一个注释
+func (a *Aof) appendTimestamp(buf []byte) []byte {
+	now := time.Now().Unix()
+	if now <= a.timestamp {
+		return buf
 	}
+	a.timestamp = now
+	return appendTimestampAnnotation(buf, now)
 }
 
-func (a *Aof) LoadAof(maxBytes int) {
+// SetFsync 修改 appendfsync, 切换到 always 的时候 writer 马上刷盘
+func (a *Aof) SetFsync(fsync string) {
+	a.aofFsync.Store(strings.ToLower(fsync))
+	a.notifyWriter()
+}
 
-	fileBuffer := a.fileBuffer
-	a.fileBuffer = nil
-	defer func(fb *FileBuffer) {
-		a.fileBuffer = fb
-	}(fileBuffer)
+func (a *Aof) fsyncPolicy() string {
+	return a.aofFsync.Load().(string)
+}
+
+// LoadAof 按照 manifest 的顺序加载 base 和 incr 文件。最后一个文件在命令的中间结束时, 开启 aof-load-truncated
+// 的话截断到最后一个完整的命令, 其他的损坏返回 *AofFormatError
+func (a *Aof) LoadAof() error {
+	a.loading = true
+	defer func() {
+		a.loading = false
+	}()
 
 	defer a.lg.Sync()
 
-	file, err := os.Open(a.aofFilename)
-	if err != nil {
-		a.lg.Errorf("load aof failed with error: %v", err)
-		return
-	}
-	defer file.Close()
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
+	if err := a.loadFiles(a.getManifest().files(), config.Properties.AofLoadTruncated); err != nil {
+		return err
 	}
-	ch := DecodeInStream(reader)
-	conn := NewClient(0, nil, true)
-	for p := range ch {
-		if p.Error != nil {
-			if p.Error == io.EOF {
-				break
-			}
-			a.lg.Error("parse error: " + p.Error.Error())
+	// 每个文件都从 db0 开始加载, 之后的写入先 select
+	a.currentDb = -1
+	size, _ := a.CurrentAofSize()
+	a.lastRewriteAofSize.Store(size)
+	return nil
+}
+
+// loadFiles truncate 为 true 时截断不完整的最后一个文件。开启 aof-load-to-timestamp 时遇到晚于它的 #TS 注释就停止加载
+func (a *Aof) loadFiles(names []string, truncate bool) error {
+	toTimestamp := int64(config.Properties.AofLoadToTimestamp)
+	for i, name := range names {
+		filename := a.path(name)
+		validSize, err := a.loadFile(filename, toTimestamp)
+		if err == nil {
 			continue
 		}
-		if p.Data == nil {
-			a.lg.Error("empty payload")
-			continue
+		if errors.Is(err, errAofTimestampReached) {
+			return a.truncateToTimestamp(name, validSize, toTimestamp, i == len(names)-1)
 		}
-		reply, ok := p.Data.(*MultiBulkReply)
-		if !ok {
-			a.lg.Error("require multi bulk protocol")
-			continue
+		if !errors.Is(err, ErrAofTruncated) {
+			a.lg.Errorf("Bad file format reading the append only file %s: make a backup of your AOF file, "+
+				"then use godis-check-aof --fix <filename.manifest>", name)
+			return err
 		}
-		conn.PushCmd(reply.Args)
-		err2 := a.exec(context.Background(), conn)
-		if err2 != nil {
-			if !errors.Is(err2, ErrorsShutdown) {
-				a.lg.Warnf("load aof falied with error: %s", err2)
-			}
-			continue
+		if !truncate || i != len(names)-1 {
+			a.lg.Errorf("Unexpected end of file reading the append only file %s. You can: 1) Make a backup of "+
+				"your AOF file, then use godis-check-aof --fix <filename.manifest>. 2) Alternatively you can set "+
+				"the 'aof-load-truncated' configuration option to yes and restart the server.", name)
+			return err
 		}
-		if strings.ToLower(string(reply.Args[0])) == "select" {
-			dbIndex, err2 := strconv.Atoi(string(reply.Args[1]))
-			if err2 != nil {
-				a.lg.Error(err2)
-			}
-			a.currentDb = dbIndex
+		a.lg.Warnf("!!! Warning: short read while loading the AOF file %s!!!", name)
+		a.lg.Warnf("!!! Truncating the AOF %s at offset %d !!!", name, validSize)
+		if err = os.Truncate(filename, validSize); err != nil {
+			return fmt.Errorf("truncate %s: %w", filename, err)
 		}
+		a.lg.Warnf("AOF %s loaded anyway because aof-load-truncated is enabled", name)
 	}
-	stat, _ := os.Stat(a.aofFilename)
-	a.lastRewriteAofSize = stat.Size()
+	return nil
 }
 
-func (a *Aof) fsyncEverySecond() {
-	ticker := time.NewTicker(time.Second)
-	fsyncEverySec := func() {
-		a.mux.Lock()
-		defer a.mux.Unlock()
-		if a.fileBuffer == nil {
-			return
-		}
-		// 尽量减少sync的次数
-		if a.fileBuffer.Buffered() == 0 {
-			return
+// truncateToTimestamp 丢弃最后一个文件中晚于 timestamp 的命令, 之后的写入追加到新的 incr 文件中。
+// 忘记去掉 aof-load-to-timestamp 时, 新的 incr 文件以晚于它的注释开头, 下一次启动会失败而不是丢弃新的数据
+func (a *Aof) truncateToTimestamp(name string, offset int64, timestamp int64, last bool) error {
+	if err := checkTruncateToTimestamp(name, offset, timestamp, last); err != nil {
+		a.lg.Errorf("Can't load the append only file to timestamp %d: %v. You can set the 'aof-load-to-timestamp' "+
+			"configuration option to a later time, or use godis-check-aof --truncate-to-timestamp <timestamp> "+
+			"<filename.manifest>", timestamp, err)
+		return err
+	}
+	a.lg.Warnf("!!! Truncating the AOF %s at offset %d to timestamp %d !!!", name, offset, timestamp)
+	if err := os.Truncate(a.path(name), offset); err != nil {
+		return fmt.Errorf("truncate %s: %w", a.path(name), err)
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, err := a.openNewIncr(); err != nil {
+		return err
+	}
+	a.lg.Warnf("AOF loaded to timestamp %d, remove the 'aof-load-to-timestamp' configuration option before "+
+		"the next restart", timestamp)
+	return nil
+}
+
+// loadFile 返回最后一个完整的命令结束的位置
+func (a *Aof) loadFile(filename string, toTimestamp int64) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	now := time.Now().UnixMilli()
+	keys := 0
+	conn := NewClient(0, nil, true)
+	// 开启 aof-use-rdb-preamble 时重写生成的 base 文件是 rdb 格式, 直接加载到 db 中
+	validSize, err := scanAof(file, toTimestamp, func(entry *rdb.Entry) error {
+		loaded, err := a.loadRdbEntry(entry, now)
+		if loaded {
+			keys++
 		}
-		if err := a.fileBuffer.Sync(); err != nil {
-			a.lg.Errorf("fsync everysec failed: %v", err)
+		return err
+	}, func(cmdLine [][]byte) {
+		conn.PushCmd(cmdLine)
+		if err := a.exec(context.Background(), conn); err != nil && !errors.Is(err, ErrorsShutdown) {
+			a.lg.Warnf("load aof falied with error: %s", err)
 		}
+	})
+	if keys > 0 {
+		a.lg.Infof("Loaded %d keys from the RDB preamble of %s", keys, filepath.Base(filename))
 	}
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				fsyncEverySec()
-			case <-a.ctx.Done():
-				return
-			}
-		}
-	}()
+	var formatErr *AofFormatError
+	if errors.As(err, &formatErr) {
+		formatErr.File = filename
+	}
+	return validSize, err
 }
 
+// Shutdown 把缓冲区中的数据都写到文件中并 fsync, 然后关闭 writer。调用时没有正在执行的命令
 func (a *Aof) Shutdown(ctx context.Context) (err error) {
 	defer a.lg.Sync()
 	// 调用cancel, 关闭其他的goroutine
 	defer func() {
 		a.cancel()
+		<-a.writerDone
 		// 关闭aof文件
-		if err = a.fileBuffer.Close(); err != nil {
+		if err := a.file.Close(); err != nil {
 			a.lg.Errorf("close aof file failed with error: %v", err)
 		}
 		a.lg.Info("shutdown aof complete...")
 	}()
 	a.lg.Infof("shutdown aof begin...")
+	a.handoff()
 	ticker := time.NewTicker(time.Millisecond * 10)
 	defer ticker.Stop()
 	for {
 		// 尝试把文件数据都落盘
 		a.lg.Info("Calling fsync() on the Aof file.")
-		err = a.fileBuffer.Sync()
-		if err != nil {
+		if err = a.flushAndSync(ctx); err == nil {
 			return
 		}
-		var buffered int
-		if buffered = a.fileBuffer.Buffered(); buffered == 0 {
-			break
-		}
-		a.lg.Infof("Shutdown aof buffered: %v", buffered)
 		select {
 		case <-ctx.Done():
 			err = ctx.Err()
@@ -275,45 +319,137 @@ func (a *Aof) Shutdown(ctx context.Context) (err error) {
 			continue
 		}
 	}
-	return
 }
 
-func NewAof(exec Exec, filename string, fsync string, tempDbMaker func() (Exec, ForEach)) (*Aof, error) {
+// NewAof 在 dir 中打开多部分 aof, legacyFilename 是以前的单个 aof 文件, 存在时迁移到 dir 中作为 base 文件
+func NewAof(exec Exec, dir string, filename string, legacyFilename string, fsync string) (*Aof, error) {
 	persister := &Aof{}
 	persister.status = none
 	persister.exec = exec
-	// aof 的文件名称
-	persister.aofFilename = filename
+	persister.dir = dir
+	// aof 文件名称的前缀
+	persister.filename = filepath.Base(filename)
 	// aof 的模式
-	persister.aofFsync = strings.ToLower(fsync)
+	persister.aofFsync.Store(strings.ToLower(fsync))
 
-	persister.tempDbMaker = tempDbMaker
-	// 创建aof文件
-	aofFile, err := initFile(persister.aofFilename)
-	if err != nil {
+	persister.lastRewriteTimeSec.Store(-1)
+	persister.currentDb = -1
+	persister.lg = logger.Named("aof-persister")
+
+	if err := persister.openManifest(legacyFilename); err != nil {
 		return nil, err
 	}
-	persister.fileBuffer = NewFileBuffer(aofFile, aofBufferSize)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	persister.ctx = ctx
 	persister.cancel = cancel
-	persister.lg = logger.Named("aof-persister")
 
-	if persister.aofFsync == FsyncEverySec {
-		persister.fsyncEverySecond()
-	}
+	persister.startWriter()
 	return persister, nil
 }
 
-func initFile(path string) (file *os.File, err error) {
-	dir := filepath.Dir(path)
-	err = os.Mkdir(dir, 0755)
+// openManifest 加载或者创建 manifest, 并打开最后一个 incr 文件用于追加
+func (a *Aof) openManifest(legacyFilename string) error {
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return err
+	}
+	manifestName := a.path(a.filename + aofManifestSuffix)
+	manifest, err := loadManifest(manifestName)
 	if err != nil {
-		if !errors.Is(err, os.ErrExist) {
-			return nil, err
+		return fmt.Errorf("%s: %w", manifestName, err)
+	}
+	if manifest == nil {
+		manifest = &aofManifest{}
+		if fileExists(legacyFilename) {
+			// 先持久化引用了 base 文件的 manifest 再移动旧的 aof, 移动之前宕机的话下次启动时继续移动
+			manifest.base = &aofInfo{name: manifest.newBaseName(a.filename, aofFormatSuffix), seq: 1, typ: aofBaseType}
+			manifest.currBaseSeq = 1
 		}
 	}
-	file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	return
+	if manifest.base != nil && manifest.base.seq == 1 && !fileExists(a.path(manifest.base.name)) &&
+		fileExists(legacyFilename) {
+		if err = persistManifest(a.dir, a.filename, manifest); err != nil {
+			return err
+		}
+		if err = os.Rename(legacyFilename, a.path(manifest.base.name)); err != nil {
+			return fmt.Errorf("migrate %s to %s: %w", legacyFilename, a.dir, err)
+		}
+		a.lg.Infof("Successfully migrated an old-style AOF %s into the AOF directory %s", legacyFilename, a.dir)
+	}
+	for _, name := range manifest.files() {
+		if !fileExists(a.path(name)) {
+			return fmt.Errorf("appendonly file %s doesn't exist", a.path(name))
+		}
+	}
+
+	var incr *aofInfo
+	if len(manifest.incrs) > 0 {
+		incr = manifest.incrs[len(manifest.incrs)-1]
+	} else {
+		manifest = manifest.copy()
+		incr = manifest.addIncr(a.filename)
+	}
+	aofFile, err := os.OpenFile(a.path(incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err = persistManifest(a.dir, a.filename, manifest); err != nil {
+		_ = aofFile.Close()
+		return err
+	}
+	a.file = aofFile
+	a.manifest.Store(manifest)
+	a.deleteHistory()
+	return nil
+}
+
+// openNewIncr 切换到新的 incr 文件, 调用时持有 a.mux
+func (a *Aof) openNewIncr() (*aofInfo, error) {
+	manifest := a.getManifest().copy()
+	incr := manifest.addIncr(a.filename)
+	aofFile, err := os.OpenFile(a.path(incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = persistManifest(a.dir, a.filename, manifest); err != nil {
+		_ = aofFile.Close()
+		_ = os.Remove(a.path(incr.name))
+		return nil, err
+	}
+	if err = a.file.Sync(); err != nil {
+		a.lg.Errorf("fsync aof file failed with error: %v", err)
+	}
+	if err = a.file.Close(); err != nil {
+		a.lg.Errorf("close aof file failed with error: %v", err)
+	}
+	a.file = aofFile
+	a.manifest.Store(manifest)
+	// 新文件从 db0 开始加载, 第一条命令之前写入时间戳
+	a.currentDb = -1
+	a.timestamp = 0
+	return incr, nil
+}
+
+// deleteHistory 删除重写之前的文件, 然后从 manifest 中去掉它们
+func (a *Aof) deleteHistory() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	manifest := a.getManifest()
+	if len(manifest.history) == 0 {
+		return
+	}
+	for _, info := range manifest.history {
+		if err := os.Remove(a.path(info.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.lg.Warnf("remove history aof file %s failed with error: %v", info.name, err)
+			return
+		}
+		a.lg.Infof("Removing the history file %s in the background", info.name)
+	}
+	manifest = manifest.copy()
+	manifest.history = nil
+	if err := persistManifest(a.dir, a.filename, manifest); err != nil {
+		a.lg.Errorf("persist aof manifest failed with error: %v", err)
+		return
+	}
+	a.manifest.Store(manifest)
 }
@@ -0,0 +1,224 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/pkg/rdb"
+	"github.com/xuning888/godis-tiny/pkg/resp"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrAofTruncated 文件在命令的中间结束, 通常是宕机时没有写完
+	ErrAofTruncated = errors.New("unexpected end of file")
+	// ErrAofBadFormat 文件中有无法解析的数据
+	ErrAofBadFormat = errors.New("bad file format")
+	// errAofTimestampReached 遇到了时间晚于目标时间的 #TS 注释, 之后的命令不再读取
+	errAofTimestampReached = errors.New("reached the timestamp")
+)
+
+// aofTimestampPrefix 开启 aof-timestamp-enabled 时写入的注释的前缀, 之后是 unix 时间(秒)
+const aofTimestampPrefix = "#TS:"
+
+func appendTimestampAnnotation(buf []byte, timestamp int64) []byte {
+	buf = append(buf, aofTimestampPrefix...)
+	buf = strconv.AppendInt(buf, timestamp, 10)
+	return append(buf, '\r', '\n')
+}
+
+// parseTimestampAnnotation 解析 #TS:<unix> 注释, 其他的注释返回 false
+func parseTimestampAnnotation(annotation []byte) (int64, bool) {
+	if !bytes.HasPrefix(annotation, []byte(aofTimestampPrefix)) {
+		return 0, false
+	}
+	timestamp, err := strconv.ParseInt(string(annotation[len(aofTimestampPrefix):]), 10, 64)
+	return timestamp, err == nil
+}
+
+// checkTruncateToTimestamp 文件在 offset 处遇到了晚于 timestamp 的注释, 只有最后一个文件可以截断,
+// 文件开头就晚于 timestamp 时之前没有可以保留的命令
+func checkTruncateToTimestamp(filename string, offset int64, timestamp int64, last bool) error {
+	if offset == 0 {
+		return fmt.Errorf("AOF %s has nothing before timestamp %d", filename, timestamp)
+	}
+	if !last {
+		return fmt.Errorf("failed to truncate AOF %s to timestamp %d at offset %d because it is not the last file. "+
+			"If you insist, please delete all files after this file according to the manifest file and delete "+
+			"the corresponding records in manifest file manually", filename, timestamp, offset)
+	}
+	return nil
+}
+
+// AofFormatError aof 文件在 Offset 处损坏, 文件被截断时 Offset 是最后一个完整的命令结束的位置
+type AofFormatError struct {
+	File   string
+	Offset int64
+	Err    error
+	// InRdbPreamble 损坏的位置在 rdb 前导中, 这时截断也无法恢复
+	InRdbPreamble bool
+}
+
+func (e *AofFormatError) Error() string {
+	return fmt.Sprintf("%v reading the append only file %s at offset %d", e.Err, e.File, e.Offset)
+}
+
+func (e *AofFormatError) Unwrap() error {
+	return e.Err
+}
+
+// scanAof 依次读取 rdb 前导中的 key 和之后的命令, 返回最后一个完整的命令结束的位置。
+// 文件损坏时返回 *AofFormatError, onEntry 返回的错误原样返回。toTimestamp 大于 0 时遇到晚于它的 #TS 注释就停止,
+// 返回注释的位置和 errAofTimestampReached
+func scanAof(src io.Reader, toTimestamp int64, onEntry func(entry *rdb.Entry) error,
+	onCmd func(cmdLine [][]byte)) (int64, error) {
+	reader := bufio.NewReaderSize(src, 1<<16)
+	var base int64
+	if rdb.IsRdb(reader) {
+		dec := rdb.NewDecoder(reader)
+		err := dec.ReadHeader()
+		for err == nil {
+			var entry *rdb.Entry
+			if entry, err = dec.Next(); err == nil && onEntry != nil {
+				if err = onEntry(entry); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if err != io.EOF {
+			// rdb 前导不完整时截断也无法恢复, 都当作格式错误
+			return 0, &AofFormatError{Offset: dec.Offset, Err: fmt.Errorf("%w: %v", ErrAofBadFormat, err),
+				InRdbPreamble: true}
+		}
+		base = dec.Offset
+	}
+
+	// reader 的缓冲区足够大, resp.NewReader 会直接使用它, 不会多读走 rdb 之后的数据
+	respReader := resp.NewReader(reader)
+	for {
+		start := respReader.Offset()
+		var value resp.Value
+		annotation, isAnnotation, err := respReader.ReadAnnotation()
+		if err == nil && !isAnnotation {
+			value, err = respReader.ReadValue()
+		}
+		if err == io.EOF {
+			return base + start, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return base + start, &AofFormatError{Offset: base + start, Err: ErrAofTruncated}
+		}
+		var protocolErr *resp.ProtocolError
+		if errors.As(err, &protocolErr) {
+			return base + start, &AofFormatError{Offset: base + protocolErr.Offset,
+				Err: fmt.Errorf("%w: %s", ErrAofBadFormat, protocolErr.Msg)}
+		}
+		if err != nil {
+			return base + start, err
+		}
+		if isAnnotation {
+			if timestamp, ok := parseTimestampAnnotation(annotation); ok && toTimestamp > 0 && timestamp > toTimestamp {
+				return base + start, errAofTimestampReached
+			}
+			continue
+		}
+		cmdLine, ok := value.Args()
+		if value.Type != resp.Array || !ok || len(cmdLine) == 0 {
+			return base + start, &AofFormatError{Offset: base + start,
+				Err: fmt.Errorf("%w: expected a command, got %s", ErrAofBadFormat, value.Type)}
+		}
+		onCmd(cmdLine)
+	}
+}
+
+// AofCheckResult 检查一个 aof 文件的结果
+type AofCheckResult struct {
+	File string
+	Size int64
+	// ValidSize 最后一个完整的命令结束的位置, 遇到晚于目标时间的注释时是注释的位置
+	ValidSize int64
+	// Err 文件完整时为 nil, 遇到晚于目标时间的注释时是 errAofTimestampReached, 否则是 *AofFormatError
+	Err error
+}
+
+func checkAofFile(filename string, toTimestamp int64) AofCheckResult {
+	result := AofCheckResult{File: filename}
+	file, err := os.Open(filename)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer file.Close()
+	if stat, err := file.Stat(); err == nil {
+		result.Size = stat.Size()
+	}
+	result.ValidSize, result.Err = scanAof(file, toTimestamp, nil, func(cmdLine [][]byte) {})
+	var formatErr *AofFormatError
+	if errors.As(result.Err, &formatErr) {
+		formatErr.File = filename
+	}
+	return result
+}
+
+// CheckAof 检查单个 aof 文件或者 manifest 中的所有文件, 结果写到 out 中。
+// fix 为 true 时把损坏的最后一个文件截断到最后一个完整的命令, 其他文件损坏时无法修复。
+// toTimestamp 大于 0 时把最后一个文件截断到第一个晚于它的 #TS 注释, 恢复到这个时间点的数据
+func CheckAof(filename string, fix bool, toTimestamp int64, out io.Writer) error {
+	files := []string{filename}
+	if strings.HasSuffix(filename, aofManifestSuffix) {
+		manifest, err := loadManifest(filename)
+		if err != nil {
+			return err
+		}
+		if manifest == nil {
+			return fmt.Errorf("can't open %s: %w", filename, os.ErrNotExist)
+		}
+		dir := filepath.Dir(filename)
+		files = files[:0]
+		for _, name := range manifest.files() {
+			files = append(files, filepath.Join(dir, name))
+		}
+		_, _ = fmt.Fprintf(out, "Start checking Multi Part AOF\n")
+	}
+
+	for i, name := range files {
+		result := checkAofFile(name, toTimestamp)
+		if errors.Is(result.Err, errAofTimestampReached) {
+			if err := checkTruncateToTimestamp(name, result.ValidSize, toTimestamp, i == len(files)-1); err != nil {
+				return err
+			}
+			if err := os.Truncate(name, result.ValidSize); err != nil {
+				return fmt.Errorf("failed to truncate AOF %s to timestamp %d: %w", name, toTimestamp, err)
+			}
+			_, _ = fmt.Fprintf(out, "Successfully truncated AOF %s to timestamp %d\n", name, toTimestamp)
+			return nil
+		}
+		if result.Err == nil {
+			_, _ = fmt.Fprintf(out, "AOF %s is valid\n", name)
+			continue
+		}
+		var formatErr *AofFormatError
+		if !errors.As(result.Err, &formatErr) {
+			return result.Err
+		}
+		_, _ = fmt.Fprintf(out, "%v\n", formatErr)
+		_, _ = fmt.Fprintf(out, "AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n",
+			name, result.Size, result.ValidSize, result.Size-result.ValidSize)
+		if !fix {
+			return fmt.Errorf("AOF %s is not valid. Use the --fix option to try fixing it", name)
+		}
+		if i != len(files)-1 || formatErr.InRdbPreamble {
+			return fmt.Errorf("AOF %s can't be fixed, only a truncated command at the end of the last file can be removed", name)
+		}
+		if err := os.Truncate(name, result.ValidSize); err != nil {
+			return fmt.Errorf("failed to truncate AOF %s: %w", name, err)
+		}
+		_, _ = fmt.Fprintf(out, "Successfully truncated AOF %s to %d bytes\n", name, result.ValidSize)
+	}
+	return nil
+}
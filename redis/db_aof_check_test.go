@@ -0,0 +1,278 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeAofContent n 条 set 命令, 返回内容和每条命令结束的位置
+func makeAofContent(n int) ([]byte, []int64) {
+	buf := &bytes.Buffer{}
+	ends := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		buf.Write(MakeMultiBulkReply(util.ToCmdLine("set", fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))).ToBytes())
+		ends = append(ends, int64(buf.Len()))
+	}
+	return buf.Bytes(), ends
+}
+
+// completeCommands offset 之前有多少条完整的命令
+func completeCommands(ends []int64, offset int64) int {
+	n := 0
+	for n < len(ends) && ends[n] <= offset {
+		n++
+	}
+	return n
+}
+
+func scanCommands(content []byte) (int, int64, error) {
+	n := 0
+	validSize, err := scanAof(bytes.NewReader(content), 0, nil, func(cmdLine [][]byte) {
+		n++
+	})
+	return n, validSize, err
+}
+
+func TestScanAofTruncated(t *testing.T) {
+	content, ends := makeAofContent(50)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		offset := rnd.Int63n(int64(len(content)))
+		n, validSize, err := scanCommands(content[:offset])
+		expected := completeCommands(ends, offset)
+		assert.Equal(t, expected, n, offset)
+		if expected == 0 {
+			assert.Equal(t, int64(0), validSize)
+		} else {
+			assert.Equal(t, ends[expected-1], validSize, offset)
+		}
+		if validSize == offset {
+			assert.Nil(t, err, offset)
+			continue
+		}
+		var formatErr *AofFormatError
+		if assert.True(t, errors.As(err, &formatErr), offset) {
+			assert.ErrorIs(t, err, ErrAofTruncated)
+			assert.Equal(t, validSize, formatErr.Offset)
+		}
+	}
+}
+
+func TestScanAofCorrupted(t *testing.T) {
+	content, ends := makeAofContent(50)
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		// 在命令之间插入无法解析的数据
+		at := ends[rnd.Intn(len(ends)-1)]
+		corrupted := append(append(append([]byte{}, content[:at]...), "garbage\r\n"...), content[at:]...)
+		n, validSize, err := scanCommands(corrupted)
+		assert.Equal(t, completeCommands(ends, at), n)
+		assert.Equal(t, at, validSize)
+		var formatErr *AofFormatError
+		if assert.True(t, errors.As(err, &formatErr)) {
+			assert.ErrorIs(t, err, ErrAofBadFormat)
+			assert.Equal(t, at, formatErr.Offset)
+		}
+
+		// 随机修改一个字节, 要么仍然可以解析, 要么在损坏的位置之后报错, 之前的命令都是完整的
+		offset := rnd.Int63n(int64(len(content)))
+		corrupted = append([]byte{}, content...)
+		corrupted[offset] = byte(rnd.Intn(256))
+		_, validSize, err = scanCommands(corrupted)
+		if err != nil {
+			var intact int64
+			if k := completeCommands(ends, offset); k > 0 {
+				intact = ends[k-1]
+			}
+			assert.True(t, errors.As(err, &formatErr), offset)
+			assert.GreaterOrEqual(t, validSize, intact, offset)
+			assert.GreaterOrEqual(t, formatErr.Offset, validSize, offset)
+		}
+	}
+}
+
+func TestScanAofCorruptedRdbLength(t *testing.T) {
+	server := makeTempServer()
+	for i := 0; i < 20; i++ {
+		execCommands(t, server, fmt.Sprintf("set key%d value%02d", i, i))
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, writeSnapshot(buf, newRewriteSnapshot(server.dbs, true)))
+	commands, _ := makeAofContent(5)
+	content := append(buf.Bytes(), commands...)
+	rnd := rand.New(rand.NewSource(3))
+	for i := 0; i < 50; i++ {
+		// 把 rdb 前导中一个字符串的长度改成接近 MaxInt32 的值, 只能报告格式错误, 不能按照这个长度分配内存
+		at := bytes.Index(content, []byte(fmt.Sprintf("value%02d", rnd.Intn(20)))) - 1
+		length := []byte{0x80, 0x7f, byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))}
+		corrupted := append(append(append([]byte{}, content[:at]...), length...), content[at+1:]...)
+		_, _, err := scanCommands(corrupted)
+		var formatErr *AofFormatError
+		if assert.True(t, errors.As(err, &formatErr), at) {
+			assert.ErrorIs(t, err, ErrAofBadFormat)
+			assert.True(t, formatErr.InRdbPreamble)
+		}
+	}
+}
+
+func TestAofLoadTruncated(t *testing.T) {
+	setAppendOnly(t)
+	// 和 redis 一样默认截断
+	assert.True(t, config.Properties.AofLoadTruncated)
+	loadTruncated := config.Properties.AofLoadTruncated
+	t.Cleanup(func() {
+		config.Properties.AofLoadTruncated = loadTruncated
+	})
+	dir := t.TempDir()
+	content, ends := makeAofContent(10)
+	incr := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+	assert.Nil(t, os.WriteFile(incr, content[:ends[6]+5], 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"),
+		[]byte("file appendonly.aof.1.incr.aof seq 1 type i\n"), 0644))
+
+	config.Properties.AofLoadTruncated = false
+	server := makeTempServer()
+	aof, err := NewAof(server.process, dir, "appendonly.aof", "", FsyncAlways)
+	assert.Nil(t, err)
+	server.bindPersister(aof)
+	err = aof.LoadAof()
+	assert.ErrorIs(t, err, ErrAofTruncated)
+	assert.Nil(t, aof.Shutdown(context.Background()))
+
+	config.Properties.AofLoadTruncated = true
+	server, aof = newTestAof(t, dir, "")
+	assert.Equal(t, 7, server.dbs[0].Len())
+	stat, err := os.Stat(incr)
+	assert.Nil(t, err)
+	assert.Equal(t, ends[6], stat.Size())
+	// 截断之后继续追加
+	execCommands(t, server, "set after 1")
+	syncAof(t, aof)
+	restarted, _ := newTestAof(t, dir, "")
+	assert.Equal(t, "1", stringValue(t, restarted, 0, "after"))
+	assert.Equal(t, 8, restarted.dbs[0].Len())
+}
+
+func TestCheckAof(t *testing.T) {
+	dir := t.TempDir()
+	content, ends := makeAofContent(10)
+	base := filepath.Join(dir, "appendonly.aof.1.base.aof")
+	incr := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+	manifest := filepath.Join(dir, "appendonly.aof.manifest")
+	assert.Nil(t, os.WriteFile(base, content, 0644))
+	assert.Nil(t, os.WriteFile(incr, content[:ends[3]+2], 0644))
+	assert.Nil(t, os.WriteFile(manifest, []byte("file appendonly.aof.1.base.aof seq 1 type b\n"+
+		"file appendonly.aof.1.incr.aof seq 1 type i\n"), 0644))
+
+	out := &bytes.Buffer{}
+	assert.NotNil(t, CheckAof(manifest, false, 0, out))
+	assert.Contains(t, out.String(), "AOF "+base+" is valid")
+	assert.Contains(t, out.String(), fmt.Sprintf("ok_up_to=%d", ends[3]))
+
+	assert.Nil(t, CheckAof(manifest, true, 0, out))
+	stat, _ := os.Stat(incr)
+	assert.Equal(t, ends[3], stat.Size())
+	assert.Nil(t, CheckAof(manifest, false, 0, out))
+
+	// 只有最后一个文件可以截断
+	assert.Nil(t, os.WriteFile(base, append([]byte("garbage\r\n"), content...), 0644))
+	assert.NotNil(t, CheckAof(manifest, true, 0, out))
+	stat, _ = os.Stat(base)
+	assert.Equal(t, int64(len(content))+9, stat.Size())
+}
+
+// makeTimestampedAof 在第 i 条命令之前写入 #TS:<100*(i+1)>, 返回内容和每个注释的位置
+func makeTimestampedAof(n int) ([]byte, []int64) {
+	content, ends := makeAofContent(n)
+	buf := &bytes.Buffer{}
+	offsets := make([]int64, 0, n)
+	var start int64
+	for i, end := range ends {
+		offsets = append(offsets, int64(buf.Len()))
+		buf.Write(appendTimestampAnnotation(nil, int64(100*(i+1))))
+		buf.Write(content[start:end])
+		start = end
+	}
+	return buf.Bytes(), offsets
+}
+
+func TestAofLoadToTimestamp(t *testing.T) {
+	setAppendOnly(t)
+	toTimestamp := config.Properties.AofLoadToTimestamp
+	t.Cleanup(func() {
+		config.Properties.AofLoadToTimestamp = toTimestamp
+	})
+	dir := t.TempDir()
+	content, offsets := makeTimestampedAof(10)
+	incr := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+	assert.Nil(t, os.WriteFile(incr, content, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"),
+		[]byte("file appendonly.aof.1.incr.aof seq 1 type i\n"), 0644))
+
+	// 文件开头就晚于目标时间时拒绝启动
+	config.Properties.AofLoadToTimestamp = 50
+	server := makeTempServer()
+	aof, err := NewAof(server.process, dir, "appendonly.aof", "", FsyncAlways)
+	assert.Nil(t, err)
+	server.bindPersister(aof)
+	assert.NotNil(t, aof.LoadAof())
+	assert.Nil(t, aof.Shutdown(context.Background()))
+
+	config.Properties.AofLoadToTimestamp = 450
+	server, aof = newTestAof(t, dir, "")
+	assert.Equal(t, 4, server.dbs[0].Len())
+	stat, err := os.Stat(incr)
+	assert.Nil(t, err)
+	assert.Equal(t, offsets[4], stat.Size())
+	// 之后的写入追加到新的 incr 文件中
+	assert.Equal(t, 2, len(aof.getManifest().files()))
+	execCommands(t, server, "set after 1")
+	syncAof(t, aof)
+
+	config.Properties.AofLoadToTimestamp = 0
+	restarted, _ := newTestAof(t, dir, "")
+	assert.Equal(t, 5, restarted.dbs[0].Len())
+	assert.Equal(t, "1", stringValue(t, restarted, 0, "after"))
+}
+
+func TestCheckAofTruncateToTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	content, offsets := makeTimestampedAof(10)
+	base := filepath.Join(dir, "appendonly.aof.1.base.aof")
+	incr := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+	manifest := filepath.Join(dir, "appendonly.aof.manifest")
+	assert.Nil(t, os.WriteFile(base, content, 0644))
+	assert.Nil(t, os.WriteFile(incr, content, 0644))
+	assert.Nil(t, os.WriteFile(manifest, []byte("file appendonly.aof.1.base.aof seq 1 type b\n"+
+		"file appendonly.aof.1.incr.aof seq 1 type i\n"), 0644))
+
+	// 注释不影响检查
+	out := &bytes.Buffer{}
+	assert.Nil(t, CheckAof(manifest, false, 0, out))
+	assert.Contains(t, out.String(), "AOF "+incr+" is valid")
+
+	// 只有最后一个文件可以截断
+	assert.NotNil(t, CheckAof(manifest, false, 250, out))
+	stat, _ := os.Stat(base)
+	assert.Equal(t, int64(len(content)), stat.Size())
+
+	assert.Nil(t, os.WriteFile(base, content[:offsets[3]], 0644))
+	assert.NotNil(t, CheckAof(manifest, false, 50, out))
+	assert.Nil(t, CheckAof(manifest, false, 350, out))
+	assert.Contains(t, out.String(), "Successfully truncated AOF "+incr+" to timestamp 350")
+	stat, _ = os.Stat(incr)
+	assert.Equal(t, offsets[3], stat.Size())
+	// 截断之后文件是完整的, 再次截断到更晚的时间不需要修改
+	assert.Nil(t, CheckAof(manifest, false, 1000, out))
+	stat, _ = os.Stat(incr)
+	assert.Equal(t, offsets[3], stat.Size())
+}
@@ -0,0 +1,245 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// manifest 中文件的类型
+const (
+	aofBaseType    = 'b'
+	aofHistoryType = 'h'
+	aofIncrType    = 'i'
+)
+
+const (
+	aofBaseSuffix     = ".base"
+	aofIncrSuffix     = ".incr"
+	aofFormatSuffix   = ".aof"
+	rdbFormatSuffix   = ".rdb"
+	aofManifestSuffix = ".manifest"
+	aofTempPrefix     = "temp-"
+)
+
+var errInvalidManifest = errors.New("invalid aof manifest")
+
+// aofInfo manifest 中的一个文件
+type aofInfo struct {
+	name string
+	seq  int64
+	typ  byte
+}
+
+// aofManifest 和 redis 7 相同的多部分 aof。一个 base 文件保存重写时的数据, incr 文件按照顺序保存之后的命令,
+// history 是重写之后等待删除的旧文件。manifest 发布之后不再修改, 修改时先复制一份
+type aofManifest struct {
+	base        *aofInfo
+	incrs       []*aofInfo
+	history     []*aofInfo
+	currBaseSeq int64
+	currIncrSeq int64
+}
+
+func (m *aofManifest) copy() *aofManifest {
+	cp := &aofManifest{currBaseSeq: m.currBaseSeq, currIncrSeq: m.currIncrSeq}
+	if m.base != nil {
+		base := *m.base
+		cp.base = &base
+	}
+	for _, info := range m.incrs {
+		incr := *info
+		cp.incrs = append(cp.incrs, &incr)
+	}
+	for _, info := range m.history {
+		history := *info
+		cp.history = append(cp.history, &history)
+	}
+	return cp
+}
+
+// addIncr 新的命令写到新的 incr 文件中
+func (m *aofManifest) addIncr(filename string) *aofInfo {
+	m.currIncrSeq++
+	info := &aofInfo{
+		name: fmt.Sprintf("%s.%d%s%s", filename, m.currIncrSeq, aofIncrSuffix, aofFormatSuffix),
+		seq:  m.currIncrSeq,
+		typ:  aofIncrType,
+	}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// newBaseName 下一个 base 文件的名称, format 是 .aof 或者使用 rdb 前导时的 .rdb
+func (m *aofManifest) newBaseName(filename string, format string) string {
+	return fmt.Sprintf("%s.%d%s%s", filename, m.currBaseSeq+1, aofBaseSuffix, format)
+}
+
+// replaceBase 重写完成之后使用新的 base 文件, 旧的 base 和 seq 小于等于 lastIncrSeq 的 incr 文件变为 history
+func (m *aofManifest) replaceBase(name string, lastIncrSeq int64) {
+	if m.base != nil {
+		m.base.typ = aofHistoryType
+		m.history = append(m.history, m.base)
+	}
+	m.currBaseSeq++
+	m.base = &aofInfo{name: name, seq: m.currBaseSeq, typ: aofBaseType}
+	incrs := make([]*aofInfo, 0, len(m.incrs))
+	for _, info := range m.incrs {
+		if info.seq <= lastIncrSeq {
+			info.typ = aofHistoryType
+			m.history = append(m.history, info)
+			continue
+		}
+		incrs = append(incrs, info)
+	}
+	m.incrs = incrs
+}
+
+// files 加载 aof 时的文件顺序
+func (m *aofManifest) files() []string {
+	names := make([]string, 0, len(m.incrs)+1)
+	if m.base != nil {
+		names = append(names, m.base.name)
+	}
+	for _, info := range m.incrs {
+		names = append(names, info.name)
+	}
+	return names
+}
+
+func (m *aofManifest) String() string {
+	b := &strings.Builder{}
+	write := func(info *aofInfo) {
+		b.WriteString(fmt.Sprintf("file %s seq %d type %c\n", info.name, info.seq, info.typ))
+	}
+	if m.base != nil {
+		write(m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return b.String()
+}
+
+// parseManifest 每行是 file <name> seq <seq> type <b|h|i>, 忽略空行和 # 开头的注释
+func parseManifest(src io.Reader) (*aofManifest, error) {
+	manifest := &aofManifest{}
+	scanner := bufio.NewScanner(src)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("%w: line %d: '%s'", errInvalidManifest, lineNum, line)
+		}
+		info := &aofInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%w: line %d: '%s'", errInvalidManifest, lineNum, line)
+				}
+				info.seq = seq
+			case "type":
+				info.typ = fields[i+1][0]
+			}
+		}
+		// 文件名不能包含路径, 防止 manifest 被篡改之后读写 aof 目录之外的文件
+		if info.name == "" || info.name != filepath.Base(info.name) || info.seq <= 0 {
+			return nil, fmt.Errorf("%w: line %d: '%s'", errInvalidManifest, lineNum, line)
+		}
+		switch info.typ {
+		case aofBaseType:
+			if manifest.base != nil {
+				return nil, fmt.Errorf("%w: found duplicate base file information", errInvalidManifest)
+			}
+			manifest.base = info
+			manifest.currBaseSeq = info.seq
+		case aofHistoryType:
+			manifest.history = append(manifest.history, info)
+		case aofIncrType:
+			if info.seq <= manifest.currIncrSeq {
+				return nil, fmt.Errorf("%w: found a non-monotonic sequence number", errInvalidManifest)
+			}
+			manifest.incrs = append(manifest.incrs, info)
+			manifest.currIncrSeq = info.seq
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown file type '%c'", errInvalidManifest, lineNum, info.typ)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if manifest.base == nil && len(manifest.incrs) == 0 {
+		return nil, fmt.Errorf("%w: no base or incr file", errInvalidManifest)
+	}
+	return manifest, nil
+}
+
+// loadManifest manifest 不存在时返回 nil
+func loadManifest(filename string) (*aofManifest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer util.Close(file)
+	return parseManifest(file)
+}
+
+// persistManifest 先写临时文件再重命名, 然后 fsync 目录, 保证宕机之后看到的是完整的旧的或者新的 manifest
+func persistManifest(dir, filename string, manifest *aofManifest) error {
+	tmpName := filepath.Join(dir, aofTempPrefix+filename+aofManifestSuffix)
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(manifest.String())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filepath.Join(dir, filename+aofManifestSuffix))
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return fsyncDir(dir)
+}
+
+// fsyncDir 让目录中的创建、重命名和删除落盘
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer util.Close(d)
+	return d.Sync()
+}
+
+func fileExists(filename string) bool {
+	stat, err := os.Stat(filename)
+	return err == nil && !stat.IsDir()
+}
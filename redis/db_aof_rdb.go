@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/dict"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/intset"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/list"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
+	"github.com/xuning888/godis-tiny/pkg/rdb"
+	"io"
+	"strconv"
+	"time"
+)
+
+// rdbVersion 写到 aux 字段中的 redis-ver, 只用于展示
+const rdbVersion = "7.0.0"
+
+// countingWriter 记录写入的字节数
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// writeRdbHeader 写入 rdb 的头和 aux 字段
+func writeRdbHeader(enc *rdb.Encoder) {
+	enc.WriteHeader()
+	enc.WriteAux("redis-ver", rdbVersion)
+	enc.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	enc.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	enc.WriteAux("aof-base", "1")
+}
+
+func writeRdbObject(enc *rdb.Encoder, key string, redisObj *obj.RedisObject) {
+	switch redisObj.ObjType {
+	case obj.RedisString:
+		value, _ := obj.StringObjEncoding(redisObj)
+		enc.WriteType(rdb.TypeString)
+		enc.WriteString([]byte(key))
+		enc.WriteString(value)
+	case obj.RedisList:
+		dequeue := redisObj.Ptr.(list.Dequeue)
+		enc.WriteType(rdb.TypeList)
+		enc.WriteString([]byte(key))
+		enc.WriteLength(uint64(dequeue.Len()))
+		dequeue.ForEach(func(value interface{}, index int) bool {
+			enc.WriteString(value.([]byte))
+			return true
+		})
+	case obj.RedisSet:
+		enc.WriteType(rdb.TypeSet)
+		enc.WriteString([]byte(key))
+		switch members := redisObj.Ptr.(type) {
+		case *intset.IntSet:
+			enc.WriteLength(uint64(members.Len()))
+			members.Range(func(index int, value int64) bool {
+				enc.WriteString(strconv.AppendInt(nil, value, 10))
+				return true
+			})
+		case *dict.SimpleDict:
+			enc.WriteLength(uint64(members.Len()))
+			members.ForEach(func(member string, val interface{}) bool {
+				enc.WriteString([]byte(member))
+				return true
+			})
+		}
+	case obj.RedisHash:
+		simpleDict := redisObj.Ptr.(*dict.SimpleDict)
+		enc.WriteType(rdb.TypeHash)
+		enc.WriteString([]byte(key))
+		enc.WriteLength(uint64(simpleDict.Len()))
+		simpleDict.ForEach(func(field string, val interface{}) bool {
+			enc.WriteString([]byte(field))
+			enc.WriteString(val.([]byte))
+			return true
+		})
+	}
+}
+
+// loadRdbEntry 把 rdb 前导中的 key 直接放到 db 中, 不经过命令的执行。now 之前已经过期的 key 不加载
+func (a *Aof) loadRdbEntry(entry *rdb.Entry, now int64) (bool, error) {
+	if entry.ExpireAt >= 0 && entry.ExpireAt <= now {
+		return false, nil
+	}
+	db, err := a.selectDb(entry.DB)
+	if err != nil {
+		return false, fmt.Errorf("rdb: db %d: %w", entry.DB, err)
+	}
+	key := string(entry.Key)
+	db.PutEntity(key, rdbEntryToObject(entry))
+	if entry.ExpireAt >= 0 {
+		db.ExpireV1(key, time.UnixMilli(entry.ExpireAt))
+	}
+	return true, nil
+}
+
+func rdbEntryToObject(entry *rdb.Entry) *obj.RedisObject {
+	switch entry.Type {
+	case rdb.TypeList:
+		redisObj := obj.NewListObject()
+		dequeue := redisObj.Ptr.(list.Dequeue)
+		for _, value := range entry.Value.([][]byte) {
+			_ = dequeue.AddLast(value)
+		}
+		return redisObj
+	case rdb.TypeSet:
+		redisObj, _ := obj.NewSetObject(entry.Value.([][]byte))
+		return redisObj
+	case rdb.TypeHash:
+		redisObj := obj.NewHashObject()
+		simpleDict := redisObj.Ptr.(*dict.SimpleDict)
+		pairs := entry.Value.([][]byte)
+		for i := 0; i < len(pairs); i += 2 {
+			simpleDict.Put(string(pairs[i]), pairs[i+1])
+		}
+		return redisObj
+	default:
+		return obj.NewStringObject(entry.Value.([]byte))
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"bufio"
 	"errors"
 	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/dict"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/intset"
 	"github.com/xuning888/godis-tiny/pkg/datastruct/list"
 	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
-	"github.com/xuning888/godis-tiny/pkg/logger"
 	"github.com/xuning888/godis-tiny/pkg/util"
-	"io"
 	"os"
 	"strconv"
 	"sync/atomic"
@@ -16,17 +16,61 @@ import (
 )
 
 type RewriteCtx struct {
-	tmpFile     *os.File
-	fileSize    int64
-	dbIdx       int
-	writtenSize int64
+	// tmpFile aof 目录中的临时文件, 重写完成之后重命名为新的 base 文件
+	tmpFile *os.File
+	// snapshot 重写开始时数据库的一致视图
+	snapshot *rewriteSnapshot
+	// lastIncrSeq 重写开始时最后一个 incr 文件的 seq, 之后的写入在新的 incr 文件中
+	lastIncrSeq int64
+	// useRdbPreamble 重写开始时的 aof-use-rdb-preamble, 为 true 时 base 文件使用 rdb 格式
+	useRdbPreamble bool
+	writtenSize    int64
 }
 
 var (
 	ErrAofRewriteIsRunning = errors.New("aof rewrite is running")
 )
 
-func (a *Aof) Rewrite() error {
+// 和 redis 相同, 自动重写连续失败 aofRewriteLimitThreshold 次之后, 下一次自动重写依次推迟 1, 2, 4 ... 60 分钟
+const (
+	aofRewriteLimitThreshold  = 3
+	aofRewriteLimitMaxMinutes = 60
+)
+
+// rewriteLimiter 自动重写失败之后的退避, 只在 cron 中使用
+type rewriteLimiter struct {
+	nextDelayMinutes int
+	nextRewriteTime  time.Time
+}
+
+// limited 连续失败 failures 次之后是否推迟这一次自动重写
+func (l *rewriteLimiter) limited(failures int64, now time.Time) (bool, int) {
+	if failures < aofRewriteLimitThreshold {
+		l.nextDelayMinutes = 0
+		l.nextRewriteTime = time.Time{}
+		return false, 0
+	}
+	if !l.nextRewriteTime.IsZero() {
+		if now.Before(l.nextRewriteTime) {
+			return true, 0
+		}
+		// 等待结束之后重试一次, 再失败的话等待的时间加倍
+		l.nextRewriteTime = time.Time{}
+		return false, 0
+	}
+	if l.nextDelayMinutes == 0 {
+		l.nextDelayMinutes = 1
+	} else {
+		l.nextDelayMinutes *= 2
+	}
+	if l.nextDelayMinutes > aofRewriteLimitMaxMinutes {
+		l.nextDelayMinutes = aofRewriteLimitMaxMinutes
+	}
+	l.nextRewriteTime = now.Add(time.Duration(l.nextDelayMinutes) * time.Minute)
+	return true, l.nextDelayMinutes
+}
+
+func (a *Aof) Rewrite() (err error) {
 
 	if atomic.LoadUint32(&a.status) != none {
 		return ErrAofRewriteIsRunning
@@ -36,197 +80,190 @@ func (a *Aof) Rewrite() error {
 		return ErrAofRewriteIsRunning
 	}
 
+	// 记录重写的耗时和结果, 用于 INFO persistence
+	start := time.Now()
+	a.rewriteStartTime.Store(start.UnixNano())
+	a.rewrites.Add(1)
+	defer func() {
+		a.rewriteStartTime.Store(0)
+		a.lastRewriteTimeSec.Store(int64(time.Since(start).Seconds()))
+		a.lastRewriteFailed.Store(err != nil)
+		if err != nil {
+			a.rewriteFailures.Add(1)
+		} else {
+			a.rewriteFailures.Store(0)
+		}
+		atomic.StoreUint32(&a.status, none)
+	}()
+	oldSize, _ := a.CurrentAofSize()
+
 	// 准备重写时需要的信息, 这个时候会暂停aof的写入
 	ctx, err := a.StartRewrite()
 	if err != nil {
 		return err
 	}
 
-	// 把aof重写前的数据拷贝到内存,然后使用命令替换的方式重写到tmpFile, 这个时候是允许 aof 继续写入的
+	// 分批把快照中的数据写到tmpFile, 这个时候是允许命令继续执行和 aof 继续写入的
 	err = a.DoRewrite(ctx)
+	a.endSnapshot()
 	if err != nil {
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
 		return err
 	}
 
 	// 加锁禁止aof写入，直到aof数据整合完毕
-	a.FinishRewrite(ctx)
-	a.lg.Info("rewrite aof completed")
-	atomic.CompareAndSwapUint32(&a.status, rewrite, none)
+	if err = a.FinishRewrite(ctx); err != nil {
+		return err
+	}
+	a.lg.Infof("Background AOF rewrite finished successfully, new base size %s, old size %s",
+		util.HumanBytes(uint64(ctx.writtenSize)), util.HumanBytes(uint64(oldSize)))
+	a.deleteHistory()
 	return nil
 }
 
+// rewriteInProgress 是否正在重写 aof
+func (a *Aof) rewriteInProgress() bool {
+	return atomic.LoadUint32(&a.status) == rewrite
+}
+
+// currentRewriteTimeSec 正在进行的重写已经花费的秒数, 没有重写时为 -1
+func (a *Aof) currentRewriteTimeSec() int64 {
+	start := a.rewriteStartTime.Load()
+	if start == 0 {
+		return -1
+	}
+	return int64(time.Since(time.Unix(0, start)).Seconds())
+}
+
 func (a *Aof) DoRewrite(ctx *RewriteCtx) (err error) {
 
 	// 临时文件
 	tmpFile := ctx.tmpFile
 	buffer := bufio.NewWriterSize(tmpFile, 1<<16)
 	defer func() {
-		err = buffer.Flush()
+		if err == nil {
+			err = buffer.Flush()
+		}
+		if err == nil {
+			err = tmpFile.Sync()
+		}
 		if err != nil {
 			a.lg.Errorf("DoRewrite flush aof file failed with error: %v", err)
 		}
 	}()
 
-	// 将重写开始前的数据加载到内存
-	tmpAof := a.newRewriteHandler()
-	tmpAof.LoadAof(int(ctx.fileSize))
-
-	// 将内存中的数据写到临时文件
-	// 遍历DB, 获取其中的每一个数据，根据其数据类型将其转换为命令写入tmpFile
 	// string类型: incr a 会被重写为  set a 1 命令
 	// list 类型: 遍历list中的所有数据, 重写为 rpush ele1 ele2 ele3
-	for i := 0; i < config.Properties.Databases; i++ {
-		// select db
-		data := MakeMultiBulkReply(util.ToCmdLine("select", strconv.Itoa(i))).ToBytes()
-		written1, err := buffer.Write(data)
-		if err != nil {
-			return err
-		}
-		ctx.writtenSize += int64(written1)
-		// 将内存中的数据写入临时文件
-		tmpAof.each(i, func(key string, redisObj *obj.RedisObject, expiration *time.Time) bool {
-			cmd := EntityToCmd(key, redisObj)
-			if cmd != nil {
-				written2, _ := buffer.Write(cmd.ToBytes())
-				ctx.writtenSize += int64(written2)
-			}
-			if expiration != nil {
-				expireCmd := ExpireCmd(key, expiration)
-				if expireCmd != nil {
-					written3, _ := buffer.Write(expireCmd.ToBytes())
-					ctx.writtenSize += int64(written3)
-				}
-			}
-			return true
-		})
-	}
-	return nil
+	// set 和 hash 类型: 分别重写为 sadd 和 hset
+	// 使用 rdb 前导时直接写出 rdb 格式的数据
+	w := &countingWriter{w: buffer}
+	err = writeSnapshot(w, ctx.snapshot)
+	ctx.writtenSize = w.written
+	return err
 }
 
-func (a *Aof) FinishRewrite(ctx *RewriteCtx) {
+// FinishRewrite 把临时文件重命名为新的 base 文件并发布新的 manifest, 重写期间的命令已经在新的 incr 文件中,
+// 不需要再拷贝。旧的文件在 manifest 中变为 history, 之后再删除
+func (a *Aof) FinishRewrite(ctx *RewriteCtx) error {
 	// 暂停aof写入
 	a.mux.Lock()
-	defer a.mux.Unlock()
+	start := time.Now()
+	defer func() {
+		latency.addSampleIfNeeded(latencyEventAofRewriteDone, time.Since(start))
+		a.mux.Unlock()
+	}()
 
 	tmpFile := ctx.tmpFile
-	errOccurs := func() bool {
-
-		// 尝试打开重写前的 aof文件
-		src, err := os.Open(a.aofFilename)
-		if err != nil {
-			a.lg.Errorf("open aofFilname %v fialed with error: %v", a.aofFilename, err)
-			return true
-		}
-
-		buffer := bufio.NewWriter(tmpFile)
-		defer func() {
-			_ = buffer.Flush()
-			_ = src.Close()
-			_ = tmpFile.Close()
-		}()
-
-		// 跳转到重写前的末尾
-		_, err = src.Seek(ctx.fileSize, io.SeekStart)
-		if err != nil {
-			a.lg.Warnf("seek failed with error: %v", err)
-			return true
-		}
-
-		// 插入一个aof重写前aof写入时使用的db
-		selectDbBytes := MakeMultiBulkReply(util.ToCmdLine("select", strconv.Itoa(ctx.dbIdx))).ToBytes()
-
-		written1, err := buffer.Write(selectDbBytes)
-		if err != nil {
-			a.lg.Errorf("tmp file rewrite failed with error: %v", err)
-			return true
-		}
-		ctx.writtenSize += int64(written1)
-
-		// 把重写时可能写入到原来aof文件中命令拷贝到tmp文件中
-		written2, err := io.Copy(buffer, src)
-		if err != nil {
-			a.lg.Errorf("copy aof file failed with error: %v", err)
-			return true
-		}
-		ctx.writtenSize += written2
-		return false
-	}
-
-	// 把DoRewrite期间的产生的数据落盘
-	err2 := a.fileBuffer.Sync()
-	if err2 != nil {
-		a.lg.Errorf("last sync aoffile fialed error %v", err2)
-		return
-	}
-
-	if errOccurs() {
-		return
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
 	}
-
-	// 关闭原来的aofFile
-	err := a.fileBuffer.Close()
-	if err != nil {
-		a.lg.Errorf("close aofFile failed with error: %v", err)
+	manifest := a.getManifest().copy()
+	format := aofFormatSuffix
+	if ctx.useRdbPreamble {
+		format = rdbFormatSuffix
 	}
-	// 使用 mv 命令把 原来的 aofFile 替换为 重写后的 tmpFile
-	if err = os.Rename(tmpFile.Name(), a.aofFilename); err != nil {
+	baseName := manifest.newBaseName(a.filename, format)
+	renameStart := time.Now()
+	if err := os.Rename(tmpFile.Name(), a.path(baseName)); err != nil {
 		a.lg.Errorf("rename aof file failed with error: %v", err)
+		_ = os.Remove(tmpFile.Name())
+		return err
 	}
+	latency.addSampleIfNeeded(latencyEventAofRename, time.Since(renameStart))
+	manifest.replaceBase(baseName, ctx.lastIncrSeq)
+	if err := persistManifest(a.dir, a.filename, manifest); err != nil {
+		a.lg.Errorf("persist aof manifest failed with error: %v", err)
+		_ = os.Remove(a.path(baseName))
+		return err
+	}
+	a.manifest.Store(manifest)
 
 	// 记录aof重写完成后的文件大小
-	a.lastRewriteAofSize = ctx.writtenSize
-
-	// 重新打开 aofFile
-	aofFile, err := os.OpenFile(a.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		panic(err)
-	}
-	// 替换aofFile
-	a.fileBuffer = NewFileBuffer(aofFile, aofBufferSize)
-	// 插入一个aof当前记录的db的命令 select curDbIndex
-	selectBytes := MakeMultiBulkReply(util.ToCmdLine("select", strconv.Itoa(a.currentDb))).ToBytes()
-	_, err = a.fileBuffer.Write(selectBytes)
-	if err != nil {
-		panic(err)
-	}
+	a.lastRewriteAofSize.Store(ctx.writtenSize)
+	return nil
 }
 
+// StartRewrite 切换到新的 incr 文件并创建数据库的快照, 快照中的数据和切换之前的文件一致
 func (a *Aof) StartRewrite() (*RewriteCtx, error) {
+	// 暂停命令的执行, 快照和新的 incr 文件之间不会遗漏或者重复命令
+	lock.Lock()
+	defer lock.Unlock()
 	// 加锁暂停主流程的aof写入
 	a.mux.Lock()
 	defer a.mux.Unlock()
 
-	// fsync 将缓冲区中的数据落盘，防止aof文件不完整造成数据错误
-	err := a.fileBuffer.Sync()
+	// 快照之前的命令都写到切换之前的文件中并 fsync, 防止aof文件不完整造成数据错误
+	a.handoff()
+	err := a.writeLocked(true)
 	if err != nil {
 		a.lg.Warnf("fsync failed, err: %v", err)
 		return nil, err
 	}
 
-	// 获取当前aof文件大小, 用于判断哪些数据是 aof 重写过程中产生的
-	// 因为前边已经暂停了aof的落盘, 可以确定当前aof文件的大小
-	fileInfo, _ := os.Stat(a.aofFilename)
-	filesize := fileInfo.Size()
+	dbs := make([]*DB, 0, config.Properties.Databases)
+	for i := 0; i < config.Properties.Databases; i++ {
+		db, err := a.selectDb(i)
+		if err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	incr, err := a.openNewIncr()
+	if err != nil {
+		a.lg.Warnf("open new incr aof file failed, err: %v", err)
+		return nil, err
+	}
 
-	// 创建临时文件供重写使用
-	file, err := os.CreateTemp("", "*.aof")
+	// 临时文件和 aof 在同一个目录中, 保证重命名是原子的
+	file, err := os.CreateTemp(a.dir, aofTempPrefix+"rewriteaof-bg-*"+aofFormatSuffix)
 	if err != nil {
 		a.lg.Warnf("tmp file create failed, err: %v", err)
 		return nil, err
 	}
 
+	snapshot := newRewriteSnapshot(dbs, config.Properties.AofUseRdbPreamble)
+	if config.Properties.AofTimestampEnabled {
+		snapshot.timestamp = time.Now().Unix()
+	}
 	ctx := &RewriteCtx{
-		// tmpFile 临时文件
-		tmpFile: file,
-		// fileSize aof文件的大小
-		fileSize: filesize,
-		// aof记录的dbIndex
-		dbIdx:       a.currentDb,
-		writtenSize: 0,
+		tmpFile:        file,
+		snapshot:       snapshot,
+		lastIncrSeq:    incr.seq - 1,
+		useRdbPreamble: config.Properties.AofUseRdbPreamble,
 	}
+	a.snapshot = ctx.snapshot
 	return ctx, nil
 }
 
+// endSnapshot 快照写完或者重写失败之后, 命令不再需要保留旧值
+func (a *Aof) endSnapshot() {
+	lock.Lock()
+	defer lock.Unlock()
+	a.snapshot = nil
+}
+
 func EntityToCmd(key string, redisObj *obj.RedisObject) *MultiBulkReply {
 	if redisObj == nil {
 		return nil
@@ -238,6 +275,10 @@ func EntityToCmd(key string, redisObj *obj.RedisObject) *MultiBulkReply {
 	case obj.RedisList:
 		dequeue := redisObj.Ptr.(list.Dequeue)
 		return listToCmd(key, dequeue)
+	case obj.RedisSet:
+		return setToCmd(key, redisObj)
+	case obj.RedisHash:
+		return hashToCmd(key, redisObj.Ptr.(*dict.SimpleDict))
 	default:
 		return nil
 	}
@@ -274,10 +315,34 @@ func listToCmd(key string, deque list.Dequeue) *MultiBulkReply {
 	return MakeMultiBulkReply(args)
 }
 
-func (a *Aof) newRewriteHandler() *Aof {
-	h := &Aof{}
-	h.aofFilename = a.aofFilename
-	h.exec, h.each = a.tempDbMaker()
-	h.lg = logger.Named("aof-rewrite")
-	return h
+var saddCmd = []byte("sadd")
+
+func setToCmd(key string, redisObj *obj.RedisObject) *MultiBulkReply {
+	args := [][]byte{saddCmd, []byte(key)}
+	switch members := redisObj.Ptr.(type) {
+	case *intset.IntSet:
+		members.Range(func(index int, value int64) bool {
+			args = append(args, strconv.AppendInt(nil, value, 10))
+			return true
+		})
+	case *dict.SimpleDict:
+		members.ForEach(func(member string, val interface{}) bool {
+			args = append(args, []byte(member))
+			return true
+		})
+	}
+	return MakeMultiBulkReply(args)
+}
+
+var hsetCmd = []byte("hset")
+
+func hashToCmd(key string, simpleDict *dict.SimpleDict) *MultiBulkReply {
+	args := make([][]byte, 2, 2+2*simpleDict.Len())
+	args[0] = hsetCmd
+	args[1] = []byte(key)
+	simpleDict.ForEach(func(field string, val interface{}) bool {
+		args = append(args, []byte(field), val.([]byte))
+		return true
+	})
+	return MakeMultiBulkReply(args)
 }
@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"bytes"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
+	"github.com/xuning888/godis-tiny/pkg/rdb"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"io"
+	"strconv"
+	"time"
+)
+
+// rewriteBatchKeys 重写时每次持有 lock 序列化的 key 的数量
+const rewriteBatchKeys = 1024
+
+// rewriteSnapshot 重写开始时数据库的一致视图, 所有的方法都需要持有 lock。
+// 开始时记录每个 db 中所有的 key, 重写的 goroutine 每次持有 lock 序列化一批还没有写出的 key。
+// 命令修改一个还没有写出的 key 之前, 先把它当前的值序列化到 preserved 中(写时复制), 所以写出的都是重写开始时的值,
+// 之后的修改都在新的 incr 文件中。重写的耗时和内存只和数据的大小有关, 和 aof 的历史无关
+type rewriteSnapshot struct {
+	useRdb bool
+	// timestamp 开启 aof-timestamp-enabled 时快照的时间, 写在 resp 格式的 base 文件的开头
+	timestamp int64
+	dbs       []*DB
+	// keys 开始时每个 db 中的 key, cursor 是下一个要写出的位置
+	keys   [][]string
+	cursor []int
+	// pending 每个 db 中还没有写出的 key
+	pending []map[string]struct{}
+	// preserved 每个 db 中修改之前序列化的 key, 轮到这个 db 的时候写出
+	preserved []*bytes.Buffer
+}
+
+func newRewriteSnapshot(dbs []*DB, useRdb bool) *rewriteSnapshot {
+	s := &rewriteSnapshot{
+		useRdb:    useRdb,
+		dbs:       dbs,
+		keys:      make([][]string, len(dbs)),
+		cursor:    make([]int, len(dbs)),
+		pending:   make([]map[string]struct{}, len(dbs)),
+		preserved: make([]*bytes.Buffer, len(dbs)),
+	}
+	for i, db := range dbs {
+		s.keys[i] = db.data.Keys()
+		s.pending[i] = make(map[string]struct{}, len(s.keys[i]))
+		for _, key := range s.keys[i] {
+			s.pending[i][key] = struct{}{}
+		}
+		s.preserved[i] = &bytes.Buffer{}
+	}
+	return s
+}
+
+// empty db 中没有需要写出的数据
+func (s *rewriteSnapshot) empty(index int) bool {
+	return len(s.pending[index]) == 0 && s.preserved[index].Len() == 0
+}
+
+// preserve 在 key 被修改之前序列化它, keys 为 nil 时整个 db 都可能被修改, 例如 flushdb
+func (s *rewriteSnapshot) preserve(db *DB, keys [][]byte) {
+	index := db.Index
+	if index < 0 || index >= len(s.pending) || len(s.pending[index]) == 0 {
+		return
+	}
+	if keys == nil {
+		for _, key := range s.keys[index][s.cursor[index]:] {
+			s.encodeKey(s.preserved[index], index, key)
+		}
+		s.cursor[index] = len(s.keys[index])
+		return
+	}
+	for _, key := range keys {
+		s.encodeKey(s.preserved[index], index, string(key))
+	}
+}
+
+// next 把 db 中修改之前序列化的 key 和下一批还没有写出的 key 写到 buf 中
+func (s *rewriteSnapshot) next(buf *bytes.Buffer, index int) {
+	buf.Write(s.preserved[index].Bytes())
+	s.preserved[index].Reset()
+	keys := s.keys[index]
+	end := s.cursor[index] + rewriteBatchKeys
+	if end > len(keys) {
+		end = len(keys)
+	}
+	for _, key := range keys[s.cursor[index]:end] {
+		s.encodeKey(buf, index, key)
+	}
+	s.cursor[index] = end
+}
+
+// encodeKey 序列化一个还没有写出的 key, 已经写出的、开始之后才创建的和已经过期的 key 跳过
+func (s *rewriteSnapshot) encodeKey(buf *bytes.Buffer, index int, key string) {
+	if _, ok := s.pending[index][key]; !ok {
+		return
+	}
+	delete(s.pending[index], key)
+	db := s.dbs[index]
+	val, ok := db.data.Get(key)
+	if !ok {
+		return
+	}
+	var expiration *time.Time
+	if expired, exists := db.ttlCache.IsExpired(key); exists {
+		if expired {
+			return
+		}
+		expireAt := db.ttlCache.ExpireAt(key)
+		expiration = &expireAt
+	}
+	redisObj := val.(*obj.RedisObject)
+	if s.useRdb {
+		enc := rdb.NewEncoder(buf)
+		if expiration != nil {
+			enc.WriteExpireTimeMs(expiration.UnixMilli())
+		}
+		writeRdbObject(enc, key, redisObj)
+		return
+	}
+	if cmd := EntityToCmd(key, redisObj); cmd != nil {
+		buf.Write(cmd.ToBytes())
+		if expiration != nil {
+			buf.Write(ExpireCmd(key, expiration).ToBytes())
+		}
+	}
+}
+
+// beforeWrite 写命令执行之前调用, 重写期间先保留将要被修改的 key 的旧值。需要持有 lock
+func (a *Aof) beforeWrite(db *DB, cmd *Command, cmdLine [][]byte) {
+	if a.snapshot == nil {
+		return
+	}
+	// 没有声明 key 的写命令(flushdb)可能修改整个 db, keys 为 nil
+	a.snapshot.preserve(db, cmd.keys(cmdLine))
+}
+
+// writeSnapshot 按照 db 的顺序写出快照中的数据。每次持有 lock 序列化一批 key, 写到 w 中的时候不持有 lock,
+// 所以重写期间命令可以继续执行
+func writeSnapshot(w io.Writer, snapshot *rewriteSnapshot) error {
+	var enc *rdb.Encoder
+	if snapshot.useRdb {
+		enc = rdb.NewEncoder(w)
+		writeRdbHeader(enc)
+	} else if snapshot.timestamp > 0 {
+		if _, err := w.Write(appendTimestampAnnotation(nil, snapshot.timestamp)); err != nil {
+			return err
+		}
+	}
+	chunk := &bytes.Buffer{}
+	for i := range snapshot.dbs {
+		selected := false
+		for {
+			chunk.Reset()
+			lock.Lock()
+			done := snapshot.empty(i)
+			if !done {
+				snapshot.next(chunk, i)
+			}
+			lock.Unlock()
+			if done {
+				break
+			}
+			if chunk.Len() == 0 {
+				continue
+			}
+			if !selected {
+				selected = true
+				if enc != nil {
+					enc.WriteSelectDB(i)
+				} else if _, err := w.Write(MakeMultiBulkReply(util.ToCmdLine("select", strconv.Itoa(i))).ToBytes()); err != nil {
+					return err
+				}
+			}
+			if enc == nil {
+				if _, err := w.Write(chunk.Bytes()); err != nil {
+					return err
+				}
+				continue
+			}
+			enc.WriteRaw(chunk.Bytes())
+			if err := enc.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	if enc != nil {
+		enc.WriteEOF()
+		return enc.Err()
+	}
+	return nil
+}
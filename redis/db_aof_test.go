@@ -0,0 +1,497 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/panjf2000/gnet/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/dict"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/intset"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/list"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/obj"
+	"github.com/xuning888/godis-tiny/pkg/logger"
+	"github.com/xuning888/godis-tiny/pkg/rdb"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestAof 在 dir 中打开 aof 并加载数据, 返回的服务器执行的写命令会追加到 aof 中
+func newTestAof(t *testing.T, dir, legacy string) (*RedisServer, *Aof) {
+	server := makeTempServer()
+	aof, err := NewAof(server.process, dir, "appendonly.aof", legacy, FsyncAlways)
+	assert.Nil(t, err)
+	server.bindPersister(aof)
+	assert.Nil(t, aof.LoadAof())
+	t.Cleanup(func() {
+		_ = aof.Shutdown(context.Background())
+	})
+	return server, aof
+}
+
+func execCommands(t *testing.T, server *RedisServer, cmdLines ...string) {
+	conn := NewClient(0, nil, true)
+	for _, cmdLine := range cmdLines {
+		fields := strings.Fields(cmdLine)
+		conn.PushCmd(util.ToCmdLine(fields[0], fields[1:]...))
+		assert.Nil(t, server.process(context.Background(), conn))
+	}
+}
+
+// syncAof 等待 writer 把已经执行的命令写到文件中并 fsync
+func syncAof(t *testing.T, aof *Aof) {
+	assert.Nil(t, aof.flushAndSync(context.Background()))
+}
+
+func stringValue(t *testing.T, server *RedisServer, dbIndex int, key string) string {
+	entity, ok := server.dbs[dbIndex].GetEntity(key)
+	if !assert.True(t, ok, key) {
+		return ""
+	}
+	value, err := obj.StringObjEncoding(entity)
+	assert.Nil(t, err)
+	return string(value)
+}
+
+func setAppendOnly(t *testing.T) {
+	appendOnly := config.Properties.AppendOnly
+	config.Properties.AppendOnly = true
+	t.Cleanup(func() {
+		config.Properties.AppendOnly = appendOnly
+	})
+}
+
+func setRdbPreamble(t *testing.T, enabled bool) {
+	useRdbPreamble := config.Properties.AofUseRdbPreamble
+	config.Properties.AofUseRdbPreamble = enabled
+	t.Cleanup(func() {
+		config.Properties.AofUseRdbPreamble = useRdbPreamble
+	})
+}
+
+func TestAofManifest(t *testing.T) {
+	content := "file appendonly.aof.2.base.aof seq 2 type b\n" +
+		"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+		"file appendonly.aof.3.incr.aof seq 3 type i\n"
+	manifest, err := parseManifest(strings.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, content, manifest.String())
+	assert.Equal(t, []string{"appendonly.aof.2.base.aof", "appendonly.aof.2.incr.aof", "appendonly.aof.3.incr.aof"},
+		manifest.files())
+
+	cp := manifest.copy()
+	incr := cp.addIncr("appendonly.aof")
+	assert.Equal(t, "appendonly.aof.4.incr.aof", incr.name)
+	cp.replaceBase(cp.newBaseName("appendonly.aof", aofFormatSuffix), 3)
+	assert.Equal(t, []string{"appendonly.aof.3.base.aof", "appendonly.aof.4.incr.aof"}, cp.files())
+	assert.Equal(t, 4, len(cp.history))
+	// 原来的 manifest 不受影响
+	assert.Equal(t, content, manifest.String())
+
+	for _, invalid := range []string{
+		"",
+		"file appendonly.aof.1.base.aof seq 1\n",
+		"file ../appendonly.aof.1.base.aof seq 1 type b\n",
+		"file a seq 1 type b\nfile b seq 2 type b\n",
+		"file a seq 2 type i\nfile b seq 1 type i\n",
+		"file a seq x type i\n",
+		"file a seq 1 type x\n",
+	} {
+		_, err = parseManifest(strings.NewReader(invalid))
+		assert.ErrorIs(t, err, errInvalidManifest, invalid)
+	}
+}
+
+func TestAofMultiPart(t *testing.T) {
+	setAppendOnly(t)
+	setRdbPreamble(t, false)
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, "appendonlydir")
+	legacy := filepath.Join(workDir, "appendonly.aof")
+	legacyContent := string(MakeMultiBulkReply(util.ToCmdLine("set", "a", "1")).ToBytes())
+	assert.Nil(t, os.WriteFile(legacy, []byte(legacyContent), 0644))
+
+	// 旧的 aof 迁移为 base 文件
+	server, aof := newTestAof(t, dir, legacy)
+	assert.False(t, fileExists(legacy))
+	assert.Equal(t, "1", stringValue(t, server, 0, "a"))
+	assert.Equal(t, "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n",
+		aof.getManifest().String())
+
+	execCommands(t, server, "set b 2", "select 1", "rpush l x y")
+	assert.Nil(t, aof.Rewrite())
+	execCommands(t, server, "select 1", "set c 3", "select 2", "set d 4")
+	assert.Equal(t, "file appendonly.aof.2.base.aof seq 2 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n",
+		aof.getManifest().String())
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"appendonly.aof.2.base.aof", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, names)
+	syncAof(t, aof)
+	size, err := aof.CurrentAofSize()
+	assert.Nil(t, err)
+	assert.Greater(t, size, aof.LasAofRewriteSize())
+
+	restarted, _ := newTestAof(t, dir, legacy)
+	assert.Equal(t, "1", stringValue(t, restarted, 0, "a"))
+	assert.Equal(t, "2", stringValue(t, restarted, 0, "b"))
+	assert.Equal(t, "3", stringValue(t, restarted, 1, "c"))
+	assert.Equal(t, "4", stringValue(t, restarted, 2, "d"))
+	_, ok := restarted.dbs[1].GetEntity("l")
+	assert.True(t, ok)
+}
+
+func TestAofMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"),
+		[]byte("file appendonly.aof.1.base.aof seq 1 type b\n"), 0644))
+	_, err := NewAof(nil, dir, "appendonly.aof", filepath.Join(dir, "missing.aof"), FsyncNo)
+	assert.NotNil(t, err)
+}
+
+func TestAofRdbPreamble(t *testing.T) {
+	setAppendOnly(t)
+	setRdbPreamble(t, true)
+	dir := t.TempDir()
+	long := strings.Repeat("v", 40)
+	server, aof := newTestAof(t, dir, filepath.Join(dir, "missing.aof"))
+	execCommands(t, server, "set a 1", "set long "+long, "expire long 1000", "select 3", "rpush l x y z")
+	assert.Nil(t, aof.Rewrite())
+	execCommands(t, server, "set after 2")
+
+	manifest := aof.getManifest()
+	assert.Equal(t, "appendonly.aof.1.base.rdb", manifest.base.name)
+	content, err := os.ReadFile(filepath.Join(dir, manifest.base.name))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "REDIS"))
+	assert.Equal(t, int64(len(content)), aof.LasAofRewriteSize())
+
+	syncAof(t, aof)
+	restarted, _ := newTestAof(t, dir, "")
+	assert.Equal(t, "1", stringValue(t, restarted, 0, "a"))
+	assert.Equal(t, long, stringValue(t, restarted, 0, "long"))
+	assert.Equal(t, "2", stringValue(t, restarted, 0, "after"))
+	assert.True(t, restarted.dbs[0].ExpiredAt("long").After(time.Now().Add(999*time.Second)))
+	entity, ok := restarted.dbs[3].GetEntity("l")
+	if assert.True(t, ok) {
+		assert.Equal(t, 3, entity.Ptr.(list.Dequeue).Len())
+	}
+}
+
+func TestRdbSetAndHash(t *testing.T) {
+	server := makeTempServer()
+	execCommands(t, server, "sadd ints 3 1 2", "sadd strs a b 1", "hset h f1 v1 f2 22", "set gone x")
+	// 已经过期但是还没有删除的 key 不写到 rdb 中
+	server.dbs[0].ExpireV1("gone", time.Now().Add(-time.Second))
+	buf := &bytes.Buffer{}
+	assert.Nil(t, writeSnapshot(buf, newRewriteSnapshot(server.dbs, true)))
+
+	loaded := makeTempServer()
+	aof := &Aof{selectDb: loaded.SelectDb}
+	n := 0
+	_, err := scanAof(buf, 0, func(entry *rdb.Entry) error {
+		n++
+		_, err := aof.loadRdbEntry(entry, time.Now().UnixMilli())
+		return err
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	ints, _ := loaded.dbs[0].GetEntity("ints")
+	assert.Equal(t, obj.EncIntSet, ints.Encoding)
+	assert.Equal(t, []int64{1, 2, 3}, ints.Ptr.(*intset.IntSet).Elements())
+	strs, _ := loaded.dbs[0].GetEntity("strs")
+	assert.ElementsMatch(t, []string{"a", "b", "1"}, strs.Ptr.(*dict.SimpleDict).Keys())
+	h, _ := loaded.dbs[0].GetEntity("h")
+	value, _ := h.Ptr.(*dict.SimpleDict).Get("f2")
+	assert.Equal(t, []byte("22"), value)
+}
+
+// dumpDbs 所有 db 中的数据, 集合和哈希按照元素排序
+func dumpDbs(server *RedisServer) []string {
+	dump := make([]string, 0)
+	for _, mdb := range server.dbs {
+		mdb.ForEach(func(key string, redisObj *obj.RedisObject, expiration *time.Time) bool {
+			args := EntityToCmd(key, redisObj).Args
+			if redisObj.ObjType == obj.RedisSet || redisObj.ObjType == obj.RedisHash {
+				sort.Slice(args[2:], func(i, j int) bool {
+					return bytes.Compare(args[2+i], args[2+j]) < 0
+				})
+			}
+			dump = append(dump, fmt.Sprintf("%d %q %v", mdb.Index, args, expiration != nil))
+			return true
+		})
+	}
+	sort.Strings(dump)
+	return dump
+}
+
+func TestAofRewriteSnapshot(t *testing.T) {
+	setAppendOnly(t)
+	for _, useRdbPreamble := range []bool{true, false} {
+		setRdbPreamble(t, useRdbPreamble)
+		dir := t.TempDir()
+		server, aof := newTestAof(t, dir, "")
+		cmdLines := []string{"sadd s 1 2 3", "sadd s2 a b", "hset h f v f2 v2", "select 1", "rpush l a b", "set x 1"}
+		for i := 0; i < 3*rewriteBatchKeys; i++ {
+			cmdLines = append(cmdLines, fmt.Sprintf("set k%d %d", i, i))
+		}
+		execCommands(t, server, cmdLines...)
+		expected := dumpDbs(server)
+
+		// 写出之前修改的 key 在快照中是修改之前的值
+		ctx, err := aof.StartRewrite()
+		assert.Nil(t, err)
+		execCommands(t, server, "select 1", "incr x", "rpush l c", "del k0", "set k1 changed", "set new 1", "expire k2 1000")
+		done := make(chan error)
+		go func() {
+			done <- aof.DoRewrite(ctx)
+		}()
+		// 重写期间命令继续执行, 有的 key 已经写出, 有的还没有
+		for i := 3; i < 3*rewriteBatchKeys; i += 100 {
+			execCommands(t, server, "select 1", fmt.Sprintf("incr k%d", i))
+		}
+		execCommands(t, server, "select 1", "flushdb", "set after 1")
+		assert.Nil(t, <-done)
+		aof.endSnapshot()
+		assert.Nil(t, aof.FinishRewrite(ctx))
+
+		// base 文件中只有快照中的数据
+		snapshot := makeTempServer()
+		loader := &Aof{exec: snapshot.process, selectDb: snapshot.SelectDb, lg: logger.Named("aof-test")}
+		_, err = loader.loadFile(filepath.Join(dir, aof.getManifest().base.name), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, dumpDbs(snapshot))
+
+		syncAof(t, aof)
+		restarted, _ := newTestAof(t, dir, "")
+		assert.Equal(t, dumpDbs(server), dumpDbs(restarted))
+		assert.Equal(t, 1, restarted.dbs[1].Len())
+	}
+}
+
+// replyConn 记录写出的回复和 writer 的唤醒
+type replyConn struct {
+	gnet.Conn
+	replies bytes.Buffer
+	woken   chan struct{}
+}
+
+func (c *replyConn) Write(p []byte) (int, error) {
+	return c.replies.Write(p)
+}
+
+func (c *replyConn) OutboundBuffered() int {
+	return 0
+}
+
+func (c *replyConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *replyConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *replyConn) InboundBuffered() int {
+	return 0
+}
+
+func (c *replyConn) Wake(callback gnet.AsyncCallback) error {
+	c.woken <- struct{}{}
+	return nil
+}
+
+func TestAofGroupCommit(t *testing.T) {
+	setAppendOnly(t)
+	server, aof := newTestAof(t, t.TempDir(), "")
+	newReplyClient := func() (*Client, *replyConn) {
+		conn := &replyConn{woken: make(chan struct{}, 1)}
+		return NewClient(0, conn, false), conn
+	}
+	run := func(client *Client, cmdLines ...string) {
+		for _, cmdLine := range cmdLines {
+			fields := strings.Fields(cmdLine)
+			client.PushCmd(util.ToCmdLine(fields[0], fields[1:]...))
+		}
+		assert.Nil(t, server.process(context.Background(), client))
+	}
+
+	// writer 被阻塞时命令继续执行, 写命令和之后的回复保留到 fsync 之后
+	aof.mux.Lock()
+	c1, conn1 := newReplyClient()
+	c2, conn2 := newReplyClient()
+	reader, readerConn := newReplyClient()
+	run(c1, "set a 1", "get a")
+	run(c2, "set b 2")
+	run(reader, "get a")
+	assert.Equal(t, 0, conn1.replies.Len())
+	assert.Equal(t, 0, conn2.replies.Len())
+	assert.Equal(t, "$1\r\n1\r\n", readerConn.replies.String())
+	assert.Greater(t, aof.bufferLength(), 0)
+	aof.mux.Unlock()
+
+	// 一次写入和 fsync 确认两个客户端, 然后在事件循环中发送回复
+	for _, conn := range []*replyConn{conn1, conn2} {
+		select {
+		case <-conn.woken:
+		case <-time.After(time.Second):
+			t.Fatal("client is not woken after fsync")
+		}
+	}
+	assert.Equal(t, aof.appendOffset, aof.syncedOffset.Load())
+	lock.Lock()
+	assert.Nil(t, server.releaseReplies(c1))
+	assert.Nil(t, server.releaseReplies(c2))
+	lock.Unlock()
+	assert.Equal(t, "+OK\r\n$1\r\n1\r\n", conn1.replies.String())
+	assert.Equal(t, "+OK\r\n", conn2.replies.String())
+	assert.Equal(t, 0, aof.bufferLength())
+
+	// releaseReplies 读取 syncedOffset 之后 writer 才确认并唤醒, 这时注册的客户端也要马上唤醒
+	aof.mux.Lock()
+	run(c1, "set a 3")
+	assert.Nil(t, aof.writeLocked(true))
+	aof.mux.Unlock()
+	<-conn1.woken
+	assert.Equal(t, aof.appendOffset, aof.syncedOffset.Load())
+	aof.waitFsync(c1, c1.heldOffset)
+	select {
+	case <-conn1.woken:
+	case <-time.After(time.Second):
+		t.Fatal("client registered after the fsync is not woken")
+	}
+	lock.Lock()
+	assert.Nil(t, server.releaseReplies(c1))
+	lock.Unlock()
+	assert.Equal(t, "+OK\r\n$1\r\n1\r\n+OK\r\n", conn1.replies.String())
+
+	// everysec 不等待 fsync
+	aof.SetFsync(FsyncEverySec)
+	aof.mux.Lock()
+	run(c1, "set a 2")
+	aof.mux.Unlock()
+	assert.Equal(t, "+OK\r\n$1\r\n1\r\n+OK\r\n+OK\r\n", conn1.replies.String())
+}
+
+func TestRewriteLimiter(t *testing.T) {
+	l := &rewriteLimiter{}
+	now := time.Now()
+	limited, _ := l.limited(aofRewriteLimitThreshold-1, now)
+	assert.False(t, limited)
+
+	delays := make([]int, 0)
+	for i := 0; i < 8; i++ {
+		limited, delay := l.limited(aofRewriteLimitThreshold, now)
+		assert.True(t, limited)
+		delays = append(delays, delay)
+		// 等待期间一直推迟
+		limited, _ = l.limited(aofRewriteLimitThreshold, now.Add(time.Duration(delay)*time.Minute-time.Second))
+		assert.True(t, limited)
+		// 等待结束之后重试一次
+		now = now.Add(time.Duration(delay) * time.Minute)
+		limited, _ = l.limited(aofRewriteLimitThreshold, now)
+		assert.False(t, limited)
+	}
+	assert.Equal(t, []int{1, 2, 4, 8, 16, 32, 60, 60}, delays)
+
+	// 成功之后重新计算
+	limited, _ = l.limited(0, now)
+	assert.False(t, limited)
+	_, delay := l.limited(aofRewriteLimitThreshold, now)
+	assert.Equal(t, 1, delay)
+}
+
+func TestAutoAofRewrite(t *testing.T) {
+	setAppendOnly(t)
+	minSize, percentage := config.Properties.AofRewriteMinSize, config.Properties.AofRewritePercentage
+	t.Cleanup(func() {
+		config.Properties.AofRewriteMinSize, config.Properties.AofRewritePercentage = minSize, percentage
+	})
+	config.Properties.AofRewriteMinSize = 0
+	config.Properties.AofRewritePercentage = 0
+	dir := t.TempDir()
+	server, aof := newTestAof(t, dir, "")
+	server.lg = logger.Named("redis-server")
+	execCommands(t, server, "set a 1", "set a 2", "set a 3")
+	syncAof(t, aof)
+
+	// auto-aof-rewrite-percentage 为 0 时关闭自动重写
+	server.doAofRewrite()
+	assert.Equal(t, int64(0), aof.rewrites.Load())
+
+	config.Properties.AofRewritePercentage = 100
+	// 已经有后台任务的时候推迟
+	atomic.StoreUint32(&aof.status, rewrite)
+	server.doAofRewrite()
+	assert.Equal(t, int64(0), aof.rewrites.Load())
+	atomic.StoreUint32(&aof.status, none)
+
+	server.doAofRewrite()
+	assert.Eventually(t, func() bool {
+		return aof.rewrites.Load() == 1 && !aof.rewriteInProgress()
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, aof.lastRewriteFailed.Load())
+	assert.NotNil(t, aof.getManifest().base)
+
+	// 没有增长时不重写
+	server.doAofRewrite()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(1), aof.rewrites.Load())
+
+	info := server.info([]string{"persistence"})
+	assert.Contains(t, info, "aof_rewrites:1\r\n")
+	assert.Contains(t, info, "aof_rewrites_consecutive_failures:0\r\n")
+}
+
+func TestAofTimestamp(t *testing.T) {
+	setAppendOnly(t)
+	setRdbPreamble(t, false)
+	enabled := config.Properties.AofTimestampEnabled
+	config.Properties.AofTimestampEnabled = true
+	t.Cleanup(func() {
+		config.Properties.AofTimestampEnabled = enabled
+	})
+	dir := t.TempDir()
+	server, aof := newTestAof(t, dir, "")
+	start := time.Now().Unix()
+	execCommands(t, server, "set a 1", "set b 2")
+	syncAof(t, aof)
+	files := aof.getManifest().files()
+	content, err := os.ReadFile(aof.path(files[len(files)-1]))
+	assert.Nil(t, err)
+	// 同一秒内的命令共用一个注释
+	assert.True(t, bytes.HasPrefix(content, []byte(aofTimestampPrefix)), string(content))
+	assert.LessOrEqual(t, bytes.Count(content, []byte(aofTimestampPrefix)), 2)
+	timestamp, ok := parseTimestampAnnotation(content[:bytes.IndexByte(content, '\r')])
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, timestamp, start)
+
+	// resp 格式的 base 文件以快照的时间开头, 新的 incr 文件的第一条命令之前也有注释
+	ctx, err := aof.StartRewrite()
+	assert.Nil(t, err)
+	assert.Nil(t, aof.DoRewrite(ctx))
+	aof.endSnapshot()
+	assert.Nil(t, aof.FinishRewrite(ctx))
+	execCommands(t, server, "set c 3")
+	syncAof(t, aof)
+	for _, name := range aof.getManifest().files() {
+		content, err = os.ReadFile(aof.path(name))
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(content, []byte(aofTimestampPrefix)), name)
+	}
+
+	restarted, _ := newTestAof(t, dir, "")
+	assert.Equal(t, dumpDbs(server), dumpDbs(restarted))
+}
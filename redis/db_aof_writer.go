@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// aof 的写入分为两步: 命令线程持有 lock 把命令追加到 a.buf 中, 每次事件循环结束时交给 writer(handoff);
+// writer goroutine 持有 a.mux 把数据写到文件中, 然后按照 appendfsync 执行 fsync。命令线程不会等待磁盘,
+// appendfsync 为 always 时写命令的回复先保留在客户端中, 等到 fsync 之后再发送, 一次 fsync 确认多个客户端的写入
+
+var errAofWriterStopped = errors.New("aof writer stopped")
+
+// aofWaiter 等待 aof fsync 到 offset 的客户端
+type aofWaiter struct {
+	client *Client
+	offset int64
+}
+
+func (a *Aof) startWriter() {
+	a.wakeup = make(chan struct{}, 1)
+	a.syncReq = make(chan chan error)
+	a.writerDone = make(chan struct{})
+	a.lastFsyncTime = time.Now()
+	go a.runWriter()
+}
+
+func (a *Aof) runWriter() {
+	defer close(a.writerDone)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.wakeup:
+			_ = a.writeAndFsync(false)
+		case <-ticker.C:
+			_ = a.writeAndFsync(false)
+		case reply := <-a.syncReq:
+			reply <- a.writeAndFsync(true)
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Aof) notifyWriter() {
+	if a.wakeup == nil {
+		return
+	}
+	select {
+	case a.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// handoff 把这一次事件循环中追加的数据交给 writer, 需要持有 lock
+func (a *Aof) handoff() {
+	if len(a.buf) == 0 {
+		return
+	}
+	a.pendingMux.Lock()
+	a.pending = append(a.pending, a.buf...)
+	a.pendingOffset = a.appendOffset
+	a.pendingMux.Unlock()
+	if cap(a.buf) > aofBufferSize {
+		a.buf = nil
+	} else {
+		a.buf = a.buf[:0]
+	}
+	a.notifyWriter()
+}
+
+// bufferLength 还没有写到文件中的字节数, 需要持有 lock
+func (a *Aof) bufferLength() int {
+	a.pendingMux.Lock()
+	defer a.pendingMux.Unlock()
+	return len(a.buf) + len(a.pending)
+}
+
+// flushAndSync 等待已经交给 writer 的数据写到文件中并且 fsync
+func (a *Aof) flushAndSync(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case a.syncReq <- reply:
+	case <-a.writerDone:
+		return errAofWriterStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *Aof) writeAndFsync(force bool) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	err := a.writeLocked(force)
+	// 磁盘出错的时候每次唤醒都会重试, 最多每秒记录一次日志
+	if err != nil && time.Since(a.lastWriteError) >= time.Second {
+		a.lastWriteError = time.Now()
+		a.lg.Errorf("write aof file failed with error: %v", err)
+	}
+	return err
+}
+
+// writeLocked 把 pending 写到文件中, appendfsync 为 always 或者 force 时马上 fsync, everysec 时距离上一次 fsync
+// 超过一秒才 fsync。然后唤醒等待的客户端。调用时持有 a.mux
+func (a *Aof) writeLocked(force bool) error {
+	if err := a.writePending(); err != nil {
+		return err
+	}
+	policy := a.fsyncPolicy()
+	if a.writtenOffset > a.fsyncedOffset {
+		start := time.Now()
+		if force || policy == FsyncAlways {
+			if err := a.file.Sync(); err != nil {
+				return err
+			}
+			latency.addSampleIfNeeded(latencyEventAofFsyncAlways, time.Since(start))
+			a.fsynced(start)
+		} else if policy == FsyncEverySec && start.Sub(a.lastFsyncTime) >= time.Second {
+			if err := a.file.Sync(); err != nil {
+				return err
+			}
+			latency.addSampleIfNeeded(latencyEventAofFsyncEverySec, time.Since(start))
+			a.fsynced(start)
+		}
+	}
+	// 只有 always 需要等待 fsync, 其他的策略写到文件中就可以回复
+	confirmed := a.writtenOffset
+	if policy == FsyncAlways {
+		confirmed = a.fsyncedOffset
+	}
+	if confirmed > a.syncedOffset.Load() {
+		a.wakeWaiters(confirmed)
+	}
+	return nil
+}
+
+func (a *Aof) fsynced(now time.Time) {
+	a.fsyncedOffset = a.writtenOffset
+	a.lastFsyncTime = now
+}
+
+// writePending 把交给 writer 的数据写到文件中, 没有写完的部分放回 pending 的前面, 下次重试。调用时持有 a.mux
+func (a *Aof) writePending() error {
+	a.pendingMux.Lock()
+	data, end := a.pending, a.pendingOffset
+	a.pending = a.spare[:0]
+	a.pendingMux.Unlock()
+	a.spare = nil
+	if len(data) == 0 {
+		a.spare = data
+		return nil
+	}
+	n, err := a.file.Write(data)
+	a.writtenOffset = end - int64(len(data)-n)
+	if err != nil {
+		rest := data[:copy(data, data[n:])]
+		a.pendingMux.Lock()
+		a.pending = append(rest, a.pending...)
+		a.pendingMux.Unlock()
+		return err
+	}
+	if cap(data) <= aofBufferSize {
+		a.spare = data[:0]
+	}
+	return nil
+}
+
+// waitFsync 客户端的回复等待 aof fsync 到 offset, 之后由 writer 唤醒。
+// 调用方检查 syncedOffset 之后 writer 可能已经确认并唤醒过了, 持有 pendingMux 再检查一次, 已经确认的话马上唤醒
+func (a *Aof) waitFsync(client *Client, offset int64) {
+	a.pendingMux.Lock()
+	if a.syncedOffset.Load() >= offset {
+		a.pendingMux.Unlock()
+		_ = client.conn.Wake(nil)
+		return
+	}
+	a.waiters = append(a.waiters, aofWaiter{client: client, offset: offset})
+	a.pendingMux.Unlock()
+}
+
+// wakeWaiters 更新 syncedOffset, 唤醒等待的数据已经确认的客户端, 在事件循环中发送保留的回复。
+// syncedOffset 和 waiters 都在持有 pendingMux 时修改, 和 waitFsync 之间不会丢失唤醒
+func (a *Aof) wakeWaiters(confirmed int64) {
+	a.pendingMux.Lock()
+	a.syncedOffset.Store(confirmed)
+	woken := make([]*Client, 0)
+	waiters := a.waiters[:0]
+	for _, waiter := range a.waiters {
+		if waiter.offset <= confirmed {
+			woken = append(woken, waiter.client)
+		} else {
+			waiters = append(waiters, waiter)
+		}
+	}
+	for i := len(waiters); i < len(a.waiters); i++ {
+		a.waiters[i] = aofWaiter{}
+	}
+	a.waiters = waiters
+	a.pendingMux.Unlock()
+	for _, client := range woken {
+		_ = client.conn.Wake(nil)
+	}
+}
+
+// holdReplies appendfsync 为 always 时, 写命令和之后的回复都先保留在客户端中
+func (r *RedisServer) holdReplies(conn *Client, cmd *Command) bool {
+	if r.aof == nil || conn.conn == nil || !cmd.hasFlag(cmdWrite) || r.aof.fsyncPolicy() != FsyncAlways {
+		return false
+	}
+	conn.holding = true
+	return true
+}
+
+// releaseReplies 客户端等待的数据已经确认时发送保留的回复, 否则等待 writer 唤醒。需要持有 lock
+func (r *RedisServer) releaseReplies(conn *Client) error {
+	if !conn.holding {
+		return nil
+	}
+	if r.aof != nil && r.aof.syncedOffset.Load() < conn.heldOffset {
+		if conn.waitingOffset < conn.heldOffset {
+			conn.waitingOffset = conn.heldOffset
+			r.aof.waitFsync(conn, conn.heldOffset)
+		}
+		return nil
+	}
+	return conn.releaseHeld()
+}
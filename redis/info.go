@@ -0,0 +1,279 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// infoSections INFO 输出的顺序, commandstats 只在 all 和 everything 中输出
+var infoSections = []struct {
+	name       string
+	isDefault  bool
+	generateFn func(r *RedisServer, b *strings.Builder)
+}{
+	{"server", true, (*RedisServer).infoServer},
+	{"clients", true, (*RedisServer).infoClients},
+	{"memory", true, (*RedisServer).infoMemory},
+	{"persistence", true, (*RedisServer).infoPersistence},
+	{"stats", true, (*RedisServer).infoStats},
+	{"replication", true, (*RedisServer).infoReplication},
+	{"cpu", true, (*RedisServer).infoCpu},
+	{"commandstats", false, (*RedisServer).infoCommandStats},
+	{"errorstats", true, (*RedisServer).infoErrorStats},
+	{"latencystats", true, (*RedisServer).infoLatencyStats},
+	{"keyspace", true, (*RedisServer).infoKeyspace},
+}
+
+// serverHz 定时任务每秒执行的次数
+const serverHz = 1
+
+// info 按照 infoSections 的顺序输出选中的部分, 不认识的部分会被忽略
+func (r *RedisServer) info(sections []string) string {
+	all, everything, defaults := false, false, len(sections) == 0
+	selected := make(map[string]bool)
+	for _, section := range sections {
+		switch section = strings.ToLower(section); section {
+		case "all":
+			all = true
+		case "everything":
+			everything = true
+		case "default":
+			defaults = true
+		default:
+			selected[section] = true
+		}
+	}
+	b := &strings.Builder{}
+	for _, section := range infoSections {
+		if !(all || everything || selected[section.name] || (defaults && section.isDefault)) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		section.generateFn(r, b)
+	}
+	return b.String()
+}
+
+func writeInfoTitle(b *strings.Builder, title string) {
+	b.WriteString("# " + title + "\r\n")
+}
+
+func writeInfoField(b *strings.Builder, name string, value interface{}) {
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(fmt.Sprint(value))
+	b.WriteString("\r\n")
+}
+
+func (r *RedisServer) infoServer(b *strings.Builder) {
+	now := time.Now()
+	uptime := int64(now.Sub(r.startTime).Seconds())
+	executable, _ := os.Executable()
+	writeInfoTitle(b, "Server")
+	writeInfoField(b, "redis_version", redisVersion)
+	writeInfoField(b, "redis_mode", "standalone")
+	writeInfoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	writeInfoField(b, "arch_bits", strconv.IntSize)
+	writeInfoField(b, "go_version", runtime.Version())
+	writeInfoField(b, "process_id", os.Getpid())
+	writeInfoField(b, "run_id", config.Properties.RunID)
+	writeInfoField(b, "tcp_port", config.Properties.Port)
+	writeInfoField(b, "server_time_usec", now.UnixMicro())
+	writeInfoField(b, "uptime_in_seconds", uptime)
+	writeInfoField(b, "uptime_in_days", uptime/(24*60*60))
+	writeInfoField(b, "hz", serverHz)
+	writeInfoField(b, "configured_hz", serverHz)
+	writeInfoField(b, "executable", executable)
+	writeInfoField(b, "config_file", config.Properties.CfPath)
+}
+
+func (r *RedisServer) infoClients(b *strings.Builder) {
+	var pausedClients int
+	var maxOutputBuffer int64
+	r.forEachClient(func(client *Client) bool {
+		if client.pauseBlocked {
+			pausedClients++
+		}
+		if size := client.outputBufferSize(); size > maxOutputBuffer {
+			maxOutputBuffer = size
+		}
+		return true
+	})
+	writeInfoTitle(b, "Clients")
+	writeInfoField(b, "connected_clients", ConnCounter.CountConnections())
+	writeInfoField(b, "maxclients", config.Properties.MaxClients)
+	writeInfoField(b, "client_recent_max_output_buffer", maxOutputBuffer)
+	writeInfoField(b, "blocked_clients", 0)
+	writeInfoField(b, "paused_clients", pausedClients)
+	writeInfoField(b, "tracking_clients", tracking.clientCount())
+	writeInfoField(b, "clients_in_timeout_table", 0)
+}
+
+func (r *RedisServer) infoMemory(b *strings.Builder) {
+	memStats := readMemStats()
+	stats.updatePeakMemory(memStats)
+	fragmentation := 0.0
+	if memStats.HeapAlloc > 0 {
+		fragmentation = float64(memStats.Sys) / float64(memStats.HeapAlloc)
+	}
+	writeInfoTitle(b, "Memory")
+	writeInfoField(b, "used_memory", memStats.HeapAlloc)
+	writeInfoField(b, "used_memory_human", util.HumanBytes(memStats.HeapAlloc))
+	// go 运行时向操作系统申请的内存, 近似于 rss
+	writeInfoField(b, "used_memory_rss", memStats.Sys)
+	writeInfoField(b, "used_memory_rss_human", util.HumanBytes(memStats.Sys))
+	writeInfoField(b, "used_memory_peak", stats.peakMemory)
+	writeInfoField(b, "used_memory_peak_human", util.HumanBytes(stats.peakMemory))
+	writeInfoField(b, "maxmemory", 0)
+	writeInfoField(b, "maxmemory_human", "0B")
+	writeInfoField(b, "maxmemory_policy", "noeviction")
+	writeInfoField(b, "mem_fragmentation_ratio", fmt.Sprintf("%.2f", fragmentation))
+	writeInfoField(b, "mem_allocator", "go")
+	writeInfoField(b, "heap_objects", memStats.HeapObjects)
+	writeInfoField(b, "gc_count", memStats.NumGC)
+	writeInfoField(b, "gc_pause_total_ms", memStats.PauseTotalNs/uint64(time.Millisecond))
+}
+
+func (r *RedisServer) infoPersistence(b *strings.Builder) {
+	writeInfoTitle(b, "Persistence")
+	writeInfoField(b, "loading", 0)
+	if r.aof == nil {
+		writeInfoField(b, "aof_enabled", 0)
+		writeInfoField(b, "aof_rewrite_in_progress", 0)
+		writeInfoField(b, "aof_rewrite_scheduled", 0)
+		writeInfoField(b, "aof_last_rewrite_time_sec", -1)
+		writeInfoField(b, "aof_current_rewrite_time_sec", -1)
+		writeInfoField(b, "aof_last_bgrewrite_status", "ok")
+		writeInfoField(b, "aof_rewrites", 0)
+		writeInfoField(b, "aof_rewrites_consecutive_failures", 0)
+		return
+	}
+	status := "ok"
+	if r.aof.lastRewriteFailed.Load() {
+		status = "err"
+	}
+	inProgress := 0
+	if r.aof.rewriteInProgress() {
+		inProgress = 1
+	}
+	currentSize, _ := r.aof.CurrentAofSize()
+	writeInfoField(b, "aof_enabled", 1)
+	writeInfoField(b, "aof_rewrite_in_progress", inProgress)
+	writeInfoField(b, "aof_rewrite_scheduled", 0)
+	writeInfoField(b, "aof_last_rewrite_time_sec", r.aof.lastRewriteTimeSec.Load())
+	writeInfoField(b, "aof_current_rewrite_time_sec", r.aof.currentRewriteTimeSec())
+	writeInfoField(b, "aof_last_bgrewrite_status", status)
+	writeInfoField(b, "aof_rewrites", r.aof.rewrites.Load())
+	writeInfoField(b, "aof_rewrites_consecutive_failures", r.aof.rewriteFailures.Load())
+	writeInfoField(b, "aof_current_size", currentSize)
+	writeInfoField(b, "aof_base_size", r.aof.LasAofRewriteSize())
+	writeInfoField(b, "aof_buffer_length", r.aof.bufferLength())
+}
+
+func (r *RedisServer) infoStats(b *strings.Builder) {
+	writeInfoTitle(b, "Stats")
+	writeInfoField(b, "total_connections_received", stats.numConnections)
+	writeInfoField(b, "total_commands_processed", stats.numCommands)
+	writeInfoField(b, "instantaneous_ops_per_sec", stats.opsPerSec)
+	writeInfoField(b, "rejected_connections", stats.rejectedConn)
+	writeInfoField(b, "expired_keys", stats.expiredKeys)
+	writeInfoField(b, "evicted_keys", stats.evictedKeys)
+	writeInfoField(b, "keyspace_hits", stats.keyspaceHits)
+	writeInfoField(b, "keyspace_misses", stats.keyspaceMisses)
+	writeInfoField(b, "tracking_total_keys", tracking.keyCount())
+	writeInfoField(b, "tracking_total_prefixes", len(tracking.prefixes))
+	writeInfoField(b, "total_error_replies", stats.totalErrorReplies)
+}
+
+func (r *RedisServer) infoReplication(b *strings.Builder) {
+	writeInfoTitle(b, "Replication")
+	writeInfoField(b, "role", "master")
+	writeInfoField(b, "connected_slaves", 0)
+	writeInfoField(b, "master_failover_state", "no-failover")
+	writeInfoField(b, "master_replid", r.replId)
+	writeInfoField(b, "master_replid2", strings.Repeat("0", 40))
+	writeInfoField(b, "master_repl_offset", 0)
+	writeInfoField(b, "second_repl_offset", -1)
+	writeInfoField(b, "repl_backlog_active", 0)
+	writeInfoField(b, "repl_backlog_size", 1024*1024)
+	writeInfoField(b, "repl_backlog_first_byte_offset", 0)
+	writeInfoField(b, "repl_backlog_histlen", 0)
+}
+
+func (r *RedisServer) infoCpu(b *strings.Builder) {
+	self, children := &syscall.Rusage{}, &syscall.Rusage{}
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, self)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, children)
+	seconds := func(tv syscall.Timeval) string {
+		return fmt.Sprintf("%.6f", float64(tv.Nano())/float64(time.Second))
+	}
+	writeInfoTitle(b, "CPU")
+	writeInfoField(b, "used_cpu_sys", seconds(self.Stime))
+	writeInfoField(b, "used_cpu_user", seconds(self.Utime))
+	writeInfoField(b, "used_cpu_sys_children", seconds(children.Stime))
+	writeInfoField(b, "used_cpu_user_children", seconds(children.Utime))
+}
+
+func (r *RedisServer) infoCommandStats(b *strings.Builder) {
+	names := make([]string, 0, len(stats.commands))
+	for name := range stats.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeInfoTitle(b, "Commandstats")
+	for _, name := range names {
+		stat := stats.commands[name]
+		usecPerCall := 0.0
+		if stat.calls > 0 {
+			usecPerCall = float64(stat.usec) / float64(stat.calls)
+		}
+		writeInfoField(b, "cmdstat_"+name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			stat.calls, stat.usec, usecPerCall, stat.rejectedCalls, stat.failedCalls))
+	}
+}
+
+func (r *RedisServer) infoErrorStats(b *strings.Builder) {
+	writeInfoTitle(b, "Errorstats")
+	for _, prefix := range stats.errorPrefixes() {
+		writeInfoField(b, "errorstat_"+prefix, fmt.Sprintf("count=%d", stats.errors[prefix]))
+	}
+}
+
+// infoLatencyStats 每个命令执行时间的 p50, p99 和 p99.9
+func (r *RedisServer) infoLatencyStats(b *strings.Builder) {
+	names := make([]string, 0, len(stats.commands))
+	for name, stat := range stats.commands {
+		if stat.latency != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	writeInfoTitle(b, "Latencystats")
+	for _, name := range names {
+		histogram := stats.commands[name].latency
+		writeInfoField(b, "latency_percentiles_usec_"+name, fmt.Sprintf("p50=%.3f,p99=%.3f,p99.9=%.3f",
+			float64(histogram.ValueAtPercentile(50)), float64(histogram.ValueAtPercentile(99)),
+			float64(histogram.ValueAtPercentile(99.9))))
+	}
+}
+
+func (r *RedisServer) infoKeyspace(b *strings.Builder) {
+	writeInfoTitle(b, "Keyspace")
+	for _, mdb := range r.dbs {
+		if mdb.Len() == 0 {
+			continue
+		}
+		writeInfoField(b, fmt.Sprintf("db%d", mdb.Index), fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0",
+			mdb.Len(), mdb.ExpiresLen()))
+	}
+}
@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func infoTitles(info string) []string {
+	titles := make([]string, 0)
+	for _, line := range strings.Split(info, "\r\n") {
+		if strings.HasPrefix(line, "# ") {
+			titles = append(titles, line[2:])
+		}
+	}
+	return titles
+}
+
+func TestInfoSections(t *testing.T) {
+	server := &RedisServer{dbs: initDbs(), connManager: NewManager(), startTime: time.Now()}
+	ConnCounter = server.connManager
+	defaults := []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "CPU", "Errorstats", "Latencystats", "Keyspace"}
+	assert.Equal(t, defaults, infoTitles(server.info(nil)))
+	assert.Equal(t, defaults, infoTitles(server.info([]string{"default"})))
+	assert.Contains(t, infoTitles(server.info([]string{"all"})), "Commandstats")
+	assert.Contains(t, infoTitles(server.info([]string{"everything"})), "Commandstats")
+	// 按照固定的顺序输出, 忽略不认识的部分
+	assert.Equal(t, []string{"CPU", "Keyspace"}, infoTitles(server.info([]string{"keyspace", "Cpu", "unknown"})))
+	assert.Empty(t, server.info([]string{"unknown"}))
+
+	assert.Contains(t, server.info([]string{"persistence"}), "aof_enabled:0\r\n")
+	server.dbs[1].PutEntity("key", nil)
+	assert.Contains(t, server.info([]string{"keyspace"}), "db1:keys=1,expires=0,avg_ttl=0\r\n")
+}
+
+func TestErrorStats(t *testing.T) {
+	stats.reset()
+	defer stats.reset()
+	stats.errorReply([]byte("-ERR unknown command\r\n"))
+	stats.errorReply([]byte("-WRONGTYPE Operation against a key\r\n"))
+	stats.errorReply([]byte("-ERR syntax error\r\n"))
+	stats.errorReply([]byte("-NOAUTH\r\n"))
+	assert.Equal(t, int64(4), stats.totalErrorReplies)
+	assert.Equal(t, []string{"ERR", "NOAUTH", "WRONGTYPE"}, stats.errorPrefixes())
+	assert.Equal(t, int64(2), stats.errors["ERR"])
+
+	cmd, _ := router("get")
+	stats.current = cmd
+	stats.keyLookup(true)
+	stats.keyLookup(false)
+	cmd, _ = router("set")
+	stats.current = cmd
+	stats.keyLookup(true)
+	assert.Equal(t, int64(1), stats.keyspaceHits)
+	assert.Equal(t, int64(1), stats.keyspaceMisses)
+}
+
+func TestStatsSample(t *testing.T) {
+	stats.reset()
+	defer stats.reset()
+	stats.numCommands = 10
+	stats.sample(&runtime.MemStats{HeapAlloc: 100})
+	assert.Equal(t, int64(10), stats.opsPerSec)
+	assert.Equal(t, uint64(100), stats.peakMemory)
+
+	stats.numCommands = 15
+	stats.sample(&runtime.MemStats{HeapAlloc: 50})
+	assert.Equal(t, int64(5), stats.opsPerSec)
+	assert.Equal(t, uint64(100), stats.peakMemory)
+}
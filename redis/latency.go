@@ -0,0 +1,222 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyTsLen 每个事件保存的样本数, 每秒最多一个样本
+const latencyTsLen = 160
+
+// 延迟监控的事件
+const (
+	latencyEventCommand          = "command"
+	latencyEventFastCommand      = "fast-command"
+	latencyEventAofFsyncAlways   = "aof-fsync-always"
+	latencyEventAofFsyncEverySec = "aof-fsync-everysec"
+	latencyEventAofRewriteDone   = "aof-rewrite-done"
+	latencyEventAofRename        = "aof-rename"
+	latencyEventExpireCycle      = "expire-cycle"
+	latencyEventDel              = "del"
+)
+
+type latencySample struct {
+	// time 秒级的时间戳
+	time int64
+	// latency 毫秒
+	latency int64
+}
+
+type latencyEvent struct {
+	// samples 环形缓冲区, idx 是下一个写入的位置
+	samples [latencyTsLen]latencySample
+	idx     int
+	// max 所有样本中最大的延迟
+	max int64
+}
+
+// latestSample 最新的样本
+func (e *latencyEvent) latestSample() latencySample {
+	return e.samples[(e.idx+latencyTsLen-1)%latencyTsLen]
+}
+
+// history 按照时间顺序返回所有的样本
+func (e *latencyEvent) history() []latencySample {
+	result := make([]latencySample, 0, latencyTsLen)
+	for i := 0; i < latencyTsLen; i++ {
+		sample := e.samples[(e.idx+i)%latencyTsLen]
+		if sample.time != 0 {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+// latencyMonitor 记录超过 latency-monitor-threshold 的延迟。
+// aof 的 fsync 和重写在其他的 goroutine 中执行, 所以需要加锁
+type latencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+var latency = &latencyMonitor{events: make(map[string]*latencyEvent)}
+
+// addSampleIfNeeded latency-monitor-threshold 为 0 时关闭延迟监控
+func (m *latencyMonitor) addSampleIfNeeded(event string, duration time.Duration) {
+	threshold := int64(config.Properties.LatencyMonitorThreshold)
+	ms := duration.Milliseconds()
+	if threshold <= 0 || ms < threshold {
+		return
+	}
+	m.addSample(event, time.Now().Unix(), ms)
+}
+
+// addSample 同一秒内的多个样本只保留最大的
+func (m *latencyMonitor) addSample(event string, now int64, ms int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	if ms > e.max {
+		e.max = ms
+	}
+	prev := &e.samples[(e.idx+latencyTsLen-1)%latencyTsLen]
+	if prev.time == now {
+		if ms > prev.latency {
+			prev.latency = ms
+		}
+		return
+	}
+	e.samples[e.idx] = latencySample{time: now, latency: ms}
+	e.idx = (e.idx + 1) % latencyTsLen
+}
+
+// eventNames 按照名称排序
+func (m *latencyMonitor) eventNames() []string {
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latest event, 最新样本的时间, 最新样本的延迟, 最大的延迟
+func (m *latencyMonitor) latest() []Reply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replies := make([]Reply, 0, len(m.events))
+	for _, name := range m.eventNames() {
+		e := m.events[name]
+		sample := e.latestSample()
+		replies = append(replies, MakeMultiRowReply([]Reply{
+			MakeBulkReply([]byte(name)),
+			MakeIntReply(sample.time),
+			MakeIntReply(sample.latency),
+			MakeIntReply(e.max),
+		}))
+	}
+	return replies
+}
+
+func (m *latencyMonitor) history(event string) []latencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.events[event]
+	if !ok {
+		return nil
+	}
+	return e.history()
+}
+
+// reset 没有指定事件时清空所有的事件, 返回清空的事件个数
+func (m *latencyMonitor) reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		count := len(m.events)
+		m.events = make(map[string]*latencyEvent)
+		return count
+	}
+	count := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			count++
+		}
+	}
+	return count
+}
+
+// latencyAdvices LATENCY DOCTOR 针对每种事件给出的建议
+var latencyAdvices = map[string]string{
+	latencyEventCommand: "Check your Slow Log to understand what are the commands you are running which are too slow " +
+		"to execute. Please check https://redis.io/commands/slowlog for more information.",
+	latencyEventFastCommand: "The system is slow to execute Redis code paths not containing system calls. " +
+		"This usually means the system does not provide Redis CPU time to run for long periods.",
+	latencyEventAofFsyncAlways: "Your fsync policy is set to 'always', replies to write commands wait for the fsync. It is very hard " +
+		"to get good performances with such a setup, if possible try to relax the fsync policy to 'everysec'.",
+	latencyEventAofFsyncEverySec: "The AOF fsync is slow, writes pile up in the AOF buffer while it runs. " +
+		"Consider using a faster disk, or check if other processes are doing I/O on the same disk.",
+	latencyEventAofRewriteDone: "Merging the rewritten AOF blocks writes to the AOF. Writing a lot while BGREWRITEAOF " +
+		"is running makes the merge slower.",
+	latencyEventAofRename: "Renaming the rewritten AOF is slow, check the file system holding the AOF.",
+	latencyEventExpireCycle: "Deleting expired keys is slow. Many keys are expiring at the same time, consider " +
+		"adding some random jitter to the expire times.",
+	latencyEventDel: "Deleting big keys blocks the server. Consider deleting big keys in smaller batches, " +
+		"for example removing elements from a big list before deleting it.",
+}
+
+// doctor 分析每个事件的样本, 生成给人看的报告
+func (m *latencyMonitor) doctor() string {
+	if config.Properties.LatencyMonitorThreshold <= 0 {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+			"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.events) == 0 {
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the " +
+			"slightest bit. I honestly think you ought to sleep better tonight.\n"
+	}
+	b := &strings.Builder{}
+	b.WriteString("Dave, I have observed latency spikes in this Redis instance. " +
+		"You don't mind talking about it, do you Dave?\n\n")
+	advices := make([]string, 0)
+	for i, name := range m.eventNames() {
+		e := m.events[name]
+		samples := e.history()
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.latency
+		}
+		avg := float64(sum) / float64(len(samples))
+		var deviation float64
+		for _, sample := range samples {
+			deviation += math.Abs(float64(sample.latency) - avg)
+		}
+		deviation /= float64(len(samples))
+		var period float64
+		if len(samples) > 1 {
+			period = float64(samples[len(samples)-1].time-samples[0].time) / float64(len(samples))
+		}
+		fmt.Fprintf(b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). "+
+			"Worst all time event %dms.\n", i+1, name, len(samples), int64(avg), int64(deviation), period, e.max)
+		if advice, ok := latencyAdvices[name]; ok {
+			advices = append(advices, advice)
+		}
+	}
+	b.WriteString("\nI have a few advices for you:\n\n")
+	for _, advice := range advices {
+		b.WriteString("- " + advice + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/config"
+	"testing"
+	"time"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	m := &latencyMonitor{events: make(map[string]*latencyEvent)}
+	m.addSample(latencyEventCommand, 100, 20)
+	// 同一秒内只保留最大的样本
+	m.addSample(latencyEventCommand, 100, 30)
+	m.addSample(latencyEventCommand, 100, 10)
+	m.addSample(latencyEventCommand, 101, 5)
+	assert.Equal(t, []latencySample{{100, 30}, {101, 5}}, m.history(latencyEventCommand))
+	assert.Equal(t, int64(30), m.events[latencyEventCommand].max)
+	assert.Equal(t, latencySample{101, 5}, m.events[latencyEventCommand].latestSample())
+
+	// 超过 latencyTsLen 之后覆盖最旧的样本
+	for i := int64(0); i < latencyTsLen+10; i++ {
+		m.addSample(latencyEventDel, 200+i, i)
+	}
+	history := m.history(latencyEventDel)
+	assert.Len(t, history, latencyTsLen)
+	assert.Equal(t, latencySample{210, 10}, history[0])
+
+	assert.Nil(t, m.history("unknown"))
+	assert.Equal(t, 1, m.reset(latencyEventDel, "unknown"))
+	assert.Equal(t, []string{latencyEventCommand}, m.eventNames())
+	assert.Equal(t, 1, m.reset())
+	assert.Empty(t, m.eventNames())
+}
+
+func TestLatencyThreshold(t *testing.T) {
+	m := &latencyMonitor{events: make(map[string]*latencyEvent)}
+	threshold := config.Properties.LatencyMonitorThreshold
+	defer func() {
+		config.Properties.LatencyMonitorThreshold = threshold
+	}()
+	config.Properties.LatencyMonitorThreshold = 0
+	m.addSampleIfNeeded(latencyEventCommand, time.Second)
+	assert.Empty(t, m.eventNames())
+	config.Properties.LatencyMonitorThreshold = 100
+	m.addSampleIfNeeded(latencyEventCommand, 99*time.Millisecond)
+	assert.Empty(t, m.eventNames())
+	m.addSampleIfNeeded(latencyEventCommand, 100*time.Millisecond)
+	assert.Equal(t, []string{latencyEventCommand}, m.eventNames())
+	assert.Contains(t, m.doctor(), "1. command: 1 latency spikes")
+}
@@ -0,0 +1,351 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsContentType prometheus 文本格式的版本
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsLatencyBuckets 命令执行时间直方图的上界(微秒), 1us 到 2^24us(约 16s) 的 2 的幂。
+// 每次抓取的桶都相同, prometheus 才能计算分位数
+var metricsLatencyBuckets = func() []int64 {
+	buckets := make([]int64, 0, 25)
+	for bound := int64(1); bound <= 1<<24; bound <<= 1 {
+		buckets = append(buckets, bound)
+	}
+	return buckets
+}()
+
+// commandMetrics 一个命令的统计的副本
+type commandMetrics struct {
+	name          string
+	calls         int64
+	usec          int64
+	rejectedCalls int64
+	failedCalls   int64
+	// buckets 执行时间小于等于 metricsLatencyBuckets 中每个上界的次数
+	buckets []int64
+}
+
+// dbMetrics 一个数据库的 key 的数量
+type dbMetrics struct {
+	index   int
+	keys    int
+	expires int
+}
+
+// metricsSnapshot 持有 lock 时复制出来的统计, 格式化的时候不再需要 lock
+type metricsSnapshot struct {
+	connectedClients  int
+	numConnections    int64
+	rejectedConn      int64
+	numCommands       int64
+	keyspaceHits      int64
+	keyspaceMisses    int64
+	expiredKeys       int64
+	evictedKeys       int64
+	totalErrorReplies int64
+	commands          []commandMetrics
+	dbs               []dbMetrics
+}
+
+// snapshotMetrics 只复制计数器, 持有 lock 的时间和命令的数量成正比
+func (r *RedisServer) snapshotMetrics() *metricsSnapshot {
+	lock.Lock()
+	defer lock.Unlock()
+	snapshot := &metricsSnapshot{
+		connectedClients:  ConnCounter.CountConnections(),
+		numConnections:    stats.numConnections,
+		rejectedConn:      stats.rejectedConn,
+		numCommands:       stats.numCommands,
+		keyspaceHits:      stats.keyspaceHits,
+		keyspaceMisses:    stats.keyspaceMisses,
+		expiredKeys:       stats.expiredKeys,
+		evictedKeys:       stats.evictedKeys,
+		totalErrorReplies: stats.totalErrorReplies,
+		commands:          make([]commandMetrics, 0, len(stats.commands)),
+		dbs:               make([]dbMetrics, 0, len(r.dbs)),
+	}
+	for name, stat := range stats.commands {
+		snapshot.commands = append(snapshot.commands, commandMetrics{
+			name:          name,
+			calls:         stat.calls,
+			usec:          stat.usec,
+			rejectedCalls: stat.rejectedCalls,
+			failedCalls:   stat.failedCalls,
+			buckets:       latencyBuckets(stat),
+		})
+	}
+	for _, mdb := range r.dbs {
+		snapshot.dbs = append(snapshot.dbs, dbMetrics{index: mdb.Index, keys: mdb.Len(), expires: mdb.ExpiresLen()})
+	}
+	return snapshot
+}
+
+// latencyBuckets 把执行时间的分布换算到固定的桶上, 超过最大记录的桶都等于总数
+func latencyBuckets(stat *commandStat) []int64 {
+	buckets := make([]int64, len(metricsLatencyBuckets))
+	if stat.latency == nil {
+		return buckets
+	}
+	cumulative := make(map[int64]int64)
+	stat.latency.ForEachPowerOfTwo(func(bound int64, count int64) {
+		cumulative[bound] = count
+	})
+	total := stat.latency.TotalCount()
+	for i, bound := range metricsLatencyBuckets {
+		count, ok := cumulative[bound]
+		if !ok {
+			count = total
+		}
+		buckets[i] = count
+	}
+	return buckets
+}
+
+// metricsWriter 输出 prometheus 的文本格式
+type metricsWriter struct {
+	b *strings.Builder
+}
+
+func (w *metricsWriter) header(name, metricType, help string) {
+	w.b.WriteString("# HELP " + name + " " + help + "\n")
+	w.b.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// sample labels 为 name value 交替出现
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			w.b.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(formatMetricValue(value))
+	w.b.WriteByte('\n')
+}
+
+// single 只有一个样本的指标
+func (w *metricsWriter) single(name, metricType, help string, value float64) {
+	w.header(name, metricType, help)
+	w.sample(name, value)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeMetrics 命令和数据库的统计来自 snapshot, aof 和 go 运行时的指标不需要 lock
+func (r *RedisServer) writeMetrics(b *strings.Builder, snapshot *metricsSnapshot) {
+	w := &metricsWriter{b: b}
+	w.single("godis_uptime_seconds", "gauge", "Number of seconds since the server started.",
+		time.Since(r.startTime).Seconds())
+	w.single("godis_connected_clients", "gauge", "Number of client connections.",
+		float64(snapshot.connectedClients))
+	w.single("godis_connections_received_total", "counter", "Total number of connections accepted by the server.",
+		float64(snapshot.numConnections))
+	w.single("godis_rejected_connections_total", "counter", "Number of connections rejected because of maxclients.",
+		float64(snapshot.rejectedConn))
+	w.single("godis_commands_processed_total", "counter", "Total number of commands processed by the server.",
+		float64(snapshot.numCommands))
+	w.single("godis_error_replies_total", "counter", "Total number of error replies.",
+		float64(snapshot.totalErrorReplies))
+	w.single("godis_keyspace_hits_total", "counter", "Number of successful lookups of keys.",
+		float64(snapshot.keyspaceHits))
+	w.single("godis_keyspace_misses_total", "counter", "Number of failed lookups of keys.",
+		float64(snapshot.keyspaceMisses))
+	w.single("godis_expired_keys_total", "counter", "Total number of key expiration events.",
+		float64(snapshot.expiredKeys))
+	w.single("godis_evicted_keys_total", "counter", "Number of evicted keys due to maxmemory limit.",
+		float64(snapshot.evictedKeys))
+	r.writeCommandMetrics(w, snapshot.commands)
+	writeDbMetrics(w, snapshot.dbs)
+	r.writeAofMetrics(w)
+	writeRuntimeMetrics(w)
+}
+
+func (r *RedisServer) writeCommandMetrics(w *metricsWriter, commands []commandMetrics) {
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].name < commands[j].name
+	})
+	w.header("godis_commands_total", "counter", "Total number of calls per command.")
+	for _, cmd := range commands {
+		w.sample("godis_commands_total", float64(cmd.calls), "cmd", cmd.name)
+	}
+	w.header("godis_commands_rejected_calls_total", "counter", "Number of calls rejected before execution per command.")
+	for _, cmd := range commands {
+		w.sample("godis_commands_rejected_calls_total", float64(cmd.rejectedCalls), "cmd", cmd.name)
+	}
+	w.header("godis_commands_failed_calls_total", "counter", "Number of calls that returned an error per command.")
+	for _, cmd := range commands {
+		w.sample("godis_commands_failed_calls_total", float64(cmd.failedCalls), "cmd", cmd.name)
+	}
+	w.header("godis_commands_duration_seconds", "histogram", "Execution time of commands.")
+	for _, cmd := range commands {
+		// 被拒绝的命令只有 rejectedCalls, 没有执行时间
+		if cmd.calls == 0 {
+			continue
+		}
+		for i, bound := range metricsLatencyBuckets {
+			le := formatMetricValue(float64(bound) / float64(time.Second/time.Microsecond))
+			w.sample("godis_commands_duration_seconds_bucket", float64(cmd.buckets[i]), "cmd", cmd.name, "le", le)
+		}
+		w.sample("godis_commands_duration_seconds_bucket", float64(cmd.calls), "cmd", cmd.name, "le", "+Inf")
+		w.sample("godis_commands_duration_seconds_sum", time.Duration(cmd.usec*int64(time.Microsecond)).Seconds(),
+			"cmd", cmd.name)
+		w.sample("godis_commands_duration_seconds_count", float64(cmd.calls), "cmd", cmd.name)
+	}
+}
+
+// writeDbMetrics 和 INFO keyspace 一样不输出空的数据库
+func writeDbMetrics(w *metricsWriter, dbs []dbMetrics) {
+	w.header("godis_db_keys", "gauge", "Number of keys per database.")
+	for _, mdb := range dbs {
+		if mdb.keys > 0 {
+			w.sample("godis_db_keys", float64(mdb.keys), "db", "db"+strconv.Itoa(mdb.index))
+		}
+	}
+	w.header("godis_db_keys_expiring", "gauge", "Number of keys with an expiration per database.")
+	for _, mdb := range dbs {
+		if mdb.keys > 0 {
+			w.sample("godis_db_keys_expiring", float64(mdb.expires), "db", "db"+strconv.Itoa(mdb.index))
+		}
+	}
+}
+
+func (r *RedisServer) writeAofMetrics(w *metricsWriter) {
+	w.single("godis_aof_enabled", "gauge", "Whether the append only file is enabled.", boolMetric(r.aof != nil))
+	if r.aof == nil {
+		return
+	}
+	currentSize, _ := r.aof.CurrentAofSize()
+	w.single("godis_aof_current_size_bytes", "gauge", "Current size of the append only file.",
+		float64(currentSize))
+	w.single("godis_aof_base_size_bytes", "gauge", "Size of the append only file after the last rewrite.",
+		float64(r.aof.LasAofRewriteSize()))
+	w.single("godis_aof_rewrite_in_progress", "gauge", "Whether an append only file rewrite is in progress.",
+		boolMetric(r.aof.rewriteInProgress()))
+	w.single("godis_aof_last_rewrite_duration_seconds", "gauge",
+		"Duration of the last append only file rewrite, -1 if never rewritten.",
+		float64(r.aof.lastRewriteTimeSec.Load()))
+	w.single("godis_aof_current_rewrite_duration_seconds", "gauge",
+		"Duration of the ongoing append only file rewrite, -1 if no rewrite is in progress.",
+		float64(r.aof.currentRewriteTimeSec()))
+	w.single("godis_aof_last_bgrewrite_status", "gauge", "Whether the last append only file rewrite succeeded.",
+		boolMetric(!r.aof.lastRewriteFailed.Load()))
+}
+
+// writeRuntimeMetrics 和 prometheus go client 的 go_memstats 同名
+func writeRuntimeMetrics(w *metricsWriter) {
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+	w.single("go_goroutines", "gauge", "Number of goroutines that currently exist.",
+		float64(runtime.NumGoroutine()))
+	w.single("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.",
+		float64(memStats.Alloc))
+	w.single("go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.",
+		float64(memStats.TotalAlloc))
+	w.single("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.",
+		float64(memStats.Sys))
+	w.single("go_memstats_mallocs_total", "counter", "Total number of mallocs.",
+		float64(memStats.Mallocs))
+	w.single("go_memstats_frees_total", "counter", "Total number of frees.",
+		float64(memStats.Frees))
+	w.single("go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.",
+		float64(memStats.HeapAlloc))
+	w.single("go_memstats_heap_sys_bytes", "gauge", "Number of heap bytes obtained from system.",
+		float64(memStats.HeapSys))
+	w.single("go_memstats_heap_idle_bytes", "gauge", "Number of heap bytes waiting to be used.",
+		float64(memStats.HeapIdle))
+	w.single("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.",
+		float64(memStats.HeapInuse))
+	w.single("go_memstats_heap_released_bytes", "gauge", "Number of heap bytes released to OS.",
+		float64(memStats.HeapReleased))
+	w.single("go_memstats_heap_objects", "gauge", "Number of allocated objects.",
+		float64(memStats.HeapObjects))
+	w.single("go_memstats_stack_inuse_bytes", "gauge", "Number of bytes in use by the stack allocator.",
+		float64(memStats.StackInuse))
+	w.single("go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.",
+		float64(memStats.NextGC))
+	w.single("go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.",
+		float64(memStats.LastGC)/float64(time.Second))
+	w.single("go_gc_cycles_total", "counter", "Number of completed GC cycles.",
+		float64(memStats.NumGC))
+	w.single("go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.",
+		float64(memStats.PauseTotalNs)/float64(time.Second))
+}
+
+// serveMetrics 只持有 lock 复制统计, 格式化和 ReadMemStats 都在 lock 之外
+func (r *RedisServer) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b := &strings.Builder{}
+	r.writeMetrics(b, r.snapshotMetrics())
+	w.Header().Set("Content-Type", metricsContentType)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// startMetrics metrics-port 不为 0 时在 bind 地址上启动 http 服务
+func (r *RedisServer) startMetrics() error {
+	port := config.Properties.MetricsPort
+	if port == 0 {
+		return nil
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(config.Properties.Bind, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", r.serveMetrics)
+	r.metrics = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := r.metrics.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.lg.Errorf("metrics server stopped with error: %v", err)
+		}
+	}()
+	r.lg.Infof("Metrics are available on http://%s/metrics", listener.Addr())
+	return nil
+}
+
+func (r *RedisServer) stopMetrics(ctx context.Context) error {
+	if r.metrics == nil {
+		return nil
+	}
+	if err := r.metrics.Shutdown(ctx); err != nil {
+		return fmt.Errorf("stop metrics server: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"github.com/panjf2000/gnet/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/config"
+	"github.com/xuning888/godis-tiny/pkg/logger"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	stats.reset()
+	defer stats.reset()
+	server := &RedisServer{dbs: initDbs(), connManager: NewManager(), startTime: time.Now()}
+	ConnCounter = server.connManager
+	server.dbs[2].PutEntity("key", nil)
+
+	get, _ := router("get")
+	stats.call(get, 3*time.Microsecond, false)
+	stats.call(get, 100*time.Microsecond, true)
+	stats.reject(get)
+	set, _ := router("set")
+	stats.reject(set)
+	stats.expiredKeys = 5
+
+	b := &strings.Builder{}
+	server.writeMetrics(b, server.snapshotMetrics())
+	metrics := b.String()
+	assert.Contains(t, metrics, "# TYPE godis_connected_clients gauge\ngodis_connected_clients 0\n")
+	assert.Contains(t, metrics, "godis_expired_keys_total 5\n")
+	assert.Contains(t, metrics, "godis_commands_total{cmd=\"get\"} 2\n")
+	assert.Contains(t, metrics, "godis_commands_rejected_calls_total{cmd=\"set\"} 1\n")
+	assert.Contains(t, metrics, "godis_commands_failed_calls_total{cmd=\"get\"} 1\n")
+	assert.Contains(t, metrics, "godis_commands_duration_seconds_bucket{cmd=\"get\",le=\"2e-06\"} 0\n")
+	assert.Contains(t, metrics, "godis_commands_duration_seconds_bucket{cmd=\"get\",le=\"4e-06\"} 1\n")
+	assert.Contains(t, metrics, "godis_commands_duration_seconds_bucket{cmd=\"get\",le=\"0.000128\"} 2\n")
+	assert.Contains(t, metrics, "godis_commands_duration_seconds_bucket{cmd=\"get\",le=\"16.777216\"} 2\n")
+	assert.Contains(t, metrics, "godis_commands_duration_seconds_bucket{cmd=\"get\",le=\"+Inf\"} 2\n")
+	assert.Contains(t, metrics, "godis_commands_duration_seconds_sum{cmd=\"get\"} 0.000103\n")
+	// 没有执行过的命令没有直方图
+	assert.NotContains(t, metrics, "godis_commands_duration_seconds_count{cmd=\"set\"}")
+	assert.Contains(t, metrics, "godis_db_keys{db=\"db2\"} 1\n")
+	assert.NotContains(t, metrics, "godis_db_keys{db=\"db0\"}")
+	assert.Contains(t, metrics, "godis_aof_enabled 0\n")
+	assert.Contains(t, metrics, "go_memstats_heap_alloc_bytes ")
+}
+
+func TestServeMetrics(t *testing.T) {
+	server := &RedisServer{dbs: initDbs(), connManager: NewManager(), startTime: time.Now()}
+	ConnCounter = server.connManager
+
+	recorder := httptest.NewRecorder()
+	server.serveMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, metricsContentType, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "godis_uptime_seconds ")
+
+	recorder = httptest.NewRecorder()
+	server.serveMetrics(recorder, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+// openConn OnOpen 只用到 Fd 和 RemoteAddr
+type openConn struct {
+	gnet.Conn
+	fd int
+}
+
+func (c *openConn) Fd() int {
+	return c.fd
+}
+
+func (c *openConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// TestServeMetricsWhileAccepting 事件循环接受连接的同时 metrics 的 goroutine 读取统计, 需要使用 -race 运行
+func TestServeMetricsWhileAccepting(t *testing.T) {
+	stats.reset()
+	defer stats.reset()
+	maxClients := config.Properties.MaxClients
+	config.Properties.MaxClients = 100
+	t.Cleanup(func() {
+		config.Properties.MaxClients = maxClients
+	})
+	server := &RedisServer{dbs: initDbs(), connManager: NewManager(), startTime: time.Now(),
+		lg: logger.Named("metrics-test")}
+	ConnCounter = server.connManager
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for fd := 1; fd <= 105; fd++ {
+			_, _ = server.OnOpen(&openConn{fd: fd})
+		}
+	}()
+	for scraping := true; scraping; {
+		select {
+		case <-done:
+			scraping = false
+		default:
+		}
+		recorder := httptest.NewRecorder()
+		server.serveMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	recorder := httptest.NewRecorder()
+	server.serveMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), "godis_connected_clients 100\n")
+	assert.Contains(t, recorder.Body.String(), "godis_connections_received_total 105\n")
+	assert.Contains(t, recorder.Body.String(), "godis_rejected_connections_total 5\n")
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabelValue("a\"b\\c\nd"))
+}
@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// redactedArg 敏感命令的参数在 MONITOR 和慢日志中被替换为这个值
+var redactedArg = []byte("(redacted)")
+
+// withSensitiveArgs 标记命令的参数包含密码等敏感信息
+func (cmd *Command) withSensitiveArgs(sensitiveArgs func(cmdLine [][]byte) []int) *Command {
+	cmd.sensitiveArgs = sensitiveArgs
+	return cmd
+}
+
+// allArgsSensitive 除了命令名之外的参数都需要隐藏
+func allArgsSensitive(cmdLine [][]byte) []int {
+	positions := make([]int, 0, len(cmdLine))
+	for i := 1; i < len(cmdLine); i++ {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// redactedCmdLine 隐藏敏感的参数, 返回新的命令
+func redactedCmdLine(cmd *Command, cmdLine [][]byte) [][]byte {
+	if cmd == nil || cmd.sensitiveArgs == nil {
+		return cmdLine
+	}
+	positions := cmd.sensitiveArgs(cmdLine)
+	if len(positions) == 0 {
+		return cmdLine
+	}
+	result := make([][]byte, len(cmdLine))
+	copy(result, cmdLine)
+	for _, i := range positions {
+		if i < len(result) {
+			result[i] = redactedArg
+		}
+	}
+	return result
+}
+
+// monitorLine +<timestamp> [<db> <addr>] "arg" "arg" ..., 参数的格式和 redis 的 sdscatrepr 相同
+func monitorLine(now time.Time, dbIndex int, addr string, cmdLine [][]byte) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "+%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, dbIndex, addr)
+	for _, arg := range cmdLine {
+		buf.WriteByte(' ')
+		writeRepr(buf, arg)
+	}
+	buf.WriteString(CRLF)
+	return buf.Bytes()
+}
+
+// writeRepr 用双引号包起来, 转义引号、反斜杠和不可打印的字符
+func writeRepr(buf *bytes.Buffer, arg []byte) {
+	buf.WriteByte('"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\a':
+			buf.WriteString("\\a")
+		case '\b':
+			buf.WriteString("\\b")
+		default:
+			if b >= 0x20 && b < 0x7f {
+				buf.WriteByte(b)
+			} else {
+				fmt.Fprintf(buf, "\\x%02x", b)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// feedMonitors 把客户端执行的命令发送给所有的 monitor, 管理命令不会发送。
+// 和命令的回复一样写入 monitor 的输出缓冲区, 跟不上的 monitor 超过 client-output-buffer-limit 之后被关闭
+func (r *RedisServer) feedMonitors(conn *Client, cmd *Command, now time.Time) {
+	if r.connManager == nil || r.connManager.MonitorCount() == 0 || cmd.hasFlag(cmdAdmin) {
+		return
+	}
+	line := monitorLine(now, conn.GetDbIndex(), conn.addr(), redactedCmdLine(cmd, conn.GetCmdLine()))
+	r.connManager.ForEachMonitor(func(monitor *Client) bool {
+		if monitor.conn == nil || monitor.closeASAP {
+			return true
+		}
+		_, err := monitor.Write(line)
+		if err == nil {
+			err = monitor.Flush()
+		}
+		if err != nil {
+			r.lg.Errorf("write to monitor %s failed with error: %v", monitor.addr(), err)
+			return true
+		}
+		if monitor.outputBufferLimitReached(time.Now()) {
+			r.lg.Warnf("Client %s scheduled to be closed ASAP for overcoming of output buffer limits.", monitor.info())
+			monitor.closeAsap()
+		}
+		return true
+	})
+}
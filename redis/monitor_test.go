@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/pkg/logger"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorLine(t *testing.T) {
+	now := time.Unix(1339518083, 107412000)
+	cmdLine := [][]byte{[]byte("set"), []byte("k\"ey"), []byte("a\r\n\\\x00\xff b")}
+	assert.Equal(t, `+1339518083.107412 [0 127.0.0.1:60866] "set" "k\"ey" "a\r\n\\\x00\xff b"`+"\r\n",
+		string(monitorLine(now, 0, "127.0.0.1:60866", cmdLine)))
+}
+
+func TestRedactedCmdLine(t *testing.T) {
+	auth, _ := router("auth")
+	get, _ := router("get")
+	cmdLine := [][]byte{[]byte("AUTH"), []byte("user"), []byte("password")}
+	assert.Equal(t, [][]byte{[]byte("AUTH"), redactedArg, redactedArg}, redactedCmdLine(auth, cmdLine))
+	cmdLine = [][]byte{[]byte("get"), []byte("key")}
+	assert.Equal(t, cmdLine, redactedCmdLine(get, cmdLine))
+
+	hello, _ := router("hello")
+	cmdLine = [][]byte{[]byte("hello"), []byte("3"), []byte("auth"), []byte("user"), []byte("password"),
+		[]byte("setname"), []byte("name")}
+	assert.Equal(t, [][]byte{[]byte("hello"), []byte("3"), []byte("auth"), redactedArg, redactedArg,
+		[]byte("setname"), []byte("name")}, redactedCmdLine(hello, cmdLine))
+	assert.Equal(t, "password", string(cmdLine[4]))
+}
+
+func TestMonitorCleanup(t *testing.T) {
+	manager := NewManager()
+	client := NewClient(10, nil, false)
+	manager.RegisterConn(10, client)
+	manager.AddMonitor(client)
+	assert.Equal(t, 1, manager.MonitorCount())
+	assert.Contains(t, client.flagString(), "O")
+	manager.RemoveConnByKey(10)
+	assert.Equal(t, 0, manager.MonitorCount())
+}
+
+// slowMonitorConn 对端不读取数据, 写出的内容都留在 gnet 的输出缓冲区中
+type slowMonitorConn struct {
+	replyConn
+	closed bool
+}
+
+func (c *slowMonitorConn) OutboundBuffered() int {
+	return c.replies.Len()
+}
+
+func (c *slowMonitorConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestFeedMonitorsOutputLimit(t *testing.T) {
+	limits := outputBufferLimits
+	t.Cleanup(func() {
+		outputBufferLimits = limits
+	})
+	server := makeTempServer()
+	server.lg = logger.Named("monitor-test")
+	server.connManager = NewManager()
+	monitorConn := &slowMonitorConn{}
+	monitor := NewClient(1, monitorConn, false)
+	server.connManager.RegisterConn(1, monitor)
+	server.connManager.AddMonitor(monitor)
+	client := NewClient(2, &replyConn{}, false)
+	run := func(cmdLine string) {
+		fields := strings.Fields(cmdLine)
+		client.PushCmd(util.ToCmdLine(fields[0], fields[1:]...))
+		assert.Nil(t, server.process(context.Background(), client))
+	}
+
+	// monitor 的输出和回复一样经过 Client.Write, 计入输出缓冲区
+	run("set k v")
+	assert.True(t, strings.HasSuffix(monitorConn.replies.String(), `"set" "k" "v"`+"\r\n"), monitorConn.replies.String())
+	assert.Equal(t, int64(monitorConn.replies.Len()), monitor.outputBufferSize())
+	assert.Equal(t, monitorConn.replies.Len(), monitor.totalReplyBytes)
+
+	// 超过 hard limit 之后关闭 monitor, 不再继续写入
+	outputBufferLimits = map[string]outputBufferLimit{"normal": {hard: 256}}
+	for i := 0; i < 10 && !monitor.closeASAP; i++ {
+		run("get k")
+	}
+	assert.True(t, monitor.closeASAP)
+	assert.True(t, monitorConn.closed)
+	size := monitorConn.replies.Len()
+	run("get k")
+	assert.Equal(t, size, monitorConn.replies.Len())
+	// 执行命令的客户端不受影响
+	assert.False(t, client.closeASAP)
+}
+
+func TestMonitorIdleTimeout(t *testing.T) {
+	client := NewClient(0, nil, false)
+	now := client.lastInteraction.Add(3 * time.Second)
+	assert.True(t, client.idleTimeout(now, 2*time.Second))
+	NewManager().AddMonitor(client)
+	assert.False(t, client.idleTimeout(now, 2*time.Second))
+}
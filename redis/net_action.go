@@ -6,6 +6,7 @@ import (
 	"github.com/panjf2000/gnet/v2"
 	"github.com/xuning888/godis-tiny/config"
 	"io"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -18,14 +19,19 @@ func (r *RedisServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
 }
 
 func (r *RedisServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
-	if r.status == statusShutdown {
+	if atomic.LoadUint32(&r.status) == statusShutdown {
 		return MakeStandardErrReply("ERR Server is shutting down").ToBytes(), gnet.Close
 	}
+	// 统计和 conns 也会被 metrics 的 goroutine 读取, 和命令执行一样持有 lock
+	lock.Lock()
+	defer lock.Unlock()
 	connectedClients := ConnCounter.CountConnections()
 	maxClients := config.Properties.MaxClients
 
 	// 如果连接数达到了最大值, 就拒绝连接
+	stats.numConnections++
 	if connectedClients >= maxClients {
+		stats.rejectedConn++
 		r.lg.Infof("max number of clients reached. clients_connected: %v, maxclinets: %v",
 			connectedClients, maxClients)
 		return MakeStandardErrReply("ERR max number of clients reached").ToBytes(), gnet.Close
@@ -46,7 +52,7 @@ func (r *RedisServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
 	} else {
 		r.lg.Debugf("conn: %v, closed", remoteAddr)
 	}
-	r.connManager.RemoveConnByKey(c.Fd())
+	r.freeClient(c.Fd())
 	return
 }
 
@@ -57,13 +63,25 @@ func (r *RedisServer) OnTick() (delay time.Duration, action gnet.Action) {
 
 func (r *RedisServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 	conn := r.connManager.Get(c.Fd())
+	// writer 在 aof fsync 之后唤醒等待的客户端
+	if conn.holding {
+		lock.Lock()
+		r.loopLocked = true
+		err := r.releaseReplies(conn)
+		r.loopLocked = false
+		lock.Unlock()
+		if err != nil {
+			r.lg.Errorf("write to peer falied with error: %v", err)
+			return gnet.Close
+		}
+	}
 	err := conn.Decode()
 	if err != nil && !conn.HasRemaining() {
 		if errors.Is(err, ErrIncompletePacket) {
 			return gnet.None
 		}
 		r.lg.Errorf("decode falied with error: %v", err)
-		_, err := conn.Write(MakeStandardErrReply(err.Error()).ToBytes())
+		err = MakeStandardErrReply(err.Error()).WriteTo(conn)
 		if err != nil {
 			r.lg.Errorf("write to peer falied with error: %v", err)
 		}
@@ -82,7 +100,7 @@ func (r *RedisServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 			return gnet.None
 		}
 		r.lg.Errorf("decode falied with error: %v", err)
-		_, err = conn.Write(MakeStandardErrReply(err.Error()).ToBytes())
+		err = MakeStandardErrReply(err.Error()).WriteTo(conn)
 		if err != nil {
 			r.lg.Errorf("write to peer falied with error: %v", err)
 		}
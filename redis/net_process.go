@@ -20,31 +20,50 @@ var (
 
 func (r *RedisServer) Init() {
 	begin := time.Now()
-	r.loadAof()
+	if err := r.loadAof(); err != nil {
+		r.lg.Fatalf("Fatal error loading the DB: %v. Exiting.", err)
+	}
 	r.lg.Infof("DB loaded from append only file: %.3f seconds", time.Now().Sub(begin).Seconds())
 }
 
-func (r *RedisServer) loadAof() {
+func (r *RedisServer) loadAof() error {
 	processWait.Add(1)
 	defer processWait.Done()
 	if config.Properties.AppendOnly {
-		r.aof.LoadAof(0)
+		return r.aof.LoadAof()
 	}
+	return nil
 }
 
 func (r *RedisServer) cron() {
-	systemClient.PushCmd(ttlOpsCmdLine)
-	if err := r.process(context.Background(), systemClient); err != nil {
-		return
+	now := time.Now()
+	r.clientsCron(now)
+	// 暂停期间不清理过期的 key
+	if !clientPause.active(now) {
+		systemClient.PushCmd(ttlOpsCmdLine)
+		if err := r.process(context.Background(), systemClient); err != nil {
+			return
+		}
 	}
+	r.evictTrackingKeys()
+	r.statsCron()
 	// 触发aof重写
-	//r.doAofRewrite()
+	r.doAofRewrite()
 }
 
 func (r *RedisServer) process(ctx context.Context, conn *Client) error {
 	lock.Lock()
+	r.loopLocked = true
 	processWait.Add(1)
 	defer func() {
+		// 每次事件循环结束时把 aof 交给 writer, 已经确认的回复马上发送
+		if r.aof != nil {
+			r.aof.handoff()
+			if err := r.releaseReplies(conn); err != nil {
+				r.lg.Errorf("write to peer falied with error: %v", err)
+			}
+		}
+		r.loopLocked = false
 		lock.Unlock()
 		processWait.Done()
 	}()
@@ -56,8 +75,21 @@ func (r *RedisServer) process(ctx context.Context, conn *Client) error {
 	conn.Rewrite = r.rewrite
 	conn.RangeCheck = r.RangeCheck
 	conn.ClearDatabase = r.clear
+	conn.ForEachClient = r.forEachClient
+	conn.Info = r.info
+	conn.Monitor = r.addMonitor
 
 	for conn.HasRemaining() {
+		// CLIENT PAUSE 期间命令留在队列中, 暂停结束之后唤醒客户端继续执行
+		now := time.Now()
+		if clientPause.blocks(conn, now) {
+			if !conn.pauseBlocked {
+				conn.pauseBlocked = true
+				clientPause.wakeAfterPause(conn, now)
+			}
+			return nil
+		}
+		conn.pauseBlocked = false
 		dbIndex := conn.GetDbIndex()
 		mdb, err := r.SelectDb(dbIndex)
 		if err != nil {
@@ -71,15 +103,28 @@ func (r *RedisServer) process(ctx context.Context, conn *Client) error {
 		if err = r.processCmd(ctx, conn); err != nil {
 			return err
 		}
+		if conn.outputBufferLimitReached(time.Now()) {
+			r.lg.Warnf("Client %s scheduled to be closed ASAP for overcoming of output buffer limits.", conn.info())
+			conn.closeAsap()
+			return nil
+		}
 	}
 	return nil
 }
 
 func (r *RedisServer) processCmd(ctx context.Context, conn *Client) error {
+	// CLIENT REPLY SKIP 跳过的是下一个命令的回复
+	conn.replySkip = conn.replySkipNext
+	conn.replySkipNext = false
+	// CLIENT CACHING 也只对下一个命令生效
+	conn.caching = conn.cachingNext
+	conn.cachingNext = cachingDefault
 	defer func() {
 		conn.curCommand = nil
+		conn.replySkip = false
 	}()
 	_ = conn.PollCmd()
+	conn.lastInteraction = time.Now()
 	cmdName := conn.GetCmdName()
 	cmd, err := router(cmdName)
 	if err != nil {
@@ -90,10 +135,50 @@ func (r *RedisServer) processCmd(ctx context.Context, conn *Client) error {
 		}
 		return MakeUnknownCommand(cmdName, with...).WriteTo(conn)
 	}
-	if cmdName != "ttlops" {
+	if !cmd.checkArity(len(conn.GetCmdLine())) {
+		stats.reject(cmd)
+		return MakeNumberOfArgsErrReply(cmdName).WriteTo(conn)
+	}
+	if reply := acl.checkCommand(conn, cmd); reply != nil {
+		stats.reject(cmd)
+		return reply.WriteTo(conn)
+	}
+	conn.lastCmd = cmd.name
+	if cmdName != "ttlops" && !clientPause.active(conn.lastInteraction) {
 		conn.GetDb().RandomCheckTTLAndClearV1()
 	}
-	return cmd.process(ctx, conn)
+	if cmd.hasFlag(cmdWrite) {
+		// 写命令可能把参数保存到数据库中, 不能引用 codec 复用的内存
+		conn.detachArgs()
+		if r.aof != nil {
+			r.aof.beforeWrite(conn.GetDb(), cmd, conn.GetCmdLine())
+		}
+	}
+	tracking.enter(conn, cmd)
+	defer tracking.leave()
+	if conn.IsInner() {
+		return cmd.process(ctx, conn)
+	}
+	stats.numCommands++
+	stats.current = cmd
+	errorReplies := stats.totalErrorReplies
+	holding := r.holdReplies(conn, cmd)
+	start := time.Now()
+	err = cmd.process(ctx, conn)
+	duration := time.Since(start)
+	if holding {
+		conn.heldOffset = r.aof.appendOffset
+	}
+	stats.call(cmd, duration, stats.totalErrorReplies > errorReplies)
+	stats.current = nil
+	slowlog.record(conn, redactedCmdLine(cmd, conn.GetCmdLine()), duration)
+	r.feedMonitors(conn, cmd, start)
+	if cmd.hasFlag(cmdFast) {
+		latency.addSampleIfNeeded(latencyEventFastCommand, duration)
+	} else {
+		latency.addSampleIfNeeded(latencyEventCommand, duration)
+	}
+	return err
 }
 
 func (r *RedisServer) SelectDb(index int) (*DB, error) {
@@ -117,20 +202,93 @@ func (r *RedisServer) ForEach(dbIndex int, cb func(key string, entity *obj.Redis
 	}
 }
 
+func (r *RedisServer) forEachClient(cb func(client *Client) bool) {
+	if r.connManager != nil {
+		r.connManager.ForEach(cb)
+	}
+}
+
+// clientsCron 恢复暂停的客户端, 关闭空闲的客户端
+func (r *RedisServer) clientsCron(now time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+	if clientPause.expired(now) {
+		unpauseClients(r.forEachClient)
+	}
+	timeout := time.Duration(config.Properties.Timeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+	r.forEachClient(func(client *Client) bool {
+		if client.idleTimeout(now, timeout) {
+			r.lg.Debugf("Closing idle client: %s", client.info())
+			client.closeAsap()
+		}
+		return true
+	})
+}
+
+// evictTrackingKeys tracking 记录的 key 太多时淘汰一部分, 并通知客户端
+func (r *RedisServer) evictTrackingKeys() {
+	lock.Lock()
+	defer lock.Unlock()
+	tracking.evict(config.Properties.TrackingTableMaxKeys)
+}
+
+// statsCron 采样每秒执行的命令数和内存使用的峰值
+func (r *RedisServer) statsCron() {
+	// ReadMemStats 会 stop the world, 在 lock 之外采样, 只在保存结果的时候持有 lock
+	memStats := readMemStats()
+	lock.Lock()
+	defer lock.Unlock()
+	stats.sample(memStats)
+}
+
+func (r *RedisServer) addMonitor(client *Client) {
+	if r.connManager != nil {
+		r.connManager.AddMonitor(client)
+	}
+}
+
+// freeClient 连接关闭之后释放客户端。回复写入失败时 gnet 在 Write 中同步地调用 OnClose,
+// 这时事件循环已经持有 lock, 不能再次获取
+func (r *RedisServer) freeClient(fd int) {
+	if !r.loopLocked {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	if conn := r.connManager.Get(fd); conn != nil {
+		tracking.disable(conn)
+	}
+	r.connManager.RemoveConnByKey(fd)
+}
+
 func (r *RedisServer) clear() {
+	start := time.Now()
 	if r.dbs != nil && len(r.dbs) > 0 {
 		for _, mdb := range r.dbs {
 			mdb.RandomCheckTTLAndClearV1()
 		}
 	}
+	latency.addSampleIfNeeded(latencyEventExpireCycle, time.Since(start))
 }
 
 func (r *RedisServer) rewrite() error {
 	return r.aof.Rewrite()
 }
 
+// persistenceInProgress 是否有正在进行的持久化后台任务, 这时不开始新的重写。目前只有 aof 重写一种后台任务
+func (r *RedisServer) persistenceInProgress() bool {
+	return r.aof != nil && r.aof.rewriteInProgress()
+}
+
+// doAofRewrite aof 比上一次重写之后增长了 auto-aof-rewrite-percentage 并且不小于 auto-aof-rewrite-min-size 时
+// 在后台重写。有后台任务或者连续失败被限制的时候推迟到之后的 cron 再检查
 func (r *RedisServer) doAofRewrite() {
-	if !config.Properties.AppendOnly {
+	if !config.Properties.AppendOnly || r.aof == nil || config.Properties.AofRewritePercentage <= 0 {
+		return
+	}
+	if r.persistenceInProgress() {
 		return
 	}
 	defer r.lg.Sync()
@@ -140,28 +298,30 @@ func (r *RedisServer) doAofRewrite() {
 		r.lg.Errorf("check aof filesize failed with error: %v", err)
 		return
 	}
-	// 上一次aof重写后的大小
-	lastAofRewriteSize := r.aof.LasAofRewriteSize()
-	// 计算aof文件的增长量
-	aofSizeIncrease := currentAofFileSize - lastAofRewriteSize
-
-	if aofSizeIncrease <= 0 {
+	if currentAofFileSize < int64(config.Properties.AofRewriteMinSize) {
 		return
 	}
+	// 上一次aof重写后的大小
+	base := r.aof.LasAofRewriteSize()
+	if base <= 0 {
+		base = 1
+	}
 	// 计算当前增长的百分比是否超过设置的阈值
-	rewriteNeeded := (aofSizeIncrease >= int64(float64(lastAofRewriteSize)*float64(config.Properties.AofRewritePercentage)/100.0)) &&
-		(currentAofFileSize >= int64(config.Properties.AofRewriteMinSize))
-
-	if rewriteNeeded {
-		go func() {
-			err2 := r.aof.Rewrite()
-			if err2 != nil {
-				if !errors.Is(err2, ErrAofRewriteIsRunning) {
-					r.lg.Errorf("aof rewrite failed with error: %v", err2)
-				}
-			} else {
-				r.lg.Info("aof rewrite successfully")
-			}
-		}()
+	growth := currentAofFileSize*100/base - 100
+	if growth < int64(config.Properties.AofRewritePercentage) {
+		return
 	}
+	if limited, delay := r.aof.limiter.limited(r.aof.rewriteFailures.Load(), time.Now()); limited {
+		if delay > 0 {
+			r.lg.Warnf("Background AOF rewrite has repeatedly failed and triggered the limit, will retry in %d minutes",
+				delay)
+		}
+		return
+	}
+	r.lg.Infof("Starting automatic rewriting of AOF on %d%% growth", growth)
+	go func() {
+		if err := r.aof.Rewrite(); err != nil && !errors.Is(err, ErrAofRewriteIsRunning) {
+			r.lg.Errorf("Background AOF rewrite failed with error: %v", err)
+		}
+	}()
 }
@@ -9,6 +9,8 @@ import (
 	"github.com/xuning888/godis-tiny/pkg/datastruct/dict"
 	"github.com/xuning888/godis-tiny/pkg/datastruct/ttl"
 	"github.com/xuning888/godis-tiny/pkg/logger"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync/atomic"
@@ -22,6 +24,9 @@ var (
 
 var defaultTimeout = 60
 
+// redisVersion 兼容的 redis 版本, 通过 HELLO 和 INFO 返回给客户端
+const redisVersion = "7.0.0"
+
 const (
 	_ = iota
 	statusInitialized
@@ -40,6 +45,11 @@ type RedisServer struct {
 	status                  uint32                     // server status
 	lg                      logger.Logger              // log
 	signalWaiter            func(err chan error) error // for shutdown
+	startTime               time.Time                  // INFO uptime_in_seconds
+	replId                  string                     // INFO master_replid
+	metrics                 *http.Server               // prometheus metrics
+	// loopLocked 事件循环正在持有 lock, 只在事件循环中访问
+	loopLocked bool
 }
 
 func waitSignal(errCh chan error) error {
@@ -72,6 +82,12 @@ func (r *RedisServer) Spin() {
 		return
 	}
 
+	if err := r.startMetrics(); err != nil {
+		r.lg.Errorf("Failed listening on metrics port %d: %v", config.Properties.MetricsPort, err)
+		atomic.StoreUint32(&r.status, statusClosed)
+		return
+	}
+
 	errCh := make(chan error)
 	address := fmt.Sprintf("tcp://%s:%d", config.Properties.Bind, config.Properties.Port)
 	go func() {
@@ -122,6 +138,10 @@ func (r *RedisServer) Shutdown(ctx context.Context) (err error) {
 		r.lg.Errorf("stop dbEngine failed with error: %v", err)
 	}
 
+	if err = r.stopMetrics(ctx); err != nil {
+		r.lg.Errorf("%v", err)
+	}
+
 	// stop network engine
 	if err = r.engine.Stop(ctx); err != nil {
 		r.lg.Errorf("stop network engine failed with error: %v", err)
@@ -159,23 +179,31 @@ func (r *RedisServer) shutdown0(ctx context.Context) (err error) {
 }
 
 func NewRedisServer() *RedisServer {
-	server := &RedisServer{}
+	server := &RedisServer{startTime: time.Now(), replId: util.RandStr(40)}
 	server.connManager = NewManager()
 	ConnCounter = server.connManager
 	server.dbs = initDbs()
+	server.bindTracking()
+	server.bindStats()
+
+	if err := acl.Init(); err != nil {
+		panic(err)
+	}
+	if err := applyOutputBufferLimits(); err != nil {
+		panic(fmt.Errorf("client-output-buffer-limit: %v", err))
+	}
 
 	if config.Properties.AppendOnly {
 		aofServer, err := NewAof(
-			server.process, config.Properties.AppendFilename, config.Properties.AppendFsync, func() (Exec, ForEach) {
-				tempServer := makeTempServer()
-				return tempServer.process, tempServer.ForEach
-			})
+			server.process, config.AppendDirPath(), config.Properties.AppendFilename, config.Properties.AppendFilename,
+			config.Properties.AppendFsync)
 		if err != nil {
 			panic(err)
 		}
 		server.bindPersister(aofServer)
 	}
 
+	server.bindConfig()
 	server.status = statusInitialized
 	server.lg = logger.Named("redis-server")
 	return server
@@ -195,8 +223,42 @@ func makeTempServer() *RedisServer {
 	return server
 }
 
+// bindConfig 注册 CONFIG SET 之后的热加载, 其他可以修改的配置在使用的时候直接读取
+func (r *RedisServer) bindConfig() {
+	config.OnChange("appendfsync", func() error {
+		if r.aof != nil {
+			r.aof.SetFsync(config.Properties.AppendFsync)
+		}
+		return nil
+	})
+	config.OnChange("requirepass", func() error {
+		acl.setRequirePass(config.Properties.RequirePass)
+		return nil
+	})
+	config.OnChange("client-output-buffer-limit", applyOutputBufferLimits)
+}
+
+// bindTracking CLIENT TRACKING 需要知道 key 的读写
+func (r *RedisServer) bindTracking() {
+	tracking.clientById = r.connManager.GetById
+	for _, mDb := range r.dbs {
+		mDb.KeyRead = tracking.keyRead
+		mDb.KeyModified = tracking.keyModified
+		mDb.Flushed = tracking.flushed
+	}
+}
+
+// bindStats INFO 需要统计 key 的命中和过期
+func (r *RedisServer) bindStats() {
+	for _, mDb := range r.dbs {
+		mDb.KeyLookup = stats.keyLookup
+		mDb.KeyExpired = stats.keyExpired
+	}
+}
+
 func (r *RedisServer) bindPersister(aof *Aof) {
 	r.aof = aof
+	aof.selectDb = r.SelectDb
 	for _, ddb := range r.dbs {
 		mDb := ddb
 		mDb.AddAof = func(cmdLine [][]byte) {
@@ -143,7 +143,13 @@ func (m *MultiBulkReply) WriteTo(client *Client) error {
 	}
 
 	for _, arg := range m.Args {
-		if _, err := client.Write(MakeBulkReply(arg).ToBytes()); err != nil {
+		var data []byte
+		if arg == nil {
+			data = nullBytesOf(client)
+		} else {
+			data = MakeBulkReply(arg).ToBytes()
+		}
+		if _, err := client.Write(data); err != nil {
 			return err
 		}
 	}
@@ -53,7 +53,7 @@ func MakePongReply() *PongReply {
 type NullBulkReply struct{}
 
 func (n *NullBulkReply) WriteTo(client *Client) error {
-	if _, err := client.Write(nullBulkReplyBytes); err != nil {
+	if _, err := client.Write(nullBytesOf(client)); err != nil {
 		return err
 	}
 	return client.Flush()
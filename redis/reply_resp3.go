@@ -0,0 +1,237 @@
+package redis
+
+import (
+	"bytes"
+	"github.com/xuning888/godis-tiny/pkg/resp"
+)
+
+// 客户端使用的协议版本, 通过 HELLO 命令协商
+const (
+	resp2 = 2
+	resp3 = 3
+)
+
+var (
+	resp3NullBytes  = []byte("_" + CRLF)
+	resp3TrueBytes  = []byte("#t" + CRLF)
+	resp3FalseBytes = []byte("#f" + CRLF)
+)
+
+// nullBytesOf 根据客户端的协议版本返回空值, RESP2 使用 $-1, RESP3 使用 _
+func nullBytesOf(client *Client) []byte {
+	if client.IsResp3() {
+		return resp3NullBytes
+	}
+	return nullBulkReplyBytes
+}
+
+// writeReplies 依次写出一组回复, 每个回复都会按照客户端的协议版本编码
+func writeReplies(client *Client, replies []Reply) error {
+	for _, reply := range replies {
+		if err := reply.WriteTo(client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MapReply RESP3 的 map 类型(%), 对 RESP2 客户端退化为 key value 交替出现的数组
+type MapReply struct {
+	pairs []Reply
+}
+
+func (m *MapReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		return MakeMultiRowReply(m.pairs).WriteTo(client)
+	}
+	if _, err := client.Write(smallTypeLineWithNum('%', len(m.pairs)/2)); err != nil {
+		return err
+	}
+	if err := writeReplies(client, m.pairs); err != nil {
+		return err
+	}
+	return client.Flush()
+}
+
+func (m *MapReply) ToBytes() []byte {
+	return MakeMultiRowReply(m.pairs).ToBytes()
+}
+
+// MakeMapReply pairs 中 key value 交替出现
+func MakeMapReply(pairs []Reply) *MapReply {
+	if pairs == nil {
+		pairs = make([]Reply, 0)
+	}
+	return &MapReply{pairs: pairs}
+}
+
+// SetReply RESP3 的 set 类型(~), 对 RESP2 客户端退化为数组
+type SetReply struct {
+	members [][]byte
+}
+
+func (s *SetReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		return MakeMultiBulkReply(s.members).WriteTo(client)
+	}
+	if _, err := client.Write(smallTypeLineWithNum('~', len(s.members))); err != nil {
+		return err
+	}
+	for _, member := range s.members {
+		if _, err := client.Write(MakeBulkReply(member).ToBytes()); err != nil {
+			return err
+		}
+	}
+	return client.Flush()
+}
+
+func (s *SetReply) ToBytes() []byte {
+	return MakeMultiBulkReply(s.members).ToBytes()
+}
+
+func MakeSetReply(members [][]byte) *SetReply {
+	if members == nil {
+		members = make([][]byte, 0)
+	}
+	return &SetReply{members: members}
+}
+
+// DoubleReply RESP3 的 double 类型(,), 对 RESP2 客户端退化为 bulk string
+type DoubleReply struct {
+	Num float64
+}
+
+func (d *DoubleReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		return MakeBulkReply([]byte(resp.FormatDouble(d.Num))).WriteTo(client)
+	}
+	if _, err := client.Write([]byte("," + resp.FormatDouble(d.Num) + CRLF)); err != nil {
+		return err
+	}
+	return client.Flush()
+}
+
+func (d *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(resp.FormatDouble(d.Num))).ToBytes()
+}
+
+func MakeDoubleReply(num float64) *DoubleReply {
+	return &DoubleReply{Num: num}
+}
+
+// BoolReply RESP3 的 boolean 类型(#), 对 RESP2 客户端退化为 :1 或者 :0
+type BoolReply struct {
+	Value bool
+}
+
+func (b *BoolReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		if _, err := client.Write(b.ToBytes()); err != nil {
+			return err
+		}
+		return client.Flush()
+	}
+	data := resp3FalseBytes
+	if b.Value {
+		data = resp3TrueBytes
+	}
+	if _, err := client.Write(data); err != nil {
+		return err
+	}
+	return client.Flush()
+}
+
+func (b *BoolReply) ToBytes() []byte {
+	if b.Value {
+		return smallTypeLineWithNum(':', 1)
+	}
+	return smallTypeLineWithNum(':', 0)
+}
+
+func MakeBoolReply(value bool) *BoolReply {
+	return &BoolReply{Value: value}
+}
+
+// BigNumberReply RESP3 的 big number 类型((), 对 RESP2 客户端退化为 bulk string
+type BigNumberReply struct {
+	Num string
+}
+
+func (b *BigNumberReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		return MakeBulkReply([]byte(b.Num)).WriteTo(client)
+	}
+	if _, err := client.Write([]byte("(" + b.Num + CRLF)); err != nil {
+		return err
+	}
+	return client.Flush()
+}
+
+func (b *BigNumberReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(b.Num)).ToBytes()
+}
+
+func MakeBigNumberReply(num string) *BigNumberReply {
+	return &BigNumberReply{Num: num}
+}
+
+// VerbatimReply RESP3 的 verbatim string 类型(=), 对 RESP2 客户端退化为 bulk string
+type VerbatimReply struct {
+	// Format 三个字节的格式, 如 txt, mkd
+	Format string
+	Text   []byte
+}
+
+func (v *VerbatimReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		return MakeBulkReply(v.Text).WriteTo(client)
+	}
+	if _, err := client.Write(v.resp3Bytes()); err != nil {
+		return err
+	}
+	return client.Flush()
+}
+
+func (v *VerbatimReply) resp3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(smallTypeLineWithNum('=', len(v.Text)+4))
+	buf.WriteString(v.Format)
+	buf.WriteByte(':')
+	buf.Write(v.Text)
+	buf.Write(CRLFBytes)
+	return buf.Bytes()
+}
+
+func (v *VerbatimReply) ToBytes() []byte {
+	return MakeBulkReply(v.Text).ToBytes()
+}
+
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
+
+// PushReply RESP3 的 push 类型(>), 用于服务端主动推送的消息, 对 RESP2 客户端退化为数组
+type PushReply struct {
+	replies []Reply
+}
+
+func (p *PushReply) WriteTo(client *Client) error {
+	if !client.IsResp3() {
+		return MakeMultiRowReply(p.replies).WriteTo(client)
+	}
+	if _, err := client.Write(smallTypeLineWithNum('>', len(p.replies))); err != nil {
+		return err
+	}
+	if err := writeReplies(client, p.replies); err != nil {
+		return err
+	}
+	return client.Flush()
+}
+
+func (p *PushReply) ToBytes() []byte {
+	return MakeMultiRowReply(p.replies).ToBytes()
+}
+
+func MakePushReply(replies []Reply) *PushReply {
+	return &PushReply{replies: replies}
+}
@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/godis-tiny/pkg/util"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestResp3Replies(t *testing.T) {
+	testCases := []struct {
+		name  string
+		reply Reply
+		resp2 string
+		resp3 string
+	}{
+		{"map", MakeMapReply([]Reply{MakeBulkReply([]byte("k")), MakeIntReply(1)}),
+			"*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{"empty map", MakeMapReply(nil), "*0\r\n", "%0\r\n"},
+		{"nested map", MakeMapReply([]Reply{MakeBulkReply([]byte("m")), MakeMapReply([]Reply{MakeBulkReply([]byte("a")), MakeNullBulkReply()})}),
+			"*2\r\n$1\r\nm\r\n*2\r\n$1\r\na\r\n$-1\r\n", "%1\r\n$1\r\nm\r\n%1\r\n$1\r\na\r\n_\r\n"},
+		{"set", MakeSetReply(util.ToCmdLine("a", "b")), "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"empty set", MakeSetReply(nil), "*0\r\n", "~0\r\n"},
+		{"double", MakeDoubleReply(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"double integral", MakeDoubleReply(3), "$1\r\n3\r\n", ",3\r\n"},
+		{"double inf", MakeDoubleReply(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"true", MakeBoolReply(true), ":1\r\n", "#t\r\n"},
+		{"false", MakeBoolReply(false), ":0\r\n", "#f\r\n"},
+		{"null", MakeNullBulkReply(), "$-1\r\n", "_\r\n"},
+		{"null in array", MakeMultiBulkReply([][]byte{[]byte("a"), nil}), "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{"big number", MakeBigNumberReply("3492890328409238509324850943850943825024385"),
+			"$43\r\n3492890328409238509324850943850943825024385\r\n", "(3492890328409238509324850943850943825024385\r\n"},
+		{"verbatim", MakeVerbatimReply("txt", []byte("Some string")), "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+		{"push", MakePushReply([]Reply{MakeBulkReply([]byte("invalidate")), MakeMultiBulkReply(util.ToCmdLine("k"))}),
+			"*2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n", ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n"},
+		// RESP2 的类型不受协议版本的影响
+		{"bulk", MakeBulkReply([]byte("v")), "$1\r\nv\r\n", "$1\r\nv\r\n"},
+		{"int", MakeIntReply(-1), ":-1\r\n", ":-1\r\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, protocol := range []int{resp2, resp3} {
+				conn := &replyConn{}
+				client := NewClient(0, conn, false)
+				client.SetProtocol(protocol)
+				assert.Nil(t, tc.reply.WriteTo(client))
+				expected := tc.resp2
+				if protocol == resp3 {
+					expected = tc.resp3
+				}
+				assert.Equal(t, expected, conn.replies.String(), "resp%d", protocol)
+			}
+			// ToBytes 用于 aof 等内部场景, 始终是 RESP2 的编码
+			assert.Equal(t, tc.resp2, string(tc.reply.ToBytes()))
+		})
+	}
+}
+
+// newResp3Client 返回的 run 依次执行命令, 返回最后一条命令的回复
+func newResp3Client(t *testing.T, server *RedisServer) (*Client, func(args ...string) string) {
+	conn := &replyConn{}
+	client := NewClient(0, conn, false)
+	return client, func(args ...string) string {
+		conn.replies.Reset()
+		client.PushCmd(util.ToCmdLine(args[0], args[1:]...))
+		assert.Nil(t, server.process(context.Background(), client))
+		return conn.replies.String()
+	}
+}
+
+func TestHelloProtocol(t *testing.T) {
+	server := makeTempServer()
+	client, run := newResp3Client(t, server)
+	run("hset", "h", "f", "v")
+	run("sadd", "s", "a")
+
+	// 默认使用 RESP2, 输出与之前完全一致
+	resp2Replies := map[string]string{
+		"hgetall h":   "*2\r\n$1\r\nf\r\n$1\r\nv\r\n",
+		"hgetall no":  "*0\r\n",
+		"smembers s":  "*1\r\n$1\r\na\r\n",
+		"get no":      "$-1\r\n",
+		"hget h no":   "$-1\r\n",
+		"mget no h":   "*2\r\n$-1\r\n$-1\r\n",
+		"set k v":     "+OK\r\n",
+		"ping":        "+PONG\r\n",
+		"smembers no": "*0\r\n",
+	}
+	for cmdLine, expected := range resp2Replies {
+		assert.Equal(t, expected, run(strings.Fields(cmdLine)...), cmdLine)
+	}
+	assert.False(t, client.IsResp3())
+
+	reply := run("hello", "3")
+	assert.True(t, strings.HasPrefix(reply, "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n"), reply)
+	assert.Contains(t, reply, "$5\r\nproto\r\n:3\r\n")
+	assert.Contains(t, reply, "$7\r\nmodules\r\n*0\r\n")
+	assert.True(t, client.IsResp3())
+
+	resp3Replies := map[string]string{
+		"hgetall h":   "%1\r\n$1\r\nf\r\n$1\r\nv\r\n",
+		"hgetall no":  "%0\r\n",
+		"smembers s":  "~1\r\n$1\r\na\r\n",
+		"smembers no": "~0\r\n",
+		"get no":      "_\r\n",
+		"hget h no":   "_\r\n",
+		"mget no h":   "*2\r\n_\r\n_\r\n",
+		"set k v":     "+OK\r\n",
+		"get k":       "$1\r\nv\r\n",
+	}
+	for cmdLine, expected := range resp3Replies {
+		assert.Equal(t, expected, run(strings.Fields(cmdLine)...), cmdLine)
+	}
+
+	// 不指定版本时保持当前的协议
+	assert.True(t, strings.HasPrefix(run("hello"), "%7\r\n"))
+	assert.True(t, client.IsResp3())
+
+	// 切换回 RESP2 之后回复也切换回来, HELLO 本身的回复是数组
+	reply = run("hello", "2")
+	assert.True(t, strings.HasPrefix(reply, "*14\r\n$6\r\nserver\r\n"), reply)
+	assert.Contains(t, reply, "$5\r\nproto\r\n:2\r\n")
+	assert.False(t, client.IsResp3())
+	for cmdLine, expected := range resp2Replies {
+		assert.Equal(t, expected, run(strings.Fields(cmdLine)...), cmdLine)
+	}
+}
+
+func TestHelloErrors(t *testing.T) {
+	server := makeTempServer()
+	client, run := newResp3Client(t, server)
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"hello", "4"}, "-NOPROTO unsupported protocol version\r\n"},
+		{[]string{"hello", "1"}, "-NOPROTO unsupported protocol version\r\n"},
+		{[]string{"hello", "abc"}, "-ERR Protocol version is not an integer or out of range\r\n"},
+		{[]string{"hello", "3", "foo"}, "-ERR Syntax error in HELLO option 'foo'\r\n"},
+		{[]string{"hello", "3", "auth", "default"}, "-ERR Syntax error in HELLO option 'auth'\r\n"},
+		{[]string{"hello", "3", "setname"}, "-ERR Syntax error in HELLO option 'setname'\r\n"},
+		{[]string{"hello", "3", "setname", "a b"}, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, run(tc.args...), strings.Join(tc.args, " "))
+		// 出错时不切换协议, 也不修改名称
+		assert.False(t, client.IsResp3())
+		assert.Equal(t, "", client.GetName())
+	}
+}
+
+func TestHelloAuthAndSetName(t *testing.T) {
+	acl.setRequirePass("secret")
+	t.Cleanup(func() {
+		acl.setRequirePass("")
+		acl.resetLog()
+	})
+	server := makeTempServer()
+	client, run := newResp3Client(t, server)
+
+	assert.True(t, strings.HasPrefix(run("get", "k"), "-NOAUTH"))
+	assert.True(t, strings.HasPrefix(run("hello", "3"), "-NOAUTH HELLO must be called"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n",
+		run("hello", "3", "auth", "default", "wrong", "setname", "conn1"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n",
+		run("hello", "3", "auth", "nobody", "secret"))
+	assert.False(t, client.IsResp3())
+	assert.Equal(t, "", client.GetName())
+
+	// 认证、设置名称和切换协议在一条命令中完成
+	reply := run("hello", "3", "AUTH", "default", "secret", "SETNAME", "conn1")
+	assert.True(t, strings.HasPrefix(reply, "%7\r\n"), reply)
+	assert.True(t, client.IsResp3())
+	assert.Equal(t, "conn1", client.GetName())
+	assert.Equal(t, "_\r\n", run("get", "k"))
+
+	// 已经认证的客户端不需要再次提供密码
+	reply = run("hello", "2", "setname", "conn2")
+	assert.True(t, strings.HasPrefix(reply, "*14\r\n"), reply)
+	assert.Equal(t, "conn2", client.GetName())
+	assert.Equal(t, "$-1\r\n", run("get", "k"))
+}
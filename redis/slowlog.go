@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/xuning888/godis-tiny/config"
+	"time"
+)
+
+const (
+	// slowLogEntryMaxArgc 每条记录最多保存的参数个数
+	slowLogEntryMaxArgc = 32
+	// slowLogEntryMaxString 每个参数最多保存的字节数
+	slowLogEntryMaxString = 128
+)
+
+type slowLogEntry struct {
+	id        int64
+	timestamp int64
+	// duration 命令执行的微秒数
+	duration   int64
+	args       [][]byte
+	clientAddr string
+	clientName string
+}
+
+// slowLog 执行时间超过 slowlog-log-slower-than 的命令, 最多保存 slowlog-max-len 条
+type slowLog struct {
+	// entries 环形缓冲区, 写满之后 head 指向最旧的记录
+	entries []*slowLogEntry
+	head    int
+	nextId  int64
+}
+
+var slowlog = &slowLog{}
+
+// record 命令执行完之后调用, slowlog-log-slower-than 为负数时关闭
+func (s *slowLog) record(conn *Client, cmdLine [][]byte, duration time.Duration) {
+	slowerThan := int64(config.Properties.SlowlogLogSlowerThan)
+	if slowerThan < 0 || duration.Microseconds() < slowerThan {
+		return
+	}
+	s.add(&slowLogEntry{
+		timestamp:  time.Now().Unix(),
+		duration:   duration.Microseconds(),
+		args:       slowLogArgs(cmdLine),
+		clientAddr: conn.addr(),
+		clientName: conn.name,
+	}, config.Properties.SlowlogMaxLen)
+}
+
+// add 写满之后覆盖最旧的记录
+func (s *slowLog) add(entry *slowLogEntry, maxLen int) {
+	entry.id = s.nextId
+	s.nextId++
+	switch {
+	case maxLen <= 0:
+		s.reset()
+	case len(s.entries) == maxLen:
+		s.entries[s.head] = entry
+		s.head = (s.head + 1) % maxLen
+	case len(s.entries) < maxLen && s.head == 0:
+		s.entries = append(s.entries, entry)
+	default:
+		// slowlog-max-len 修改过, 按照从旧到新的顺序重新排列, 只保留最新的 maxLen-1 条
+		entries := s.latest(maxLen - 1)
+		reverse(entries)
+		s.entries = append(entries, entry)
+		s.head = 0
+	}
+}
+
+// latest 最新的 n 条记录, 最新的在最前边
+func (s *slowLog) latest(n int) []*slowLogEntry {
+	if n < 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+	result := make([]*slowLogEntry, n)
+	for i := 0; i < n; i++ {
+		result[i] = s.entries[(s.head+len(s.entries)-1-i)%len(s.entries)]
+	}
+	return result
+}
+
+func (s *slowLog) len() int {
+	return len(s.entries)
+}
+
+func (s *slowLog) reset() {
+	s.entries = nil
+	s.head = 0
+}
+
+func reverse(entries []*slowLogEntry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
+
+// slowLogArgs 参数太多或者太长时截断, 避免慢日志占用太多内存
+func slowLogArgs(cmdLine [][]byte) [][]byte {
+	argc := len(cmdLine)
+	if argc > slowLogEntryMaxArgc {
+		argc = slowLogEntryMaxArgc
+	}
+	args := make([][]byte, argc)
+	for i := 0; i < argc; i++ {
+		if i == argc-1 && argc != len(cmdLine) {
+			args[i] = []byte(fmt.Sprintf("... (%d more arguments)", len(cmdLine)-argc+1))
+			break
+		}
+		arg := cmdLine[i]
+		if len(arg) > slowLogEntryMaxString {
+			args[i] = []byte(fmt.Sprintf("%s... (%d more bytes)", arg[:slowLogEntryMaxString],
+				len(arg)-slowLogEntryMaxString))
+			continue
+		}
+		// 复制一份, 命令的参数可能会被复用
+		args[i] = append([]byte(nil), arg...)
+	}
+	return args
+}
+
+func (e *slowLogEntry) toReply() Reply {
+	return MakeMultiRowReply([]Reply{
+		MakeIntReply(e.id),
+		MakeIntReply(e.timestamp),
+		MakeIntReply(e.duration),
+		MakeMultiBulkReply(e.args),
+		MakeBulkReply([]byte(e.clientAddr)),
+		MakeBulkReply([]byte(e.clientName)),
+	})
+}
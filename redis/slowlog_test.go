@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func slowLogIds(entries []*slowLogEntry) []int64 {
+	ids := make([]int64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.id
+	}
+	return ids
+}
+
+func TestSlowLogRing(t *testing.T) {
+	s := &slowLog{}
+	for i := 0; i < 5; i++ {
+		s.add(&slowLogEntry{}, 3)
+	}
+	assert.Equal(t, 3, s.len())
+	assert.Equal(t, []int64{4, 3, 2}, slowLogIds(s.latest(-1)))
+	assert.Equal(t, []int64{4, 3}, slowLogIds(s.latest(2)))
+
+	// slowlog-max-len 变大之后继续追加
+	s.add(&slowLogEntry{}, 5)
+	s.add(&slowLogEntry{}, 5)
+	s.add(&slowLogEntry{}, 5)
+	assert.Equal(t, []int64{7, 6, 5, 4, 3}, slowLogIds(s.latest(-1)))
+	// 变小之后只保留最新的记录
+	s.add(&slowLogEntry{}, 2)
+	assert.Equal(t, []int64{8, 7}, slowLogIds(s.latest(-1)))
+	s.add(&slowLogEntry{}, 0)
+	assert.Equal(t, 0, s.len())
+
+	s.add(&slowLogEntry{}, 2)
+	s.reset()
+	assert.Empty(t, s.latest(10))
+	s.add(&slowLogEntry{}, 2)
+	// 清空之后 id 继续增长
+	assert.Equal(t, []int64{11}, slowLogIds(s.latest(10)))
+}
+
+func TestSlowLogArgs(t *testing.T) {
+	cmdLine := [][]byte{[]byte("set"), []byte("key"), []byte(strings.Repeat("v", 200))}
+	args := slowLogArgs(cmdLine)
+	assert.Equal(t, "key", string(args[1]))
+	assert.Equal(t, strings.Repeat("v", 128)+"... (72 more bytes)", string(args[2]))
+
+	cmdLine = [][]byte{[]byte("rpush"), []byte("key")}
+	for i := 0; i < 40; i++ {
+		cmdLine = append(cmdLine, []byte(fmt.Sprint(i)))
+	}
+	args = slowLogArgs(cmdLine)
+	assert.Len(t, args, slowLogEntryMaxArgc)
+	assert.Equal(t, "28", string(args[30]))
+	assert.Equal(t, "... (11 more arguments)", string(args[31]))
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"bytes"
+	"github.com/xuning888/godis-tiny/pkg/datastruct/hdr"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// commandStat INFO commandstats 中一个命令的统计
+type commandStat struct {
+	calls int64
+	// usec 执行命令花费的微秒数
+	usec int64
+	// rejectedCalls 参数个数错误、没有权限等在执行之前被拒绝的次数
+	rejectedCalls int64
+	// failedCalls 执行之后返回了错误的次数
+	failedCalls int64
+	// latency 执行时间(微秒)的分布, 第一次执行的时候创建
+	latency *hdr.Histogram
+}
+
+// serverStats 服务器的统计信息, CONFIG RESETSTAT 会清空。只在事件循环中或者持有 lock 的时候访问
+type serverStats struct {
+	// numCommands total_commands_processed, 不包含服务器内部的命令
+	numCommands int64
+	// numConnections total_connections_received
+	numConnections int64
+	// rejectedConn 超过 maxclients 被拒绝的连接
+	rejectedConn int64
+	// keyspaceHits, keyspaceMisses 只读命令查找 key 的命中次数和未命中次数
+	keyspaceHits   int64
+	keyspaceMisses int64
+	// expiredKeys 过期删除的 key
+	expiredKeys int64
+	// evictedKeys 还没有支持 maxmemory, 始终为 0
+	evictedKeys int64
+	// totalErrorReplies 返回给客户端的错误
+	totalErrorReplies int64
+	// peakMemory 定时任务和 INFO 看到的最大的内存使用量
+	peakMemory uint64
+	// opsPerSec 定时任务每秒采样一次执行的命令数
+	opsPerSec    int64
+	lastCommands int64
+	// commands 命令名 => 统计
+	commands map[string]*commandStat
+	// errors 错误前缀 => 次数, 例如 ERR, WRONGTYPE
+	errors map[string]int64
+	// current 正在执行的命令, 客户端是服务器内部的客户端时为 nil
+	current *Command
+}
+
+// maxErrorTypes errorstats 最多记录多少种错误, 防止错误前缀过多时占用太多内存
+const maxErrorTypes = 128
+
+var stats = newServerStats()
+
+func newServerStats() *serverStats {
+	return &serverStats{
+		commands: make(map[string]*commandStat),
+		errors:   make(map[string]int64),
+	}
+}
+
+func (s *serverStats) reset() {
+	*s = *newServerStats()
+}
+
+func (s *serverStats) commandStat(name string) *commandStat {
+	stat, ok := s.commands[name]
+	if !ok {
+		stat = &commandStat{}
+		s.commands[name] = stat
+	}
+	return stat
+}
+
+// call 记录一次命令的执行, failed 表示命令返回了错误
+func (s *serverStats) call(cmd *Command, duration time.Duration, failed bool) {
+	stat := s.commandStat(cmd.name)
+	stat.calls++
+	stat.usec += duration.Microseconds()
+	if stat.latency == nil {
+		stat.latency = hdr.New()
+	}
+	stat.latency.Record(duration.Microseconds())
+	if failed {
+		stat.failedCalls++
+	}
+}
+
+// reject 命令在执行之前被拒绝
+func (s *serverStats) reject(cmd *Command) {
+	s.commandStat(cmd.name).rejectedCalls++
+}
+
+// errorReply 记录一个错误回复, 错误前缀是第一个空格之前的部分
+func (s *serverStats) errorReply(reply []byte) {
+	s.totalErrorReplies++
+	line := reply[1:]
+	if i := bytes.IndexAny(line, " \r"); i >= 0 {
+		line = line[:i]
+	}
+	prefix := string(line)
+	if _, ok := s.errors[prefix]; !ok && len(s.errors) >= maxErrorTypes {
+		return
+	}
+	s.errors[prefix]++
+}
+
+// keyLookup 只统计客户端执行的只读命令
+func (s *serverStats) keyLookup(hit bool) {
+	if s.current == nil || !s.current.hasFlag(cmdReadonly) {
+		return
+	}
+	if hit {
+		s.keyspaceHits++
+	} else {
+		s.keyspaceMisses++
+	}
+}
+
+func (s *serverStats) keyExpired(key string) {
+	s.expiredKeys++
+}
+
+// sample 定时任务每秒调用一次, memStats 在 lock 之外读取
+func (s *serverStats) sample(memStats *runtime.MemStats) {
+	s.opsPerSec = s.numCommands - s.lastCommands
+	s.lastCommands = s.numCommands
+	s.updatePeakMemory(memStats)
+}
+
+// readMemStats 返回当前的内存使用情况, ReadMemStats 会 stop the world, 定时任务不要在持有 lock 的时候调用
+func readMemStats() *runtime.MemStats {
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+	return memStats
+}
+
+func (s *serverStats) updatePeakMemory(memStats *runtime.MemStats) {
+	if memStats.HeapAlloc > s.peakMemory {
+		s.peakMemory = memStats.HeapAlloc
+	}
+}
+
+// errorPrefixes 按照字母顺序返回记录过的错误前缀
+func (s *serverStats) errorPrefixes() []string {
+	prefixes := make([]string, 0, len(s.errors))
+	for prefix := range s.errors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
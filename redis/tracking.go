@@ -0,0 +1,288 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CLIENT CACHING 对下一个命令的影响
+const (
+	cachingDefault = iota
+	cachingYes
+	cachingNo
+)
+
+// trackingInvalidateChannel RESP2 的客户端通过这个频道接收重定向过来的失效消息
+const trackingInvalidateChannel = "__redis__:invalidate"
+
+// clientTracking 客户端 CLIENT TRACKING 的选项, 关闭时 Client.tracking 为 nil
+type clientTracking struct {
+	bcast  bool
+	optIn  bool
+	optOut bool
+	noLoop bool
+	// redirect 接收失效消息的客户端 id, 0 表示发给自己
+	redirect int64
+	// redirectBroken 重定向的客户端已经断开了
+	redirectBroken bool
+	// prefixes BCAST 模式下订阅的前缀
+	prefixes []string
+}
+
+// trackingTable 记录客户端读取过的 key, key 被修改、过期或者从表中淘汰时给客户端发送失效消息。
+// 只在持有 lock 的时候访问
+type trackingTable struct {
+	// keys 默认模式下 key => 读取过这个 key 的客户端 id
+	keys map[string]map[int64]struct{}
+	// prefixes BCAST 模式下 前缀 => 订阅了这个前缀的客户端
+	prefixes map[string]map[int64]*Client
+	// clients 开启了 tracking 的客户端
+	clients map[int64]*Client
+	// caller, callerCmd 正在执行的客户端和命令, 只有只读命令读取的 key 才会被记录
+	caller    *Client
+	callerCmd *Command
+	// clientById 查找重定向的客户端, 由服务器设置
+	clientById func(id int64) *Client
+}
+
+var tracking = newTrackingTable()
+
+func newTrackingTable() *trackingTable {
+	return &trackingTable{
+		keys:     make(map[string]map[int64]struct{}),
+		prefixes: make(map[string]map[int64]*Client),
+		clients:  make(map[int64]*Client),
+	}
+}
+
+// enter 开始执行命令
+func (t *trackingTable) enter(conn *Client, cmd *Command) {
+	t.caller = conn
+	t.callerCmd = cmd
+}
+
+// leave 命令执行完毕
+func (t *trackingTable) leave() {
+	t.caller = nil
+	t.callerCmd = nil
+}
+
+func (t *trackingTable) clientCount() int {
+	return len(t.clients)
+}
+
+func (t *trackingTable) keyCount() int {
+	return len(t.keys)
+}
+
+// enable 开启或者修改客户端的 tracking, 调用前已经检查过选项
+func (t *trackingTable) enable(conn *Client, options *clientTracking) {
+	if conn.tracking != nil {
+		// 再次开启时保留之前订阅的前缀
+		options.prefixes = append(conn.tracking.prefixes, options.prefixes...)
+	}
+	if options.bcast && len(options.prefixes) == 0 {
+		options.prefixes = []string{""}
+	}
+	conn.tracking = options
+	t.clients[conn.id] = conn
+	for _, prefix := range options.prefixes {
+		subscribers, ok := t.prefixes[prefix]
+		if !ok {
+			subscribers = make(map[int64]*Client)
+			t.prefixes[prefix] = subscribers
+		}
+		subscribers[conn.id] = conn
+	}
+}
+
+// disable 关闭客户端的 tracking, 默认模式下记录的 key 在发送失效消息的时候再清理
+func (t *trackingTable) disable(conn *Client) {
+	if conn.tracking == nil {
+		return
+	}
+	for _, prefix := range conn.tracking.prefixes {
+		if subscribers, ok := t.prefixes[prefix]; ok {
+			delete(subscribers, conn.id)
+			if len(subscribers) == 0 {
+				delete(t.prefixes, prefix)
+			}
+		}
+	}
+	delete(t.clients, conn.id)
+	conn.tracking = nil
+	conn.caching = cachingDefault
+	conn.cachingNext = cachingDefault
+}
+
+// checkPrefixes BCAST 模式下同一个客户端的前缀不能互相包含
+func (t *trackingTable) checkPrefixes(conn *Client, prefixes []string) error {
+	for i, prefix := range prefixes {
+		if conn.tracking != nil {
+			for _, exists := range conn.tracking.prefixes {
+				if prefixOverlaps(prefix, exists) {
+					return fmt.Errorf("ERR Prefix '%s' overlaps with an existing prefix '%s'. "+
+						"Prefixes for a single client must not overlap.", prefix, exists)
+				}
+			}
+		}
+		for _, other := range prefixes[i+1:] {
+			if prefixOverlaps(prefix, other) {
+				return fmt.Errorf("ERR Prefix '%s' overlaps with another provided prefix '%s'. "+
+					"Prefixes for a single client must not overlap.", prefix, other)
+			}
+		}
+	}
+	return nil
+}
+
+func prefixOverlaps(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// keyRead DB 读取 key 的时候调用, 默认模式下记录只读命令读取的 key
+func (t *trackingTable) keyRead(key string) {
+	conn := t.caller
+	if conn == nil || conn.tracking == nil || conn.tracking.bcast {
+		return
+	}
+	if t.callerCmd == nil || !t.callerCmd.hasFlag(cmdReadonly) {
+		return
+	}
+	if conn.tracking.optIn && conn.caching != cachingYes {
+		return
+	}
+	if conn.tracking.optOut && conn.caching == cachingNo {
+		return
+	}
+	ids, ok := t.keys[key]
+	if !ok {
+		ids = make(map[int64]struct{})
+		t.keys[key] = ids
+	}
+	ids[conn.id] = struct{}{}
+}
+
+// keyModified key 被修改、删除或者过期的时候调用, 通知读取过这个 key 或者订阅了前缀的客户端
+func (t *trackingTable) keyModified(key string) {
+	if len(t.clients) == 0 {
+		return
+	}
+	if ids, ok := t.keys[key]; ok {
+		delete(t.keys, key)
+		for id := range ids {
+			conn, ok := t.clients[id]
+			if !ok || conn.tracking.bcast {
+				continue
+			}
+			if conn.tracking.noLoop && conn == t.caller {
+				continue
+			}
+			t.sendInvalidation(conn, [][]byte{[]byte(key)})
+		}
+	}
+	// BCAST 模式不攒批, key 被修改时马上发送
+	for prefix, subscribers := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, conn := range subscribers {
+			if conn.tracking.noLoop && conn == t.caller {
+				continue
+			}
+			t.sendInvalidation(conn, [][]byte{[]byte(key)})
+		}
+	}
+}
+
+// flushed 数据库被清空, 通知所有开启了 tracking 的客户端清空缓存
+func (t *trackingTable) flushed() {
+	if len(t.clients) == 0 {
+		return
+	}
+	t.keys = make(map[string]map[int64]struct{})
+	for _, conn := range t.clients {
+		t.sendInvalidation(conn, nil)
+	}
+}
+
+// evict 记录的 key 超过 maxKeys 时, 淘汰多出来的 key 并通知客户端, maxKeys 为 0 时不限制
+func (t *trackingTable) evict(maxKeys int) {
+	if maxKeys <= 0 {
+		return
+	}
+	for key := range t.keys {
+		if len(t.keys) <= maxKeys {
+			return
+		}
+		t.keyModified(key)
+	}
+}
+
+// sendInvalidation 发送失效消息, keys 为 nil 表示所有的 key 都失效了。
+// RESP3 的客户端收到 push 消息, RESP2 的客户端只能通过重定向在 __redis__:invalidate 频道上收到
+func (t *trackingTable) sendInvalidation(conn *Client, keys [][]byte) {
+	target := conn
+	redirected := conn.tracking.redirect != 0
+	if redirected {
+		if t.clientById != nil {
+			target = t.clientById(conn.tracking.redirect)
+		} else {
+			target = nil
+		}
+		if target == nil {
+			if !conn.tracking.redirectBroken && conn.IsResp3() {
+				conn.writePush(trackingRedirBrokenBytes(conn.tracking.redirect))
+			}
+			conn.tracking.redirectBroken = true
+			return
+		}
+	}
+	if !target.IsResp3() && !redirected {
+		return
+	}
+	target.writePush(invalidationBytes(target, keys))
+}
+
+func invalidationBytes(target *Client, keys [][]byte) []byte {
+	buf := bytes.Buffer{}
+	if target.IsResp3() {
+		buf.Write(smallTypeLineWithNum('>', 2))
+		buf.Write(MakeBulkReply([]byte("invalidate")).ToBytes())
+	} else {
+		buf.Write(MakeMultiBulkHeaderReply(3).ToBytes())
+		buf.Write(MakeBulkReply([]byte("message")).ToBytes())
+		buf.Write(MakeBulkReply([]byte(trackingInvalidateChannel)).ToBytes())
+	}
+	if keys == nil {
+		buf.Write(nullBytesOf(target))
+	} else {
+		buf.Write(MakeMultiBulkReply(keys).ToBytes())
+	}
+	return buf.Bytes()
+}
+
+func trackingRedirBrokenBytes(redirect int64) []byte {
+	buf := bytes.Buffer{}
+	buf.Write(smallTypeLineWithNum('>', 2))
+	buf.Write(MakeBulkReply([]byte("tracking-redir-broken")).ToBytes())
+	buf.Write(MakeIntReply(redirect).ToBytes())
+	return buf.Bytes()
+}
+
+// writePush 写出服务端主动推送的消息, 不受 CLIENT REPLY OFF 的影响。
+// 正在执行命令的客户端直接写入, 保证失效消息在命令的回复之前; 其他客户端可能在定时任务中被通知, 需要异步写入
+func (c *Client) writePush(msg []byte) {
+	if c.conn == nil {
+		return
+	}
+	if c == tracking.caller {
+		c.pushing = true
+		_, _ = c.Write(msg)
+		_ = c.Flush()
+		c.pushing = false
+		return
+	}
+	_ = c.conn.AsyncWrite(msg, nil)
+}
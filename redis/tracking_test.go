@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTrackingKeys(t *testing.T) {
+	table := newTrackingTable()
+	get, _ := router("get")
+	set, _ := router("set")
+	client := NewClient(0, nil, false)
+	other := NewClient(0, nil, false)
+	table.enable(client, &clientTracking{})
+	table.enable(other, &clientTracking{optIn: true})
+
+	table.enter(client, get)
+	table.keyRead("foo")
+	table.leave()
+	// 写命令读取的 key 不记录
+	table.enter(client, set)
+	table.keyRead("bar")
+	table.leave()
+	// OPTIN 模式下只有 CLIENT CACHING YES 之后的命令才记录
+	table.enter(other, get)
+	table.keyRead("baz")
+	other.caching = cachingYes
+	table.keyRead("foo")
+	table.leave()
+	assert.Equal(t, 1, table.keyCount())
+	assert.Len(t, table.keys["foo"], 2)
+
+	table.keyModified("foo")
+	assert.Equal(t, 0, table.keyCount())
+
+	table.enter(client, get)
+	table.keyRead("a")
+	table.keyRead("b")
+	table.keyRead("c")
+	table.leave()
+	table.evict(1)
+	assert.Equal(t, 1, table.keyCount())
+
+	table.disable(client)
+	assert.Nil(t, client.tracking)
+	assert.Equal(t, 1, table.clientCount())
+}
+
+func TestTrackingPrefixes(t *testing.T) {
+	table := newTrackingTable()
+	client := NewClient(0, nil, false)
+	assert.NotNil(t, table.checkPrefixes(client, []string{"user:", "user:1"}))
+	assert.Nil(t, table.checkPrefixes(client, []string{"user:", "order:"}))
+	table.enable(client, &clientTracking{bcast: true, prefixes: []string{"user:", "order:"}})
+	err := table.checkPrefixes(client, []string{"order:1"})
+	assert.Equal(t, "ERR Prefix 'order:1' overlaps with an existing prefix 'order:'. "+
+		"Prefixes for a single client must not overlap.", err.Error())
+
+	table.enable(client, &clientTracking{bcast: true, prefixes: []string{"item:"}})
+	assert.Equal(t, []string{"user:", "order:", "item:"}, client.tracking.prefixes)
+	assert.Len(t, table.prefixes, 3)
+
+	table.disable(client)
+	assert.Len(t, table.prefixes, 0)
+
+	all := NewClient(0, nil, false)
+	table.enable(all, &clientTracking{bcast: true})
+	assert.Equal(t, []string{""}, all.tracking.prefixes)
+}